@@ -0,0 +1,396 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// debianTrackerURL is the JSON feed of the Debian security tracker.
+const debianTrackerURL = "https://security-tracker.debian.org/tracker/data/json"
+
+// ubuntuTrackerURL is the CVE API of the Ubuntu security tracker, queried by source package.
+const ubuntuTrackerURL = "https://ubuntu.com/security/cves.json"
+
+// severities are the known severities, from the least to the most severe.
+var severities = []string{"negligible", "low", "medium", "high", "critical"}
+
+// vulnerability is a known CVE affecting a published package, reported by the 'audit' subcommand.
+type vulnerability struct {
+	Package      string `json:"package"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+	// Source and SourceVersion are the affected source package: the one of the package,
+	// or one listed by its Built-Using field.
+	Source        string `json:"source"`
+	SourceVersion string `json:"source_version"`
+	BuiltUsing    bool   `json:"built_using,omitempty"`
+	CVE           string `json:"cve"`
+	Severity      string `json:"severity"`
+	// Fixed is the first fixed version of the source package in the release, if any.
+	Fixed string `json:"fixed,omitempty"`
+}
+
+// sourceRef is a source package version used by a binary package.
+type sourceRef struct {
+	name, version string
+	builtUsing    bool
+}
+
+// runAudit executes the 'audit' subcommand, which reports the known CVEs affecting the published packages.
+func runAudit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	repo := fs.String("repo", "", "the repository `directory or repo.tar.gz` to audit")
+	tracker := fs.String("tracker", "debian", "the security tracker: 'debian' or 'ubuntu'")
+	release := fs.String("release", "", "the `codename` of the distribution the packages are installed on, e.g. bookworm or noble (defaults to the Codename of the Release file)")
+	feed := fs.String("feed", "", "the `URL or file` of the Debian tracker JSON feed, e.g. a mirror (defaults to the Debian security tracker)")
+	failOn := fs.String("fail-on", "", "fail if a vulnerability has this `severity` or more: negligible, low, medium, high or critical")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm audit -repo <repository directory or repo.tar.gz> [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if *repo == "" && fs.NArg() == 1 {
+		*repo = fs.Arg(0)
+	}
+	if *repo == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *failOn != "" && !slices.Contains(severities, *failOn) {
+		fatalf("invalid -fail-on %q, expected one of: %s", *failOn, strings.Join(severities, ", "))
+	}
+
+	source, err := openRepoSource(*repo)
+	if err != nil {
+		fatalf("Failed to open repository: %v", err)
+	}
+	pkgs, err := listPackages(source)
+	if err != nil {
+		fatalf("Failed to list packages: %v", err)
+	}
+	codename := *release
+	if codename == "" {
+		if codename, err = repoCodename(source, pkgs); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	var lookup func(source string) ([]advisory, error)
+	switch *tracker {
+	case "debian":
+		advisories, err := loadDebianTracker(client, cmp.Or(*feed, debianTrackerURL), codename)
+		if err != nil {
+			fatalf("Failed to load the Debian security tracker: %v", err)
+		}
+		lookup = func(source string) ([]advisory, error) { return advisories[source], nil }
+	case "ubuntu":
+		if *feed != "" {
+			fatalf("-feed only applies to the debian tracker")
+		}
+		lookup = func(source string) ([]advisory, error) {
+			return queryUbuntuTracker(client, ubuntuTrackerURL, source, codename)
+		}
+	default:
+		fatalf("unknown -tracker %q, expected 'debian' or 'ubuntu'", *tracker)
+	}
+
+	var found []vulnerability
+	cache := make(map[string][]advisory)
+	for _, p := range pkgs {
+		for _, ref := range sourceRefs(p.Metadata) {
+			advisories, ok := cache[ref.name]
+			if !ok {
+				if advisories, err = lookup(ref.name); err != nil {
+					fatalf("Failed to query the security tracker for %s: %v", ref.name, err)
+				}
+				cache[ref.name] = advisories
+			}
+			for _, a := range advisories {
+				if !a.affects(ref.version) {
+					continue
+				}
+				found = append(found, vulnerability{
+					Package:       p.Name,
+					Version:       p.Version,
+					Architecture:  p.Architecture,
+					Source:        ref.name,
+					SourceVersion: ref.version,
+					BuiltUsing:    ref.builtUsing,
+					CVE:           a.cve,
+					Severity:      a.severity,
+					Fixed:         a.fixed,
+				})
+			}
+		}
+	}
+
+	failing := 0
+	for _, v := range found {
+		if *failOn != "" && slices.Index(severities, v.Severity) >= slices.Index(severities, *failOn) {
+			failing++
+		}
+		if jsonOutput {
+			printJSON("vulnerability", v)
+			continue
+		}
+		line := fmt.Sprintf("%s %s [%s]: %s (%s) in %s %s", v.Package, v.Version, v.Architecture, v.CVE, v.Severity, v.Source, v.SourceVersion)
+		if v.BuiltUsing {
+			line += " (Built-Using)"
+		}
+		if v.Fixed != "" {
+			line += ", fixed in " + v.Fixed
+		}
+		fmt.Println(line)
+	}
+	if failing > 0 {
+		fatalf("%d vulnerabilit(ies) with severity %s or more", failing, *failOn)
+	}
+	succeed(fmt.Sprintf("%d package(s) audited against %s, %d vulnerabilit(ies) found.", len(pkgs), codename, len(found)))
+}
+
+// repoCodename returns the Codename of the Release files of the suites of the packages, or of the Release
+// file of a flat repository. It fails if there is none, or if the suites have different codenames.
+func repoCodename(source *repoSource, pkgs []listedPackage) (string, error) {
+	var codenames []string
+	read := make(map[string]bool)
+	for _, p := range pkgs {
+		name := "Release"
+		if p.Suite != "" {
+			name = "dists/" + p.Suite + "/Release"
+		}
+		if read[name] {
+			continue
+		}
+		read[name] = true
+		content, err := source.read(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		info, err := deb.ParseRelease(string(content))
+		if err != nil {
+			return "", fmt.Errorf("parsing %s: %w", name, err)
+		}
+		if info.Codename == "" {
+			return "", fmt.Errorf("%s has no Codename, audit requires -release", name)
+		}
+		if !slices.Contains(codenames, info.Codename) {
+			codenames = append(codenames, info.Codename)
+		}
+	}
+	switch len(codenames) {
+	case 0:
+		return "", fmt.Errorf("the repository has no packages, audit requires -release")
+	case 1:
+		return codenames[0], nil
+	default:
+		return "", fmt.Errorf("the suites have different codenames (%s), audit requires -release", strings.Join(codenames, ", "))
+	}
+}
+
+// sourceRefs returns the source packages of a binary package: its own, and the ones of its Built-Using field.
+func sourceRefs(m deb.Metadata) []sourceRef {
+	// Source: name, or name (version) when it differs from the binary version.
+	name, version := m.Package, m.Version
+	if m.Source != "" {
+		n, v, ok := strings.Cut(m.Source, "(")
+		name = strings.TrimSpace(n)
+		if ok {
+			version = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), ")"))
+		}
+	}
+	refs := []sourceRef{{name: name, version: version}}
+	// Built-Using: name (= version), name (= version)
+	for _, item := range strings.Split(m.BuiltUsing, ",") {
+		n, v, ok := strings.Cut(item, "(")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), ")"))
+		v = strings.TrimSpace(strings.TrimPrefix(v, "="))
+		refs = append(refs, sourceRef{name: strings.TrimSpace(n), version: v, builtUsing: true})
+	}
+	return refs
+}
+
+// advisory is a CVE of a source package in a release.
+type advisory struct {
+	cve      string
+	severity string
+	// fixed is the first fixed version, empty if the CVE is not fixed in the release.
+	fixed string
+}
+
+// affects reports whether the source package version is affected by the advisory.
+func (a advisory) affects(version string) bool {
+	return a.fixed == "" || deb.CompareVersions(version, a.fixed) < 0
+}
+
+// debianSeverity returns the severity of a Debian tracker urgency, e.g. "low**".
+// Unassigned urgencies are medium.
+func debianSeverity(urgency string) string {
+	switch strings.TrimRight(urgency, "*") {
+	case "unimportant", "end-of-life":
+		return "negligible"
+	case "low":
+		return "low"
+	case "high":
+		return "high"
+	default:
+		return "medium"
+	}
+}
+
+// loadDebianTracker returns the open advisories of the release in the Debian tracker JSON feed at location,
+// a URL or a file, by source package.
+func loadDebianTracker(client *http.Client, location, codename string) (map[string][]advisory, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		start := time.Now()
+		resp, err := client.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("GET", "url", location, "status", resp.StatusCode, "duration", time.Since(start))
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", location, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+
+	// {"<source>": {"<CVE>": {"releases": {"<codename>": {"status": ..., "fixed_version": ..., "urgency": ...}}}}}
+	var feed map[string]map[string]struct {
+		Releases map[string]struct {
+			Status       string `json:"status"`
+			FixedVersion string `json:"fixed_version"`
+			Urgency      string `json:"urgency"`
+		} `json:"releases"`
+	}
+	if err := json.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", location, err)
+	}
+	advisories := make(map[string][]advisory)
+	known := false
+	for source, cves := range feed {
+		for cve, entry := range cves {
+			rel, ok := entry.Releases[codename]
+			if !ok {
+				continue
+			}
+			known = true
+			a := advisory{cve: cve, severity: debianSeverity(rel.Urgency)}
+			switch {
+			case rel.Status == "open":
+			case rel.Status == "resolved" && rel.FixedVersion != "" && rel.FixedVersion != "0":
+				a.fixed = rel.FixedVersion
+			default:
+				// Not affected, or undetermined.
+				continue
+			}
+			advisories[source] = append(advisories[source], a)
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("%s: unknown release %q", location, codename)
+	}
+	for _, list := range advisories {
+		slices.SortFunc(list, func(a, b advisory) int { return strings.Compare(a.cve, b.cve) })
+	}
+	return advisories, nil
+}
+
+// queryUbuntuTracker returns the advisories of a source package in an Ubuntu release, from the Ubuntu CVE API
+// at base, e.g. ubuntuTrackerURL.
+func queryUbuntuTracker(client *http.Client, base, source, codename string) ([]advisory, error) {
+	var advisories []advisory
+	const limit = 100
+	for offset := 0; ; offset += limit {
+		q := url.Values{"package": {source}, "limit": {fmt.Sprint(limit)}, "offset": {fmt.Sprint(offset)}}
+		location := base + "?" + q.Encode()
+		start := time.Now()
+		resp, err := client.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("GET", "url", location, "status", resp.StatusCode, "duration", time.Since(start))
+		var page struct {
+			CVEs []struct {
+				ID       string `json:"id"`
+				Priority string `json:"priority"`
+				Packages []struct {
+					Name     string `json:"name"`
+					Statuses []struct {
+						ReleaseCodename string `json:"release_codename"`
+						Status          string `json:"status"`
+						// Description is the fixed version of released statuses.
+						Description string `json:"description"`
+					} `json:"statuses"`
+				} `json:"packages"`
+			} `json:"cves"`
+		}
+		err = nil
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s: %s", location, resp.Status)
+		} else if derr := json.NewDecoder(resp.Body).Decode(&page); derr != nil {
+			err = fmt.Errorf("parsing %s: %w", location, derr)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, cve := range page.CVEs {
+			for _, p := range cve.Packages {
+				if p.Name != source {
+					continue
+				}
+				for _, s := range p.Statuses {
+					if s.ReleaseCodename != codename {
+						continue
+					}
+					a := advisory{cve: cve.ID, severity: cve.Priority}
+					if !slices.Contains(severities, a.severity) {
+						a.severity = "medium"
+					}
+					switch s.Status {
+					case "needed", "deferred", "pending":
+					case "released":
+						a.fixed = strings.TrimSpace(s.Description)
+						if a.fixed == "" {
+							continue
+						}
+					default:
+						// not-affected, needs-triage, DNE, ignored...
+						continue
+					}
+					advisories = append(advisories, a)
+				}
+			}
+		}
+		if len(page.CVEs) < limit {
+			return advisories, nil
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// debianFeed is an excerpt of the Debian security tracker JSON feed.
+const debianFeed = `{
+  "openssl": {
+    "CVE-2024-0002": {"releases": {
+      "bookworm": {"status": "resolved", "fixed_version": "3.0.13-1~deb12u1", "urgency": "high"},
+      "trixie": {"status": "resolved", "fixed_version": "3.1.0-1", "urgency": "high"}
+    }},
+    "CVE-2024-0001": {"releases": {"bookworm": {"status": "open", "urgency": "low**"}}},
+    "CVE-2023-0001": {"releases": {"bookworm": {"status": "resolved", "fixed_version": "0", "urgency": "medium"}}}
+  },
+  "zlib": {
+    "CVE-2022-0001": {"releases": {"bookworm": {"status": "undetermined", "urgency": "unimportant"}}}
+  }
+}`
+
+func TestLoadDebianTracker(t *testing.T) {
+	feed := filepath.Join(t.TempDir(), "feed.json")
+	if err := os.WriteFile(feed, []byte(debianFeed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	advisories, err := loadDebianTracker(http.DefaultClient, feed, "bookworm")
+	if err != nil {
+		t.Fatalf("loadDebianTracker failed: %v", err)
+	}
+	want := []advisory{
+		{cve: "CVE-2024-0001", severity: "low"},
+		{cve: "CVE-2024-0002", severity: "high", fixed: "3.0.13-1~deb12u1"},
+	}
+	if got := advisories["openssl"]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("openssl advisories = %v, want %v", got, want)
+	}
+	if got := advisories["zlib"]; len(got) != 0 {
+		t.Errorf("zlib advisories = %v, want none", got)
+	}
+	if !advisories["openssl"][1].affects("3.0.11-1~deb12u2") || advisories["openssl"][1].affects("3.0.13-1~deb12u1") {
+		t.Errorf("the fixed version is not compared to the source version")
+	}
+	if _, err := loadDebianTracker(http.DefaultClient, feed, "stable"); err == nil {
+		t.Errorf("loadDebianTracker succeeded for a release unknown to the feed")
+	}
+}
+
+func TestQueryUbuntuTracker(t *testing.T) {
+	// 150 CVEs, served in pages: the first 100 are fixed, the others are not.
+	type status struct {
+		ReleaseCodename string `json:"release_codename"`
+		Status          string `json:"status"`
+		Description     string `json:"description"`
+	}
+	type pkg struct {
+		Name     string   `json:"name"`
+		Statuses []status `json:"statuses"`
+	}
+	type cve struct {
+		ID       string `json:"id"`
+		Priority string `json:"priority"`
+		Packages []pkg  `json:"packages"`
+	}
+	var cves []cve
+	for i := range 150 {
+		s := status{ReleaseCodename: "noble", Status: "released", Description: "1.2-1ubuntu1"}
+		if i >= 100 {
+			s = status{ReleaseCodename: "noble", Status: "needed"}
+		}
+		cves = append(cves, cve{
+			ID:       fmt.Sprintf("CVE-2024-%04d", i),
+			Priority: "medium",
+			Packages: []pkg{{Name: "tool", Statuses: []status{s, {ReleaseCodename: "jammy", Status: "needed"}}}},
+		})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		if q.Get("package") != "tool" || limit == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		page := cves[min(offset, len(cves)):min(offset+limit, len(cves))]
+		json.NewEncoder(w).Encode(map[string]any{"cves": page})
+	}))
+	defer server.Close()
+
+	advisories, err := queryUbuntuTracker(server.Client(), server.URL, "tool", "noble")
+	if err != nil {
+		t.Fatalf("queryUbuntuTracker failed: %v", err)
+	}
+	if len(advisories) != len(cves) {
+		t.Fatalf("queryUbuntuTracker returned %d advisories, want %d", len(advisories), len(cves))
+	}
+	if a := advisories[0]; a.fixed != "1.2-1ubuntu1" || a.severity != "medium" {
+		t.Errorf("first advisory = %+v, want fixed in 1.2-1ubuntu1", a)
+	}
+	if a := advisories[len(advisories)-1]; a.cve != "CVE-2024-0149" || a.fixed != "" {
+		t.Errorf("last advisory = %+v, want the unfixed CVE-2024-0149", a)
+	}
+}
+
+func TestRepoCodename(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "dists", "stable"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dists", "stable", "Release"), []byte("Suite: stable\nCodename: bookworm\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := openRepoSource(dir)
+	if err != nil {
+		t.Fatalf("openRepoSource failed: %v", err)
+	}
+	codename, err := repoCodename(source, []listedPackage{{Name: "app", Suite: "stable"}})
+	if err != nil || codename != "bookworm" {
+		t.Errorf("repoCodename = %q, %v, want %q", codename, err, "bookworm")
+	}
+	if _, err := repoCodename(source, []listedPackage{{Name: "app"}}); err == nil {
+		t.Errorf("repoCodename succeeded for a flat repository without Release file")
+	}
+}
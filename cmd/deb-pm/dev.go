@@ -0,0 +1,167 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/manifest"
+)
+
+// runDev executes the 'dev' subcommand, which builds a repository, serves it over HTTP,
+// and builds it again when the files of its directory change.
+func runDev(args []string) {
+	fs := flag.NewFlagSet("dev", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	interval := fs.Duration("interval", time.Second, "how often the files are checked for changes")
+	cacheDir := fs.String("cache", "", "cache web resources in this directory, and revalidate them on the next builds")
+	allowExec := fs.Bool("allow-exec", false, "allow package files to run their 'exec' command")
+	overrides := make(setFlag)
+	fs.Var(overrides, "set", "override a define with `key=value`, taking precedence over the repository file (repeatable)")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm dev [flags] [Repository file]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	file := fs.Arg(0)
+	if file == "" {
+		file = defaultRepositoryFile()
+	}
+	if file == "" {
+		fatalf("Usage: deb-pm dev [flags] [Repository file]")
+	}
+
+	repository, err := manifest.NewRepositoryWithOverrides(file, overrides)
+	if err != nil {
+		fatalf("Failed to load archivefile: %v", err)
+	}
+	if len(repository.Repositories) > 0 {
+		fatalf("dev serves a single repository, the file defines %d 'repositories'", len(repository.Repositories))
+	}
+	dir := repository.Path
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(file), dir)
+	}
+	opts := manifest.CompileOptions{GPGKey: os.Getenv("GPG_KEY"), CacheDir: *cacheDir, AllowExec: *allowExec, Logger: slog.Default()}
+	build := func() {
+		start := time.Now()
+		repository, err := manifest.NewRepositoryWithOverrides(file, overrides)
+		if err == nil {
+			err = repository.CompileWithOptions(opts, printEvent)
+		}
+		if err != nil {
+			// The server keeps serving the last successful build.
+			slog.Error("Build failed", "error", err)
+			return
+		}
+		slog.Info("Build completed", "duration", time.Since(start).Round(time.Millisecond))
+	}
+	build()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	url := "http://" + listener.Addr().String() + "/"
+	source := &repoSource{dir: dir, fsys: os.DirFS(dir)}
+	go func() {
+		fatalf("%v", http.Serve(listener, &repoServer{read: source.read}))
+	}()
+	slog.Info("Serving repository", "source", dir, "url", url)
+	if opts.GPGKey != "" {
+		fmt.Printf("Download the public key with:\n\n    curl -o /etc/apt/keyrings/dev.gpg %spublic.gpg\n\n", url)
+	}
+	fmt.Printf("Add this line to /etc/apt/sources.list.d/dev.list:\n\n    %s\n\n", sourcesListLine(repository, url, opts.GPGKey != ""))
+
+	// The whole directory of the repository file is watched, except the generated files.
+	root := filepath.Dir(file)
+	skip := []string{dir}
+	if *cacheDir != "" {
+		skip = append(skip, *cacheDir)
+	}
+	last := snapshot(root, skip)
+	for range time.Tick(*interval) {
+		current := snapshot(root, skip)
+		if changed := changedFile(last, current); changed != "" {
+			slog.Info("File changed, building again", "file", changed)
+			build()
+			// Files changed by the build itself (e.g. a lock file) do not trigger another one.
+			current = snapshot(root, skip)
+		}
+		last = current
+	}
+}
+
+// sourcesListLine returns the apt sources.list line of the repository served at url.
+// Unsigned repositories are trusted explicitly, signed ones use the served public key.
+func sourcesListLine(r *manifest.Repository, url string, signed bool) string {
+	options := "[trusted=yes]"
+	if signed {
+		options = "[signed-by=/etc/apt/keyrings/dev.gpg]"
+	}
+	dist := "./"
+	if r.Layout == manifest.LayoutStandard {
+		dist = cmp.Or(r.Codename, r.Suite) + " " + cmp.Or(strings.Join(r.Components, " "), "main")
+	}
+	return fmt.Sprintf("deb %s %s %s", options, url, dist)
+}
+
+// fileState is the modification time and size of a watched file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshot returns the state of the files under root, except the skipped directories and hidden files.
+func snapshot(root string, skip []string) map[string]fileState {
+	files := make(map[string]fileState)
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			for _, s := range skip {
+				if filepath.Clean(s) == filepath.Clean(p) {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files[p] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return files
+}
+
+// changedFile returns a file that was created, modified or deleted between two snapshots, or "".
+func changedFile(before, after map[string]fileState) string {
+	for p, s := range after {
+		if b, ok := before[p]; !ok || b != s {
+			return p
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			return p
+		}
+	}
+	return ""
+}
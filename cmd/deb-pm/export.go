@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// runExport executes the 'export' subcommand, which writes the files of a repo.tar.gz to a directory.
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	repo := fs.String("repo", "", "the repo.tar.gz `file` to export")
+	to := fs.String("to", "", "the `directory` to write the repository to")
+	clean := fs.Bool("clean", false, "delete the files of the directory that are not in the archive")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm export -repo <repo.tar.gz> -to <directory> [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if *repo == "" || *to == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	source, err := openRepoSource(*repo)
+	if err != nil {
+		fatalf("Failed to open repository: %v", err)
+	}
+	if source.files == nil {
+		fatalf("-repo must be a .tar.gz or .tgz file")
+	}
+	if err := checkReleases(source); err != nil {
+		fatalf("Invalid repository: %v", err)
+	}
+	names, err := source.names()
+	if err != nil {
+		fatalf("Failed to read repository: %v", err)
+	}
+	for _, name := range names {
+		if err := writeFile(filepath.Join(*to, filepath.FromSlash(name)), source.files[name], 0644); err != nil {
+			fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if *clean {
+		if err := removeOthers(*to, names); err != nil {
+			fatalf("Failed to clean %s: %v", *to, err)
+		}
+	}
+	if dryRun {
+		succeed("Dry run completed successfully, nothing was written.")
+		return
+	}
+	succeed(fmt.Sprintf("Repository exported to %s.", *to))
+}
+
+// runPack executes the 'pack' subcommand, which writes the files of a repository directory to a repo.tar.gz.
+func runPack(args []string) {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	from := fs.String("from", "", "the repository `directory` to pack")
+	repo := fs.String("repo", "", "the repo.tar.gz `file` to write")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm pack -from <directory> -repo <repo.tar.gz> [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if *from == "" || *repo == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !strings.HasSuffix(*repo, ".tar.gz") && !strings.HasSuffix(*repo, ".tgz") {
+		fatalf("-repo must be a .tar.gz or .tgz file")
+	}
+	source, err := openRepoSource(*from)
+	if err != nil {
+		fatalf("Failed to open repository: %v", err)
+	}
+	if source.files != nil {
+		fatalf("-from must be a directory")
+	}
+	if err := checkReleases(source); err != nil {
+		fatalf("Invalid repository: %v", err)
+	}
+	names, err := source.names()
+	if err != nil {
+		fatalf("Failed to read repository: %v", err)
+	}
+	archive := &repoSource{files: make(map[string][]byte)}
+	for _, name := range names {
+		if archive.files[name], err = source.read(name); err != nil {
+			fatalf("Failed to read %s: %v", name, err)
+		}
+	}
+	if err := archive.save(*repo); err != nil {
+		fatalf("Failed to write %s: %v", *repo, err)
+	}
+	if dryRun {
+		succeed("Dry run completed successfully, nothing was written.")
+		return
+	}
+	succeed(fmt.Sprintf("%d file(s) packed.", len(names)))
+}
+
+// checkReleases checks that the repository has a Release file, and that the files listed
+// by its Release files have the size and SHA256 checksum they list.
+func checkReleases(source *repoSource) error {
+	names, err := source.names()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, name := range names {
+		dir, ok := releaseDir(name)
+		if !ok {
+			continue
+		}
+		found = true
+		content, err := source.read(name)
+		if err != nil {
+			return err
+		}
+		entries, err := deb.ParseReleaseEntries(string(content))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, e := range entries {
+			if !slices.Contains(names, dir+e.Path) {
+				// e.g. a compression that is not published.
+				continue
+			}
+			content, err := source.read(dir + e.Path)
+			if err != nil {
+				return err
+			}
+			if err := e.Verify(content); err != nil {
+				return fmt.Errorf("%s%s does not match %s: %w", dir, e.Path, name, err)
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("no Release file found")
+	}
+	return nil
+}
+
+// removeOthers removes the files of dir that are not in names, slash separated paths relative to dir,
+// and reports them. With -dry-run, they are only reported.
+func removeOthers(dir string, names []string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if p == dir && errors.Is(err, fs.ErrNotExist) {
+			// Nothing to clean in a dry run.
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(names, filepath.ToSlash(rel)) {
+			return nil
+		}
+		if !dryRun {
+			if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if jsonOutput {
+			printJSON("removed", writtenFile{Path: p, DryRun: dryRun})
+		} else {
+			fmt.Printf(" - %s\n", p)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// runImport executes the 'import' subcommand, which converts an existing repository directory
+// (e.g. made by apt-ftparchive or reprepro) into a repo.tar.gz archive managed by deb-pm.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	from := fs.String("from", "", "the existing repository `directory`, flat or standard (dists/ and pool/)")
+	repo := fs.String("repo", "", "the repo.tar.gz `file` to write")
+	suite := fs.String("suite", "", "the suite to import from a standard repository with several suites")
+	keyFile := fs.String("key", "", "sign the repository with this ASCII-armored private key `file` (defaults to the GPG_KEY environment variable)")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm import -from <repository directory> -repo <repo.tar.gz> [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if *from == "" || *repo == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !strings.HasSuffix(*repo, ".tar.gz") && !strings.HasSuffix(*repo, ".tgz") {
+		fatalf("-repo must be a .tar.gz or .tgz file")
+	}
+	key := os.Getenv("GPG_KEY")
+	if *keyFile != "" {
+		content, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fatalf("Failed to read the private key: %v", err)
+		}
+		key = string(content)
+	}
+
+	source, err := openRepoSource(*from)
+	if err != nil {
+		fatalf("Failed to open repository: %v", err)
+	}
+	archive, count, err := importRepository(source, *suite, key)
+	if err != nil {
+		fatalf("Failed to import repository: %v", err)
+	}
+	if err := archive.save(*repo); err != nil {
+		fatalf("Failed to write %s: %v", *repo, err)
+	}
+	if dryRun {
+		succeed(fmt.Sprintf("Dry run completed successfully, %d package(s) would be imported.", count))
+		return
+	}
+	succeed(fmt.Sprintf("%d package(s) imported.", count))
+}
+
+// importRepository returns the archive of the packages of a flat or standard repository, with deb-pm's layout
+// and indices, and the number of packages. The package files are copied byte for byte, and checked against
+// the checksums of the indices. Only the suite of a standard repository is imported, it can be empty if there is only one.
+func importRepository(source *repoSource, suite, key string) (*repoSource, int, error) {
+	listed, err := listPackages(source)
+	if err != nil {
+		return nil, 0, err
+	}
+	var suites []string
+	for _, p := range listed {
+		if !slices.Contains(suites, p.Suite) {
+			suites = append(suites, p.Suite)
+		}
+	}
+	switch {
+	case len(suites) == 0:
+		return nil, 0, fmt.Errorf("no Packages index found")
+	case suite == "" && len(suites) > 1:
+		return nil, 0, fmt.Errorf("several suites found (%s), use -suite", strings.Join(suites, ", "))
+	case suite == "":
+		suite = suites[0]
+	case !slices.Contains(suites, suite):
+		return nil, 0, fmt.Errorf("suite %q not found, expected one of: %s", suite, strings.Join(suites, ", "))
+	}
+
+	release := "Release"
+	if suite != "" {
+		release = "dists/" + suite + "/Release"
+	}
+	var info deb.ArchiveInfo
+	if content, err := source.read(release); err == nil {
+		if info, err = deb.ParseRelease(string(content)); err != nil {
+			return nil, 0, fmt.Errorf("parsing %s: %w", release, err)
+		}
+	}
+	// The indices are regenerated.
+	info.Date = ""
+	if suite != "" && info.Codename == "" {
+		info.Codename = suite
+	}
+
+	// The repository is written in a temporary directory. The packages keep their original
+	// content, which the repository writer reuses byte for byte.
+	tmp, err := os.MkdirTemp("", "deb-pm-import-")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.RemoveAll(tmp)
+	var pkgs []*deb.Package
+	components := make(map[*deb.Package]string)
+	for _, p := range listed {
+		if p.Suite != suite {
+			continue
+		}
+		content, err := source.read(path.Clean(p.Filename))
+		if err != nil {
+			return nil, 0, err
+		}
+		h := sha256.Sum256(content)
+		sum := hex.EncodeToString(h[:])
+		if p.SHA256 != "" && !strings.EqualFold(p.SHA256, sum) {
+			return nil, 0, fmt.Errorf("%s: SHA256 mismatch, the index has %s, the file %s", p.Filename, p.SHA256, sum)
+		}
+		pkg, err := deb.NewPackage(bytes.NewReader(content))
+		if err != nil {
+			return nil, 0, fmt.Errorf("parsing %s: %w", p.Filename, err)
+		}
+		pkg.SetOriginalContent(content)
+		pkgs = append(pkgs, pkg)
+		components[pkg] = p.Component
+	}
+
+	if suite == "" {
+		repo := &deb.Repository{ArchiveInfo: info, Packages: pkgs, GPGKey: key}
+		_, err = repo.WriteToDir(tmp)
+	} else {
+		_, err = standardRepository(info, pkgs, components, key).WriteToDir(tmp)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dir, err := openRepoSource(tmp)
+	if err != nil {
+		return nil, 0, err
+	}
+	names, err := dir.names()
+	if err != nil {
+		return nil, 0, err
+	}
+	archive := &repoSource{files: make(map[string][]byte)}
+	for _, name := range names {
+		if archive.files[name], err = dir.read(name); err != nil {
+			return nil, 0, err
+		}
+	}
+	return archive, len(pkgs), nil
+}
+
+// standardRepository returns the standard repository of the packages, with one part per component
+// and architecture of info. Architecture independent packages are in every part of their component.
+func standardRepository(info deb.ArchiveInfo, pkgs []*deb.Package, components map[*deb.Package]string, key string) *deb.StandardRepository {
+	comps := strings.Fields(info.Components)
+	archs := strings.Fields(info.Architectures)
+	for _, pkg := range pkgs {
+		if c := components[pkg]; !slices.Contains(comps, c) {
+			comps = append(comps, c)
+		}
+		if a := pkg.Metadata.Architecture; a != "all" && !slices.Contains(archs, a) {
+			archs = append(archs, a)
+		}
+	}
+	info.Components = strings.Join(comps, " ")
+	info.Architectures = strings.Join(archs, " ")
+	std := &deb.StandardRepository{ArchiveInfo: info, GPGKey: key}
+	for _, comp := range comps {
+		for _, arch := range archs {
+			part := &deb.Repository{ArchiveInfo: deb.ArchiveInfo{Components: comp, Architectures: arch}}
+			for _, pkg := range pkgs {
+				if components[pkg] == comp && (pkg.Metadata.Architecture == arch || pkg.Metadata.Architecture == "all") {
+					part.Packages = append(part.Packages, pkg)
+				}
+			}
+			std.Parts = append(std.Parts, part)
+		}
+	}
+	return std
+}
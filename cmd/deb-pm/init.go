@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+	"github.com/etnz/apt-repo-builder/manifest"
+)
+
+// initConfig are the answers used to generate the starter files.
+type initConfig struct {
+	Name       string
+	Maintainer string
+	Layout     string
+	Suite      string
+	// Input is an optional .deb to start from: a path, a URL or a GitHub release asset.
+	Input string
+	// Upstream is the optional URL of an APT repository to import packages from.
+	Upstream string
+}
+
+// repositoryTemplate is the starter repository file.
+var repositoryTemplate = template.Must(template.New("repository.yml").Parse(`# yaml-language-server: $schema=https://raw.githubusercontent.com/etnz/apt-repo-builder/master/repository.schema.json
+
+# The directory of the generated repository. See the documentation for all the options:
+# https://github.com/etnz/apt-repo-builder/blob/master/Documentation.md
+path: "dist"
+
+defines:
+  VERSION: "0.1.0"
+
+meta_defaults:
+  Maintainer: "{{.Maintainer}}"
+{{- if eq .Layout "standard"}}
+
+layout: standard
+suite: "{{.Suite}}"
+components: ["main"]
+architectures: ["amd64", "arm64"]
+{{- end}}
+{{- if .Upstream}}
+
+upstream:
+  - url: "{{.Upstream}}"
+{{- if eq .Layout "standard"}}
+    suite: "{{.Suite}}"
+    components: ["main"]
+    architectures: ["amd64"]
+{{- end}}
+    packages: ["*"]
+{{- end}}
+
+packages:
+  - "{{.Name}}.yml"
+`))
+
+// packageTemplate is the starter package definition.
+var packageTemplate = template.Must(template.New("package.yml").Parse(`# yaml-language-server: $schema=https://raw.githubusercontent.com/etnz/apt-repo-builder/master/package.schema.json
+{{- if .Input}}
+
+# The package is built from this existing .deb file.
+input: "{{.Input}}"
+{{- end}}
+
+meta:
+  Package: "{{.Name}}"
+  Version: "{{"{{"}} .VERSION {{"}}"}}"
+  Architecture: "all"
+  Description: |
+    {{.Name}}
+    Describe your package here.
+
+injects:
+  - src: "files/README"
+    dst: "/usr/share/doc/{{.Name}}/README"
+`))
+
+// runInit executes the 'init' subcommand, which writes a starter repository and package definition.
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	dir := fs.String("dir", ".", "the `directory` where the files are written")
+	cfg := initConfig{}
+	fs.StringVar(&cfg.Name, "name", "", "the name of the sample package")
+	fs.StringVar(&cfg.Maintainer, "maintainer", "", "the maintainer of the packages, e.g. \"Jane Doe <jane@example.com>\"")
+	fs.StringVar(&cfg.Layout, "layout", "", "the repository layout: 'flat' or 'standard'")
+	fs.StringVar(&cfg.Suite, "suite", "", "the suite of a standard repository, e.g. bookworm")
+	fs.StringVar(&cfg.Input, "input", "", "an existing .deb to build the sample package from: a path, a URL, or a GitHub release asset (github.com/<owner>/<repo>/tags/<tag>/<asset>)")
+	fs.StringVar(&cfg.Upstream, "upstream", "", "the `URL` of an APT repository to import packages from")
+	yes := fs.Bool("y", false, "do not ask any question, use the defaults for the missing flags")
+	force := fs.Bool("force", false, "overwrite existing files")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm init [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+
+	// Questions are only asked in a terminal, scripts use the flags.
+	interactive := false
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && !*yes && !jsonOutput {
+		interactive = true
+	}
+	p := &prompter{in: bufio.NewScanner(os.Stdin), interactive: interactive}
+	p.ask(&cfg.Name, "Package name", filepath.Base(absDir(*dir)), deb.CheckPackageName)
+	p.ask(&cfg.Maintainer, "Maintainer", "Maintainer <maintainer@example.com>", nil)
+	p.ask(&cfg.Layout, "Layout (flat or standard)", "flat", checkLayout)
+	if cfg.Layout == "standard" {
+		p.ask(&cfg.Suite, "Suite", "stable", nil)
+	}
+	p.ask(&cfg.Input, "Existing .deb to start from (path, URL or GitHub release asset, empty for none)", "", checkInput)
+	p.ask(&cfg.Upstream, "Upstream APT repository URL (empty for none)", "", func(s string) error { return checkUpstream(s, cfg.Suite) })
+	if p.err != nil {
+		fatalf("%v", p.err)
+	}
+
+	files := map[string]*template.Template{
+		"repository.yml":  repositoryTemplate,
+		cfg.Name + ".yml": packageTemplate,
+	}
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(*dir, name)); err == nil && !*force {
+			fatalf("%s already exists, use -force to overwrite it", filepath.Join(*dir, name))
+		}
+	}
+	write := func(name string, content []byte) {
+		path := filepath.Join(*dir, name)
+		if err := writeFile(path, content, 0644); err != nil {
+			fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	for _, name := range []string{"repository.yml", cfg.Name + ".yml"} {
+		var b strings.Builder
+		if err := files[name].Execute(&b, cfg); err != nil {
+			fatalf("Failed to generate %s: %v", name, err)
+		}
+		write(name, []byte(b.String()))
+	}
+	readme := filepath.Join("files", "README")
+	if _, err := os.Stat(filepath.Join(*dir, readme)); err != nil || *force {
+		write(readme, []byte(fmt.Sprintf("%s {{ .VERSION }}\n", cfg.Name)))
+	}
+
+	if dryRun {
+		succeed("Dry run completed successfully, nothing was written.")
+		return
+	}
+	if err := manifest.Validate(filepath.Join(*dir, "repository.yml")); err != nil {
+		// e.g. an input or upstream that requires credentials: the files are still a good start.
+		slog.Warn("The generated repository is not valid yet", "error", err)
+	}
+	succeed(fmt.Sprintf("Repository initialized, build it with: deb-pm %s", filepath.Join(*dir, "repository.yml")))
+}
+
+// absDir returns the absolute path of dir, or dir itself.
+func absDir(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// prompter asks for the values that are not set by flags.
+type prompter struct {
+	in          *bufio.Scanner
+	interactive bool
+	// err is the first invalid value, in non interactive mode.
+	err error
+}
+
+// ask sets *value, if it is empty, to the answer of the user, or to def when there is no answer or no terminal.
+// Invalid answers are asked again. Values set by flags must be valid too.
+func (p *prompter) ask(value *string, question, def string, check func(string) error) {
+	if check == nil {
+		check = func(string) error { return nil }
+	}
+	if *value != "" || !p.interactive {
+		if *value == "" {
+			*value = def
+		}
+		if err := check(*value); err != nil && p.err == nil {
+			p.err = err
+		}
+		return
+	}
+	for {
+		if def != "" {
+			fmt.Printf("%s [%s]: ", question, def)
+		} else {
+			fmt.Printf("%s: ", question)
+		}
+		if !p.in.Scan() {
+			// End of input: the remaining values are the defaults.
+			if err := p.in.Err(); err != nil {
+				fatalf("%v", err)
+			}
+			fmt.Println()
+			p.interactive = false
+			p.ask(value, question, def, check)
+			return
+		}
+		answer := def
+		if s := strings.TrimSpace(p.in.Text()); s != "" {
+			answer = s
+		}
+		if err := check(answer); err != nil {
+			fmt.Printf("  %v\n", err)
+			continue
+		}
+		*value = answer
+		return
+	}
+}
+
+// checkLayout checks that layout is a repository layout.
+func checkLayout(layout string) error {
+	if layout != "flat" && layout != "standard" {
+		return fmt.Errorf("invalid layout %q, expected 'flat' or 'standard'", layout)
+	}
+	return nil
+}
+
+// checkInput checks that input is a local .deb file, a web URL or a GitHub release asset.
+func checkInput(input string) error {
+	switch {
+	case input == "":
+		return nil
+	case strings.HasPrefix(input, "github.com/"):
+		if !manifest.IsGitHubAsset(input) {
+			return fmt.Errorf("invalid GitHub release asset %q, expected github.com/<owner>/<repo>/tags/<tag>/<asset> or github.com/<owner>/<repo>/latest/<asset>", input)
+		}
+	case strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://"):
+		if _, err := url.ParseRequestURI(input); err != nil {
+			return fmt.Errorf("invalid URL %q: %v", input, err)
+		}
+	default:
+		if _, err := os.Stat(input); err != nil {
+			return fmt.Errorf("invalid input: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkUpstream checks that upstream is the URL of an APT repository. Its Release file is fetched,
+// a repository that cannot be reached is only reported, since it might require credentials or a network.
+func checkUpstream(upstream, suite string) error {
+	if upstream == "" {
+		return nil
+	}
+	u, err := url.ParseRequestURI(upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid upstream URL %q, expected an http or https URL", upstream)
+	}
+	release := strings.TrimSuffix(upstream, "/") + "/Release"
+	if suite != "" {
+		release = strings.TrimSuffix(upstream, "/") + "/dists/" + suite + "/Release"
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(release)
+	if err != nil {
+		slog.Warn("Upstream repository cannot be reached", "url", release, "error", err)
+		return nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Warn("Upstream repository has no Release file", "url", release, "status", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// runKeygen executes the 'keygen' subcommand, which creates a repository signing key.
+func runKeygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	name := fs.String("name", "", "the name of the key identity, e.g. the organization (required)")
+	email := fs.String("email", "", "the email of the key identity")
+	comment := fs.String("comment", "", "the comment of the key identity")
+	algorithm := fs.String("algorithm", deb.KeyAlgorithmRSA, "the key algorithm: 'rsa' or 'ed25519'")
+	bits := fs.Int("bits", 4096, "the size of RSA keys")
+	expireDays := fs.Int("expire-days", 0, "the validity of the key in days (0 for a key that never expires)")
+	output := fs.String("o", "private.asc", "write the ASCII-armored private key to this `file`; public.gpg and public.asc are written next to it")
+	publish := fs.String("publish", "", "also write public.gpg and public.asc to this repository `directory`")
+	force := fs.Bool("force", false, "overwrite an existing private key file")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm keygen -name <name> [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if *name == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		fatalf("%s already exists, use -force to overwrite it", *output)
+	}
+	if *expireDays < 0 {
+		fatalf("-expire-days must not be negative")
+	}
+
+	key, err := deb.GenerateKey(deb.KeyOptions{
+		Name:      *name,
+		Comment:   *comment,
+		Email:     *email,
+		Algorithm: *algorithm,
+		RSABits:   *bits,
+		Lifetime:  time.Duration(*expireDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		fatalf("Failed to generate key: %v", err)
+	}
+	if err := writeFile(*output, []byte(key), 0600); err != nil {
+		fatalf("Failed to write private key: %v", err)
+	}
+
+	dirs := []string{filepath.Dir(*output)}
+	if *publish != "" {
+		dirs = append(dirs, *publish)
+	}
+	for _, dir := range dirs {
+		for _, file := range []string{"public.gpg", "public.asc"} {
+			pub, err := deb.PublicKey(key, file == "public.asc")
+			if err != nil {
+				fatalf("Failed to export public key: %v", err)
+			}
+			if err := writeFile(filepath.Join(dir, file), pub, 0644); err != nil {
+				fatalf("Failed to write public key: %v", err)
+			}
+		}
+	}
+	if dryRun {
+		succeed("Dry run completed successfully, no key was written.")
+		return
+	}
+	succeed("Set the GPG_KEY environment variable (e.g. a CI secret) to the content of the private key to sign the repository.")
+}
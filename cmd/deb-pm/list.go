@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// defaultListFormat is the default template of the 'list' subcommand.
+const defaultListFormat = "{{.Name}} {{.Version}} {{.Architecture}} {{.Size}} {{.SHA256}}"
+
+// listedPackage is a package printed by the 'list' subcommand.
+type listedPackage struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+	// Size is the size of the package file in bytes.
+	Size int64 `json:"size"`
+	// SHA256 is the hex encoded SHA256 checksum of the package file.
+	SHA256 string `json:"sha256"`
+	// Filename is the path of the package file, relative to the repository root.
+	Filename string `json:"filename"`
+	// Suite and Component are empty in flat repositories.
+	Suite     string `json:"suite,omitempty"`
+	Component string `json:"component,omitempty"`
+	// Metadata holds all the control fields, for templates.
+	Metadata deb.Metadata `json:"-"`
+}
+
+// runList executes the 'list' subcommand, which prints the packages of a repository.
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	repo := fs.String("repo", "", "the repository `directory or repo.tar.gz` to list")
+	format := fs.String("format", defaultListFormat, "print each package with this Go `template` (fields: Name, Version, Architecture, Size, SHA256, Filename, Suite, Component, Metadata)")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm list -repo <repository directory or repo.tar.gz> [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if *repo == "" && fs.NArg() == 1 {
+		*repo = fs.Arg(0)
+	}
+	if *repo == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	tmpl, err := template.New("format").Parse(*format)
+	if err != nil {
+		fatalf("Invalid -format: %v", err)
+	}
+
+	source, err := openRepoSource(*repo)
+	if err != nil {
+		fatalf("Failed to open repository: %v", err)
+	}
+	pkgs, err := listPackages(source)
+	if err != nil {
+		fatalf("Failed to list packages: %v", err)
+	}
+
+	if jsonOutput {
+		for _, p := range pkgs {
+			printJSON("package", p)
+		}
+		succeed(fmt.Sprintf("%d package(s) listed.", len(pkgs)))
+		return
+	}
+	for _, p := range pkgs {
+		if err := tmpl.Execute(os.Stdout, p); err != nil {
+			fatalf("Failed to format %s: %v", p.Filename, err)
+		}
+		fmt.Println()
+	}
+}
+
+// packagesIndices are the names of the Packages indices, by order of preference.
+var packagesIndices = []string{"Packages", "Packages.gz", "Packages.xz", "Packages.zst"}
+
+// listPackages returns the packages of the Packages (or compressed Packages) indices of a flat or standard
+// repository, in index order. Packages listed by several indices (e.g. "all" packages) are returned once.
+func listPackages(source *repoSource) ([]listedPackage, error) {
+	names, err := source.names()
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []listedPackage
+	seen := make(map[string]bool)
+	for _, name := range names {
+		i := slices.Index(packagesIndices, path.Base(name))
+		if i < 0 {
+			continue
+		}
+		// Compressed indices are only read when there is no preferred one, e.g. from apt-ftparchive
+		// or from repositories with xz indices only.
+		if slices.ContainsFunc(packagesIndices[:i], func(index string) bool {
+			return slices.Contains(names, path.Join(path.Dir(name), index))
+		}) {
+			continue
+		}
+		// Flat repositories have a single index at the root,
+		// standard ones have dists/<suite>/<component>/binary-<arch>/Packages.
+		var suite, component string
+		if path.Dir(name) != "." {
+			parts := strings.Split(name, "/")
+			if len(parts) != 5 || parts[0] != "dists" || !strings.HasPrefix(parts[3], "binary-") {
+				continue
+			}
+			suite, component = parts[1], parts[2]
+		}
+		content, err := source.read(name)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = deb.DecompressIndex(name, content); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		entries, err := deb.ParsePackagesIndex(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", name, err)
+		}
+		for _, e := range entries {
+			key := suite + "/" + e.Filename
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			pkgs = append(pkgs, listedPackage{
+				Name:         e.Metadata.Package,
+				Version:      e.Metadata.Version,
+				Architecture: e.Metadata.Architecture,
+				Size:         e.Size,
+				SHA256:       e.SHA256,
+				Filename:     e.Filename,
+				Suite:        suite,
+				Component:    component,
+				Metadata:     e.Metadata,
+			})
+		}
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+func TestListPackagesXz(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "dists", "stable", "main", "binary-amd64")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	xw, err := xz.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xw.Write([]byte("Package: app\nVersion: 1.0\nArchitecture: amd64\nFilename: pool/main/a/app/app_1.0_amd64.deb\n"))
+	if err := xw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Packages.xz"), b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := openRepoSource(root)
+	if err != nil {
+		t.Fatalf("openRepoSource failed: %v", err)
+	}
+	pkgs, err := listPackages(source)
+	if err != nil {
+		t.Fatalf("listPackages failed: %v", err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Name != "app" || pkgs[0].Suite != "stable" || pkgs[0].Component != "main" {
+		t.Errorf("listPackages = %+v, want app in stable/main", pkgs)
+	}
+}
@@ -1,57 +1,341 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/etnz/apt-repo-builder/deb"
 	"github.com/etnz/apt-repo-builder/manifest"
 )
 
+// usage is the synopsis of the deb-pm commands.
+const usage = `Usage: deb-pm [flags] [Repository file]
+       deb-pm serve [flags] <repository directory or repo.tar.gz>
+       deb-pm dev [flags] [Repository file]
+       deb-pm list -repo <repository directory or repo.tar.gz> [flags]
+       deb-pm search [flags] <pattern> [Repository file]
+       deb-pm audit -repo <repository directory or repo.tar.gz> [flags]
+       deb-pm verify-published -to <URL or directory> [flags]
+       deb-pm sign -repo <repository directory or repo.tar.gz> [flags]
+       deb-pm keygen -name <name> [flags]
+       deb-pm init [flags]
+       deb-pm import -from <repository directory> -repo <repo.tar.gz> [flags]
+       deb-pm export -repo <repo.tar.gz> -to <directory> [flags]
+       deb-pm pack -from <directory> -repo <repo.tar.gz> [flags]
+       deb-pm prune [-github owner/repo] [Repository file]
+`
+
+// subcommands are the commands other than build, by name. They receive their arguments.
+var subcommands = map[string]func(args []string){
+	"serve":            runServe,
+	"dev":              runDev,
+	"list":             runList,
+	"search":           runSearch,
+	"verify-published": runVerifyPublished,
+	"audit":            runAudit,
+	"sign":             runSign,
+	"keygen":           runKeygen,
+	"init":             runInit,
+	"import":           runImport,
+	"export":           runExport,
+	"pack":             runPack,
+	"prune":            runPrune,
+}
+
+// subcommand returns the name and the arguments of the subcommand of args, which can be preceded by
+// global flags, e.g. "deb-pm -log-format json list". ok is false if args are the ones of a build.
+func subcommand(args []string) (name string, rest []string, ok bool) {
+	global := flag.NewFlagSet("deb-pm", flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	addGlobalFlags(global)
+	if err := global.Parse(args); err != nil {
+		// e.g. a build flag, reported by the build.
+		return "", nil, false
+	}
+	if _, ok := subcommands[global.Arg(0)]; !ok {
+		return "", nil, false
+	}
+	return global.Arg(0), global.Args()[1:], true
+}
+
 // main is the entry point for the deb-pm CLI tool.
 func main() {
-	if len(os.Args) < 2 {
-		for _, name := range []string{"repository.yml", "repository.yaml", "repository.json"} {
-			if _, err := os.Stat(name); err == nil {
-				runBuild(name)
-				return
-			}
+	args := os.Args[1:]
+	if name, rest, ok := subcommand(args); ok {
+		commandName = name
+		subcommands[name](rest)
+		return
+	}
+
+	validate := flag.Bool("validate", false, "check the repository file and all its packages, without running hooks or commands, nor writing anything")
+	jobs := flag.Int("j", 0, "maximum number of packages built concurrently (defaults to the number of CPUs)")
+	plan := flag.Bool("plan", false, "build all the packages and report what would change in the repository, without writing anything")
+	schema := flag.String("schema", "", "print the JSON Schema of 'repository' or 'package' files, and exit")
+	cacheDir := flag.String("cache", "", "cache web resources in this directory, and revalidate them on the next builds")
+	offline := flag.Bool("offline", false, "only use the web resources of the -cache directory, without network requests")
+	allowExec := flag.Bool("allow-exec", false, "allow package files to run their 'exec' command")
+	lockFile := flag.String("lock", "", "record every web resource used by the build (URL, size, SHA256) in this lock file")
+	frozen := flag.Bool("frozen", false, "fail if a web resource is not in the -lock file, or differs from it")
+	continueOnError := flag.Bool("continue-on-error", false, "build and publish every package that can be built, and report the failing ones at the end")
+	lint := flag.Bool("lint", false, "check the built packages for policy violations, and fail the packages with errors")
+	var hashes hashesFlag
+	flag.Var(&hashes, "hashes", "list the package files and indices with these comma separated `checksums` (MD5Sum, SHA1, SHA256, SHA512), defaults to SHA256")
+	var compressions compressionsFlag
+	flag.Var(&compressions, "index-compressions", "write the Packages and Sources indices with these comma separated `compressions` (gzip, xz, zstd) too, defaults to gzip")
+	metricsFile := flag.String("metrics", "", "write the build metrics to this `file`, in the Prometheus text format, even if the build fails")
+	overrides := make(setFlag)
+	flag.Var(overrides, "set", "override a define with `key=value`, taking precedence over the repository file (repeatable)")
+	addGlobalFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+	setupLogging()
+
+	switch *schema {
+	case "":
+	case "repository":
+		os.Stdout.Write(manifest.RepositorySchema())
+		return
+	case "package":
+		os.Stdout.Write(manifest.PackageSchema())
+		return
+	default:
+		fatalf("unknown schema %q, expected 'repository' or 'package'", *schema)
+	}
+
+	path := flag.Arg(0)
+	if path == "" {
+		path = defaultRepositoryFile()
+	}
+	if path == "" {
+		fatalf("Usage: deb-pm [flags] [Repository file]")
+	}
+
+	opts := manifest.CompileOptions{
+		GPGKey:            os.Getenv("GPG_KEY"),
+		Parallelism:       *jobs,
+		CacheDir:          *cacheDir,
+		Offline:           *offline,
+		AllowExec:         *allowExec,
+		LockFile:          *lockFile,
+		Frozen:            *frozen,
+		ContinueOnError:   *continueOnError,
+		Logger:            slog.Default(),
+		Hashes:            hashes,
+		IndexCompressions: compressions,
+		Lint:              *lint,
+	}
+	switch {
+	case *validate && (*plan || dryRun):
+		fatalf("-validate cannot be used with -plan or -dry-run")
+	case *validate:
+		opts.Mode = manifest.ModeValidate
+	case *plan || dryRun:
+		opts.Mode = manifest.ModePlan
+	}
+	if *metricsFile != "" {
+		opts.Metrics = &manifest.Prometheus{Namespace: "deb_pm"}
+	}
+	runBuild(path, overrides, opts, *metricsFile)
+}
+
+// interruptible returns a context canceled by the first interrupt signal (e.g. Ctrl-C), so that
+// the running command stops cleanly. The next signal kills the process.
+func interruptible() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx
+}
+
+// setFlag collects the -set key=value flags.
+type setFlag map[string]string
+
+func (s setFlag) String() string { return fmt.Sprint(map[string]string(s)) }
+
+func (s setFlag) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", v)
+	}
+	s[key] = value
+	return nil
+}
+
+// hashesFlag collects the -hashes flag.
+type hashesFlag []deb.Hash
+
+func (h *hashesFlag) String() string {
+	var names []string
+	for _, hash := range *h {
+		names = append(names, hash.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (h *hashesFlag) Set(v string) error {
+	*h = nil
+	for name := range strings.SplitSeq(v, ",") {
+		hash, err := deb.HashByName(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		*h = append(*h, hash)
+	}
+	return nil
+}
+
+// compressionsFlag collects the -index-compressions flag.
+type compressionsFlag []deb.Compression
+
+func (c *compressionsFlag) String() string {
+	var names []string
+	for _, compression := range *c {
+		names = append(names, string(compression))
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *compressionsFlag) Set(v string) error {
+	*c = nil
+	for name := range strings.SplitSeq(v, ",") {
+		compression, err := deb.ParseCompression(strings.TrimSpace(name))
+		if err != nil {
+			return err
 		}
-		log.Fatal("Usage: deb-pm [Repository file]")
-	} else {
-		runBuild(os.Args[1])
+		*c = append(*c, compression)
 	}
+	return nil
 }
 
 // runBuild executes the 'build' subcommand, which processes a manifest file.
-func runBuild(path string) {
+// The metrics of opts, if any, are written to metricsFile.
+func runBuild(path string, overrides map[string]string, opts manifest.CompileOptions, metricsFile string) {
 
-	repository, err := manifest.NewRepository(path)
+	repository, err := manifest.NewRepositoryWithOverrides(path, overrides)
 	if err != nil {
-		log.Fatalf("Failed to load archivefile: %v", err)
+		fatalf("Failed to load archivefile: %v", err)
 	}
 
-	gpgKey := os.Getenv("GPG_KEY")
-	if err := repository.Compile(gpgKey, func(e fmt.Stringer) {
-		switch v := e.(type) {
-		case manifest.EventRepositoryLoadSuccess:
-			fmt.Printf("Loaded repository from %s\n", v.Path)
-		case manifest.EventPackageApplySuccess:
-			if v.Package != "" {
-				fmt.Printf("Applied package: %s (%s) [%s]\n", v.Package, v.Version, v.Architecture)
-			}
-		case manifest.EventFileOperation:
-			symbol := "="
-			if v.Created {
-				symbol = "+"
-			} else if v.Updated {
-				symbol = "~"
-			}
-			fmt.Printf(" %s %s\n", symbol, v.Path)
+	err = repository.CompileContext(interruptible(), opts, printEvent)
+	if p, ok := opts.Metrics.(*manifest.Prometheus); ok {
+		if werr := writeMetrics(metricsFile, p); werr != nil {
+			slog.Warn("Failed to write metrics", "file", metricsFile, "error", werr)
 		}
-	}); err != nil {
-		log.Fatalf("Failed to compile repository: %v", err)
+	}
+	if err != nil {
+		if opts.Mode == manifest.ModeValidate {
+			fatalf("Repository is not valid:\n%v", err)
+		}
+		fatalf("Failed to compile repository: %v", err)
 	}
 
-	fmt.Println("Build completed successfully.")
+	switch opts.Mode {
+	case manifest.ModeValidate:
+		succeed("Repository is valid.")
+		return
+	case manifest.ModePlan:
+		succeed("Plan completed successfully, nothing was written.")
+		return
+	}
+	succeed("Build completed successfully.")
+}
+
+// writeMetrics writes the metrics to path atomically, so that a collector (e.g. the textfile
+// collector of the node exporter) never reads a partial file.
+func writeMetrics(path string, p *manifest.Prometheus) error {
+	var b bytes.Buffer
+	p.WriteTo(&b)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// printEvent prints a build event.
+func printEvent(e fmt.Stringer) {
+	if jsonOutput {
+		// Events are JSON objects with their type as the only key.
+		fmt.Println(e.String())
+		return
+	}
+	// Progress is logged, the plan and the file operations are the command output.
+	if v, ok := e.(manifest.EventProgress); ok {
+		showProgress(v.Progress)
+		return
+	}
+	clearProgress()
+	switch v := e.(type) {
+	case manifest.EventRepositoryLoadSuccess:
+		slog.Info("Loaded repository", "path", v.Path)
+	case manifest.EventPackageBuildStarted:
+		slog.Debug("Building package", "file", v.FilePath)
+	case manifest.EventPackageBuildFinished:
+		if v.Error != "" {
+			slog.Debug("Failed to build package", "file", v.FilePath, "duration", v.Duration, "error", v.Error)
+			break
+		}
+		slog.Debug("Built package", "file", v.FilePath, "package", v.Package, "version", v.Version, "architecture", v.Architecture,
+			"files", v.Files, "installed_size", v.InstalledSize, "duration", v.Duration)
+	case manifest.EventResourceFetched:
+		slog.Debug("Fetched resource", "url", v.URL, "size", v.Size, "cached", v.Cached, "duration", v.Duration)
+	case manifest.EventPackageApplySuccess:
+		if v.Package != "" {
+			slog.Info("Applied package", "package", v.Package, "version", v.Version, "architecture", v.Architecture)
+		}
+	case manifest.EventRepositorySaveSuccess:
+		slog.Info("Saved repository", "path", v.Path, "duration", v.Duration.Round(time.Millisecond))
+	case manifest.EventPackageFailure:
+		slog.Error("Failed package", "file", v.FilePath, "error", v.Error)
+	case manifest.EventPackageLint:
+		level := slog.LevelWarn
+		if v.Severity == string(deb.SeverityError) {
+			level = slog.LevelError
+		}
+		slog.Log(context.Background(), level, "Lint "+v.Severity, "package", v.Package, "tag", v.Tag, "path", v.Path, "message", v.Message)
+	case manifest.EventUpstreamImport:
+		slog.Info("Imported package", "package", v.Package, "version", v.Version, "architecture", v.Architecture, "url", v.URL)
+	case manifest.EventPackagePrune:
+		slog.Info("Pruned package", "package", v.Package, "version", v.Version, "architecture", v.Architecture, "reason", v.Reason)
+	case manifest.EventPackagePlan:
+		switch v.Action {
+		case manifest.PlanBump:
+			fmt.Printf("Plan: bump %s (%s -> %s) [%s]\n", v.Package, v.Previous, v.Version, v.Architecture)
+			for _, c := range v.Changes {
+				fmt.Printf("    %s\n", c)
+			}
+		default:
+			fmt.Printf("Plan: %s %s (%s) [%s]\n", v.Action, v.Package, v.Version, v.Architecture)
+		}
+	case manifest.EventPackageOutput:
+		symbol := "="
+		if v.Created {
+			symbol = "+"
+		} else if v.Updated {
+			symbol = "~"
+		}
+		fmt.Printf(" %s %s\n", symbol, v.Path)
+	case manifest.EventFileOperation:
+		symbol := "="
+		if v.Created {
+			symbol = "+"
+		} else if v.Updated {
+			symbol = "~"
+		}
+		fmt.Printf(" %s %s\n", symbol, v.Path)
+	}
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSubcommand(t *testing.T) {
+	defer func(format string, j bool) { logFormat, jsonOutput = format, j }(logFormat, jsonOutput)
+	for _, tt := range []struct {
+		args []string
+		name string
+		rest []string
+	}{
+		{[]string{"-log-format", "json", "list", "-repo", "X"}, "list", []string{"-repo", "X"}},
+		{[]string{"-json", "audit"}, "audit", []string{}},
+		{[]string{"serve", "-json", "repo"}, "serve", []string{"-json", "repo"}},
+		{[]string{"-json", "repository.yml"}, "", nil},
+		{[]string{"-j", "4", "list"}, "", nil},
+		{nil, "", nil},
+	} {
+		name, rest, ok := subcommand(tt.args)
+		if name != tt.name || ok != (tt.name != "") || !slices.Equal(rest, tt.rest) {
+			t.Errorf("subcommand(%q) = %q, %q, %v, want %q, %q", tt.args, name, rest, ok, tt.name, tt.rest)
+		}
+	}
+	if logFormat != "json" {
+		t.Errorf("the global -log-format flag was not parsed: %q", logFormat)
+	}
+}
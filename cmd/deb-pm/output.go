@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// jsonOutput makes the commands print JSON objects, one per line, instead of text:
+// the build events (see the manifest Event types), the command specific results,
+// and a final {"result": ...} object.
+var jsonOutput bool
+
+// dryRun makes the commands report the changes they would make (packages added or removed,
+// files written, release assets deleted...) without making them.
+var dryRun bool
+
+// Logging flags, see setupLogging.
+var (
+	verbose   bool
+	quiet     bool
+	logFormat = "text"
+)
+
+// addGlobalFlags registers the flags shared by every command on fs.
+// Their current values are the defaults, so that they can be set before and after the subcommand name.
+func addGlobalFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&jsonOutput, "json", jsonOutput, "print the events and the results as JSON objects, one per line")
+	fs.BoolVar(&dryRun, "dry-run", dryRun, "report the changes (packages, files, release assets) without making them")
+	fs.BoolVar(&verbose, "v", verbose, "log debug messages too, e.g. the URL and timing of every network request")
+	fs.BoolVar(&quiet, "q", quiet, "only log warnings and errors")
+	fs.StringVar(&logFormat, "log-format", logFormat, "the format of the log messages, written to the standard error: 'text' or 'json'")
+}
+
+// setupLogging configures the default slog logger from the global flags.
+func setupLogging() {
+	level := slog.LevelInfo
+	switch {
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelWarn
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		// Timestamps are noise in a terminal, CI systems add their own.
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		fatalf("unknown -log-format %q, expected 'text' or 'json'", logFormat)
+	}
+	if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		progressTerminal = !quiet && !verbose && !jsonOutput
+	}
+}
+
+// progressTerminal shows the progress events on the standard error, a terminal. See showProgress.
+var progressTerminal bool
+
+// progressShown reports that a progress line is on the terminal.
+var progressShown bool
+
+// progressWidth is the maximum length of the progress line, so that it never wraps.
+const progressWidth = 79
+
+// showProgress replaces the progress line of the terminal, if any, with p.
+func showProgress(p deb.Progress) {
+	if !progressTerminal {
+		return
+	}
+	line := p.Stage + " " + p.Item
+	if p.TotalItems > 0 {
+		line = fmt.Sprintf("[%d/%d] %s", p.Items, p.TotalItems, line)
+	}
+	size := fmt.Sprintf(" %d B", p.Bytes)
+	if !p.Done() && p.TotalBytes > 0 {
+		size = fmt.Sprintf(" %d%%", p.Bytes*100/p.TotalBytes)
+	}
+	if len(line)+len(size) > progressWidth {
+		line = line[:max(0, progressWidth-len(size)-3)] + "..."
+	}
+	fmt.Fprint(os.Stderr, "\r\033[K"+line+size)
+	progressShown = true
+}
+
+// clearProgress removes the progress line from the terminal, before anything else is printed.
+func clearProgress() {
+	if progressShown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		progressShown = false
+	}
+}
+
+// commandName is the name of the running command, reported in the final result.
+var commandName = "build"
+
+// commandResult is the final JSON object printed by a command.
+type commandResult struct {
+	Command string `json:"command"`
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// printJSON prints v as a single line of JSON, wrapped in an object with key as the only field.
+func printJSON(key string, v any) {
+	b, err := json.Marshal(map[string]any{key: v})
+	if err != nil {
+		fatalf("%v", err)
+	}
+	os.Stdout.Write(append(b, '\n'))
+}
+
+// succeed reports the success of the command.
+func succeed(message string) {
+	clearProgress()
+	if jsonOutput {
+		printJSON("result", commandResult{Command: commandName, Success: true, Message: message})
+		return
+	}
+	fmt.Println(message)
+}
+
+// fatalf reports the failure of the command, and exits with a non zero status.
+func fatalf(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	clearProgress()
+	if jsonOutput {
+		printJSON("result", commandResult{Command: commandName, Error: message})
+	} else {
+		slog.Error(message)
+	}
+	os.Exit(1)
+}
+
+// writtenFile reports a file written by a command, in JSON mode.
+type writtenFile struct {
+	Path    string `json:"path"`
+	Created bool   `json:"created,omitempty"`
+	Updated bool   `json:"updated,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+}
+
+// writeFile writes content to the file at path, creating its directory, and reports it
+// like the build file operations: '+' created, '~' updated, '=' unchanged.
+// With -dry-run, the file is only reported.
+func writeFile(path string, content []byte, perm os.FileMode) error {
+	old, err := os.ReadFile(path)
+	w := writtenFile{Path: path, Created: errors.Is(err, fs.ErrNotExist), DryRun: dryRun}
+	w.Updated = !w.Created && (err != nil || !bytes.Equal(old, content))
+	if (w.Created || w.Updated) && !dryRun {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, content, perm); err != nil {
+			return err
+		}
+	}
+	reportFile(w)
+	return nil
+}
+
+// reportFile reports a file written by a command.
+func reportFile(w writtenFile) {
+	if jsonOutput {
+		printJSON("file", w)
+		return
+	}
+	symbol := "="
+	if w.Created {
+		symbol = "+"
+	} else if w.Updated {
+		symbol = "~"
+	}
+	fmt.Printf(" %s %s\n", symbol, w.Path)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+	"github.com/etnz/apt-repo-builder/manifest"
+)
+
+// runPrune executes the 'prune' subcommand, which applies the retention to a published repository,
+// and deletes the obsolete package files from GitHub releases.
+func runPrune(args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	github := fs.String("github", "", "also delete the obsolete .deb assets from the releases of this GitHub `owner/repo`, using the GITHUB_TOKEN environment variable")
+	var hashes hashesFlag
+	fs.Var(&hashes, "hashes", "list the package files and indices with these comma separated `checksums` (MD5Sum, SHA1, SHA256, SHA512), defaults to SHA256")
+	var compressions compressionsFlag
+	fs.Var(&compressions, "index-compressions", "write the Packages and Sources indices with these comma separated `compressions` (gzip, xz, zstd) too, defaults to gzip")
+	overrides := make(setFlag)
+	fs.Var(overrides, "set", "override a define with `key=value`, taking precedence over the repository file (repeatable)")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm prune [flags] [Repository file]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+
+	path := fs.Arg(0)
+	if path == "" {
+		path = defaultRepositoryFile()
+	}
+	if path == "" {
+		fatalf("Usage: deb-pm prune [flags] [Repository file]")
+	}
+	var gh *githubReleases
+	if *github != "" {
+		owner, repo, ok := strings.Cut(*github, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			fatalf("invalid -github %q, expected owner/repo", *github)
+		}
+		gh = &githubReleases{
+			api:    strings.TrimSuffix(cmp.Or(os.Getenv("GITHUB_API_URL"), "https://api.github.com"), "/"),
+			owner:  owner,
+			repo:   repo,
+			token:  os.Getenv("GITHUB_TOKEN"),
+			client: &http.Client{Timeout: time.Minute},
+		}
+	}
+
+	repository, err := manifest.NewRepositoryWithOverrides(path, overrides)
+	if err != nil {
+		fatalf("Failed to load archivefile: %v", err)
+	}
+	opts := manifest.CompileOptions{GPGKey: os.Getenv("GPG_KEY"), Logger: slog.Default(), Hashes: hashes, IndexCompressions: compressions}
+	if dryRun {
+		opts.Mode = manifest.ModePlan
+	}
+	var pruned []manifest.EventPackagePrune
+	kept, err := repository.PruneContext(interruptible(), opts, func(e fmt.Stringer) {
+		if p, ok := e.(manifest.EventPackagePrune); ok {
+			pruned = append(pruned, p)
+		}
+		printEvent(e)
+	})
+	if err != nil {
+		fatalf("Failed to prune repository: %v", err)
+	}
+
+	if gh != nil {
+		obsolete := obsoleteAssets(kept, pruned)
+		if err := gh.prune(obsolete, dryRun); err != nil {
+			fatalf("Failed to prune GitHub release assets: %v", err)
+		}
+	}
+	if dryRun {
+		succeed("Dry run completed successfully, nothing was removed.")
+		return
+	}
+	succeed("Prune completed successfully.")
+}
+
+// defaultRepositoryFile returns the repository file of the current directory, or "" if there is none.
+func defaultRepositoryFile() string {
+	for _, name := range []string{"repository.yml", "repository.yaml", "repository.json"} {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// obsoleteAssets returns a function reporting whether a release asset, by name, is an obsolete package file:
+// the file of a package pruned from the repository, matched by its exact name, unless a kept package
+// still uses it. Other assets, e.g. binaries, or packages that this repository did not prune, are never obsolete.
+func obsoleteAssets(kept []*deb.Package, pruned []manifest.EventPackagePrune) func(name string) bool {
+	files := make(map[string]bool)
+	for _, p := range pruned {
+		if p.Path != "" {
+			files[filepath.Base(p.Path)] = true
+		}
+	}
+	for _, pkg := range kept {
+		delete(files, pkg.StandardFilename())
+	}
+	return func(asset string) bool {
+		return files[asset]
+	}
+}
+
+// githubReleases deletes the assets of the releases of a GitHub repository.
+type githubReleases struct {
+	api, owner, repo, token string
+	client                  *http.Client
+}
+
+// githubRelease is a release, as returned by the GitHub API.
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	} `json:"assets"`
+}
+
+// prunedAsset reports a deleted release asset, in JSON mode.
+type prunedAsset struct {
+	Release string `json:"release"`
+	Name    string `json:"name"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+}
+
+// prune deletes the assets of every release for which obsolete returns true. With dryRun, they are only reported.
+func (g *githubReleases) prune(obsolete func(name string) bool, dryRun bool) error {
+	for page := 1; ; page++ {
+		var releases []githubRelease
+		url := fmt.Sprintf("%s/repos/%s/%s/releases?per_page=100&page=%d", g.api, g.owner, g.repo, page)
+		if err := g.do(http.MethodGet, url, &releases); err != nil {
+			return err
+		}
+		for _, r := range releases {
+			for _, a := range r.Assets {
+				if !obsolete(a.Name) {
+					continue
+				}
+				if !dryRun {
+					url := fmt.Sprintf("%s/repos/%s/%s/releases/assets/%d", g.api, g.owner, g.repo, a.ID)
+					if err := g.do(http.MethodDelete, url, nil); err != nil {
+						return fmt.Errorf("deleting %s from release %s: %w", a.Name, r.TagName, err)
+					}
+				}
+				if jsonOutput {
+					printJSON("asset", prunedAsset{Release: r.TagName, Name: a.Name, DryRun: dryRun})
+				} else if dryRun {
+					fmt.Printf(" - %s (release %s, dry run)\n", a.Name, r.TagName)
+				} else {
+					fmt.Printf(" - %s (release %s)\n", a.Name, r.TagName)
+				}
+			}
+		}
+		if len(releases) < 100 {
+			return nil
+		}
+	}
+}
+
+// do sends an API request, and decodes the JSON response into v, if not nil.
+func (g *githubReleases) do(method, url string, v any) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+	start := time.Now()
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	slog.Debug(method, "url", url, "status", resp.StatusCode, "duration", time.Since(start))
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
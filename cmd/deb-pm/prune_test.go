@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/etnz/apt-repo-builder/deb"
+	"github.com/etnz/apt-repo-builder/manifest"
+)
+
+func TestPruneAssets(t *testing.T) {
+	assets := map[int64]string{
+		1: "app_1.0-1_amd64.deb", // pruned
+		2: "app_2.0-1_amd64.deb", // kept
+		3: "app_0.9-1_amd64.deb", // published by another repository
+		4: "app_1.0-1_arm64.deb", // not pruned by this index
+		5: "app-linux-amd64",     // not a package
+	}
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/releases":
+			release := map[string]any{"tag_name": "v1"}
+			var list []map[string]any
+			for id := int64(1); id <= int64(len(assets)); id++ {
+				list = append(list, map[string]any{"id": id, "name": assets[id]})
+			}
+			release["assets"] = list
+			json.NewEncoder(w).Encode([]any{release})
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/releases/assets/"):
+			id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/releases/assets/"), 10, 64)
+			deleted = append(deleted, assets[id])
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	kept := []*deb.Package{{Metadata: deb.Metadata{Package: "app", Version: "2.0-1", Architecture: "amd64"}}}
+	pruned := []manifest.EventPackagePrune{{Package: "app", Version: "1.0-1", Architecture: "amd64", Path: "/srv/repo/app_1.0-1_amd64.deb"}}
+	gh := &githubReleases{api: server.URL, owner: "owner", repo: "repo", client: server.Client()}
+	if err := gh.prune(obsoleteAssets(kept, pruned), false); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if want := []string{"app_1.0-1_amd64.deb"}; !slices.Equal(deleted, want) {
+		t.Errorf("deleted %q, want %q", deleted, want)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/template"
+
+	"github.com/etnz/apt-repo-builder/manifest"
+)
+
+// defaultSearchFormat is the default template of the 'search' subcommand.
+const defaultSearchFormat = "{{.Package}} {{.Version}} {{.Architecture}} {{.Origin}} {{.URL}}"
+
+// runSearch executes the 'search' subcommand, which finds packages in a repository and its upstreams.
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	format := fs.String("format", defaultSearchFormat, "print each package with this Go `template` (fields: Package, Version, Architecture, Synopsis, Provides, Origin, URL)")
+	cacheDir := fs.String("cache", "", "cache the upstream indices in this directory, and revalidate them on the next searches")
+	offline := fs.Bool("offline", false, "only use the upstream indices of the -cache directory, without network requests")
+	overrides := make(setFlag)
+	fs.Var(overrides, "set", "override a define with `key=value`, taking precedence over the repository file (repeatable)")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm search [flags] <pattern> [Repository file]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	tmpl, err := template.New("format").Parse(*format)
+	if err != nil {
+		fatalf("Invalid -format: %v", err)
+	}
+	path := fs.Arg(1)
+	if path == "" {
+		path = defaultRepositoryFile()
+	}
+	if path == "" {
+		fatalf("Usage: deb-pm search [flags] <pattern> [Repository file]")
+	}
+
+	repository, err := manifest.NewRepositoryWithOverrides(path, overrides)
+	if err != nil {
+		fatalf("Failed to load archivefile: %v", err)
+	}
+	results, err := repository.SearchContext(interruptible(), fs.Arg(0), manifest.CompileOptions{CacheDir: *cacheDir, Offline: *offline, Logger: slog.Default()})
+	if err != nil {
+		fatalf("Failed to search packages: %v", err)
+	}
+
+	if jsonOutput {
+		for _, r := range results {
+			printJSON("package", r)
+		}
+		succeed(fmt.Sprintf("%d package(s) found.", len(results)))
+		return
+	}
+	for _, r := range results {
+		if err := tmpl.Execute(os.Stdout, r); err != nil {
+			fatalf("Failed to format %s: %v", r.Package, err)
+		}
+		fmt.Println()
+	}
+	if len(results) == 0 {
+		fatalf("No package matches %q", fs.Arg(0))
+	}
+}
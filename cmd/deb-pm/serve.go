@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// contentTypes are the content types of the repository files, by suffix. The first match wins.
+var contentTypes = []struct{ suffix, contentType string }{
+	{".deb", "application/vnd.debian.binary-package"},
+	{".gz", "application/gzip"},
+	{".xz", "application/x-xz"},
+	{".zst", "application/zstd"},
+	{"Release.gpg", "application/pgp-signature"},
+	{".gpg", "application/pgp-keys"},
+	{".asc", "application/pgp-keys"},
+	{"InRelease", "text/plain; charset=utf-8"},
+	{"Release", "text/plain; charset=utf-8"},
+	{"Packages", "text/plain; charset=utf-8"},
+	{"Sources", "text/plain; charset=utf-8"},
+	{"Translation-en", "text/plain; charset=utf-8"},
+}
+
+// contentType returns the content type of a repository file.
+func contentType(name string) string {
+	for _, c := range contentTypes {
+		if strings.HasSuffix(name, c.suffix) {
+			return c.contentType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// runServe executes the 'serve' subcommand, which serves a repository over HTTP.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	auth := fs.String("auth", "", "require HTTP basic authentication with `user:password`")
+	addGlobalFlags(fs)
+	sign := fs.Bool("sign", false, "sign the Release files on the fly with the GPG_KEY environment variable (InRelease and Release.gpg), and serve public.asc and public.gpg")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm serve [flags] <repository directory or repo.tar.gz>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	src := fs.Arg(0)
+	source, err := openRepoSource(src)
+	if err != nil {
+		fatalf("Failed to open repository: %v", err)
+	}
+	s := &repoServer{read: source.read}
+	if *auth != "" {
+		user, password, ok := strings.Cut(*auth, ":")
+		if !ok {
+			fatalf("-auth must be user:password")
+		}
+		s.user, s.password = user, password
+	}
+	if *sign {
+		if s.key = os.Getenv("GPG_KEY"); s.key == "" {
+			fatalf("-sign requires the GPG_KEY environment variable")
+		}
+	}
+
+	slog.Info("Serving repository", "source", src, "url", "http://"+*addr+"/")
+	fatalf("%v", http.ListenAndServe(*addr, s))
+}
+
+// repoServer serves the files of a repository.
+type repoServer struct {
+	// read returns the content of a file, by slash separated path relative to the repository root.
+	read func(name string) ([]byte, error)
+	// user and password, if set, are required with HTTP basic authentication.
+	user, password string
+	// key, if set, signs the Release files on the fly.
+	key string
+}
+
+// servedRequest is a request logged in JSON mode.
+type servedRequest struct {
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Status   int           `json:"status"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (s *repoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	s.serve(sw, r)
+	status := sw.Status()
+	if jsonOutput {
+		printJSON("request", servedRequest{Method: r.Method, Path: r.URL.Path, Status: status, Duration: time.Since(start)})
+		return
+	}
+	slog.Info("Served request", "method", r.Method, "path", r.URL.Path, "status", status, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// statusWriter is a http.ResponseWriter recording the response status,
+// e.g. the 206, 304 or 416 responses of http.ServeContent.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Status returns the status of the response, 200 if nothing was written.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// serve serves a request.
+func (s *repoServer) serve(w http.ResponseWriter, r *http.Request) {
+	if s.user != "" {
+		user, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="deb-pm"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	content, err := s.content(r.Context(), name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType(name))
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(content))
+}
+
+// content returns the content of a repository file. With a signing key, the InRelease and Release.gpg
+// files and public keys are generated instead of read.
+func (s *repoServer) content(ctx context.Context, name string) ([]byte, error) {
+	if s.key == "" {
+		return s.read(name)
+	}
+	switch dir, base := path.Split(name); base {
+	case "InRelease", "Release.gpg":
+		release, err := s.read(dir + "Release")
+		if err != nil {
+			return nil, err
+		}
+		if base == "Release.gpg" {
+			return deb.DetachSignContext(ctx, release, s.key)
+		}
+		return deb.ClearSignContext(ctx, release, s.key)
+	case "public.asc", "public.gpg":
+		if dir != "" {
+			return nil, os.ErrNotExist
+		}
+		return deb.PublicKey(s.key, base == "public.asc")
+	}
+	return s.read(name)
+}
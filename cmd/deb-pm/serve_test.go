@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	release := []byte("Origin: test\nSHA256:\n")
+	if err := os.WriteFile(filepath.Join(dir, "Release"), release, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	key, err := deb.GenerateKey(deb.KeyOptions{Name: "Test", Algorithm: deb.KeyAlgorithmEd25519})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	pub, err := deb.PublicKey(key, true)
+	if err != nil {
+		t.Fatalf("PublicKey failed: %v", err)
+	}
+	source, err := openRepoSource(dir)
+	if err != nil {
+		t.Fatalf("openRepoSource failed: %v", err)
+	}
+	s := &repoServer{read: source.read, key: key}
+
+	get := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.serve(w, httptest.NewRequest(http.MethodGet, name, nil))
+		return w
+	}
+	if w := get("/"); w.Code != http.StatusNotFound {
+		t.Errorf("GET / = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w := get("/Release.gpg")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /Release.gpg = %d, want %d", w.Code, http.StatusOK)
+	}
+	if err := deb.VerifyDetached(release, w.Body.Bytes(), pub); err != nil {
+		t.Errorf("Release.gpg does not sign Release: %v", err)
+	}
+	w = get("/InRelease")
+	if _, err := deb.VerifyClearSigned(w.Body.Bytes(), pub); err != nil {
+		t.Errorf("InRelease is not signed: %v", err)
+	}
+}
+
+func TestServeStatus(t *testing.T) {
+	defer func(logger *slog.Logger, j bool) { slog.SetDefault(logger); jsonOutput = j }(slog.Default(), jsonOutput)
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	jsonOutput = false
+
+	s := &repoServer{read: func(name string) ([]byte, error) {
+		if name != "Sources" {
+			return nil, os.ErrNotExist
+		}
+		return []byte("Package: app\n"), nil
+	}}
+	for _, tt := range []struct {
+		path, rangeHeader string
+		want              int
+	}{
+		{"/Sources", "", http.StatusOK},
+		{"/Sources", "bytes=0-6", http.StatusPartialContent},
+		{"/Sources", "bytes=100-", http.StatusRequestedRangeNotSatisfiable},
+		{"/Packages", "", http.StatusNotFound},
+	} {
+		logs.Reset()
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.rangeHeader != "" {
+			r.Header.Set("Range", tt.rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("GET %s (Range %q) = %d, want %d", tt.path, tt.rangeHeader, w.Code, tt.want)
+		}
+		if want := "status=" + strconv.Itoa(tt.want); !strings.Contains(logs.String(), want) {
+			t.Errorf("GET %s (Range %q) logged %q, want %s", tt.path, tt.rangeHeader, logs.String(), want)
+		}
+		if tt.want == http.StatusOK && w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+			t.Errorf("GET %s Content-Type = %q, want text", tt.path, w.Header().Get("Content-Type"))
+		}
+	}
+}
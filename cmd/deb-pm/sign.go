@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"flag"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// releaseHashes are the checksum sections of a Release file, and their hash functions.
+var releaseHashes = map[string]func() hash.Hash{
+	"MD5Sum": md5.New,
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// runSign executes the 'sign' subcommand, which signs an existing repository again.
+func runSign(args []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	repo := fs.String("repo", "", "the repository `directory or repo.tar.gz` to sign")
+	keyFile := fs.String("key", "", "the ASCII-armored private key `file` (defaults to the GPG_KEY environment variable)")
+	output := fs.String("o", "", "write the signed repo.tar.gz to this `file` instead of replacing -repo")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm sign -repo <repository directory or repo.tar.gz> [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if *repo == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	key := os.Getenv("GPG_KEY")
+	if *keyFile != "" {
+		content, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fatalf("Failed to read the private key: %v", err)
+		}
+		key = string(content)
+	}
+	if key == "" {
+		fatalf("sign requires -key or the GPG_KEY environment variable")
+	}
+
+	source, err := openRepoSource(*repo)
+	if err != nil {
+		fatalf("Failed to open repository: %v", err)
+	}
+	if *output != "" && source.files == nil {
+		fatalf("-o only applies to repo.tar.gz repositories")
+	}
+	if err := signRepository(interruptible(), source, key); err != nil {
+		fatalf("Failed to sign repository: %v", err)
+	}
+	if source.files != nil {
+		dst := *repo
+		if *output != "" {
+			dst = *output
+		}
+		if err := source.save(dst); err != nil {
+			fatalf("Failed to write %s: %v", dst, err)
+		}
+	}
+	if dryRun {
+		succeed("Dry run completed successfully, nothing was written.")
+		return
+	}
+	succeed("Repository signed successfully.")
+}
+
+// signRepository refreshes the checksums and date of the Release files of a flat or standard repository,
+// then writes their InRelease and Release.gpg signatures, and the public.gpg and public.asc keys.
+// Packages are not rebuilt.
+func signRepository(ctx context.Context, source *repoSource, key string) error {
+	names, err := source.names()
+	if err != nil {
+		return err
+	}
+	signed := 0
+	for _, name := range names {
+		dir, ok := releaseDir(name)
+		if !ok {
+			continue
+		}
+		content, err := source.read(name)
+		if err != nil {
+			return err
+		}
+		release, err := refreshRelease(string(content), func(p string) ([]byte, error) { return source.read(dir + p) })
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		inRelease, err := deb.ClearSignContext(ctx, release, key)
+		if err != nil {
+			return fmt.Errorf("signing %s: %w", name, err)
+		}
+		detached, err := deb.DetachSignContext(ctx, release, key)
+		if err != nil {
+			return fmt.Errorf("signing %s: %w", name, err)
+		}
+		files := map[string][]byte{"Release": release, "InRelease": inRelease, "Release.gpg": detached}
+		for _, file := range []string{"Release", "InRelease", "Release.gpg"} {
+			if err := source.write(dir+file, files[file]); err != nil {
+				return err
+			}
+		}
+		if jsonOutput {
+			printJSON("signed", name)
+		} else {
+			fmt.Printf("Signed %s\n", name)
+		}
+		signed++
+	}
+	if signed == 0 {
+		return fmt.Errorf("no Release file found")
+	}
+
+	for _, file := range []string{"public.gpg", "public.asc"} {
+		pub, err := deb.PublicKey(key, file == "public.asc")
+		if err != nil {
+			return fmt.Errorf("exporting the public key: %w", err)
+		}
+		if err := source.write(file, pub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshRelease returns the content of a Release file with its date updated, and the checksums
+// of the files it lists computed again. read returns the content of a listed file.
+// Entries of files that no longer exist are removed. Other fields are kept as-is.
+func refreshRelease(content string, read func(path string) ([]byte, error)) ([]byte, error) {
+	var b strings.Builder
+	var h func() hash.Hash
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		if !strings.HasPrefix(line, " ") {
+			key, _, _ := strings.Cut(line, ":")
+			h = releaseHashes[strings.TrimSpace(key)]
+			if deb.ReleaseField(strings.TrimSpace(key)) == deb.RelDate {
+				line = fmt.Sprintf("%s: %s", deb.RelDate, time.Now().UTC().Format(time.RFC1123Z))
+			}
+			b.WriteString(line + "\n")
+			continue
+		}
+		fields := strings.Fields(line)
+		if h == nil || len(fields) != 3 {
+			b.WriteString(line + "\n")
+			continue
+		}
+		file, err := read(fields[2])
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum := h()
+		sum.Write(file)
+		fmt.Fprintf(&b, " %x %d %s\n", sum.Sum(nil), len(file), fields[2])
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// repoSource reads the files of a flat or standard repository, from its directory or
+// from a repository archive (see deb.Repository.WriteTo).
+type repoSource struct {
+	// dir is the repository directory, if it is not an archive, and fsys its files.
+	dir  string
+	fsys fs.FS
+	// files are the archive files, by path.
+	files map[string][]byte
+}
+
+// openRepoSource opens the repository directory, or the repository archive, at src.
+func openRepoSource(src string) (*repoSource, error) {
+	if !strings.HasSuffix(src, ".tar.gz") && !strings.HasSuffix(src, ".tgz") {
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: src, Err: fs.ErrInvalid}
+		}
+		return &repoSource{dir: src, fsys: os.DirFS(src)}, nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	s := &repoSource{files: make(map[string][]byte)}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		s.files[strings.TrimPrefix(path.Clean("/"+h.Name), "/")] = content
+	}
+}
+
+// read returns the content of a file, by slash separated path relative to the repository root.
+func (s *repoSource) read(name string) ([]byte, error) {
+	if s.files != nil {
+		content, ok := s.files[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return content, nil
+	}
+	// Directories have no content, including the root, whose empty name is not a valid path.
+	if !fs.ValidPath(name) {
+		return nil, os.ErrNotExist
+	}
+	if info, err := fs.Stat(s.fsys, name); err == nil && info.IsDir() {
+		return nil, os.ErrNotExist
+	}
+	return fs.ReadFile(s.fsys, name)
+}
+
+// names returns the paths of all the repository files, sorted.
+func (s *repoSource) names() ([]string, error) {
+	if s.files != nil {
+		names := make([]string, 0, len(s.files))
+		for name := range s.files {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return names, nil
+	}
+	var names []string
+	err := fs.WalkDir(s.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		names = append(names, p)
+		return nil
+	})
+	return names, err
+}
+
+// releaseDir reports whether name is the Release file of a flat repository, or of a suite
+// of a standard one (dists/<suite>/Release), and returns its directory with a trailing slash, or "" at the root.
+func releaseDir(name string) (string, bool) {
+	if name == "Release" {
+		return "", true
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] != "dists" || parts[2] != "Release" {
+		return "", false
+	}
+	return path.Dir(name) + "/", true
+}
+
+// write sets the content of a file, by slash separated path relative to the repository root.
+// Files are reported, see writeFile. Archive files are only written by save.
+func (s *repoSource) write(name string, content []byte) error {
+	if s.files != nil {
+		old, ok := s.files[name]
+		reportFile(writtenFile{Path: name, Created: !ok, Updated: ok && !bytes.Equal(old, content), DryRun: dryRun})
+		s.files[name] = content
+		return nil
+	}
+	return writeFile(filepath.Join(s.dir, filepath.FromSlash(name)), content, 0644)
+}
+
+// save writes the files of an archive repository to the archive at dst, see writeFile.
+func (s *repoSource) save(dst string) error {
+	names, err := s.names()
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	// The build time of the packages, so that saving the same files gives the same archive.
+	now, err := deb.DefaultBuildTime()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		content := s.files[name]
+		h := &tar.Header{Name: name, Size: int64(len(content)), Mode: 0644, ModTime: now}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return writeFile(dst, b.Bytes(), 0644)
+}
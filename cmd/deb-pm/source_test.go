@@ -0,0 +1,42 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRepoSourceSaveReproducible(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	s := &repoSource{files: map[string][]byte{"Release": []byte("Origin: test\n"), "Packages": nil}}
+	dst := filepath.Join(t.TempDir(), "repo.tar.gz")
+	if err := s.save(dst); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := time.Unix(1700000000, 0); !h.ModTime.Equal(want) {
+			t.Errorf("%s: ModTime = %v, want SOURCE_DATE_EPOCH %v", h.Name, h.ModTime, want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// runVerifyPublished executes the 'verify-published' subcommand, which checks a published repository
+// the way apt would: signatures, index checksums, and package files availability.
+func runVerifyPublished(args []string) {
+	fs := flag.NewFlagSet("verify-published", flag.ExitOnError)
+	to := fs.String("to", "", "the `URL` (or directory) where the repository is published, the one of the apt sources")
+	suite := fs.String("suite", "", "the suite of a standard repository (e.g. bookworm); leave empty for a flat repository")
+	keyFile := fs.String("key", "", "the public key `file` to check signatures with (defaults to the public.gpg published with the repository)")
+	sample := fs.Int("sample", 10, "number of package files, picked at random, checked for availability (0 for all)")
+	addGlobalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deb-pm verify-published -to <URL or directory> [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setupLogging()
+	if *to == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	v := &publishedVerifier{base: strings.TrimSuffix(*to, "/"), sample: *sample}
+	if strings.HasPrefix(v.base, "http://") || strings.HasPrefix(v.base, "https://") {
+		v.client = http.DefaultClient
+	} else {
+		source, err := openRepoSource(v.base)
+		if err != nil {
+			fatalf("Failed to open repository: %v", err)
+		}
+		v.source = source
+	}
+	if *keyFile != "" {
+		key, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fatalf("Failed to read the public key: %v", err)
+		}
+		v.key = key
+	}
+	dir := ""
+	if *suite != "" {
+		dir = "dists/" + *suite + "/"
+	}
+
+	v.verify(dir)
+	if v.failures > 0 {
+		fatalf("Published repository is broken: %d problem(s) found.", v.failures)
+	}
+	succeed("Published repository is valid.")
+}
+
+// publishedVerifier checks a published repository, and reports the problems it finds.
+type publishedVerifier struct {
+	base string
+	// client fetches web repositories, source reads local ones.
+	client *http.Client
+	source *repoSource
+	// key is the public key to check signatures with.
+	key    []byte
+	sample int
+
+	failures int
+}
+
+// verifyCheck is the outcome of a check, printed in JSON mode.
+type verifyCheck struct {
+	// Status is "ok", "warn" or "fail".
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// report prints the outcome of a check.
+func (v *publishedVerifier) report(status, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if jsonOutput {
+		printJSON("check", verifyCheck{Status: status, Message: message})
+		return
+	}
+	fmt.Printf("%-4s %s\n", strings.ToUpper(status), message)
+}
+
+// fail reports a problem.
+func (v *publishedVerifier) fail(format string, args ...any) {
+	v.failures++
+	v.report("fail", format, args...)
+}
+
+// ok reports a successful check.
+func (v *publishedVerifier) ok(format string, args ...any) {
+	v.report("ok", format, args...)
+}
+
+// url returns the URL of a repository file, by path relative to the base.
+// Paths are not escaped, except for '%', found in the file names of versions with an epoch (e.g. 1%3a2.0-1).
+func (v *publishedVerifier) url(name string) string {
+	return v.base + "/" + strings.ReplaceAll(name, "%", "%25")
+}
+
+// get returns the content of a repository file, by path relative to the base.
+// It returns an error wrapping os.ErrNotExist if the file does not exist.
+func (v *publishedVerifier) get(name string) ([]byte, error) {
+	if v.source != nil {
+		return v.source.read(name)
+	}
+	start := time.Now()
+	resp, err := v.client.Get(v.url(name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	slog.Debug("GET", "url", resp.Request.URL.String(), "status", resp.StatusCode, "duration", time.Since(start))
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", resp.Request.URL, os.ErrNotExist)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: %s", resp.Request.URL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// reachable checks that the package file at location, a URL or a path relative to the base, can be downloaded.
+func (v *publishedVerifier) reachable(location string) error {
+	if v.source != nil && !strings.Contains(location, "://") {
+		_, err := v.source.read(location)
+		return err
+	}
+	url := location
+	if !strings.Contains(location, "://") {
+		url = v.url(location)
+	}
+	client := v.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	start := time.Now()
+	resp, err := client.Head(url)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		// Some hosts do not support HEAD requests.
+		resp.Body.Close()
+		resp, err = client.Get(url)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	slog.Debug(resp.Request.Method, "url", url, "resolved", resp.Request.URL.String(), "status", resp.StatusCode, "duration", time.Since(start))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// verify checks the repository whose Release files are in dir, relative to the base.
+func (v *publishedVerifier) verify(dir string) {
+	release, err := v.get(dir + "Release")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		v.fail("%sRelease: %v", dir, err)
+		return
+	}
+	release = v.verifySignatures(dir, release)
+	if release == nil {
+		v.fail("%sRelease: not found", dir)
+		return
+	}
+
+	entries, err := deb.ParseReleaseEntries(string(release))
+	if err != nil {
+		v.fail("%sRelease: %v", dir, err)
+		return
+	}
+	if len(entries) == 0 {
+		v.fail("%sRelease: no checksum entries", dir)
+	}
+	for _, e := range entries {
+		content, err := v.get(dir + e.Path)
+		if err != nil {
+			v.fail("%s%s: %v", dir, e.Path, err)
+			continue
+		}
+		if err := e.Verify(content); err != nil {
+			v.fail("%s%s: %v", dir, e.Path, err)
+			continue
+		}
+		v.ok("%s%s: checksum matches the Release file", dir, e.Path)
+
+		if base := path.Base(e.Path); base != "Packages" && strings.HasPrefix(base, "Packages.") {
+			// Compressed indices must decompress to the uncompressed one, whose packages are checked.
+			index, err := deb.DecompressIndex(e.Path, content)
+			if err != nil {
+				v.fail("%s%s: %v", dir, e.Path, err)
+				continue
+			}
+			if i := slices.IndexFunc(entries, func(u deb.ReleaseEntry) bool { return u.Path == path.Join(path.Dir(e.Path), "Packages") }); i >= 0 {
+				if err := entries[i].Verify(index); err != nil {
+					v.fail("%s%s: decompressed index: %v", dir, e.Path, err)
+				}
+			}
+			continue
+		}
+		if path.Base(e.Path) == "Packages" {
+			// Package paths are relative to the repository root, which is dir in flat repositories.
+			root := ""
+			if dir == "" {
+				root = path.Dir(e.Path) + "/"
+				if root == "./" {
+					root = ""
+				}
+			}
+			v.verifyPackages(dir+e.Path, root, string(content))
+		}
+	}
+}
+
+// verifySignatures checks the InRelease and Release.gpg signatures in dir, and returns the signed release.
+// release is the content of the Release file, or nil if there is none.
+func (v *publishedVerifier) verifySignatures(dir string, release []byte) []byte {
+	inRelease, err := v.get(dir + "InRelease")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		v.fail("%sInRelease: %v", dir, err)
+	}
+	detached, err := v.get(dir + "Release.gpg")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		v.fail("%sRelease.gpg: %v", dir, err)
+	}
+	if inRelease == nil && detached == nil {
+		if v.key != nil {
+			v.fail("%sRelease is not signed", dir)
+		} else {
+			v.report("warn", "%sRelease is not signed", dir)
+		}
+		return release
+	}
+
+	key := v.key
+	if key == nil {
+		if key, err = v.get("public.gpg"); err != nil {
+			v.fail("public.gpg: %v (use -key to provide the public key)", err)
+			return release
+		}
+	}
+	if inRelease != nil {
+		text, err := deb.VerifyClearSigned(inRelease, key)
+		switch {
+		case err != nil:
+			v.fail("%sInRelease: %v", dir, err)
+		case release != nil && !bytes.Equal(text, release):
+			v.fail("%sInRelease: signed content differs from the Release file", dir)
+		default:
+			v.ok("%sInRelease: valid signature", dir)
+			if release == nil {
+				release = text
+			}
+		}
+	}
+	if detached != nil {
+		switch {
+		case release == nil:
+			v.fail("%sRelease.gpg: there is no Release file", dir)
+		default:
+			if err := deb.VerifyDetached(release, detached, key); err != nil {
+				v.fail("%sRelease.gpg: %v", dir, err)
+			} else {
+				v.ok("%sRelease.gpg: valid signature", dir)
+			}
+		}
+	}
+	return release
+}
+
+// verifyPackages checks that a sample of the packages of the index, at name, are available.
+// Their paths are relative to root.
+func (v *publishedVerifier) verifyPackages(name, root, index string) {
+	entries, err := deb.ParsePackagesIndex(index)
+	if err != nil {
+		v.fail("%s: %v", name, err)
+		return
+	}
+	if v.sample > 0 && len(entries) > v.sample {
+		rand.Shuffle(len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+		entries = entries[:v.sample]
+	}
+	for _, e := range entries {
+		location := e.Filename
+		if !strings.Contains(location, "://") {
+			location = root + location
+		}
+		if err := v.reachable(location); err != nil {
+			v.fail("%s (%s): %v", e.Metadata.Package, e.Metadata.Version, err)
+			continue
+		}
+		v.ok("%s (%s) [%s]: %s is available", e.Metadata.Package, e.Metadata.Version, e.Metadata.Architecture, location)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyPackagesEpoch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "pool"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pool", "foo_1%3a2.0-1_amd64.deb"), []byte("deb"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	index := "Package: foo\nVersion: 1:2.0-1\nArchitecture: amd64\nFilename: pool/foo_1%3a2.0-1_amd64.deb\n"
+	v := &publishedVerifier{base: server.URL, client: server.Client()}
+	v.verifyPackages("Packages", "", index)
+	if v.failures != 0 {
+		t.Errorf("verifyPackages reported %d failure(s), want none", v.failures)
+	}
+}
@@ -0,0 +1,45 @@
+package deb
+
+import (
+	"fmt"
+	"io"
+)
+
+// BinaryDir is the directory where NewBinaryPackage installs the binary.
+const BinaryDir = "/usr/bin"
+
+// NewBinaryPackage creates a package installing the executable read from binary as BinaryDir/name,
+// with mode 0755, in the "utils" section with the "optional" priority. The Installed-Size is
+// computed from the payload when the package is written. The caller completes the Metadata,
+// at least the Maintainer and the Description, and can add files, e.g. a systemd service.
+func NewBinaryPackage(name, version, arch string, binary io.Reader) (*Package, error) {
+	if err := CheckPackageName(name); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(version); err != nil {
+		return nil, err
+	}
+	if arch == "" {
+		return nil, fmt.Errorf("package %s has no architecture", name)
+	}
+	body, err := io.ReadAll(binary)
+	if err != nil {
+		return nil, fmt.Errorf("reading the binary of %s: %w", name, err)
+	}
+	return &Package{
+		Metadata: Metadata{
+			Package:      name,
+			Version:      version,
+			Architecture: arch,
+			Section:      "utils",
+			Priority:     "optional",
+			ExtraFields:  make(map[string]string),
+		},
+		ExtraControlFiles: make(map[string]string),
+		Files: []File{{
+			DestPath: BinaryDir + "/" + name,
+			Mode:     0755,
+			Body:     string(body),
+		}},
+	}, nil
+}
@@ -0,0 +1,131 @@
+package deb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// changelogDateLayout is the date format of the changelog trailer line (RFC 5322).
+const changelogDateLayout = "Mon, 02 Jan 2006 15:04:05 -0700"
+
+// ChangelogEntry is a single release in a Debian changelog.
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-source.html#debian-changelog-debian-changelog
+type ChangelogEntry struct {
+	// Version is the package version this entry describes.
+	Version string
+	// Distribution is the target distribution. Defaults to "unstable".
+	Distribution string
+	// Urgency is the upload urgency. Defaults to "medium".
+	Urgency string
+	// Changes are the change descriptions, one per bullet.
+	Changes []string
+	// Maintainer is the author of the entry, as "Name <email>".
+	Maintainer string
+	// Date is the entry date.
+	Date time.Time
+}
+
+// FormatChangelog formats the entries of the package changelog in the Debian changelog format.
+// Entries must be sorted from the most recent to the oldest.
+func FormatChangelog(pkg string, entries []ChangelogEntry) string {
+	var b strings.Builder
+	for i, e := range entries {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		dist, urgency := e.Distribution, e.Urgency
+		if dist == "" {
+			dist = "unstable"
+		}
+		if urgency == "" {
+			urgency = "medium"
+		}
+		fmt.Fprintf(&b, "%s (%s) %s; urgency=%s\n\n", pkg, e.Version, dist, urgency)
+		for _, c := range e.Changes {
+			// Continuation lines are indented under the bullet text.
+			fmt.Fprintf(&b, "  * %s\n", strings.ReplaceAll(strings.TrimSpace(c), "\n", "\n    "))
+		}
+		fmt.Fprintf(&b, "\n -- %s  %s\n", e.Maintainer, e.Date.Format(changelogDateLayout))
+	}
+	return b.String()
+}
+
+// ChangelogPath returns the path of the changelog installed by the package.
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-docs.html#changelog-files-and-release-notes
+func (p *Package) ChangelogPath() string {
+	return "/usr/share/doc/" + p.Metadata.Package + "/changelog.Debian.gz"
+}
+
+// SetChangelog installs content, a changelog in the Debian format, as the package changelog.
+// The content is compressed and replaces any existing changelog file.
+func (p *Package) SetChangelog(content string) error {
+	var buf bytes.Buffer
+	// The gzip header has no name nor modification time, so the output is deterministic.
+	gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := gw.Write([]byte(content)); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	path := p.ChangelogPath()
+	file := File{DestPath: path, Mode: 0644, Body: buf.String()}
+	for i, f := range p.Files {
+		if f.DestPath == path {
+			p.Files[i] = file
+			return nil
+		}
+	}
+	p.Files = append(p.Files, file)
+	return nil
+}
+
+// Changelog returns the uncompressed content of the package changelog, or "" if there is none.
+func (p *Package) Changelog() (string, error) {
+	path := p.ChangelogPath()
+	for _, f := range p.Files {
+		if f.DestPath != path {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("reading changelog: %w", err)
+		}
+		defer r.Close()
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return "", fmt.Errorf("reading changelog: %w", err)
+		}
+		content, err := io.ReadAll(gr)
+		if err != nil {
+			return "", fmt.Errorf("reading changelog: %w", err)
+		}
+		return string(content), nil
+	}
+	return "", nil
+}
+
+// changelogChanges returns the change lines of the changelog content that are not in old.
+func changelogChanges(old, content string) []string {
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(old, "\n") {
+		seen[line] = true
+	}
+	var changes []string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "  * ") && !seen[line] {
+			changes = append(changes, strings.TrimPrefix(line, "  * "))
+		}
+	}
+	return changes
+}
@@ -0,0 +1,57 @@
+package deb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormatChangelog(t *testing.T) {
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	got := FormatChangelog("foo", []ChangelogEntry{
+		{Version: "1.1-1", Changes: []string{"Add bar", "Fix baz\nin qux"}, Maintainer: "Dev <dev@example.com>", Date: date},
+		{Version: "1.0-1", Distribution: "stable", Urgency: "low", Changes: []string{"Initial release"}, Maintainer: "Dev <dev@example.com>", Date: date},
+	})
+	want := `foo (1.1-1) unstable; urgency=medium
+
+  * Add bar
+  * Fix baz
+    in qux
+
+ -- Dev <dev@example.com>  Fri, 01 Mar 2024 12:00:00 +0000
+
+foo (1.0-1) stable; urgency=low
+
+  * Initial release
+
+ -- Dev <dev@example.com>  Fri, 01 Mar 2024 12:00:00 +0000
+`
+	if got != want {
+		t.Errorf("FormatChangelog() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSetChangelog(t *testing.T) {
+	p := &Package{Metadata: Metadata{Package: "foo", Version: "1.0-1", Architecture: "all"}}
+	if err := p.SetChangelog("v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetChangelog("  * Initial release\n"); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Files) != 1 || p.Files[0].DestPath != "/usr/share/doc/foo/changelog.Debian.gz" {
+		t.Fatalf("Files = %+v, want a single changelog file", p.Files)
+	}
+	if got, err := p.Changelog(); err != nil || got != "  * Initial release\n" {
+		t.Errorf("Changelog() = %q, %v", got, err)
+	}
+
+	q := &Package{Metadata: p.Metadata, Files: []File{p.Files[0]}}
+	if err := q.SetChangelog("  * Fix crash\n\n  * Initial release\n"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"~ /usr/share/doc/foo/changelog.Debian.gz", "+ changelog: Fix crash"}
+	if got, err := Diff(p, q); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %q, %v, want %q", got, err, want)
+	}
+}
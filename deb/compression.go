@@ -0,0 +1,156 @@
+package deb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression is the compression of the control and data archives of a .deb package.
+type Compression string
+
+// The compressions of the .deb archives.
+//
+// Reference: https://manpages.debian.org/unstable/dpkg-dev/deb.5.en.html#FORMAT
+const (
+	// CompressionGzip writes control.tar.gz and data.tar.gz. It is the default.
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd writes control.tar.zst and data.tar.zst, the default of modern dpkg.
+	CompressionZstd Compression = "zstd"
+	// CompressionXz writes control.tar.xz and data.tar.xz, the compression of most Debian archive packages.
+	CompressionXz Compression = "xz"
+	// CompressionNone writes uncompressed control.tar and data.tar archives.
+	CompressionNone Compression = "none"
+)
+
+// ParseCompression returns the compression named name, "" being CompressionGzip.
+func ParseCompression(name string) (Compression, error) {
+	switch c := Compression(strings.ToLower(name)); c {
+	case "":
+		return CompressionGzip, nil
+	case CompressionGzip, CompressionZstd, CompressionXz, CompressionNone:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compression %q, expected %s, %s, %s or %s", name, CompressionGzip, CompressionZstd, CompressionXz, CompressionNone)
+}
+
+// extension returns the suffix of the archive members compressed with c, e.g. ".gz".
+func (c Compression) extension() string {
+	switch c {
+	case CompressionZstd:
+		return ".zst"
+	case CompressionXz:
+		return ".xz"
+	case CompressionNone:
+		return ""
+	default:
+		return ".gz"
+	}
+}
+
+// newWriter returns a writer compressing to w with c, at level, see WriteOptions.Level.
+// It must be closed to flush the compressed stream.
+func (c Compression) newWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	switch c {
+	case "", CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip level %d", level)
+		}
+		return gw, nil
+	case CompressionZstd:
+		if level == 0 {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		}
+		if level < 1 || level > 22 {
+			return nil, fmt.Errorf("invalid zstd level %d", level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	case CompressionXz:
+		return xz.NewWriter(w)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", c)
+}
+
+// compressionOf returns the compression of the archive member name, e.g. "data.tar.zst".
+func compressionOf(name string) (Compression, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return CompressionNone, nil
+	case strings.HasSuffix(name, ".gz"):
+		return CompressionGzip, nil
+	case strings.HasSuffix(name, ".zst"):
+		return CompressionZstd, nil
+	case strings.HasSuffix(name, ".xz"):
+		return CompressionXz, nil
+	}
+	return "", fmt.Errorf("unsupported compression of %s", name)
+}
+
+// decompress returns a reader of the decompressed content of the archive member name read from r,
+// and a function releasing its resources.
+func decompress(name string, r io.Reader) (io.Reader, func(), error) {
+	c, err := compressionOf(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch c {
+	case CompressionGzip:
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening %s: %w", name, err)
+		}
+		return gzr, func() { gzr.Close() }, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening %s: %w", name, err)
+		}
+		return zr, zr.Close, nil
+	case CompressionXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening %s: %w", name, err)
+		}
+		return xr, func() {}, nil
+	}
+	return r, func() {}, nil
+}
+
+// DecompressIndex returns the decompressed content of a repository index compressed as its name
+// tells, e.g. "Packages.xz". An index without compression extension, e.g. "Packages", is returned as is.
+func DecompressIndex(name string, content []byte) ([]byte, error) {
+	if _, err := compressionOf(name); err != nil {
+		return content, nil
+	}
+	r, done, err := decompress(name, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return io.ReadAll(r)
+}
+
+// WriteOptions configures how a package is written, see Package.WriteToWithOptions.
+type WriteOptions struct {
+	// Compression, if set, overrides the Package.Compression of the archives.
+	Compression Compression
+	// Level is the compression level: from 1 (fastest) to 9 (best) for gzip, from 1 to 22 for zstd,
+	// as with the zstd command. Zero is the default level. xz and none ignore it.
+	Level int
+}
+
+// nopWriteCloser is an io.WriteCloser with a no-op Close.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
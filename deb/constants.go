@@ -46,9 +46,14 @@ const (
 type PackageFile string
 
 const (
-	PkgDebianBinary PackageFile = "debian-binary"
-	PkgControlTarGz PackageFile = "control.tar.gz"
-	PkgDataTarGz    PackageFile = "data.tar.gz"
+	PkgDebianBinary  PackageFile = "debian-binary"
+	PkgControlTarGz  PackageFile = "control.tar.gz"
+	PkgDataTarGz     PackageFile = "data.tar.gz"
+	PkgControlTarZst PackageFile = "control.tar.zst"
+	PkgDataTarZst    PackageFile = "data.tar.zst"
+	PkgControlTarXz  PackageFile = "control.tar.xz"
+	PkgDataTarXz     PackageFile = "data.tar.xz"
+	PkgGpgOrigin     PackageFile = "_gpgorigin"
 )
 
 // ReleaseField represents a standard field in a Debian Release file.
@@ -0,0 +1,192 @@
+package deb
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// copyrightFormat is the Format field of the machine-readable copyright files.
+const copyrightFormat = "https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/"
+
+// Copyright is a machine-readable copyright file (DEP-5).
+//
+// Reference: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+type Copyright struct {
+	// UpstreamName is the name upstream uses for the software.
+	UpstreamName string
+	// UpstreamContact is the preferred address to reach upstream, e.g. "Name <email>" or a URL.
+	UpstreamContact string
+	// Source is where the upstream source comes from, typically a URL.
+	Source string
+	// Files are the copyright and license of the files, the most general first, e.g. "*".
+	Files []CopyrightFiles
+	// Licenses are the texts of the licenses referenced by Files. The licenses with a built-in
+	// text (see LicenseText) do not need one.
+	Licenses []License
+}
+
+// CopyrightFiles is the copyright and license of a set of files.
+type CopyrightFiles struct {
+	// Patterns are the file name patterns, e.g. "*" or "vendor/*".
+	Patterns []string
+	// Copyright are the copyright holders, one per line, e.g. "2024 Jane Doe <jane@example.com>".
+	Copyright []string
+	// License is the license short name, e.g. "MIT", "Apache-2.0" or "GPL-2.0-or-later".
+	License string
+	// Comment is an optional comment.
+	Comment string
+}
+
+// License is the text of a license.
+type License struct {
+	// Name is the license short name, as referenced by CopyrightFiles.License.
+	Name string
+	// Text is the license text.
+	Text string
+}
+
+// commonLicenses are the licenses shipped in /usr/share/common-licenses, by SPDX identifier.
+// Their copyright files reference them instead of copying their text.
+var commonLicenses = map[string]struct{ file, name string }{
+	"Apache-2.0":        {"Apache-2.0", "Apache License, Version 2.0"},
+	"GPL-2.0-only":      {"GPL-2", "GNU General Public License version 2"},
+	"GPL-2.0-or-later":  {"GPL-2", "GNU General Public License version 2"},
+	"GPL-3.0-only":      {"GPL-3", "GNU General Public License version 3"},
+	"GPL-3.0-or-later":  {"GPL-3", "GNU General Public License version 3"},
+	"LGPL-2.1-only":     {"LGPL-2.1", "GNU Lesser General Public License version 2.1"},
+	"LGPL-2.1-or-later": {"LGPL-2.1", "GNU Lesser General Public License version 2.1"},
+	"LGPL-3.0-only":     {"LGPL-3", "GNU Lesser General Public License version 3"},
+	"LGPL-3.0-or-later": {"LGPL-3", "GNU Lesser General Public License version 3"},
+	"MPL-2.0":           {"MPL-2.0", "Mozilla Public License, Version 2.0"},
+	"CC0-1.0":           {"CC0-1.0", "Creative Commons CC0 1.0 Universal"},
+}
+
+// bsdConditions are the conditions of the BSD licenses.
+const bsdConditions = `Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+`
+
+// bsdDisclaimer is the disclaimer of the BSD licenses.
+const bsdDisclaimer = `
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.`
+
+// licenseTexts are the full texts of the common permissive licenses, by SPDX identifier.
+var licenseTexts = map[string]string{
+	"MIT": `Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.`,
+	"ISC": `Permission to use, copy, modify, and/or distribute this software for any purpose with or without fee is hereby granted, provided that the above copyright notice and this permission notice appear in all copies.
+
+THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.`,
+	"BSD-2-Clause": bsdConditions + bsdDisclaimer,
+	"BSD-3-Clause": bsdConditions + `
+3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+` + bsdDisclaimer,
+}
+
+// LicenseText returns the built-in text of the license named by its SPDX identifier, and whether
+// there is one. The full text of the common permissive licenses (MIT, ISC, BSD-2-Clause, BSD-3-Clause)
+// is built in, the other common licenses reference their copy in /usr/share/common-licenses.
+func LicenseText(name string) (string, bool) {
+	if text, ok := licenseTexts[name]; ok {
+		return text, true
+	}
+	if l, ok := commonLicenses[name]; ok {
+		return fmt.Sprintf("On Debian systems, the complete text of the %s can be found in \"/usr/share/common-licenses/%s\".", l.name, l.file), true
+	}
+	return "", false
+}
+
+// Format returns the copyright file. It fails if a license referenced by Files has
+// neither a text in Licenses nor a built-in text.
+func (c Copyright) Format() (string, error) {
+	if len(c.Files) == 0 {
+		return "", fmt.Errorf("a copyright file needs at least one Files paragraph")
+	}
+	var b strings.Builder
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", name, formatFieldValue(value))
+		}
+	}
+	field("Format", copyrightFormat)
+	field("Upstream-Name", c.UpstreamName)
+	field("Upstream-Contact", c.UpstreamContact)
+	field("Source", c.Source)
+
+	var referenced []string
+	for i, f := range c.Files {
+		if len(f.Patterns) == 0 || f.License == "" {
+			return "", fmt.Errorf("copyright files[%d]: patterns and license are required", i)
+		}
+		b.WriteString("\n")
+		field("Files", strings.Join(f.Patterns, " "))
+		field("Copyright", strings.Join(f.Copyright, "\n"))
+		field("License", f.License)
+		field("Comment", f.Comment)
+		if !slices.Contains(referenced, f.License) {
+			referenced = append(referenced, f.License)
+		}
+	}
+
+	texts := make(map[string]string)
+	for _, l := range c.Licenses {
+		texts[l.Name] = l.Text
+	}
+	for _, name := range referenced {
+		text, ok := texts[name]
+		if !ok {
+			if text, ok = LicenseText(name); !ok {
+				return "", fmt.Errorf("no text for the license %q", name)
+			}
+		}
+		b.WriteString("\n")
+		field("License", name+"\n"+text)
+	}
+	return b.String(), nil
+}
+
+// formatFieldValue returns a multiline field value with its continuation lines indented,
+// and its empty lines as " .".
+func formatFieldValue(value string) string {
+	lines := strings.Split(strings.TrimRight(value, "\n"), "\n")
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			lines[i] = " ."
+		} else {
+			lines[i] = " " + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CopyrightPath returns the path of the copyright file installed by the package.
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-docs.html#copyright-information
+func (p *Package) CopyrightPath() string {
+	return "/usr/share/doc/" + p.Metadata.Package + "/copyright"
+}
+
+// SetCopyright installs c as the package copyright file, replacing any existing one.
+func (p *Package) SetCopyright(c Copyright) error {
+	content, err := c.Format()
+	if err != nil {
+		return err
+	}
+	path := p.CopyrightPath()
+	file := File{DestPath: path, Mode: 0644, Body: content}
+	for i, f := range p.Files {
+		if f.DestPath == path {
+			p.Files[i] = file
+			return nil
+		}
+	}
+	p.Files = append(p.Files, file)
+	return nil
+}
@@ -0,0 +1,54 @@
+package deb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCopyrightFormat(t *testing.T) {
+	c := Copyright{
+		UpstreamName: "tool",
+		Source:       "https://example.com/tool",
+		Files: []CopyrightFiles{
+			{Patterns: []string{"*"}, Copyright: []string{"2024 Jane Doe <jane@example.com>", "2023 John Doe"}, License: "MIT"},
+			{Patterns: []string{"vendor/a/*", "vendor/b/*"}, Copyright: []string{"2020 Vendor"}, License: "Apache-2.0"},
+			{Patterns: []string{"extra/*"}, Copyright: []string{"2021 Other"}, License: "Custom"},
+		},
+		Licenses: []License{{Name: "Custom", Text: "Do what you want.\n\nReally."}},
+	}
+	got, err := c.Format()
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	for _, want := range []string{
+		"Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/\nUpstream-Name: tool\nSource: https://example.com/tool\n\n",
+		"Files: *\nCopyright: 2024 Jane Doe <jane@example.com>\n 2023 John Doe\nLicense: MIT\n",
+		"Files: vendor/a/* vendor/b/*\n",
+		"\nLicense: MIT\n Permission is hereby granted, free of charge,",
+		"\n .\n The above copyright notice",
+		"\nLicense: Apache-2.0\n On Debian systems, the complete text of the Apache License, Version 2.0 can be found in \"/usr/share/common-licenses/Apache-2.0\".\n",
+		"\nLicense: Custom\n Do what you want.\n .\n Really.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Format() = %s\nwant it to contain %q", got, want)
+		}
+	}
+
+	c.Files[0].License = "Unknown"
+	if _, err := c.Format(); err == nil {
+		t.Errorf("Format should fail on a license without text")
+	}
+}
+
+func TestSetCopyright(t *testing.T) {
+	pkg := &Package{Metadata: Metadata{Package: "tool"}}
+	c := Copyright{Files: []CopyrightFiles{{Patterns: []string{"*"}, Copyright: []string{"2024 Jane"}, License: "ISC"}}}
+	for range 2 {
+		if err := pkg.SetCopyright(c); err != nil {
+			t.Fatalf("SetCopyright failed: %v", err)
+		}
+	}
+	if len(pkg.Files) != 1 || pkg.Files[0].DestPath != "/usr/share/doc/tool/copyright" {
+		t.Errorf("Files = %+v, want a single copyright file", pkg.Files)
+	}
+}
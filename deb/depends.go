@@ -0,0 +1,217 @@
+package deb
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Relation is a package of a relationship field, e.g. "libc6 (>= 2.34)" in Depends.
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-relationships.html#syntax-of-relationship-fields
+type Relation struct {
+	// Name is the name of the package, or of a virtual package.
+	Name string
+	// Arch is the architecture qualifier, e.g. "any" in "python3:any", or "".
+	Arch string
+	// Operator is the version operator: "<<", "<=", "=", ">=" or ">>", or "" if any version matches.
+	Operator string
+	// Version is the version the operator compares with.
+	Version string
+	// Architectures restricts the relation to architectures, e.g. ["amd64", "!i386"], as in Build-Depends.
+	Architectures []string
+	// Profiles are the build profile restrictions, e.g. ["!nocheck"] for "<!nocheck>", as in Build-Depends.
+	Profiles []string
+}
+
+// Dependency is a group of alternative relations, e.g. "mail-transport-agent | postfix":
+// one of them is enough to satisfy the dependency.
+type Dependency []Relation
+
+// ParseDependencies parses the entries of a relationship field, e.g. Metadata.Depends.
+func ParseDependencies(entries []string) ([]Dependency, error) {
+	var deps []Dependency
+	for _, entry := range entries {
+		d, err := ParseDependency(entry)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, d)
+	}
+	return deps, nil
+}
+
+// ParseDependency parses an entry of a relationship field, e.g. "default-mta | mail-transport-agent".
+func ParseDependency(entry string) (Dependency, error) {
+	var d Dependency
+	for alt := range strings.SplitSeq(entry, "|") {
+		r, err := ParseRelation(alt)
+		if err != nil {
+			return nil, err
+		}
+		d = append(d, r)
+	}
+	return d, nil
+}
+
+// ParseRelation parses a single relation, e.g. "libc6:amd64 (>= 2.34) [linux-any] <!nocheck>".
+// The obsolete "<" and ">" operators are read as "<=" and ">=".
+func ParseRelation(s string) (Relation, error) {
+	var r Relation
+	rest := strings.TrimSpace(s)
+	end := strings.IndexAny(rest, " \t\n([<")
+	if end < 0 {
+		end = len(rest)
+	}
+	r.Name, rest = rest[:end], strings.TrimSpace(rest[end:])
+	if name, arch, ok := strings.Cut(r.Name, ":"); ok {
+		r.Name, r.Arch = name, arch
+	}
+	if err := CheckPackageName(r.Name); err != nil {
+		return r, fmt.Errorf("invalid relation %q: %w", s, err)
+	}
+
+	if strings.HasPrefix(rest, "(") {
+		inner, after, ok := strings.Cut(rest[1:], ")")
+		if !ok {
+			return r, fmt.Errorf("invalid relation %q: missing )", s)
+		}
+		inner = strings.TrimSpace(inner)
+		end := strings.IndexFunc(inner, func(c rune) bool { return !strings.ContainsRune("<=>", c) })
+		if end <= 0 {
+			return r, fmt.Errorf("invalid relation %q: missing version operator", s)
+		}
+		c, err := ParseConstraint(inner)
+		if err != nil {
+			return r, fmt.Errorf("invalid relation %q: %w", s, err)
+		}
+		r.Operator, r.Version = c.Operator, strings.TrimSpace(inner[end:])
+		rest = strings.TrimSpace(after)
+	}
+
+	if strings.HasPrefix(rest, "[") {
+		inner, after, ok := strings.Cut(rest[1:], "]")
+		if !ok {
+			return r, fmt.Errorf("invalid relation %q: missing ]", s)
+		}
+		r.Architectures = strings.Fields(inner)
+		rest = strings.TrimSpace(after)
+	}
+
+	for strings.HasPrefix(rest, "<") {
+		inner, after, ok := strings.Cut(rest[1:], ">")
+		if !ok {
+			return r, fmt.Errorf("invalid relation %q: missing >", s)
+		}
+		r.Profiles = append(r.Profiles, strings.Join(strings.Fields(inner), " "))
+		rest = strings.TrimSpace(after)
+	}
+	if rest != "" {
+		return r, fmt.Errorf("invalid relation %q: unexpected %q", s, rest)
+	}
+	return r, nil
+}
+
+// String returns the relation as written in a control file.
+func (r Relation) String() string {
+	var b strings.Builder
+	b.WriteString(r.Name)
+	if r.Arch != "" {
+		b.WriteString(":" + r.Arch)
+	}
+	if r.Operator != "" {
+		fmt.Fprintf(&b, " (%s %s)", r.Operator, r.Version)
+	}
+	if len(r.Architectures) > 0 {
+		fmt.Fprintf(&b, " [%s]", strings.Join(r.Architectures, " "))
+	}
+	for _, p := range r.Profiles {
+		fmt.Fprintf(&b, " <%s>", p)
+	}
+	return b.String()
+}
+
+// String returns the dependency as written in a control file.
+func (d Dependency) String() string {
+	alts := make([]string, len(d))
+	for i, r := range d {
+		alts[i] = r.String()
+	}
+	return strings.Join(alts, " | ")
+}
+
+// MatchesVersion reports whether version satisfies the version constraint of the relation.
+// A relation without operator matches every version.
+func (r Relation) MatchesVersion(version string) bool {
+	return r.Operator == "" || matchOperator(r.Operator, CompareVersions(version, r.Version))
+}
+
+// Satisfies reports whether the package satisfies one of the alternatives of d: by its name
+// and version, or by one of its Provides, versioned or not. An architecture qualifier other
+// than "any" must be the architecture of the package, or "all".
+func (p *Package) Satisfies(d Dependency) bool {
+	for _, r := range d {
+		if r.Arch != "" && r.Arch != "any" && r.Arch != p.Metadata.Architecture && p.Metadata.Architecture != "all" {
+			continue
+		}
+		if r.Name == p.Metadata.Package && r.MatchesVersion(p.Metadata.Version) {
+			return true
+		}
+		for _, entry := range p.Metadata.Provides {
+			provided, err := ParseRelation(entry)
+			if err != nil || provided.Name != r.Name {
+				continue
+			}
+			// An unversioned Provides only satisfies unversioned relations.
+			if r.Operator == "" || provided.Operator == "=" && r.MatchesVersion(provided.Version) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UnsatisfiedDependency is a dependency of a package that no package of a repository satisfies,
+// see Repository.UnsatisfiedDependencies.
+type UnsatisfiedDependency struct {
+	// Package is the package that has the dependency.
+	Package *Package
+	// Field is the relationship field of the dependency, Depends or Pre-Depends.
+	Field ControlField
+	// Dependency is the unsatisfied dependency.
+	Dependency Dependency
+}
+
+// UnsatisfiedDependencies returns the Depends and Pre-Depends of the packages that no package of
+// the repository satisfies, of the same architecture or "all", e.g. to check that the repository
+// is dependency-closed, or which dependencies the distribution must provide. It fails if
+// a relationship field cannot be parsed.
+func (r *Repository) UnsatisfiedDependencies() ([]UnsatisfiedDependency, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var unsatisfied []UnsatisfiedDependency
+	for _, pkg := range r.Packages {
+		for _, field := range []struct {
+			name    ControlField
+			entries []string
+		}{
+			{FieldPreDepends, pkg.Metadata.PreDepends},
+			{FieldDepends, pkg.Metadata.Depends},
+		} {
+			deps, err := ParseDependencies(field.entries)
+			if err != nil {
+				return nil, fmt.Errorf("package %s: %s: %w", pkg.Metadata.Package, field.name, err)
+			}
+			for _, d := range deps {
+				satisfied := slices.ContainsFunc(r.Packages, func(other *Package) bool {
+					arch := other.Metadata.Architecture
+					return (arch == "all" || pkg.Metadata.Architecture == "all" || arch == pkg.Metadata.Architecture) && other.Satisfies(d)
+				})
+				if !satisfied {
+					unsatisfied = append(unsatisfied, UnsatisfiedDependency{pkg, field.name, d})
+				}
+			}
+		}
+	}
+	return unsatisfied, nil
+}
@@ -0,0 +1,98 @@
+package deb
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseRelation(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Relation
+		str  string
+	}{
+		{"libc6", Relation{Name: "libc6"}, "libc6"},
+		{" libc6 (>= 2.34) ", Relation{Name: "libc6", Operator: ">=", Version: "2.34"}, "libc6 (>= 2.34)"},
+		{"python3:any (>=3.11)", Relation{Name: "python3", Arch: "any", Operator: ">=", Version: "3.11"}, "python3:any (>= 3.11)"},
+		{"foo (< 1.0)", Relation{Name: "foo", Operator: "<=", Version: "1.0"}, "foo (<= 1.0)"},
+		{"foo (= 1:1.0-1) [amd64 !i386] <!nocheck> <cross>", Relation{Name: "foo", Operator: "=", Version: "1:1.0-1", Architectures: []string{"amd64", "!i386"}, Profiles: []string{"!nocheck", "cross"}}, "foo (= 1:1.0-1) [amd64 !i386] <!nocheck> <cross>"},
+	}
+	for _, tt := range tests {
+		got, err := ParseRelation(tt.in)
+		if err != nil {
+			t.Errorf("ParseRelation(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got.Name != tt.want.Name || got.Arch != tt.want.Arch || got.Operator != tt.want.Operator || got.Version != tt.want.Version ||
+			!slices.Equal(got.Architectures, tt.want.Architectures) || !slices.Equal(got.Profiles, tt.want.Profiles) {
+			t.Errorf("ParseRelation(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if got.String() != tt.str {
+			t.Errorf("ParseRelation(%q).String() = %q, want %q", tt.in, got.String(), tt.str)
+		}
+	}
+	for _, in := range []string{"", "Foo", "foo (1.0)", "foo (~= 1.0)", "foo (>= 1.0", "foo (>= )", "foo [amd64", "foo bar"} {
+		if _, err := ParseRelation(in); err == nil {
+			t.Errorf("ParseRelation(%q) should fail", in)
+		}
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	pkg := &Package{Metadata: Metadata{
+		Package:      "postfix",
+		Version:      "3.7.6-0+deb12u1",
+		Architecture: "amd64",
+		Provides:     []string{"mail-transport-agent", "default-mta (= 3.7)"},
+	}}
+	tests := []struct {
+		dep  string
+		want bool
+	}{
+		{"postfix", true},
+		{"postfix (>= 3.7)", true},
+		{"postfix (>> 3.7.6-0+deb12u1)", false},
+		{"postfix (<< 3.8~)", true},
+		{"exim4 | postfix", true},
+		{"exim4", false},
+		{"postfix:any", true},
+		{"postfix:arm64", false},
+		{"mail-transport-agent", true},
+		{"mail-transport-agent (>= 1)", false},
+		{"default-mta (>= 3.0)", true},
+		{"default-mta (>= 4.0)", false},
+	}
+	for _, tt := range tests {
+		d, err := ParseDependency(tt.dep)
+		if err != nil {
+			t.Fatalf("ParseDependency(%q) failed: %v", tt.dep, err)
+		}
+		if got := pkg.Satisfies(d); got != tt.want {
+			t.Errorf("Satisfies(%q) = %v, want %v", tt.dep, got, tt.want)
+		}
+	}
+}
+
+func TestUnsatisfiedDependencies(t *testing.T) {
+	repo := &Repository{Packages: []*Package{
+		{Metadata: Metadata{Package: "app", Version: "1.0", Architecture: "amd64", Depends: []string{"app-data (= 1.0)", "libfoo | libfoo-compat", "libc6 (>= 2.34)"}}},
+		{Metadata: Metadata{Package: "app-data", Version: "1.0", Architecture: "all"}},
+		{Metadata: Metadata{Package: "libfoo", Version: "2.0", Architecture: "arm64"}},
+	}}
+	got, err := repo.UnsatisfiedDependencies()
+	if err != nil {
+		t.Fatalf("UnsatisfiedDependencies failed: %v", err)
+	}
+	var deps []string
+	for _, u := range got {
+		deps = append(deps, string(u.Field)+": "+u.Dependency.String())
+	}
+	if want := []string{"Depends: libfoo | libfoo-compat", "Depends: libc6 (>= 2.34)"}; !slices.Equal(deps, want) {
+		t.Errorf("UnsatisfiedDependencies = %q, want %q", deps, want)
+	}
+
+	repo.Packages[0].Metadata.Depends = []string{"app-data ("}
+	if _, err := repo.UnsatisfiedDependencies(); err == nil {
+		t.Error("an invalid dependency should fail")
+	}
+}
@@ -0,0 +1,170 @@
+package deb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Diff returns a human readable description of the differences between two packages.
+// Each line describes one change: control fields ("~ Version: 1.0-1 -> 1.0-2"),
+// maintainer scripts and control files ("~ postinst"), and payload files
+// ("+ /usr/bin/app", "- /etc/app.conf", "~ /usr/share/doc/app/README"),
+// and new changelog entries ("+ changelog: Fix crash on startup").
+// Identical packages produce no line. It fails if the content of a payload file cannot be read.
+func Diff(old, new *Package) ([]string, error) {
+	var changes []string
+
+	oldFields, newFields := old.controlFields(), new.controlFields()
+	for _, k := range unionKeys(oldFields, newFields) {
+		o, n := oldFields[k], newFields[k]
+		switch {
+		case o == n:
+		case o == "":
+			changes = append(changes, fmt.Sprintf("+ %s: %s", k, n))
+		case n == "":
+			changes = append(changes, fmt.Sprintf("- %s: %s", k, o))
+		default:
+			changes = append(changes, fmt.Sprintf("~ %s: %s -> %s", k, o, n))
+		}
+	}
+
+	oldControl, newControl := old.controlFiles(), new.controlFiles()
+	for _, k := range unionKeys(oldControl, newControl) {
+		if c := diffEntry(k, oldControl[k], newControl[k]); c != "" {
+			changes = append(changes, c)
+		}
+	}
+
+	oldFiles, err := old.payloadEntries()
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := new.payloadEntries()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range unionKeys(oldFiles, newFiles) {
+		if c := diffEntry(k, oldFiles[k], newFiles[k]); c != "" {
+			changes = append(changes, c)
+		}
+	}
+
+	// New changelog entries give human written context to the changes.
+	oldLog, _ := old.Changelog()
+	newLog, _ := new.Changelog()
+	for _, c := range changelogChanges(oldLog, newLog) {
+		changes = append(changes, "+ changelog: "+c)
+	}
+	return changes, nil
+}
+
+// diffEntry describes the change of a named entry, or returns "" if it did not change.
+// An empty content means the entry does not exist.
+func diffEntry(name, old, new string) string {
+	switch {
+	case old == new:
+		return ""
+	case old == "":
+		return "+ " + name
+	case new == "":
+		return "- " + name
+	default:
+		return "~ " + name
+	}
+}
+
+// controlFields returns the control fields of the package as a map, with multi-valued
+// fields joined the same way they are in the control file.
+func (p *Package) controlFields() map[string]string {
+	m := p.Metadata
+	fields := map[string]string{
+		string(FieldPackage):      m.Package,
+		string(FieldVersion):      m.Version,
+		string(FieldArchitecture): m.Architecture,
+		string(FieldMaintainer):   m.Maintainer,
+		string(FieldDescription):  m.Description,
+		string(FieldSection):      m.Section,
+		string(FieldPriority):     m.Priority,
+		string(FieldHomepage):     m.Homepage,
+		string(FieldDepends):      strings.Join(m.Depends, ", "),
+		string(FieldPreDepends):   strings.Join(m.PreDepends, ", "),
+		string(FieldRecommends):   strings.Join(m.Recommends, ", "),
+		string(FieldSuggests):     strings.Join(m.Suggests, ", "),
+		string(FieldEnhances):     strings.Join(m.Enhances, ", "),
+		string(FieldConflicts):    strings.Join(m.Conflicts, ", "),
+		string(FieldBreaks):       strings.Join(m.Breaks, ", "),
+		string(FieldReplaces):     strings.Join(m.Replaces, ", "),
+		string(FieldProvides):     strings.Join(m.Provides, ", "),
+		string(FieldBuiltUsing):   m.BuiltUsing,
+		string(FieldSource):       m.Source,
+	}
+	if m.Essential {
+		fields[string(FieldEssential)] = "yes"
+	}
+	for k, v := range m.ExtraFields {
+		if k != string(FieldInstalledSize) {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// controlFiles returns the maintainer scripts and extra control files by name.
+func (p *Package) controlFiles() map[string]string {
+	files := map[string]string{
+		string(FilePreinst):  p.Scripts.PreInst,
+		string(FilePostinst): p.Scripts.PostInst,
+		string(FilePrerm):    p.Scripts.PreRm,
+		string(FilePostrm):   p.Scripts.PostRm,
+		string(FileConfig):   p.Scripts.Config,
+	}
+	for k, v := range p.ExtraControlFiles {
+		files[k] = v
+	}
+	return files
+}
+
+// payloadEntries returns a description of each payload file by path.
+// The description captures everything that is part of the package digest.
+func (p *Package) payloadEntries() (map[string]string, error) {
+	files := make(map[string]string, len(p.Files))
+	for _, f := range p.Files {
+		owner := f.ownership()
+		if f.LinkTarget != "" {
+			files[f.DestPath] = owner + " -> " + f.LinkTarget
+			continue
+		}
+		if f.HardLink != "" {
+			files[f.DestPath] = fmt.Sprintf("%s:%o => %s", owner, f.Mode, f.HardLink)
+			continue
+		}
+		if f.IsDir {
+			files[f.DestPath] = fmt.Sprintf("%s:%o:dir", owner, f.dirMode())
+			continue
+		}
+		digest, err := f.contentDigest()
+		if err != nil {
+			return nil, err
+		}
+		files[f.DestPath] = fmt.Sprintf("%s:%o:%v:%s", owner, f.Mode, f.IsConf, digest)
+	}
+	return files, nil
+}
+
+// unionKeys returns the sorted union of the keys of both maps.
+func unionKeys(a, b map[string]string) []string {
+	set := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		set[k] = true
+	}
+	for k := range b {
+		set[k] = true
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
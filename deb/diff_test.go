@@ -0,0 +1,41 @@
+package deb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := &Package{
+		Metadata: Metadata{Package: "foo", Version: "1.0-1", Architecture: "amd64", Section: "utils"},
+		Scripts:  Scripts{PostInst: "#!/bin/sh\n"},
+		Files: []File{
+			{DestPath: "/usr/bin/foo", Mode: 0755, Body: "v1"},
+			{DestPath: "/etc/foo.conf", Mode: 0644, Body: "conf", IsConf: true},
+		},
+	}
+	new := &Package{
+		Metadata: Metadata{Package: "foo", Version: "1.0-2", Architecture: "amd64", Depends: []string{"libc6"}},
+		Scripts:  Scripts{PostInst: "#!/bin/sh\necho hi\n"},
+		Files: []File{
+			{DestPath: "/usr/bin/foo", Mode: 0755, Body: "v2"},
+			{DestPath: "/usr/share/doc/foo/README", Mode: 0644, Body: "doc"},
+		},
+	}
+
+	want := []string{
+		"+ Depends: libc6",
+		"- Section: utils",
+		"~ Version: 1.0-1 -> 1.0-2",
+		"~ postinst",
+		"- /etc/foo.conf",
+		"~ /usr/bin/foo",
+		"+ /usr/share/doc/foo/README",
+	}
+	if got, err := Diff(old, new); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() =\n%q, %v\nwant\n%q", got, err, want)
+	}
+	if got, err := Diff(old, old); err != nil || len(got) != 0 {
+		t.Errorf("Diff() of identical packages = %q, %v, want nothing", got, err)
+	}
+}
@@ -19,7 +19,7 @@
 // Repository Management:
 //   - Create and manage APT repositories in-memory.
 //   - Support for both flat and standard (hierarchical) repository layouts.
-//   - Automatic generation of indices: Packages, Packages.gz, Release.
+//   - Automatic generation of indices: Packages, Packages.gz (or .xz, .zst), Release.
 //   - GPG signing of Release files (InRelease) using Go's openpgp.
 //   - Import existing repositories from tar.gz streams.
 //
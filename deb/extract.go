@@ -0,0 +1,151 @@
+package deb
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// ExtractOptions are the options of Package.ExtractTo.
+type ExtractOptions struct {
+	// Control also writes the control files in a DEBIAN directory: control, md5sums, conffiles,
+	// the maintainer scripts and the extra control files, like `dpkg-deb -R`.
+	Control bool
+}
+
+// ExtractTo writes the payload of the package in dir, created if needed: the files with their mode
+// and modification time, the directories, the symbolic links and the hard links. Owners are not applied, so that
+// it does not need to run as root. Existing files are overwritten.
+// Paths are resolved within dir, and files are written with an os.Root, so that a path leaving dir
+// through a symbolic link fails the extraction.
+func (p *Package) ExtractTo(dir string, opts ExtractOptions) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	md5Map := make(map[string]string)
+	var installedSize int64
+	for _, f := range p.Files {
+		name := strings.TrimPrefix(path.Clean("/"+f.DestPath), "/")
+		if name == "" {
+			continue
+		}
+		if err := root.MkdirAll(path.Dir(name), 0755); err != nil {
+			return fmt.Errorf("extracting %s: %w", f.DestPath, err)
+		}
+		switch {
+		case f.IsDir:
+			if err := root.MkdirAll(name, 0755); err != nil {
+				return fmt.Errorf("extracting %s: %w", f.DestPath, err)
+			}
+			// The mode is applied without the umask.
+			if err := root.Chmod(name, unixMode(f.dirMode())); err != nil {
+				return fmt.Errorf("extracting %s: %w", f.DestPath, err)
+			}
+		case f.LinkTarget != "":
+			if err := root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("extracting %s: %w", f.DestPath, err)
+			}
+			if err := root.Symlink(f.LinkTarget, name); err != nil {
+				return fmt.Errorf("extracting %s: %w", f.DestPath, err)
+			}
+		case f.HardLink != "":
+			target := strings.TrimPrefix(path.Clean("/"+f.HardLink), "/")
+			if err := root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("extracting %s: %w", f.DestPath, err)
+			}
+			if err := root.Link(target, name); err != nil {
+				return fmt.Errorf("extracting %s: %w", f.DestPath, err)
+			}
+			md5Map[f.DestPath] = md5Map[f.HardLink]
+		default:
+			sum, err := extractFile(root, name, f)
+			if err != nil {
+				return fmt.Errorf("extracting %s: %w", f.DestPath, err)
+			}
+			md5Map[f.DestPath] = sum
+			installedSize += f.Size()
+		}
+	}
+	if !opts.Control {
+		return nil
+	}
+
+	if err := root.MkdirAll("DEBIAN", 0755); err != nil {
+		return err
+	}
+	for _, e := range p.controlEntries(md5Map, installedSize) {
+		name := "DEBIAN/" + string(e.name)
+		if err := root.WriteFile(name, e.content, unixMode(e.mode)); err != nil {
+			return fmt.Errorf("writing %s: %w", e.name, err)
+		}
+		if err := root.Chmod(name, unixMode(e.mode)); err != nil {
+			return fmt.Errorf("writing %s: %w", e.name, err)
+		}
+	}
+	return nil
+}
+
+// extractFile writes the content of f at name in root, with its mode and modification time,
+// and returns its hex encoded MD5 sum.
+func extractFile(root *os.Root, name string, f File) (string, error) {
+	r, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	// A symbolic link is replaced, not followed.
+	if info, err := root.Lstat(name); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := root.Remove(name); err != nil {
+			return "", err
+		}
+	}
+	w, err := root.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	// The mode is applied without the umask.
+	if err := root.Chmod(name, unixMode(f.Mode)); err != nil {
+		return "", err
+	}
+	if !f.ModTime.IsZero() {
+		if err := root.Chtimes(name, f.ModTime, f.ModTime); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// unixMode returns a Unix mode, e.g. 04755, as an os.FileMode.
+func unixMode(mode int64) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
@@ -0,0 +1,146 @@
+package deb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractTo(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pkg := &Package{
+		Metadata: Metadata{Package: "extracted", Version: "1.0", Architecture: "all", Description: "d", ExtraFields: map[string]string{}},
+		Scripts:  Scripts{PostInst: "#!/bin/sh\n"},
+		Files: []File{
+			{DestPath: "/usr/lib/extracted/app", Mode: 0755, Body: "binary", ModTime: modTime},
+			{DestPath: "/usr/bin/app", LinkTarget: "../lib/extracted/app"},
+			{DestPath: "/etc/extracted.conf", Mode: 0640, Body: "conf", IsConf: true},
+			{DestPath: "/var/lib/extracted", Mode: 0700, IsDir: true},
+		},
+	}
+	dir := t.TempDir()
+	if err := pkg.ExtractTo(dir, ExtractOptions{Control: true}); err != nil {
+		t.Fatalf("ExtractTo failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "usr/lib/extracted/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 || !info.ModTime().Equal(modTime) {
+		t.Errorf("app mode %v modified at %v, want 0755 at %v", info.Mode(), info.ModTime(), modTime)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "usr/bin/app")); err != nil || target != "../lib/extracted/app" {
+		t.Errorf("link = %q, %v", target, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "usr/bin/app")); err != nil || string(content) != "binary" {
+		t.Errorf("content through the link = %q, %v", content, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "etc/extracted.conf")); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("conffile = %v, %v", info, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "var/lib/extracted")); err != nil || !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Errorf("directory = %v, %v", info, err)
+	}
+
+	control, err := os.ReadFile(filepath.Join(dir, "DEBIAN/control"))
+	if err != nil || !strings.Contains(string(control), "Package: extracted\n") {
+		t.Errorf("DEBIAN/control = %q, %v", control, err)
+	}
+	if conffiles, err := os.ReadFile(filepath.Join(dir, "DEBIAN/conffiles")); err != nil || string(conffiles) != "/etc/extracted.conf\n" {
+		t.Errorf("DEBIAN/conffiles = %q, %v", conffiles, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "DEBIAN/postinst")); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("DEBIAN/postinst = %v, %v", info, err)
+	}
+	md5sums, err := os.ReadFile(filepath.Join(dir, "DEBIAN/md5sums"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sums := parseMd5sums(string(md5sums)); len(sums) != 2 || sums["usr/lib/extracted/app"] == "" {
+		t.Errorf("DEBIAN/md5sums = %q", md5sums)
+	}
+}
+
+func TestExtractToEscape(t *testing.T) {
+	pkg := &Package{Files: []File{
+		{DestPath: "/usr/escape", LinkTarget: "/"},
+		{DestPath: "/usr/escape/tmp/escaped", Mode: 0644, Body: "x"},
+	}}
+	if err := pkg.ExtractTo(t.TempDir(), ExtractOptions{}); err == nil {
+		t.Errorf("ExtractTo should fail to write through a link leaving the directory")
+	}
+
+	// ".." is resolved within the directory.
+	dir := t.TempDir()
+	pkg = &Package{Files: []File{{DestPath: "/../../dotdot", Mode: 0644, Body: "x"}}}
+	if err := pkg.ExtractTo(dir, ExtractOptions{}); err != nil {
+		t.Fatalf("ExtractTo failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dotdot")); err != nil {
+		t.Errorf("dotdot should be extracted in the directory: %v", err)
+	}
+}
+
+func TestNewPackageFromDir(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "tree", Version: "1.0", Architecture: "all", Description: "d", ExtraFields: map[string]string{"X-Custom": "yes"}},
+		Scripts:  Scripts{PreRm: "#!/bin/sh\n"},
+		Files: []File{
+			{DestPath: "/usr/lib/tree/app", Mode: 04755, Body: "binary"},
+			{DestPath: "/usr/bin/app", LinkTarget: "../lib/tree/app"},
+			{DestPath: "/etc/tree.conf", Mode: 0644, Body: "conf", IsConf: true},
+		},
+		ExtraControlFiles: map[string]string{"triggers": "interest-noawait /usr/lib/tree\n"},
+	}
+	dir := t.TempDir()
+	if err := pkg.ExtractTo(dir, ExtractOptions{Control: true}); err != nil {
+		t.Fatalf("ExtractTo failed: %v", err)
+	}
+	built, err := NewPackageFromDir(dir)
+	if err != nil {
+		t.Fatalf("NewPackageFromDir failed: %v", err)
+	}
+	if built.Metadata.Package != "tree" || built.Metadata.ExtraFields["X-Custom"] != "yes" || built.Scripts.PreRm != pkg.Scripts.PreRm {
+		t.Errorf("unexpected control %+v %+v", built.Metadata, built.Scripts)
+	}
+	if built.ExtraControlFiles["triggers"] != pkg.ExtraControlFiles["triggers"] {
+		t.Errorf("ExtraControlFiles = %v", built.ExtraControlFiles)
+	}
+
+	files := make(map[string]File)
+	for _, f := range built.Files {
+		if strings.HasPrefix(f.DestPath, "/DEBIAN") {
+			t.Errorf("the control directory should not be in the payload: %s", f.DestPath)
+		}
+		files[f.DestPath] = f
+	}
+	if app := files["/usr/lib/tree/app"]; app.Mode != 04755 || app.Size() != 6 {
+		t.Errorf("app = %+v", app)
+	}
+	if link := files["/usr/bin/app"]; link.LinkTarget != "../lib/tree/app" {
+		t.Errorf("link = %+v", link)
+	}
+	if conf := files["/etc/tree.conf"]; !conf.IsConf {
+		t.Errorf("/etc/tree.conf should be a conffile")
+	}
+	if usr := files["/usr"]; !usr.IsDir {
+		t.Errorf("/usr should be a directory entry")
+	}
+
+	// The package built from the tree is written with the same payload.
+	var buf bytes.Buffer
+	if _, err := built.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if !parsed.Equal(built) {
+		t.Errorf("the written package should be equal to the one built from the tree")
+	}
+}
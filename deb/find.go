@@ -0,0 +1,49 @@
+package deb
+
+import (
+	"regexp"
+	"slices"
+)
+
+// Filter selects packages, see Repository.Find. Any func(*Package) bool is a Filter, e.g. to filter
+// on a custom condition.
+type Filter func(*Package) bool
+
+// Find returns the packages of the repository matching all the filters, in the repository order.
+// Without filters, it returns all the packages.
+func (r *Repository) Find(filters ...Filter) []*Package {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []*Package
+	for _, p := range r.Packages {
+		if !slices.ContainsFunc(filters, func(f Filter) bool { return !f(p) }) {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// ByName selects the packages named name.
+func ByName(name string) Filter {
+	return func(p *Package) bool { return p.Metadata.Package == name }
+}
+
+// ByNameRegexp selects the packages whose name matches re, e.g. regexp.MustCompile("^lib").
+func ByNameRegexp(re *regexp.Regexp) Filter {
+	return func(p *Package) bool { return re.MatchString(p.Metadata.Package) }
+}
+
+// ByArchitecture selects the packages of the architecture arch, e.g. "amd64" or "all".
+func ByArchitecture(arch string) Filter {
+	return func(p *Package) bool { return p.Metadata.Architecture == arch }
+}
+
+// BySection selects the packages of the section, e.g. "utils".
+func BySection(section string) Filter {
+	return func(p *Package) bool { return p.Metadata.Section == section }
+}
+
+// ByVersion selects the packages whose version satisfies c, e.g. the result of ParseConstraint(">= 1.2").
+func ByVersion(c Constraint) Filter {
+	return func(p *Package) bool { return c.Matches(p.Metadata.Version) }
+}
@@ -0,0 +1,43 @@
+package deb
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRepositoryFind(t *testing.T) {
+	repo := &Repository{Packages: []*Package{
+		{Metadata: Metadata{Package: "app", Version: "1.0-1", Architecture: "amd64", Section: "utils"}},
+		{Metadata: Metadata{Package: "app", Version: "2.0-1", Architecture: "amd64", Section: "utils"}},
+		{Metadata: Metadata{Package: "app", Version: "2.0-1", Architecture: "arm64", Section: "utils"}},
+		{Metadata: Metadata{Package: "libapp", Version: "2.0-1", Architecture: "amd64", Section: "libs"}},
+		{Metadata: Metadata{Package: "app-doc", Version: "2.0-1", Architecture: "all", Section: "doc"}},
+	}}
+	newer, err := ParseConstraint(">= 2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		filters []Filter
+		want    []string
+	}{
+		{"all", nil, []string{"app_1.0-1_amd64", "app_2.0-1_amd64", "app_2.0-1_arm64", "libapp_2.0-1_amd64", "app-doc_2.0-1_all"}},
+		{"name", []Filter{ByName("app"), ByArchitecture("amd64")}, []string{"app_1.0-1_amd64", "app_2.0-1_amd64"}},
+		{"regexp", []Filter{ByNameRegexp(regexp.MustCompile("^app"))}, []string{"app_1.0-1_amd64", "app_2.0-1_amd64", "app_2.0-1_arm64", "app-doc_2.0-1_all"}},
+		{"section and version", []Filter{BySection("utils"), ByVersion(newer)}, []string{"app_2.0-1_amd64", "app_2.0-1_arm64"}},
+		{"custom", []Filter{func(p *Package) bool { return strings.HasSuffix(p.Metadata.Package, "-doc") }}, []string{"app-doc_2.0-1_all"}},
+		{"none", []Filter{ByName("missing")}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range repo.Find(tt.filters...) {
+			got = append(got, p.Metadata.Package+"_"+p.Metadata.Version+"_"+p.Metadata.Architecture)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Find = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package deb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// controlDir is the directory of the control files in a package tree, see NewPackageFromFS.
+const controlDir = "DEBIAN"
+
+// NewPackageFromDir creates a Package from the directory tree at path, like `dpkg-deb --build`.
+// See NewPackageFromFS.
+func NewPackageFromDir(path string) (*Package, error) {
+	return NewPackageFromFS(os.DirFS(path))
+}
+
+// NewPackageFromFS creates a Package from the tree at the root of fsys: every file, directory and
+// symbolic link is a payload entry at the same path, with its mode and modification time, owned by root.
+// The file contents are read from fsys when they are needed, so the tree must not change while the
+// package is in use.
+//
+// The optional DEBIAN directory holds the control files: control for the Metadata, conffiles for the
+// configuration files, the maintainer scripts, and the extra control files. md5sums is ignored, as
+// it is generated when the package is written.
+func NewPackageFromFS(fsys fs.FS) (*Package, error) {
+	pkg := &Package{
+		Metadata:          Metadata{ExtraFields: make(map[string]string)},
+		ExtraControlFiles: make(map[string]string),
+	}
+	conffiles, err := pkg.readControlDir(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		if name == controlDir {
+			return fs.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := File{
+			DestPath: "/" + name,
+			Mode:     unixModeOf(info.Mode()),
+			ModTime:  info.ModTime(),
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if f.LinkTarget, err = fs.ReadLink(fsys, name); err != nil {
+				return err
+			}
+			f.Mode = 0
+		case info.IsDir():
+			f.IsDir = true
+		case info.Mode().IsRegular():
+			f.Content = fsContent{fsys: fsys, name: name, size: info.Size()}
+			f.IsConf = conffiles[f.DestPath]
+		default:
+			return fmt.Errorf("%s: unsupported file type %v", name, info.Mode().Type())
+		}
+		pkg.Files = append(pkg.Files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading package tree: %w", err)
+	}
+	return pkg, nil
+}
+
+// readControlDir reads the control files of the DEBIAN directory of fsys, if any,
+// and returns the set of the conffiles it lists.
+func (p *Package) readControlDir(fsys fs.FS) (map[string]bool, error) {
+	entries, err := fs.ReadDir(fsys, controlDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	conffiles := make(map[string]bool)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		b, err := fs.ReadFile(fsys, controlDir+"/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+		content := string(b)
+		switch ControlFile(e.Name()) {
+		case FileControl:
+			if err := parseControlFile(content, &p.Metadata, DefaultLimits); err != nil {
+				return nil, fmt.Errorf("parsing %s/control: %w", controlDir, err)
+			}
+			p.recordControl(content)
+		case FileConffiles:
+			for line := range strings.SplitSeq(content, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					conffiles[line] = true
+				}
+			}
+		case FilePreinst:
+			p.Scripts.PreInst = content
+		case FilePostinst:
+			p.Scripts.PostInst = content
+		case FilePrerm:
+			p.Scripts.PreRm = content
+		case FilePostrm:
+			p.Scripts.PostRm = content
+		case FileConfig:
+			p.Scripts.Config = content
+		case FileMd5sums:
+			// Generated by WriteTo.
+		default:
+			if !strings.HasPrefix(e.Name(), ".") {
+				p.ExtraControlFiles[e.Name()] = content
+			}
+		}
+	}
+	return conffiles, nil
+}
+
+// fsContent is the Content of a file read from a file system.
+type fsContent struct {
+	fsys fs.FS
+	name string
+	size int64
+}
+
+func (c fsContent) Open() (io.ReadCloser, error) { return c.fsys.Open(c.name) }
+
+func (c fsContent) Size() int64 { return c.size }
+
+// unixModeOf returns the Unix mode of an fs.FileMode, e.g. 04755, as in the archive headers.
+func unixModeOf(m fs.FileMode) int64 {
+	mode := int64(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
@@ -0,0 +1,97 @@
+package deb
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Hash is a checksum algorithm of the repository indices and of the content digests.
+type Hash struct {
+	// Name is the name of the checksum fields in the Packages indices and Release files, e.g. "SHA256".
+	Name string
+	// New returns a new hash.Hash computing the checksum.
+	New func() hash.Hash
+}
+
+// The hashes supported by APT for the indices. MD5 and SHA1 are broken: modern APT ignores them,
+// they are only listed for older clients and proxies, next to SHA256 or SHA512.
+//
+// Reference: https://wiki.debian.org/DebianRepository/Format#MD5Sum.2C_SHA1.2C_SHA256
+var (
+	MD5    = Hash{Name: "MD5Sum", New: md5.New}
+	SHA1   = Hash{Name: "SHA1", New: sha1.New}
+	SHA256 = Hash{Name: "SHA256", New: sha256.New}
+	SHA512 = Hash{Name: "SHA512", New: sha512.New}
+)
+
+// DefaultHashes are the checksums of the indices of the repositories without explicit Hashes.
+var DefaultHashes = []Hash{SHA256}
+
+// digestHashOrDefault returns h, or SHA256 if it is the zero Hash, the algorithm of the content
+// digests without explicit DigestHash.
+func digestHashOrDefault(h Hash) Hash {
+	if h.New == nil {
+		return SHA256
+	}
+	return h
+}
+
+// HashByName returns the hash named name, e.g. "SHA512", case insensitively. "MD5" is MD5 too.
+func HashByName(name string) (Hash, error) {
+	if strings.EqualFold(name, "MD5") {
+		return MD5, nil
+	}
+	for _, h := range []Hash{MD5, SHA1, SHA256, SHA512} {
+		if strings.EqualFold(h.Name, name) {
+			return h, nil
+		}
+	}
+	return Hash{}, fmt.Errorf("unknown hash %q, expected %s, %s, %s or %s", name, MD5.Name, SHA1.Name, SHA256.Name, SHA512.Name)
+}
+
+// field returns the name of the checksum field of h in the Packages indices: the name of its
+// Release section, except "MD5sum" for MD5.
+func (h Hash) field() string {
+	if h.Name == MD5.Name {
+		return "MD5sum"
+	}
+	return h.Name
+}
+
+// hashesOrDefault returns hashes, or DefaultHashes if it is empty.
+func hashesOrDefault(hashes []Hash) []Hash {
+	if len(hashes) == 0 {
+		return DefaultHashes
+	}
+	return hashes
+}
+
+// sum returns the hex encoded checksum of content.
+func (h Hash) sum(content []byte) string {
+	w := h.New()
+	w.Write(content)
+	return hex.EncodeToString(w.Sum(nil))
+}
+
+// checksums returns the hex encoded checksums of content, one per hash.
+func checksums(hashes []Hash, content []byte) []string {
+	sums := make([]string, len(hashes))
+	for i, h := range hashes {
+		sums[i] = h.sum(content)
+	}
+	return sums
+}
+
+// digestReader returns a reader of r computing the digest of its content with hash, and a function
+// returning the hex encoded digest once r has been read.
+func digestReader(r io.Reader, hash Hash) (io.Reader, func() string) {
+	h := hash.New()
+	return io.TeeReader(r, h), func() string { return hex.EncodeToString(h.Sum(nil)) }
+}
@@ -0,0 +1,188 @@
+package deb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// IndexEntry is a package stanza of a Packages index.
+//
+// Reference: https://wiki.debian.org/DebianRepository/Format#Packages_Indices
+type IndexEntry struct {
+	// Metadata holds the control fields of the package, without the index-only fields.
+	Metadata Metadata
+	// Filename is the path of the package file, relative to the repository root.
+	Filename string
+	// Size is the size of the package file in bytes.
+	Size int64
+	// SHA256 is the hex encoded SHA256 checksum of the package file.
+	SHA256 string
+	// SHA512 is the hex encoded SHA512 checksum of the package file, if the index lists it.
+	SHA512 string
+}
+
+// ParsePackagesIndex parses the content of a Packages index, within the DefaultLimits.
+func ParsePackagesIndex(content string) ([]IndexEntry, error) {
+	return ParsePackagesIndexWithLimits(content, Limits{})
+}
+
+// ParsePackagesIndexWithLimits is like ParsePackagesIndex, and fails with a LimitError when the index
+// has more stanzas than limits.MaxEntries, or longer fields than limits.MaxFieldLength.
+func ParsePackagesIndexWithLimits(content string, limits Limits) ([]IndexEntry, error) {
+	return ParsePackagesIndexReader(strings.NewReader(content), limits)
+}
+
+// ParsePackagesIndexReader is like ParsePackagesIndexWithLimits, for an index read from r,
+// e.g. a gzip reader, without holding its content in memory. It also fails with a LimitError
+// when r is longer than limits.MaxBytes.
+func ParsePackagesIndexReader(r io.Reader, limits Limits) ([]IndexEntry, error) {
+	var entries []IndexEntry
+	s := newIndexScanner(r, limits)
+	for s.next() {
+		// The keys and values are substrings of a single string per stanza.
+		stanza := string(s.buf)
+		e := IndexEntry{Metadata: Metadata{ExtraFields: make(map[string]string)}}
+		for _, f := range s.fields {
+			key, value := stanza[f.key:f.start], strings.TrimSpace(stanza[f.start:f.end])
+			switch key {
+			case "Filename":
+				e.Filename = value
+			case "SHA256":
+				e.SHA256 = value
+			case "SHA512":
+				e.SHA512 = value
+			case "Size":
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("package %s: invalid Size %q", e.Metadata.Package, value)
+				}
+				e.Size = n
+			case "MD5sum", "SHA1", fieldDescriptionMD5:
+			default:
+				e.Metadata.setField(key, value)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.err
+}
+
+// parsePackagesIndex parses a Packages index file content, within the DefaultLimits.
+// It parses each stanza into a Package struct, without the index-specific fields
+// (Filename, Size, SHA256, etc.).
+func parsePackagesIndex(content string) ([]*Package, error) {
+	entries, err := ParsePackagesIndex(content)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make([]*Package, len(entries))
+	for i, e := range entries {
+		pkgs[i] = &Package{Metadata: e.Metadata}
+	}
+	return pkgs, nil
+}
+
+// indexScanner reads the stanzas of an index (a Packages index, or any control file with several
+// paragraphs) one at a time. The fields are read into a buffer reused across stanzas, so that
+// the caller allocates a single string per stanza.
+type indexScanner struct {
+	r      *bufio.Reader
+	b      *budget
+	err    error
+	buf    []byte
+	fields []indexField
+}
+
+// indexField is a field of the current stanza: the key and the value are ranges of the buffer.
+// The value does not include the spaces around the first line.
+type indexField struct {
+	key, start, end int
+}
+
+// newIndexScanner returns a scanner of the index read from r, within limits.
+func newIndexScanner(r io.Reader, limits Limits) *indexScanner {
+	b := newBudget(limits)
+	return &indexScanner{r: bufio.NewReaderSize(b.reader("Packages", r), 64<<10), b: b}
+}
+
+// next reads the next stanza, and reports whether there is one. It returns false at the end
+// of the index, or on errors, reported by s.err.
+func (s *indexScanner) next() bool {
+	s.buf = s.buf[:0]
+	s.fields = s.fields[:0]
+	for s.err == nil {
+		line, err := s.readLine()
+		switch {
+		case err != nil && err != io.EOF:
+			s.err = err
+			return false
+		case len(bytes.TrimSpace(line)) == 0 && (err == io.EOF || len(s.fields) > 0):
+			return s.end()
+		case len(bytes.TrimSpace(line)) == 0:
+			// Blank lines before the stanza.
+		case line[0] == ' ' || line[0] == '\t':
+			// A continuation line of the current field, if any.
+			if n := len(s.fields); n > 0 {
+				s.buf = append(s.buf, '\n')
+				s.buf = append(s.buf, line...)
+				s.fields[n-1].end = len(s.buf)
+				s.err = s.checkField(s.fields[n-1])
+			}
+		default:
+			if key, value, ok := bytes.Cut(line, []byte(":")); ok {
+				f := indexField{key: len(s.buf)}
+				s.buf = append(s.buf, key...)
+				f.start = len(s.buf)
+				s.buf = append(s.buf, bytes.TrimSpace(value)...)
+				f.end = len(s.buf)
+				s.fields = append(s.fields, f)
+				s.err = s.checkField(f)
+			}
+		}
+	}
+	return false
+}
+
+// end ends the current stanza, and reports whether it is one.
+func (s *indexScanner) end() bool {
+	if len(s.fields) == 0 {
+		return false
+	}
+	if s.err = s.b.entry("Packages"); s.err != nil {
+		return false
+	}
+	return true
+}
+
+// checkField returns a LimitError if the value of f is longer than the limits allow.
+// It is checked while the value grows, so that a huge field does not fill the memory.
+func (s *indexScanner) checkField(f indexField) error {
+	if f.end-f.start > s.b.limits.MaxFieldLength && len(bytes.TrimSpace(s.buf[f.start:f.end])) > s.b.limits.MaxFieldLength {
+		return &LimitError{Limit: "MaxFieldLength", Max: int64(s.b.limits.MaxFieldLength), Name: string(s.buf[f.key:f.start])}
+	}
+	return nil
+}
+
+// readLine returns the next line, without its line feed, valid until the next call.
+// Lines longer than the reader buffer are accumulated, up to the field length limit.
+func (s *indexScanner) readLine() ([]byte, error) {
+	line, err := s.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		long := bytes.Clone(line)
+		for errors.Is(err, bufio.ErrBufferFull) {
+			if len(long) > s.b.limits.MaxFieldLength+s.r.Size() {
+				return nil, &LimitError{Limit: "MaxFieldLength", Max: int64(s.b.limits.MaxFieldLength), Name: "Packages"}
+			}
+			line, err = s.r.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		line = long
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return line, err
+}
@@ -0,0 +1,86 @@
+package deb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// benchmarkIndex returns a Packages index of n stanzas, shaped like the ones of a distribution.
+func benchmarkIndex(n int) string {
+	var b strings.Builder
+	for i := range n {
+		fmt.Fprintf(&b, "Package: package-%d\n", i)
+		b.WriteString("Architecture: amd64\nVersion: 1:2.36.1-8+deb12u1\nPriority: optional\nSection: libs\n")
+		b.WriteString("Maintainer: Debian Maintainers <debian@example.org>\nInstalled-Size: 1234\n")
+		b.WriteString("Depends: libc6 (>= 2.34), libgcc-s1 (>= 3.0), zlib1g (>= 1:1.2.0)\n")
+		fmt.Fprintf(&b, "Filename: pool/main/p/package-%d/package-%d_2.36.1-8+deb12u1_amd64.deb\n", i, i)
+		b.WriteString("Size: 123456\nMD5sum: 0123456789abcdef0123456789abcdef\n")
+		b.WriteString("SHA256: 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef\n")
+		b.WriteString("Description: a package of the benchmark\n This is the long description\n .\n of the package.\n\n")
+	}
+	return b.String()
+}
+
+func TestParsePackagesIndexReader(t *testing.T) {
+	content := "Package: foo\nVersion: 1.0\nArchitecture: all\nDescription: short\n long\n .\n end\nX-Custom: value\n" +
+		"Filename: pool/foo.deb\nSize: 42\nSHA256: abc\nSHA512: def\n\n\n" +
+		"Package: bar\nVersion: 2.0\nArchitecture: amd64\nDepends: a, b (>= 1)\nFilename: bar.deb\n"
+	// One byte reads exercise the lines split across reads.
+	entries, err := ParsePackagesIndexReader(iotest.OneByteReader(strings.NewReader(content)), Limits{})
+	if err != nil {
+		t.Fatalf("ParsePackagesIndexReader failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	foo, bar := entries[0], entries[1]
+	if foo.Metadata.Package != "foo" || foo.Filename != "pool/foo.deb" || foo.Size != 42 || foo.SHA256 != "abc" || foo.SHA512 != "def" {
+		t.Errorf("unexpected entry %+v", foo)
+	}
+	if foo.Metadata.Description != "short\n long\n .\n end" {
+		t.Errorf("Description = %q", foo.Metadata.Description)
+	}
+	if len(foo.Metadata.ExtraFields) != 1 || foo.Metadata.ExtraFields["X-Custom"] != "value" {
+		t.Errorf("ExtraFields = %v, want only X-Custom", foo.Metadata.ExtraFields)
+	}
+	if !slices.Equal(bar.Metadata.Depends, []string{"a", "b (>= 1)"}) {
+		t.Errorf("Depends = %q", bar.Metadata.Depends)
+	}
+
+	// The same index, parsed from a string, gives the same entries.
+	fromString, err := ParsePackagesIndex(content)
+	if err != nil {
+		t.Fatalf("ParsePackagesIndex failed: %v", err)
+	}
+	if fmt.Sprint(fromString) != fmt.Sprint(entries) {
+		t.Errorf("ParsePackagesIndex = %+v, want %+v", fromString, entries)
+	}
+}
+
+func TestParsePackagesIndexReaderLimits(t *testing.T) {
+	long := "Package: foo\nDescription: " + strings.Repeat("x", 100) + "\n"
+	_, err := ParsePackagesIndexReader(strings.NewReader(long), Limits{MaxFieldLength: 50})
+	var le *LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxFieldLength" {
+		t.Errorf("got error %v, want a MaxFieldLength LimitError", err)
+	}
+	_, err = ParsePackagesIndexReader(strings.NewReader(benchmarkIndex(3)), Limits{MaxEntries: 2})
+	if !errors.As(err, &le) || le.Limit != "MaxEntries" {
+		t.Errorf("got error %v, want a MaxEntries LimitError", err)
+	}
+}
+
+func BenchmarkParsePackagesIndex(b *testing.B) {
+	content := benchmarkIndex(10000)
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := ParsePackagesIndex(content); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
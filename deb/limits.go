@@ -0,0 +1,133 @@
+package deb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Limits bounds the resources used to parse packages, repositories and indices,
+// which can come from untrusted sources (e.g. third party releases, upstream repositories).
+// A zero field uses the value of DefaultLimits.
+type Limits struct {
+	// MaxBytes is the maximum number of bytes decompressed from an archive: all the members of a .deb,
+	// or all the files of a repository archive.
+	MaxBytes int64
+	// MaxMemberBytes is the maximum size of a single entry of an archive: an ar member of a .deb as
+	// stored, a file of its control or data archive, or a file of a repository archive. It stops
+	// a crafted archive announcing a huge entry before its bytes are read.
+	MaxMemberBytes int64
+	// MaxEntries is the maximum number of entries of an archive (ar members and tar entries),
+	// or of stanzas of an index.
+	MaxEntries int
+	// MaxFieldLength is the maximum length of a control field value, continuation lines included.
+	MaxFieldLength int
+}
+
+// DefaultLimits are the limits of the parsers called without explicit Limits.
+// They are far above the needs of real packages and indices.
+var DefaultLimits = Limits{
+	MaxBytes:       8 << 30,
+	MaxMemberBytes: 4 << 30,
+	MaxEntries:     1 << 20,
+	MaxFieldLength: 1 << 20,
+}
+
+// withDefaults returns l, with the zero fields set from DefaultLimits.
+func (l Limits) withDefaults() Limits {
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultLimits.MaxBytes
+	}
+	if l.MaxMemberBytes <= 0 {
+		l.MaxMemberBytes = DefaultLimits.MaxMemberBytes
+	}
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = DefaultLimits.MaxEntries
+	}
+	if l.MaxFieldLength <= 0 {
+		l.MaxFieldLength = DefaultLimits.MaxFieldLength
+	}
+	return l
+}
+
+// ErrLimit is wrapped by every LimitError, to test for them with errors.Is.
+var ErrLimit = errors.New("limit exceeded")
+
+// LimitError is returned by the parsers when their input exceeds one of the Limits.
+type LimitError struct {
+	// Limit is the name of the exceeded field of Limits, e.g. "MaxBytes".
+	Limit string
+	// Max is the value of the limit.
+	Max int64
+	// Name is the archive member, file or field being parsed when the limit was exceeded.
+	Name string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s of %d exceeded", e.Name, e.Limit, e.Max)
+}
+
+// Unwrap returns ErrLimit.
+func (e *LimitError) Unwrap() error { return ErrLimit }
+
+// checkField returns a LimitError if the value of the field name is longer than the limits allow.
+func (l Limits) checkField(name, value string) error {
+	if len(value) > l.MaxFieldLength {
+		return &LimitError{Limit: "MaxFieldLength", Max: int64(l.MaxFieldLength), Name: name}
+	}
+	return nil
+}
+
+// budget counts the bytes and entries read from an archive against its limits.
+type budget struct {
+	limits  Limits
+	bytes   int64
+	entries int
+}
+
+// newBudget returns a budget for the limits, with their defaults.
+func newBudget(l Limits) *budget {
+	return &budget{limits: l.withDefaults()}
+}
+
+// entry counts an entry of the archive, named name.
+func (b *budget) entry(name string) error {
+	b.entries++
+	if b.entries > b.limits.MaxEntries {
+		return &LimitError{Limit: "MaxEntries", Max: int64(b.limits.MaxEntries), Name: name}
+	}
+	return nil
+}
+
+// member counts an entry of the archive, named name, of size bytes.
+func (b *budget) member(name string, size int64) error {
+	if size > b.limits.MaxMemberBytes {
+		return &LimitError{Limit: "MaxMemberBytes", Max: b.limits.MaxMemberBytes, Name: name}
+	}
+	return b.entry(name)
+}
+
+// reader returns a reader of r, the content of the member name, counting its bytes.
+func (b *budget) reader(name string, r io.Reader) io.Reader {
+	return &budgetReader{b: b, name: name, r: r}
+}
+
+// budgetReader is a reader failing with a LimitError when its budget has no bytes left.
+type budgetReader struct {
+	b    *budget
+	name string
+	r    io.Reader
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	// One byte more than the budget allows, to detect the overflow without reading much further.
+	if left := r.b.limits.MaxBytes - r.b.bytes + 1; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := r.r.Read(p)
+	r.b.bytes += int64(n)
+	if r.b.bytes > r.b.limits.MaxBytes {
+		return n, &LimitError{Limit: "MaxBytes", Max: r.b.limits.MaxBytes, Name: r.name}
+	}
+	return n, err
+}
@@ -0,0 +1,113 @@
+package deb
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Severity is the severity of a lint Finding.
+type Severity string
+
+const (
+	// SeverityError is a policy violation that breaks the package, or that the archives reject.
+	SeverityError Severity = "error"
+	// SeverityWarning is a policy violation that should be fixed.
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a policy violation reported by Lint.
+type Finding struct {
+	Severity Severity
+	// Tag identifies the check, in the style of the lintian tags, e.g. "conffile-not-in-etc".
+	Tag string
+	// Path is the payload file or the control file concerned, if any.
+	Path string
+	// Message describes the violation.
+	Message string
+}
+
+// String returns the finding as "<severity>: <tag> <path>: <message>".
+func (f Finding) String() string {
+	if f.Path == "" {
+		return fmt.Sprintf("%s: %s: %s", f.Severity, f.Tag, f.Message)
+	}
+	return fmt.Sprintf("%s: %s %s: %s", f.Severity, f.Tag, f.Path, f.Message)
+}
+
+// MaxSynopsisLength is the maximum length of the first line of the Description recommended by the policy.
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-binary.html#the-single-line-synopsis
+const MaxSynopsisLength = 80
+
+// fhsDirs are the top level directories where packages can install files.
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-opersys.html#file-system-hierarchy
+var fhsDirs = []string{"bin", "boot", "etc", "lib", "lib32", "lib64", "libx32", "opt", "sbin", "srv", "usr", "var"}
+
+// binDirs are the directories of the commands, whose files must be executable.
+var binDirs = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin", "/usr/games"}
+
+// Lint checks pkg for common policy violations, and returns the findings sorted by severity,
+// errors first, then by tag and path. It is more opinionated than Package.Verify: warnings
+// do not prevent dpkg from installing the package. A nil result means no violation was found.
+func Lint(pkg *Package) []Finding {
+	var findings []Finding
+	report := func(severity Severity, tag, path, format string, args ...any) {
+		findings = append(findings, Finding{Severity: severity, Tag: tag, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	synopsis, _, _ := strings.Cut(pkg.Metadata.Description, "\n")
+	switch {
+	case strings.TrimSpace(synopsis) == "":
+		report(SeverityError, "missing-description", "", "the Description field is empty")
+	case len(synopsis) > MaxSynopsisLength:
+		report(SeverityWarning, "synopsis-too-long", "", "the first line of the Description is %d characters long, more than %d", len(synopsis), MaxSynopsisLength)
+	}
+	fields := pkg.controlFields()
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		if value := fields[name]; len(value) > DefaultLimits.MaxFieldLength {
+			report(SeverityError, "field-too-long", "", "the %s field is %d bytes long, more than the %d bytes of DefaultLimits.MaxFieldLength", name, len(value), DefaultLimits.MaxFieldLength)
+		}
+	}
+
+	for _, name := range pkg.nonExecutableScripts {
+		report(SeverityError, "maintainer-script-not-executable", string(name), "the maintainer script is not executable")
+	}
+
+	for _, f := range pkg.Files {
+		top, _, _ := strings.Cut(strings.TrimPrefix(f.DestPath, "/"), "/")
+		switch {
+		case f.DestPath == "/usr/local" || strings.HasPrefix(f.DestPath, "/usr/local/"):
+			report(SeverityError, "file-in-usr-local", f.DestPath, "/usr/local is reserved to the local administrator")
+		case !slices.Contains(fhsDirs, top):
+			report(SeverityWarning, "file-outside-fhs", f.DestPath, "/%s is not a directory of the file system hierarchy where packages install files", top)
+		}
+		if f.IsConf && f.LinkTarget == "" && f.HardLink == "" && !f.IsDir && !strings.HasPrefix(f.DestPath, "/etc/") {
+			report(SeverityError, "conffile-not-in-etc", f.DestPath, "configuration files must be under /etc")
+		}
+		if f.LinkTarget == "" && !f.IsDir && f.Mode&0111 == 0 && slices.Contains(binDirs, path.Dir(f.DestPath)) {
+			report(SeverityWarning, "command-not-executable", f.DestPath, "the file is in a directory of commands, but is not executable")
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Severity != b.Severity {
+			return a.Severity == SeverityError
+		}
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		return a.Path < b.Path
+	})
+	return findings
+}
+
+// HasErrors reports whether findings contain a SeverityError finding.
+func HasErrors(findings []Finding) bool {
+	return slices.ContainsFunc(findings, func(f Finding) bool { return f.Severity == SeverityError })
+}
@@ -0,0 +1,73 @@
+package deb
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	clean := &Package{
+		Metadata: Metadata{Package: "clean", Version: "1.0", Architecture: "all", Description: "a clean package", ExtraFields: map[string]string{}},
+		Files: []File{
+			{DestPath: "/usr/bin/clean", Mode: 0755, Body: "#!/bin/sh\n"},
+			{DestPath: "/etc/clean.conf", Mode: 0644, Body: "x", IsConf: true},
+		},
+	}
+	if findings := Lint(clean); len(findings) != 0 {
+		t.Errorf("Lint(clean) = %v, want no finding", findings)
+	}
+
+	dirty := &Package{
+		Metadata: Metadata{Package: "dirty", Version: "1.0", Architecture: "all", ExtraFields: map[string]string{}},
+		Files: []File{
+			{DestPath: "/usr/bin/dirty", Mode: 0644, Body: "#!/bin/sh\n"},
+			{DestPath: "/usr/share/dirty/dirty.conf", Mode: 0644, Body: "x", IsConf: true},
+			{DestPath: "/home/dirty/file", Mode: 0644, Body: "x"},
+			{DestPath: "/usr/local/bin/dirty", Mode: 0755, Body: "x"},
+		},
+	}
+	findings := Lint(dirty)
+	var tags []string
+	for _, f := range findings {
+		tags = append(tags, f.Tag)
+	}
+	want := []string{"conffile-not-in-etc", "file-in-usr-local", "missing-description", "command-not-executable", "file-outside-fhs"}
+	if !slices.Equal(tags, want) {
+		t.Errorf("Lint(dirty) tags = %q, want %q", tags, want)
+	}
+	if !HasErrors(findings) {
+		t.Errorf("HasErrors should be true")
+	}
+	if got := findings[0].String(); got != "error: conffile-not-in-etc /usr/share/dirty/dirty.conf: configuration files must be under /etc" {
+		t.Errorf("String = %q", got)
+	}
+
+	long := &Package{Metadata: Metadata{Package: "long", Description: strings.Repeat("x", 81)}}
+	if findings := Lint(long); len(findings) != 1 || findings[0].Tag != "synopsis-too-long" || HasErrors(findings) {
+		t.Errorf("Lint(long) = %v, want a synopsis-too-long warning", findings)
+	}
+}
+
+func TestLintNonExecutableScript(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "scripted", Version: "1.0", Architecture: "all", Description: "d", ExtraFields: map[string]string{}},
+		Scripts:  Scripts{PostInst: "#!/bin/sh\n"},
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if findings := Lint(parsed); len(findings) != 0 {
+		t.Errorf("scripts written by WriteTo are executable, got %v", findings)
+	}
+	parsed.nonExecutableScripts = []ControlFile{FilePostinst}
+	if findings := Lint(parsed); len(findings) != 1 || findings[0].Tag != "maintainer-script-not-executable" || findings[0].Path != "postinst" {
+		t.Errorf("Lint = %v, want a maintainer-script-not-executable error", findings)
+	}
+}
@@ -3,14 +3,19 @@ package deb
 import (
 	"archive/tar"
 	"bytes"
-	"compress/gzip"
+	"cmp"
 	"crypto/md5"
-	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,8 +34,50 @@ type Package struct {
 	// Reserved names ("control", "md5sums", "conffiles", "preinst", "postinst", "prerm", "postrm", "config") are ignored.
 	ExtraControlFiles map[string]string
 
+	// Compression is the compression of the control and data archives written by WriteTo,
+	// CompressionGzip if empty. NewPackage sets it to the compression of the data archive read.
+	Compression Compression
+
+	// BuildTime, if set, is the modification time of the archive members written by WriteTo, and of
+	// the files without ModTime. Later file ModTimes are clamped to it, so that rebuilding the package
+	// gives the same bytes. If zero, the SOURCE_DATE_EPOCH environment variable is used when set,
+	// and the current time otherwise.
+	//
+	// Reference: https://reproducible-builds.org/specs/source-date-epoch/
+	BuildTime time.Time
+
+	// Udeb makes a Debian installer component (.udeb) instead of a regular package: StandardFilename
+	// has the .udeb extension, and the control archive has no md5sums nor conffiles, that udebs must
+	// not carry. NewPackage sets it for the packages in the "debian-installer" section.
+	//
+	// Reference: https://wiki.debian.org/DebianInstaller/Modules
+	Udeb bool
+
+	// SigningKey, if set, is the ASCII-armored PGP private key signing the package: WriteTo adds
+	// a _gpgorigin member, the detached signature of the other members, like debsigs.
+	// See VerifyPackageSignature.
+	SigningKey string
+
+	// DefaultOwner and DefaultGroup own the payload files that have no Owner or Group, as names
+	// or numeric ids, e.g. to ship a whole tree owned by a service user. Empty means root.
+	// The control archive is always owned by root.
+	DefaultOwner string
+	DefaultGroup string
+
 	originalContentDigest string
 	onDiskDigest          string
+	// onDiskHash is the algorithm of onDiskDigest, SHA256 if zero.
+	onDiskHash Hash
+	// original is the original .deb file, see SetOriginalContent.
+	original []byte
+	// md5sums is the md5sums file read by NewPackage, by path without leading slash, see Verify.
+	md5sums map[string]string
+	// nonExecutableScripts are the maintainer scripts read by NewPackage without execute permission, see Lint.
+	nonExecutableScripts []ControlFile
+	// controlOnly is set for a package read with ReadOptions.ControlOnly, that has no payload.
+	controlOnly bool
+	// originalControl are the fields of the control file read by NewPackage, in order, see generateControlFile.
+	originalControl []originalField
 }
 
 // Metadata maps directly to the fields in the Debian 'control' file.
@@ -151,6 +198,12 @@ type Metadata struct {
 	// Reference: https://www.debian.org/doc/debian-policy/ch-controlfields.html#s-f-source
 	Source string
 
+	// InstalledSize is the Installed-Size field, an estimate of the disk space used by the package in KiB,
+	// as read by NewPackage or from an index. WriteTo ignores it and writes InstalledSize() instead.
+	//
+	// Reference: https://www.debian.org/doc/debian-policy/ch-controlfields.html#installed-size
+	InstalledSize int64
+
 	// ExtraFields holds any custom or non-standard fields that should be written to the control file.
 	// Examples include "Bugs", "Origin", or internal metadata.
 	//
@@ -203,6 +256,10 @@ type File struct {
 	// Body is the source of the file content.
 	Body string
 
+	// Content, if set, is the source of the file content instead of Body, for contents that are not
+	// held in memory, e.g. the files of a package parsed with a Spool. See Open and ReadBody.
+	Content Content
+
 	// IsConf, if true, marks this file as a configuration file in the 'conffiles' list.
 	// dpkg will prompt the user before overwriting this file during upgrades.
 	//
@@ -210,16 +267,61 @@ type File struct {
 	IsConf bool
 
 	// ModTime is the modification time stored in the archive.
-	// If zero, the current time is used.
+	// If zero, the Package.BuildTime is used.
 	ModTime time.Time
+
+	// Owner and Group are the user and group owning the file, as names (e.g. "www-data") or numeric ids.
+	// Empty means Package.DefaultOwner and Package.DefaultGroup, or root. dpkg resolves names when unpacking, so the user must exist by then
+	// (e.g. created in preinst).
+	Owner string
+	Group string
+
+	// LinkTarget, if set, makes this entry a symbolic link to LinkTarget (e.g. "../lib/app/app"
+	// or "/etc/alternatives/editor"). Body, Content and IsConf are then ignored.
+	LinkTarget string
+
+	// HardLink, if set, makes this entry a hard link to the regular file of the payload at HardLink
+	// (e.g. "/bin/busybox"), which must come first in Files. Body, Content and IsConf are then ignored.
+	HardLink string
+
+	// IsDir, if true, makes this entry a directory, e.g. an empty directory or one with a specific
+	// Mode or Owner. Body, Content and IsConf are then ignored. Parent directories need no entry.
+	IsDir bool
 }
 
 // StandardFilename returns the canonical filename for the package.
-// Format: {Package}_{Version}_{Architecture}.deb
+// Format: {Package}_{Version}_{Architecture}.deb, or .udeb if Udeb is set. The ':' of an epoch
+// is encoded as "%3a", like apt does in its cache, e.g. "foo_1%3a2.0-1_amd64.deb", as it is not
+// allowed in the file names of many file systems and in URLs. See ParseStandardFilename.
 //
 // Reference: https://www.debian.org/doc/manuals/debian-faq/ch-pkg_basics.en.html#s-pkgname
 func (p *Package) StandardFilename() string {
-	return fmt.Sprintf("%s_%s_%s.deb", p.Metadata.Package, p.Metadata.Version, p.Metadata.Architecture)
+	ext := "deb"
+	if p.Udeb {
+		ext = "udeb"
+	}
+	version := strings.ReplaceAll(p.Metadata.Version, ":", epochEscape)
+	return fmt.Sprintf("%s_%s_%s.%s", p.Metadata.Package, version, p.Metadata.Architecture, ext)
+}
+
+// epochEscape is the encoding of the ':' of an epoch in a package filename.
+const epochEscape = "%3a"
+
+// ParseStandardFilename returns the package name, version and architecture of a package filename
+// in the format of StandardFilename, e.g. "foo_1%3a2.0-1_amd64.deb" gives "foo", "1:2.0-1" and
+// "amd64". The directory of name is ignored, and the ':' of the epoch can be encoded or not.
+func ParseStandardFilename(name string) (pkg, version, arch string, err error) {
+	base := path.Base(name)
+	trimmed, ok := strings.CutSuffix(base, ".deb")
+	if !ok {
+		trimmed, ok = strings.CutSuffix(base, ".udeb")
+	}
+	parts := strings.Split(trimmed, "_")
+	if !ok || len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("invalid package filename %q: want {Package}_{Version}_{Architecture}.deb", name)
+	}
+	version = strings.NewReplacer(epochEscape, ":", strings.ToUpper(epochEscape), ":").Replace(parts[1])
+	return parts[0], version, parts[2], nil
 }
 
 // UpstreamVersion returns the upstream part of the version (everything before the last hyphen).
@@ -286,7 +388,7 @@ func (p *Package) Set(key, value string) {
 	case FieldSource:
 		p.Metadata.Source = value
 	case FieldInstalledSize:
-		// ignored, computed at generation time.
+		p.Metadata.InstalledSize, _ = strconv.ParseInt(value, 10, 64)
 	default:
 		if p.Metadata.ExtraFields == nil {
 			p.Metadata.ExtraFields = make(map[string]string)
@@ -295,25 +397,45 @@ func (p *Package) Set(key, value string) {
 	}
 }
 
+// Get returns the value of a control field as written in the control file, or "" if it is not set.
+func (p *Package) Get(key string) string {
+	return p.controlFields()[key]
+}
+
 // WriteTo generates the .deb package and writes it to the provided io.Writer.
 // It returns the total number of bytes written and any error encountered.
 // This satisfies the io.WriterTo interface.
 func (p *Package) WriteTo(w io.Writer) (int64, error) {
+	return p.WriteToWithOptions(w, WriteOptions{})
+}
+
+// WriteToWithOptions is like WriteTo, with explicit options, e.g. the fastest compression in CI
+// and the best one for releases. The options are not part of the Digest.
+func (p *Package) WriteToWithOptions(w io.Writer, opts WriteOptions) (int64, error) {
+	opts.Compression = cmp.Or(opts.Compression, p.Compression)
 	// Wrapper to count bytes written for io.WriterTo return value
 	cw := &countingWriter{w: w}
+	if p.controlOnly {
+		return 0, fmt.Errorf("package %s was read without its payload", p.Metadata.Package)
+	}
 
-	// 1. Build Data Archive (data.tar.gz)
+	clk, err := p.clock()
+	if err != nil {
+		return 0, err
+	}
+
+	// 1. Build Data Archive (data.tar.gz, or per Compression)
 	// We must build this first to calculate MD5 sums of files for the control archive.
 	dataBuf := new(bytes.Buffer)
-	md5Map, installedSize, err := p.buildDataArchive(dataBuf)
+	md5Map, installedSize, err := p.buildDataArchive(dataBuf, clk, opts)
 	if err != nil {
 		return cw.n, fmt.Errorf("building data archive: %w", err)
 	}
 
-	// 2. Build Control Archive (control.tar.gz)
+	// 2. Build Control Archive (control.tar.gz, or per Compression)
 	// Requires metadata and the MD5 sums calculated in step 1.
 	controlBuf := new(bytes.Buffer)
-	if err := p.buildControlArchive(controlBuf, md5Map, installedSize); err != nil {
+	if err := p.buildControlArchive(controlBuf, md5Map, installedSize, clk, opts); err != nil {
 		return cw.n, fmt.Errorf("building control archive: %w", err)
 	}
 
@@ -328,27 +450,114 @@ func (p *Package) WriteTo(w io.Writer) (int64, error) {
 
 	// 3b. Write debian-binary file (Must be first member)
 	// Reference: https://manpages.debian.org/unstable/dpkg-dev/deb.5.en.html#FORMAT
-	if err := addBufferToAr(arW, string(PkgDebianBinary), []byte("2.0\n")); err != nil {
+	if err := addBufferToAr(arW, string(PkgDebianBinary), []byte("2.0\n"), clk.now); err != nil {
 		return cw.n, fmt.Errorf("writing %s: %w", PkgDebianBinary, err)
 	}
 
 	// 3c. Write control.tar.gz (Must be second member)
-	if err := addBufferToAr(arW, string(PkgControlTarGz), controlBuf.Bytes()); err != nil {
-		return cw.n, fmt.Errorf("writing %s: %w", PkgControlTarGz, err)
+	controlName := "control.tar" + opts.Compression.extension()
+	if err := addBufferToAr(arW, controlName, controlBuf.Bytes(), clk.now); err != nil {
+		return cw.n, fmt.Errorf("writing %s: %w", controlName, err)
 	}
 
 	// 3d. Write data.tar.gz (Must be third member)
-	if err := addBufferToAr(arW, string(PkgDataTarGz), dataBuf.Bytes()); err != nil {
-		return cw.n, fmt.Errorf("writing %s: %w", PkgDataTarGz, err)
+	dataName := "data.tar" + opts.Compression.extension()
+	if err := addBufferToAr(arW, dataName, dataBuf.Bytes(), clk.now); err != nil {
+		return cw.n, fmt.Errorf("writing %s: %w", dataName, err)
+	}
+
+	// 3e. Write _gpgorigin, the signature of the members, in their order.
+	if p.SigningKey != "" {
+		signed := slices.Concat([]byte("2.0\n"), controlBuf.Bytes(), dataBuf.Bytes())
+		signature, err := DetachSign(signed, p.SigningKey)
+		if err != nil {
+			return cw.n, fmt.Errorf("signing package: %w", err)
+		}
+		if err := addBufferToAr(arW, string(PkgGpgOrigin), signature, clk.now); err != nil {
+			return cw.n, fmt.Errorf("writing %s: %w", PkgGpgOrigin, err)
+		}
 	}
 
 	return cw.n, nil
 }
 
+// clock gives the modification times of the archive entries written by WriteTo.
+type clock struct {
+	// now is the build time.
+	now time.Time
+	// clamp is true if the modification times must not be later than now.
+	clamp bool
+}
+
+// clock returns the clock of the package BuildTime, see newClock.
+func (p *Package) clock() (clock, error) {
+	return newClock(p.BuildTime)
+}
+
+// timeNow returns the current time of the packages built without BuildTime nor SOURCE_DATE_EPOCH.
+// Tests replace it to build packages at another time.
+var timeNow = time.Now
+
+// newClock returns the clock of buildTime if set, or of SOURCE_DATE_EPOCH, or of the current time.
+func newClock(buildTime time.Time) (clock, error) {
+	if !buildTime.IsZero() {
+		return clock{now: buildTime.Truncate(time.Second), clamp: true}, nil
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return clock{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", epoch, err)
+		}
+		return clock{now: time.Unix(sec, 0), clamp: true}, nil
+	}
+	return clock{now: timeNow().Truncate(time.Second)}, nil
+}
+
+// DefaultBuildTime returns the build time of the packages without BuildTime: SOURCE_DATE_EPOCH when set,
+// and the current time otherwise. Files written next to the packages use it to be reproducible too.
+func DefaultBuildTime() (time.Time, error) {
+	c, err := newClock(time.Time{})
+	return c.now, err
+}
+
+// modTime returns the modification time of an entry whose file was modified at t.
+func (c clock) modTime(t time.Time) time.Time {
+	if t.IsZero() || c.clamp && t.After(c.now) {
+		return c.now
+	}
+	return t
+}
+
+// InstalledSize returns the Installed-Size written by WriteTo, in KiB: the total size of the files,
+// rounded up. A package without files, e.g. read from an index, returns Metadata.InstalledSize.
+func (p *Package) InstalledSize() int64 {
+	if len(p.Files) == 0 {
+		return p.Metadata.InstalledSize
+	}
+	var size int64
+	for _, f := range p.Files {
+		if !f.IsDir && f.LinkTarget == "" && f.HardLink == "" {
+			size += f.Size()
+		}
+	}
+	return installedKiB(size)
+}
+
+// installedKiB returns a size in bytes as an Installed-Size, in KiB rounded up.
+func installedKiB(size int64) int64 {
+	return (size + 1023) / 1024
+}
+
 // buildDataArchive creates the data.tar.gz containing the package files.
 // It returns a map of file paths to MD5 checksums and the total installed size in bytes.
-func (p *Package) buildDataArchive(w io.Writer) (map[string]string, int64, error) {
-	gw := gzip.NewWriter(w)
+func (p *Package) buildDataArchive(w io.Writer, clk clock, opts WriteOptions) (map[string]string, int64, error) {
+	if err := errors.Join(checkPayloadPaths(p.Files)...); err != nil {
+		return nil, 0, err
+	}
+	gw, err := opts.Compression.newWriter(w, opts.Level)
+	if err != nil {
+		return nil, 0, err
+	}
 	defer gw.Close()
 	tw := tar.NewWriter(gw)
 	defer tw.Close()
@@ -357,105 +566,219 @@ func (p *Package) buildDataArchive(w io.Writer) (map[string]string, int64, error
 	var installedSize int64
 
 	for _, file := range p.Files {
-		// We must read the whole file to calculate size and MD5 before writing the tar header.
-		content := []byte(file.Body)
-
-		// Calculate MD5
-		hash := md5.Sum(content)
-		md5Map[file.DestPath] = hex.EncodeToString(hash[:])
+		if file.LinkTarget != "" {
+			// Symbolic links have no content, and are not listed in md5sums.
+			header := &tar.Header{
+				Typeflag: tar.TypeSymlink,
+				Name:     dataPath(file.DestPath),
+				Linkname: file.LinkTarget,
+				Mode:     0777,
+				ModTime:  clk.modTime(file.ModTime),
+			}
+			setOwner(header, cmp.Or(file.Owner, p.DefaultOwner), cmp.Or(file.Group, p.DefaultGroup))
+			if err := tw.WriteHeader(header); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if file.HardLink != "" {
+			// Hard links share the content of their target, listed first, and its MD5 sum.
+			sum, ok := md5Map[file.HardLink]
+			if !ok {
+				return nil, 0, fmt.Errorf("hard link %s: %s is not a previous regular file", file.DestPath, file.HardLink)
+			}
+			header := &tar.Header{
+				Typeflag: tar.TypeLink,
+				Name:     dataPath(file.DestPath),
+				Linkname: dataPath(file.HardLink),
+				Mode:     file.Mode,
+				ModTime:  clk.modTime(file.ModTime),
+			}
+			setOwner(header, cmp.Or(file.Owner, p.DefaultOwner), cmp.Or(file.Group, p.DefaultGroup))
+			if err := tw.WriteHeader(header); err != nil {
+				return nil, 0, err
+			}
+			md5Map[file.DestPath] = sum
+			continue
+		}
+		if file.IsDir {
+			// Directories have no content, and are not listed in md5sums.
+			header := &tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     strings.TrimSuffix(dataPath(file.DestPath), "/") + "/",
+				Mode:     file.dirMode(),
+				ModTime:  clk.modTime(file.ModTime),
+			}
+			setOwner(header, cmp.Or(file.Owner, p.DefaultOwner), cmp.Or(file.Group, p.DefaultGroup))
+			if err := tw.WriteHeader(header); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
 
-		size := int64(len(content))
+		// The content is streamed to the archive, and to the MD5 sum.
+		size := file.Size()
 		installedSize += size
 
-		// Prepare Tar Header
-		// Remove leading slash to make path relative (standard for data.tar)
-		relPath := strings.TrimPrefix(file.DestPath, "/")
-		// Ensure it starts with ./ for strict Debian compliance
-		if !strings.HasPrefix(relPath, "./") {
-			relPath = "./" + relPath
-		}
-
 		header := &tar.Header{
-			Name:    relPath,
-			Size:    size,
-			Mode:    file.Mode,
-			ModTime: file.ModTime,
-		}
-		if header.ModTime.IsZero() {
-			header.ModTime = time.Now()
+			Typeflag: tar.TypeReg,
+			Name:     dataPath(file.DestPath),
+			Size:     size,
+			Mode:     file.Mode,
+			ModTime:  clk.modTime(file.ModTime),
 		}
+		setOwner(header, cmp.Or(file.Owner, p.DefaultOwner), cmp.Or(file.Group, p.DefaultGroup))
 
 		if err := tw.WriteHeader(header); err != nil {
 			return nil, 0, err
 		}
-		if _, err := tw.Write(content); err != nil {
-			return nil, 0, err
+		content, err := file.Open()
+		if err != nil {
+			return nil, 0, fmt.Errorf("opening %s: %w", file.DestPath, err)
+		}
+		hash := md5.New()
+		_, err = io.Copy(io.MultiWriter(tw, hash), content)
+		content.Close()
+		if err != nil {
+			return nil, 0, fmt.Errorf("writing %s: %w", file.DestPath, err)
 		}
+		md5Map[file.DestPath] = hex.EncodeToString(hash.Sum(nil))
 	}
 	return md5Map, installedSize, nil
 }
 
+// dirMode returns the mode of a directory entry, 0755 if Mode is not set.
+func (f File) dirMode() int64 {
+	if f.Mode == 0 {
+		return 0755
+	}
+	return f.Mode
+}
+
+// setOwner sets the owner and group of an archive entry, from names or numeric ids.
+// Empty names and id 0 are written explicitly as root (root:root, 0/0), like dpkg-deb.
+func setOwner(h *tar.Header, owner, group string) {
+	h.Uid, h.Uname = ownerID(owner)
+	h.Gid, h.Gname = ownerID(group)
+}
+
+// ownerID returns the id and name of an archive entry owner: a numeric id has no name,
+// except root, and a name has id 0, so that dpkg resolves it when unpacking.
+func ownerID(owner string) (int, string) {
+	switch id, err := strconv.Atoi(owner); {
+	case owner == "" || owner == "root" || err == nil && id == 0:
+		return 0, "root"
+	case err == nil:
+		return id, ""
+	default:
+		return 0, owner
+	}
+}
+
+// ownerOf returns the owner and group of a data archive entry, as set by setOwner.
+// root is returned as empty strings.
+func ownerOf(h *tar.Header) (owner, group string) {
+	name := func(name string, id int) string {
+		switch {
+		case name == "root" || name == "" && id == 0:
+			return ""
+		case name != "":
+			return name
+		default:
+			return strconv.Itoa(id)
+		}
+	}
+	return name(h.Uname, h.Uid), name(h.Gname, h.Gid)
+}
+
+// ownership returns "owner:group" with root as an empty name, or "" if the file is owned by root.
+func (f File) ownership() string {
+	root := func(s string) string {
+		if s == "root" || s == "0" {
+			return ""
+		}
+		return s
+	}
+	owner, group := root(f.Owner), root(f.Group)
+	if owner == "" && group == "" {
+		return ""
+	}
+	return owner + ":" + group
+}
+
+// dataPath returns the name of the data archive entry for the destination path.
+func dataPath(destPath string) string {
+	// Remove leading slash to make path relative (standard for data.tar)
+	relPath := strings.TrimPrefix(destPath, "/")
+	// Ensure it starts with ./ for strict Debian compliance
+	if !strings.HasPrefix(relPath, "./") {
+		relPath = "./" + relPath
+	}
+	return relPath
+}
+
 // buildControlArchive creates the control.tar.gz containing metadata files.
-func (p *Package) buildControlArchive(w io.Writer, md5Map map[string]string, installedSize int64) error {
-	gw := gzip.NewWriter(w)
+func (p *Package) buildControlArchive(w io.Writer, md5Map map[string]string, installedSize int64, clk clock, opts WriteOptions) error {
+	gw, err := opts.Compression.newWriter(w, opts.Level)
+	if err != nil {
+		return err
+	}
 	defer gw.Close()
 	tw := tar.NewWriter(gw)
 	defer tw.Close()
 
-	// Helper to write a file to the tarball
-	writeEntry := func(name ControlFile, content []byte, mode int64) error {
+	for _, e := range p.controlEntries(md5Map, installedSize) {
 		header := &tar.Header{
-			Name:    "./" + string(name),
-			Size:    int64(len(content)),
-			Mode:    mode,
-			ModTime: time.Now(),
+			Typeflag: tar.TypeReg,
+			Name:     "./" + string(e.name),
+			Size:     int64(len(e.content)),
+			Mode:     e.mode,
+			ModTime:  clk.now,
 		}
+		setOwner(header, "", "")
 		if err := tw.WriteHeader(header); err != nil {
-			return err
+			return fmt.Errorf("writing %s: %w", e.name, err)
+		}
+		if _, err := tw.Write(e.content); err != nil {
+			return fmt.Errorf("writing %s: %w", e.name, err)
 		}
-		_, err := tw.Write(content)
-		return err
 	}
+	return nil
+}
+
+// controlEntry is a file of the control archive.
+type controlEntry struct {
+	name    ControlFile
+	content []byte
+	mode    int64
+}
 
+// controlEntries returns the files of the control archive, in their archive order, from the MD5 sums
+// of the payload files and their total installed size in bytes.
+func (p *Package) controlEntries(md5Map map[string]string, installedSize int64) []controlEntry {
 	// 1. control
-	controlContent := p.generateControlFile(installedSize)
-	if err := writeEntry(FileControl, []byte(controlContent), 0644); err != nil {
-		return fmt.Errorf("writing control: %w", err)
-	}
+	entries := []controlEntry{{FileControl, []byte(p.generateControlFile(installedSize)), 0644}}
 
-	// 2. md5sums
-	md5Content := p.generateMd5sums(md5Map)
-	if err := writeEntry(FileMd5sums, []byte(md5Content), 0644); err != nil {
-		return fmt.Errorf("writing md5sums: %w", err)
+	// 2. md5sums, and 3. conffiles, that udebs do not have.
+	if !p.Udeb {
+		entries = append(entries, controlEntry{FileMd5sums, []byte(p.generateMd5sums(md5Map)), 0644})
 	}
 
-	// 3. conffiles
 	var conffiles []string
 	for _, f := range p.Files {
-		if f.IsConf {
+		if !p.Udeb && f.IsConf && f.LinkTarget == "" && f.HardLink == "" && !f.IsDir {
 			conffiles = append(conffiles, f.DestPath)
 		}
 	}
 	if len(conffiles) > 0 {
 		content := strings.Join(conffiles, "\n") + "\n"
-		if err := writeEntry(FileConffiles, []byte(content), 0644); err != nil {
-			return fmt.Errorf("writing conffiles: %w", err)
-		}
+		entries = append(entries, controlEntry{FileConffiles, []byte(content), 0644})
 	}
 
-	// 4. Maintainer Scripts
-	scripts := map[ControlFile]string{
-		FilePreinst:  p.Scripts.PreInst,
-		FilePostinst: p.Scripts.PostInst,
-		FilePrerm:    p.Scripts.PreRm,
-		FilePostrm:   p.Scripts.PostRm,
-		FileConfig:   p.Scripts.Config,
-	}
-	for name, body := range scripts {
-		if body != "" {
-			if err := writeEntry(name, []byte(body), 0755); err != nil {
-				return fmt.Errorf("writing %s: %w", name, err)
-			}
+	// 4. Maintainer Scripts, in a fixed order for reproducible builds.
+	for _, s := range p.maintainerScripts() {
+		if s.content != "" {
+			entries = append(entries, controlEntry{s.name, []byte(s.content), 0755})
 		}
 	}
 
@@ -472,23 +795,62 @@ func (p *Package) buildControlArchive(w io.Writer, md5Map map[string]string, ins
 		case FileControl, FileMd5sums, FileConffiles, FilePreinst, FilePostinst, FilePrerm, FilePostrm, FileConfig:
 			continue
 		}
-		content := p.ExtraControlFiles[name]
-		if content != "" {
-			if err := writeEntry(ControlFile(name), []byte(content), 0644); err != nil {
-				return fmt.Errorf("writing extra control file %s: %w", name, err)
-			}
+		if content := p.ExtraControlFiles[name]; content != "" {
+			entries = append(entries, controlEntry{ControlFile(name), []byte(content), 0644})
 		}
 	}
-
-	return nil
+	return entries
 }
 
+// generateControlFile returns the content of the control file. The fields of a control file read
+// by NewPackage keep their order, and their formatting while their value is unchanged, so that
+// changing a field only changes its line. The other fields follow, in the standard order.
 func (p *Package) generateControlFile(installedBytes int64) string {
+	installedSize := installedKiB(installedBytes)
+	fields := p.formatControlFields(installedSize)
 	var b strings.Builder
+	if p.originalControl != nil {
+		values := p.controlValues(installedSize)
+		for _, o := range p.originalControl {
+			i := slices.IndexFunc(fields, func(f controlField) bool { return f.name == o.name })
+			if i < 0 {
+				// The field was removed.
+				continue
+			}
+			if values[o.name] == o.value {
+				b.WriteString(o.text)
+			} else {
+				b.WriteString(fields[i].text)
+			}
+			fields = slices.Delete(fields, i, i+1)
+		}
+	}
+	for _, f := range fields {
+		b.WriteString(f.text)
+	}
+	return b.String()
+}
+
+// controlField is a field of a control file, as written: its name, and its text, e.g.
+// "Depends: libc6,\n libssl3\n" for a folded field.
+type controlField struct {
+	name, text string
+}
 
+// originalField is a field of the control file read by NewPackage, with its value at that time,
+// as returned by controlValues.
+type originalField struct {
+	controlField
+	value string
+}
+
+// formatControlFields returns the fields of the control file, in the standard order, the extra
+// fields sorted by name.
+func (p *Package) formatControlFields(installedSize int64) []controlField {
+	var fields []controlField
 	writeField := func(field ControlField, value string) {
 		if value != "" {
-			fmt.Fprintf(&b, "%s: %s\n", field, value)
+			fields = append(fields, controlField{string(field), fmt.Sprintf("%s: %s\n", field, value)})
 		}
 	}
 
@@ -498,9 +860,7 @@ func (p *Package) generateControlFile(installedBytes int64) string {
 	writeField(FieldArchitecture, p.Metadata.Architecture)
 	writeField(FieldMaintainer, p.Metadata.Maintainer)
 
-	// Installed-Size is in kilobytes, rounded up
-	kbytes := (installedBytes + 1023) / 1024
-	writeField(FieldInstalledSize, fmt.Sprintf("%d", kbytes))
+	writeField(FieldInstalledSize, strconv.FormatInt(installedSize, 10))
 
 	// Optional fields
 	writeField(FieldSection, p.Metadata.Section)
@@ -530,15 +890,16 @@ func (p *Package) generateControlFile(installedBytes int64) string {
 	writeField(FieldBuiltUsing, p.Metadata.BuiltUsing)
 	writeField(FieldSource, p.Metadata.Source)
 
-	// Extra fields
-	for k, v := range p.Metadata.ExtraFields {
-		writeField(ControlField(k), v)
+	// Extra fields, sorted for reproducible builds.
+	for _, k := range slices.Sorted(maps.Keys(p.Metadata.ExtraFields)) {
+		writeField(ControlField(k), p.Metadata.ExtraFields[k])
 	}
 
 	// Description
 	if p.Metadata.Description != "" {
+		var b strings.Builder
 		lines := strings.Split(p.Metadata.Description, "\n")
-		writeField(FieldDescription, lines[0])
+		fmt.Fprintf(&b, "%s: %s\n", FieldDescription, lines[0])
 		for _, line := range lines[1:] {
 			if strings.TrimSpace(line) == "" {
 				fmt.Fprintf(&b, " .\n")
@@ -551,9 +912,44 @@ func (p *Package) generateControlFile(installedBytes int64) string {
 				}
 			}
 		}
+		fields = append(fields, controlField{string(FieldDescription), b.String()})
 	}
 
-	return b.String()
+	return fields
+}
+
+// controlValues returns the values of the control fields, see controlFields, with the Installed-Size.
+func (p *Package) controlValues(installedSize int64) map[string]string {
+	values := p.controlFields()
+	values[string(FieldInstalledSize)] = strconv.FormatInt(installedSize, 10)
+	return values
+}
+
+// recordControl records the order and the formatting of the fields of the control file content,
+// just parsed into the Metadata, see generateControlFile.
+func (p *Package) recordControl(content string) {
+	values := p.controlValues(p.Metadata.InstalledSize)
+	p.originalControl = []originalField{}
+	for _, f := range splitControlFields(content) {
+		p.originalControl = append(p.originalControl, originalField{f, values[f.name]})
+	}
+}
+
+// maintainerScript is a maintainer script of a package, by control file name.
+type maintainerScript struct {
+	name    ControlFile
+	content string
+}
+
+// maintainerScripts returns the maintainer scripts of the package, set or not, in a fixed order.
+func (p *Package) maintainerScripts() []maintainerScript {
+	return []maintainerScript{
+		{FilePreinst, p.Scripts.PreInst},
+		{FilePostinst, p.Scripts.PostInst},
+		{FilePrerm, p.Scripts.PreRm},
+		{FilePostrm, p.Scripts.PostRm},
+		{FileConfig, p.Scripts.Config},
+	}
 }
 
 func (p *Package) generateMd5sums(md5Map map[string]string) string {
@@ -572,8 +968,52 @@ func (p *Package) generateMd5sums(md5Map map[string]string) string {
 	return b.String()
 }
 
-// NewPackage creates a Package struct from a .deb file reader.
+// NewPackage creates a Package struct from a .deb file reader, within the DefaultLimits.
 func NewPackage(r io.Reader) (*Package, error) {
+	return NewPackageWithLimits(r, Limits{})
+}
+
+// NewPackageWithLimits is like NewPackage, and fails with a LimitError when the package exceeds limits.
+func NewPackageWithLimits(r io.Reader, limits Limits) (*Package, error) {
+	return NewPackageWithOptions(r, ReadOptions{Limits: limits})
+}
+
+// ReadOptions configures how packages are parsed.
+type ReadOptions struct {
+	// Limits bounds the resources used by the parser.
+	Limits Limits
+	// Spool, if set, stores the payload files instead of memory: their File.Content reads them
+	// from the spool, and their Body is empty. The package is valid until the spool is closed.
+	Spool *Spool
+	// BlobStore, if set, stores the payload files like Spool, which it takes precedence over.
+	BlobStore BlobStore
+	// SpillThreshold is the size in bytes above which the payload files are stored in the Spool
+	// or the BlobStore, the smaller ones being kept in memory. Zero stores every file.
+	SpillThreshold int64
+	// DigestHash is the algorithm of the digests of the package files read by NewRepositoryWithOptions,
+	// recorded to reuse the unchanged files when the repository is written again. Zero uses SHA256.
+	DigestHash Hash
+	// ControlOnly stops reading after the control archive, e.g. to index packages: the package has
+	// its metadata, maintainer scripts and control files, but no Files. It can still be published
+	// from its original content while unchanged, but WriteTo fails.
+	ControlOnly bool
+}
+
+// store returns the store of the payload files, or nil to keep them in memory.
+func (o ReadOptions) store() BlobStore {
+	if o.BlobStore != nil {
+		return o.BlobStore
+	}
+	if o.Spool != nil {
+		return o.Spool
+	}
+	return nil
+}
+
+// NewPackageWithOptions is like NewPackage, with explicit options.
+// The package is parsed in a single pass over r, so it can be a network or pipe stream.
+func NewPackageWithOptions(r io.Reader, opts ReadOptions) (*Package, error) {
+	b := newBudget(opts.Limits)
 	pkg := &Package{
 		Metadata:          Metadata{ExtraFields: make(map[string]string)},
 		ExtraControlFiles: make(map[string]string),
@@ -589,19 +1029,17 @@ func NewPackage(r io.Reader) (*Package, error) {
 		if err != nil {
 			return nil, fmt.Errorf("reading ar header: %w", err)
 		}
+		if err := b.member(header.Name, header.Size); err != nil {
+			return nil, err
+		}
 
 		if strings.HasPrefix(header.Name, "control.tar") {
-			var tr *tar.Reader
-			if strings.HasSuffix(header.Name, ".gz") {
-				gzr, err := gzip.NewReader(arR)
-				if err != nil {
-					return nil, fmt.Errorf("opening control.tar.gz: %w", err)
-				}
-				defer gzr.Close()
-				tr = tar.NewReader(gzr)
-			} else {
-				tr = tar.NewReader(arR)
+			zr, closeZr, err := decompress(header.Name, arR)
+			if err != nil {
+				return nil, err
 			}
+			defer closeZr()
+			tr := tar.NewReader(b.reader(header.Name, zr))
 
 			for {
 				th, err := tr.Next()
@@ -611,6 +1049,9 @@ func NewPackage(r io.Reader) (*Package, error) {
 				if err != nil {
 					return nil, fmt.Errorf("reading control tar header: %w", err)
 				}
+				if err := b.member(th.Name, th.Size); err != nil {
+					return nil, err
+				}
 
 				name := filepath.Base(th.Name)
 				var buf bytes.Buffer
@@ -619,11 +1060,18 @@ func NewPackage(r io.Reader) (*Package, error) {
 				}
 				content := buf.String()
 
+				switch ControlFile(name) {
+				case FilePreinst, FilePostinst, FilePrerm, FilePostrm, FileConfig:
+					if th.Mode&0111 == 0 {
+						pkg.nonExecutableScripts = append(pkg.nonExecutableScripts, ControlFile(name))
+					}
+				}
 				switch ControlFile(name) {
 				case FileControl:
-					if err := parseControlFile(content, &pkg.Metadata); err != nil {
+					if err := parseControlFile(content, &pkg.Metadata, b.limits); err != nil {
 						return nil, fmt.Errorf("parsing control file: %w", err)
 					}
+					pkg.recordControl(content)
 				case FileConffiles:
 					conffiles = strings.Split(strings.TrimSpace(content), "\n")
 				case FilePreinst:
@@ -637,7 +1085,8 @@ func NewPackage(r io.Reader) (*Package, error) {
 				case FileConfig:
 					pkg.Scripts.Config = content
 				case FileMd5sums:
-					// Ignore
+					// Regenerated by WriteTo, kept to Verify the payload.
+					pkg.md5sums = parseMd5sums(content)
 				default:
 					if !strings.HasPrefix(name, ".") {
 						pkg.ExtraControlFiles[name] = content
@@ -645,17 +1094,20 @@ func NewPackage(r io.Reader) (*Package, error) {
 				}
 			}
 		} else if strings.HasPrefix(header.Name, "data.tar") {
-			var tr *tar.Reader
-			if strings.HasSuffix(header.Name, ".gz") {
-				gzr, err := gzip.NewReader(arR)
-				if err != nil {
-					return nil, fmt.Errorf("opening data.tar.gz: %w", err)
-				}
-				defer gzr.Close()
-				tr = tar.NewReader(gzr)
-			} else {
-				tr = tar.NewReader(arR)
+			pkg.Compression, err = compressionOf(header.Name)
+			if err != nil {
+				return nil, err
 			}
+			if opts.ControlOnly {
+				pkg.controlOnly = true
+				break
+			}
+			zr, closeZr, err := decompress(header.Name, arR)
+			if err != nil {
+				return nil, err
+			}
+			defer closeZr()
+			tr := tar.NewReader(b.reader(header.Name, zr))
 
 			for {
 				th, err := tr.Next()
@@ -665,29 +1117,79 @@ func NewPackage(r io.Reader) (*Package, error) {
 				if err != nil {
 					return nil, fmt.Errorf("reading data tar header: %w", err)
 				}
+				if err := b.member(th.Name, th.Size); err != nil {
+					return nil, err
+				}
 
-				if th.Typeflag != tar.TypeReg {
+				owner, group := ownerOf(th)
+				if th.Typeflag == tar.TypeSymlink {
+					pkg.Files = append(pkg.Files, File{
+						DestPath:   destPathOf(th.Name),
+						Mode:       th.Mode,
+						ModTime:    th.ModTime,
+						Owner:      owner,
+						Group:      group,
+						LinkTarget: th.Linkname,
+					})
 					continue
 				}
-
-				var buf bytes.Buffer
-				if _, err := io.Copy(&buf, tr); err != nil {
-					return nil, fmt.Errorf("reading file %s: %w", th.Name, err)
+				if th.Typeflag == tar.TypeDir {
+					// The root directory is implicit.
+					if destPath := destPathOf(th.Name); destPath != "/" {
+						pkg.Files = append(pkg.Files, File{
+							DestPath: strings.TrimSuffix(destPath, "/"),
+							Mode:     th.Mode,
+							ModTime:  th.ModTime,
+							Owner:    owner,
+							Group:    group,
+							IsDir:    true,
+						})
+					}
+					continue
+				}
+				if th.Typeflag == tar.TypeLink {
+					pkg.Files = append(pkg.Files, File{
+						DestPath: destPathOf(th.Name),
+						Mode:     th.Mode,
+						ModTime:  th.ModTime,
+						Owner:    owner,
+						Group:    group,
+						HardLink: destPathOf(th.Linkname),
+					})
+					continue
+				}
+				if th.Typeflag != tar.TypeReg {
+					continue
 				}
 
-				destPath := "/" + strings.TrimPrefix(th.Name, "./")
-				destPath = strings.ReplaceAll(destPath, "//", "/")
-
-				pkg.Files = append(pkg.Files, File{
-					DestPath: destPath,
+				file := File{
+					DestPath: destPathOf(th.Name),
 					Mode:     th.Mode,
-					Body:     buf.String(),
 					ModTime:  th.ModTime,
-				})
+					Owner:    owner,
+					Group:    group,
+				}
+				if store := opts.store(); store != nil && th.Size > opts.SpillThreshold {
+					if file.Content, err = store.Put(tr); err != nil {
+						return nil, fmt.Errorf("reading file %s: %w", th.Name, err)
+					}
+				} else {
+					var buf bytes.Buffer
+					if _, err := io.Copy(&buf, tr); err != nil {
+						return nil, fmt.Errorf("reading file %s: %w", th.Name, err)
+					}
+					file.Body = buf.String()
+				}
+				pkg.Files = append(pkg.Files, file)
 			}
 		}
 	}
 
+	// The entries must not be extracted out of the target directory, nor overwrite one another.
+	if err := errors.Join(checkPayloadPaths(pkg.Files)...); err != nil {
+		return nil, fmt.Errorf("invalid data archive: %w", err)
+	}
+
 	if len(conffiles) > 0 {
 		confSet := make(map[string]bool)
 		for _, cf := range conffiles {
@@ -701,26 +1203,24 @@ func NewPackage(r io.Reader) (*Package, error) {
 			}
 		}
 	}
+	// The udebs are in their own section.
+	pkg.Udeb = pkg.Metadata.Section == "debian-installer"
 
 	return pkg, nil
 }
 
+// destPathOf returns the destination path of a data archive entry name.
+func destPathOf(name string) string {
+	destPath := "/" + strings.TrimPrefix(name, "./")
+	return strings.ReplaceAll(destPath, "//", "/")
+}
+
 // Digest computes a deterministic SHA256 hash of the package content.
 // It includes metadata, scripts, and file contents, but excludes file modification times
-// and is insensitive to the order of files in the payload.
-func (p *Package) Digest() string {
-	// Ensure Installed-Size is up to date.
-	// TODO it's a problem if the source has a wrong installed size, this will change the value of the Package that is supposed to be immutable.
-	// We should probably calculate the installed size in NewPackage and store it in the Metadata,
-	// then use that value here instead of recalculating it.
-	var installedSize int64
-	for _, f := range p.Files {
-		installedSize += int64(len(f.Body))
-	}
-	kbytes := (installedSize + 1023) / 1024
-	p.Set(string(FieldInstalledSize), fmt.Sprintf("%d", kbytes))
-
-	h := sha256.New()
+// and is insensitive to the order of files in the payload. It does not include Metadata.InstalledSize,
+// derived from the files. It fails if the content of a file cannot be read.
+func (p *Package) Digest() (string, error) {
+	h := SHA256.New()
 
 	// write appends a length-prefixed string to the hash to ensure uniqueness.
 	write := func(s string) {
@@ -798,15 +1298,49 @@ func (p *Package) Digest() string {
 
 	for _, f := range files {
 		write(f.DestPath)
+		f.Owner, f.Group = cmp.Or(f.Owner, p.DefaultOwner), cmp.Or(f.Group, p.DefaultGroup)
+		if owner := f.ownership(); owner != "" {
+			write("owner:" + owner)
+		}
+		if f.LinkTarget != "" {
+			// The mode and content of symbolic links are not meaningful.
+			write("link:" + f.LinkTarget)
+			continue
+		}
+		if f.HardLink != "" {
+			write("hardlink:" + f.HardLink)
+			write(fmt.Sprintf("%d", f.Mode))
+			continue
+		}
+		if f.IsDir {
+			write(fmt.Sprintf("dir:%d", f.dirMode()))
+			continue
+		}
 		write(fmt.Sprintf("%d", f.Mode))
 		write(fmt.Sprintf("%v", f.IsConf))
-		write(f.Body)
+		if f.Content == nil {
+			write(f.Body)
+			continue
+		}
+		// Streamed like write, without holding the content in memory.
+		fmt.Fprintf(h, "%d:", f.Content.Size())
+		r, err := f.Content.Open()
+		if err != nil {
+			return "", fmt.Errorf("opening %s: %w", f.DestPath, err)
+		}
+		_, err = io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", f.DestPath, err)
+		}
+		h.Write([]byte{0})
 	}
 
-	return hex.EncodeToString(h.Sum(nil))
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // Equal compares two packages for data equality using their Digest.
+// Packages whose Digest fails are not equal.
 func (p *Package) Equal(other *Package) bool {
 	if p == nil && other == nil {
 		return true
@@ -814,7 +1348,12 @@ func (p *Package) Equal(other *Package) bool {
 	if p == nil || other == nil {
 		return false
 	}
-	return p.Digest() == other.Digest()
+	d, err := p.Digest()
+	if err != nil {
+		return false
+	}
+	o, err := other.Digest()
+	return err == nil && d == o
 }
 
 // SetOriginalState records the digests of the package when loaded from disk.
@@ -823,6 +1362,54 @@ func (p *Package) SetOriginalState(contentDigest, diskDigest string) {
 	p.onDiskDigest = diskDigest
 }
 
+// SetOriginalContent records content, the .deb file the package was parsed from, e.g. a package
+// harvested from another repository. As long as the package is not modified, the repository
+// writers publish content byte for byte, with its checksums, instead of regenerating it.
+func (p *Package) SetOriginalContent(content []byte) {
+	p.original = content
+	p.setOriginalFile(SHA256.sum(content), SHA256)
+}
+
+// setOriginalFile records the digest, computed with hash, of the file the package was loaded from.
+// A package whose Digest fails is never original.
+func (p *Package) setOriginalFile(digest string, hash Hash) {
+	contentDigest, _ := p.Digest()
+	p.SetOriginalState(contentDigest, digest)
+	p.onDiskHash = hash
+}
+
+// isOriginalFile reports whether the package is unchanged since it was loaded from a file with
+// the content of existing, see IsOriginal.
+func (p *Package) isOriginalFile(existing []byte) bool {
+	contentDigest, err := p.Digest()
+	return err == nil && p.IsOriginal(contentDigest, digestHashOrDefault(p.onDiskHash).sum(existing))
+}
+
+// originalContent returns the content recorded by SetOriginalContent, or nil if there is none
+// or the package was modified since.
+func (p *Package) originalContent() []byte {
+	if p.original == nil {
+		return nil
+	}
+	if digest, err := p.Digest(); err != nil || digest != p.originalContentDigest {
+		return nil
+	}
+	return p.original
+}
+
+// content returns the .deb file of the package: its original content if it is unchanged,
+// or a newly generated one, written with opts.
+func (p *Package) content(opts WriteOptions) ([]byte, error) {
+	if content := p.originalContent(); content != nil {
+		return content, nil
+	}
+	var buf bytes.Buffer
+	if _, err := p.WriteToWithOptions(&buf, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // IsOriginal checks if the package content matches the state when it was loaded
 // and if the provided disk digest matches the original file on disk.
 func (p *Package) IsOriginal(currentContentDigest, diskDigest string) bool {
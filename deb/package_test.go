@@ -1,15 +1,26 @@
 package deb
 
 import (
+	"archive/tar"
 	"bytes"
+	"cmp"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
+
+	"github.com/blakesmith/ar"
 )
 
 func TestGenerateControlFile(t *testing.T) {
@@ -76,7 +87,7 @@ func TestBuildDataArchive(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	md5Map, size, err := p.buildDataArchive(&buf)
+	md5Map, size, err := p.buildDataArchive(&buf, clock{now: time.Now()}, WriteOptions{})
 	if err != nil {
 		t.Fatalf("buildDataArchive failed: %v", err)
 	}
@@ -92,6 +103,57 @@ func TestBuildDataArchive(t *testing.T) {
 	}
 }
 
+func TestControlFieldOrder(t *testing.T) {
+	control := `Package: ordered
+Version: 1.0-1
+Architecture: all
+Installed-Size: 1
+X-Zebra: first
+Depends: libc6 (>= 2.34),
+         libssl3
+Maintainer: Dev <dev@example.com>
+Description: an ordered package
+ with a long description.
+X-Alpha: last
+`
+	pkg, err := NewPackageFromFS(fstest.MapFS{
+		"DEBIAN/control":      {Data: []byte(control)},
+		"usr/share/ordered/a": {Data: []byte("a"), Mode: 0644},
+	})
+	if err != nil {
+		t.Fatalf("NewPackageFromFS failed: %v", err)
+	}
+	if got := pkg.generateControlFile(1); got != control {
+		t.Errorf("the unchanged control file should be written as read, got:\n%s", got)
+	}
+
+	pkg.Metadata.Version = "1.0-2"
+	pkg.Metadata.ExtraFields["X-Middle"] = "new"
+	pkg.Metadata.ExtraFields["X-Alpha"] = ""
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	got, err := extractControlFromBytes(buf.Bytes(), DefaultLimits)
+	if err != nil {
+		t.Fatalf("reading the control file failed: %v", err)
+	}
+	want := strings.Replace(control, "Version: 1.0-1", "Version: 1.0-2", 1)
+	want = strings.Replace(want, "X-Alpha: last\n", "X-Middle: new\n", 1)
+	if got != want {
+		t.Errorf("control file =\n%s\nwant\n%s", got, want)
+	}
+
+	// Read back, the package keeps the order.
+	parsed, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if got := parsed.generateControlFile(1); got != want {
+		t.Errorf("the parsed control file =\n%s\nwant\n%s", got, want)
+	}
+}
+
 func TestStandardFilename(t *testing.T) {
 	p := &Package{
 		Metadata: Metadata{
@@ -103,6 +165,73 @@ func TestStandardFilename(t *testing.T) {
 	if got := p.StandardFilename(); got != "foo_1.0.0_arm64.deb" {
 		t.Errorf("expected foo_1.0.0_arm64.deb, got %s", got)
 	}
+	p.Metadata.Version = "1:2.0-1"
+	if got := p.StandardFilename(); got != "foo_1%3a2.0-1_arm64.deb" {
+		t.Errorf("expected foo_1%%3a2.0-1_arm64.deb, got %s", got)
+	}
+	for _, name := range []string{"foo_1%3a2.0-1_arm64.deb", "pool/main/foo/foo_1%3A2.0-1_arm64.deb", "foo_1:2.0-1_arm64.deb"} {
+		pkg, version, arch, err := ParseStandardFilename(name)
+		if err != nil || pkg != "foo" || version != "1:2.0-1" || arch != "arm64" {
+			t.Errorf("ParseStandardFilename(%q) = %q, %q, %q, %v", name, pkg, version, arch, err)
+		}
+	}
+	for _, name := range []string{"foo_1.0.deb", "foo_1.0_all.tar", "_1.0_all.deb", "foo_1_0_all.deb"} {
+		if _, _, _, err := ParseStandardFilename(name); err == nil {
+			t.Errorf("ParseStandardFilename(%q) should fail", name)
+		}
+	}
+}
+
+func TestUdeb(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "foo-udeb", Version: "1.0", Architecture: "amd64", Section: "debian-installer", ExtraFields: map[string]string{}},
+		Files:    []File{{DestPath: "/lib/foo", Mode: 0644, Body: "foo"}},
+		Udeb:     true,
+	}
+	if got := pkg.StandardFilename(); got != "foo-udeb_1.0_amd64.udeb" {
+		t.Errorf("expected foo-udeb_1.0_amd64.udeb, got %s", got)
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if !parsed.Udeb {
+		t.Error("a package in the debian-installer section should be read as a udeb")
+	}
+	if parsed.md5sums != nil {
+		t.Error("a udeb should have no md5sums")
+	}
+
+	pkg.Files[0].IsConf = true
+	for _, e := range pkg.controlEntries(nil, 0) {
+		if e.name == FileMd5sums || e.name == FileConffiles {
+			t.Errorf("a udeb should have no %s", e.name)
+		}
+	}
+	if err := pkg.Verify(); err == nil || !strings.Contains(err.Error(), "udebs cannot have configuration files") {
+		t.Errorf("Verify() = %v, want a configuration file error", err)
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	p := &Package{}
+	p.Set("Section", "utils")
+	p.Set("Depends", "libc6, libssl3")
+	p.Set("X-Custom", "value")
+	for key, want := range map[string]string{
+		"Section":  "utils",
+		"Depends":  "libc6, libssl3",
+		"X-Custom": "value",
+		"Homepage": "",
+	} {
+		if got := p.Get(key); got != want {
+			t.Errorf("Get(%q) = %q, want %q", key, got, want)
+		}
+	}
 }
 
 func TestIntegrationDebGeneration(t *testing.T) {
@@ -162,3 +291,662 @@ func TestIntegrationDebGeneration(t *testing.T) {
 		t.Errorf("missing file in contents: %s", contents)
 	}
 }
+
+func TestSymlinkRoundTrip(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{
+			Package:      "links",
+			Version:      "1.0",
+			Architecture: "all",
+			ExtraFields:  map[string]string{},
+		},
+		Files: []File{
+			{DestPath: "/usr/lib/app/app", Mode: 0755, Body: "binary"},
+			{DestPath: "/usr/bin/app", LinkTarget: "../lib/app/app", IsConf: true},
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if len(parsed.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(parsed.Files))
+	}
+	link := parsed.Files[1]
+	if link.DestPath != "/usr/bin/app" || link.LinkTarget != "../lib/app/app" || link.Body != "" {
+		t.Errorf("unexpected link entry: %+v", link)
+	}
+	if link.IsConf {
+		t.Errorf("symbolic links must not be conffiles")
+	}
+	if !parsed.Equal(pkg) {
+		t.Errorf("parsed package should be equal to the original one")
+	}
+}
+
+func TestInstalledSize(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "sized", Version: "1.0", Architecture: "all"},
+		Files: []File{
+			{DestPath: "/usr/share/sized/a", Mode: 0644, Body: strings.Repeat("a", 1500)},
+			{DestPath: "/usr/share/sized/b", Mode: 0644, Body: strings.Repeat("b", 1000)},
+			{DestPath: "/usr/share/sized/c", LinkTarget: "a"},
+		},
+	}
+	if got := pkg.InstalledSize(); got != 3 {
+		t.Errorf("InstalledSize = %d, want 3", got)
+	}
+	digest, err := pkg.Digest()
+	if err != nil {
+		t.Fatalf("Digest failed: %v", err)
+	}
+	if pkg.Metadata.InstalledSize != 0 || pkg.Get(string(FieldInstalledSize)) != "" {
+		t.Errorf("Digest should not change the package: %+v", pkg.Metadata)
+	}
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if parsed.Metadata.InstalledSize != 3 {
+		t.Errorf("Metadata.InstalledSize = %d, want 3", parsed.Metadata.InstalledSize)
+	}
+	if got, err := parsed.Digest(); err != nil || got != digest {
+		t.Errorf("the parsed package should have the same digest")
+	}
+
+	indexed := &Package{Metadata: Metadata{Package: "sized", InstalledSize: 42}}
+	if got := indexed.InstalledSize(); got != 42 {
+		t.Errorf("InstalledSize of a package without files = %d, want 42", got)
+	}
+}
+
+func TestNewBinaryPackage(t *testing.T) {
+	pkg, err := NewBinaryPackage("hello", "1.0-1", "amd64", strings.NewReader(strings.Repeat("x", 2048)))
+	if err != nil {
+		t.Fatalf("NewBinaryPackage failed: %v", err)
+	}
+	pkg.Metadata.Maintainer = "Jane Doe <jane@example.com>"
+	pkg.Metadata.Description = "says hello"
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	m := parsed.Metadata
+	if m.Section != "utils" || m.Priority != "optional" || m.InstalledSize != 2 || m.Architecture != "amd64" {
+		t.Errorf("unexpected metadata: %+v", m)
+	}
+	if len(parsed.Files) != 1 || parsed.Files[0].DestPath != "/usr/bin/hello" || parsed.Files[0].Mode != 0755 {
+		t.Errorf("unexpected files: %+v", parsed.Files)
+	}
+
+	for _, args := range [][3]string{{"Hello", "1.0", "amd64"}, {"hello", "1.0-", "amd64"}, {"hello", "1.0", ""}} {
+		if _, err := NewBinaryPackage(args[0], args[1], args[2], strings.NewReader("")); err == nil {
+			t.Errorf("NewBinaryPackage%q should fail", args)
+		}
+	}
+}
+
+func TestWriteOptions(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "compressed", Version: "1.0", Architecture: "all"},
+		Files:    []File{{DestPath: "/usr/share/compressed/data", Mode: 0644, Body: strings.Repeat("some data to compress ", 1000)}},
+	}
+	size := func(opts WriteOptions) int {
+		var buf bytes.Buffer
+		if _, err := pkg.WriteToWithOptions(&buf, opts); err != nil {
+			t.Fatalf("%+v: WriteToWithOptions failed: %v", opts, err)
+		}
+		n := buf.Len()
+		parsed, err := NewPackage(&buf)
+		if err != nil {
+			t.Fatalf("%+v: NewPackage failed: %v", opts, err)
+		}
+		if want := cmp.Or(opts.Compression, CompressionGzip); parsed.Compression != want || !parsed.Equal(pkg) {
+			t.Errorf("%+v: parsed Compression = %q, want an equal package in %q", opts, parsed.Compression, want)
+		}
+		return n
+	}
+	if fastest, best := size(WriteOptions{Level: 1}), size(WriteOptions{Level: 9}); fastest <= best {
+		t.Errorf("gzip: the fastest level (%d bytes) should compress less than the best one (%d bytes)", fastest, best)
+	}
+	if none, zstd := size(WriteOptions{Compression: CompressionNone}), size(WriteOptions{Compression: CompressionZstd, Level: 19}); none <= zstd {
+		t.Errorf("the uncompressed package (%d bytes) should be larger than the zstd one (%d bytes)", none, zstd)
+	}
+	for _, opts := range []WriteOptions{{Level: 10}, {Compression: CompressionZstd, Level: 23}} {
+		if _, err := pkg.WriteToWithOptions(io.Discard, opts); err == nil {
+			t.Errorf("%+v: an invalid level should fail", opts)
+		}
+	}
+}
+
+func TestHardLinkRoundTrip(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "multicall", Version: "1.0", Architecture: "all", Maintainer: "Test <test@example.com>", Description: "Multi-call binary"},
+		Files: []File{
+			{DestPath: "/bin/busybox", Mode: 0755, Body: strings.Repeat("x", 4096)},
+			{DestPath: "/bin/ls", Mode: 0755, HardLink: "/bin/busybox"},
+			{DestPath: "/bin/cat", Mode: 0755, HardLink: "/bin/busybox"},
+		},
+	}
+	if got := pkg.InstalledSize(); got != 4 {
+		t.Errorf("InstalledSize = %d, want 4: hard links take no space", got)
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if len(parsed.Files) != 3 || parsed.Files[1].HardLink != "/bin/busybox" || parsed.Files[1].Body != "" {
+		t.Fatalf("unexpected files: %+v", parsed.Files)
+	}
+	if !parsed.Equal(pkg) {
+		t.Errorf("parsed package should be equal to the original one")
+	}
+	if err := parsed.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := parsed.ExtractTo(dir, ExtractOptions{}); err != nil {
+		t.Fatalf("ExtractTo failed: %v", err)
+	}
+	target, err := os.Stat(filepath.Join(dir, "bin/busybox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Stat(filepath.Join(dir, "bin/ls")); err != nil || !os.SameFile(target, link) {
+		t.Errorf("bin/ls should be a hard link to bin/busybox (%v)", err)
+	}
+
+	pkg.Files = []File{{DestPath: "/bin/ls", HardLink: "/bin/busybox"}, pkg.Files[0]}
+	if _, err := pkg.WriteTo(io.Discard); err == nil {
+		t.Error("a hard link before its target should fail")
+	}
+	if err := pkg.Verify(); err == nil || !strings.Contains(err.Error(), "hard link /bin/ls") {
+		t.Errorf("Verify = %v, want a hard link error", err)
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for _, c := range []Compression{"", CompressionGzip, CompressionZstd, CompressionXz, CompressionNone} {
+		pkg := &Package{
+			Metadata:    Metadata{Package: "compressed", Version: "1.0", Architecture: "all", ExtraFields: map[string]string{}},
+			Files:       []File{{DestPath: "/usr/share/compressed/data", Mode: 0644, Body: strings.Repeat("data", 100)}},
+			Compression: c,
+		}
+		var buf bytes.Buffer
+		if _, err := pkg.WriteTo(&buf); err != nil {
+			t.Fatalf("%q: WriteTo failed: %v", c, err)
+		}
+		content := buf.Bytes()
+
+		var members []string
+		arR := ar.NewReader(bytes.NewReader(content))
+		for {
+			header, err := arR.Next()
+			if err != nil {
+				break
+			}
+			members = append(members, header.Name)
+		}
+		ext := c.extension()
+		if want := []string{"debian-binary", "control.tar" + ext, "data.tar" + ext}; !slices.Equal(members, want) {
+			t.Errorf("%q: members = %q, want %q", c, members, want)
+		}
+
+		parsed, err := NewPackage(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("%q: NewPackage failed: %v", c, err)
+		}
+		if want := cmp.Or(c, CompressionGzip); parsed.Compression != want {
+			t.Errorf("%q: parsed Compression = %q, want %q", c, parsed.Compression, want)
+		}
+		if !parsed.Equal(pkg) {
+			t.Errorf("%q: parsed package should be equal to the original one", c)
+		}
+		if control, err := extractControlFromBytes(content, Limits{}); err != nil || !strings.Contains(control, "Package: compressed") {
+			t.Errorf("%q: extractControlFromBytes = %q, %v", c, control, err)
+		}
+	}
+}
+
+func TestDirRoundTrip(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "dirs", Version: "1.0", Architecture: "all", ExtraFields: map[string]string{}},
+		Files: []File{
+			{DestPath: "/var/lib/app", Mode: 0750, Owner: "app", Group: "app", IsDir: true},
+			{DestPath: "/var/cache/app", IsDir: true, IsConf: true},
+			{DestPath: "/usr/bin/app", Mode: 0755, Body: "binary"},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if len(parsed.Files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(parsed.Files))
+	}
+	dir := parsed.Files[0]
+	if !dir.IsDir || dir.DestPath != "/var/lib/app" || dir.Mode != 0750 || dir.Owner != "app" {
+		t.Errorf("unexpected directory entry: %+v", dir)
+	}
+	// Directories are never conffiles.
+	if cache := parsed.Files[1]; !cache.IsDir || cache.Mode != 0755 || cache.IsConf {
+		t.Errorf("unexpected default directory entry: %+v", cache)
+	}
+	if !parsed.Equal(pkg) {
+		t.Errorf("parsed package should be equal to the original one")
+	}
+}
+
+// setTimeNow sets the current time of the packages built without BuildTime until the end of the test.
+func setTimeNow(t *testing.T, now time.Time) {
+	t.Helper()
+	t.Cleanup(func() { timeNow = time.Now })
+	timeNow = func() time.Time { return now }
+}
+
+func TestReproducibleBuild(t *testing.T) {
+	build := func(pkg *Package) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if _, err := pkg.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+	later := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	newPackage := func() *Package {
+		return &Package{
+			Metadata: Metadata{Package: "repro", Version: "1.0", Architecture: "all", ExtraFields: map[string]string{}},
+			Files: []File{
+				{DestPath: "/usr/share/repro/a", Mode: 0644, Body: "a"},
+				{DestPath: "/usr/share/repro/b", Mode: 0644, Body: "b", ModTime: later},
+			},
+			Scripts: Scripts{PreInst: "#!/bin/sh\n", PostInst: "#!/bin/sh\n", PreRm: "#!/bin/sh\n", PostRm: "#!/bin/sh\n"},
+		}
+	}
+
+	buildTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pkg := newPackage()
+	pkg.BuildTime = buildTime
+	first := build(pkg)
+	setTimeNow(t, time.Now().Add(time.Hour))
+	if second := build(pkg); !bytes.Equal(first, second) {
+		t.Errorf("rebuilding the package with a BuildTime should give the same bytes")
+	}
+	parsed, err := NewPackage(bytes.NewReader(first))
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	for _, f := range parsed.Files {
+		if !f.ModTime.Equal(buildTime) {
+			t.Errorf("%s: ModTime = %v, want the clamped build time %v", f.DestPath, f.ModTime, buildTime)
+		}
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", strconv.FormatInt(buildTime.Unix(), 10))
+	if fromEnv := build(newPackage()); !bytes.Equal(first, fromEnv) {
+		t.Errorf("SOURCE_DATE_EPOCH should be the default BuildTime")
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if _, err := newPackage().WriteTo(io.Discard); err == nil {
+		t.Errorf("an invalid SOURCE_DATE_EPOCH should fail")
+	}
+}
+
+func TestFileOwnerRoundTrip(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "owned", Version: "1.0", Architecture: "all", ExtraFields: map[string]string{}},
+		Files: []File{
+			{DestPath: "/etc/app/secret.conf", Mode: 0640, Body: "x", Owner: "root", Group: "app"},
+			{DestPath: "/var/lib/app/state", Mode: 0600, Body: "y", Owner: "1001", Group: "1001"},
+			{DestPath: "/usr/bin/app", Mode: 0755, Body: "z"},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	// root is the default owner, so it is read back as an empty owner.
+	want := [][2]string{{"", "app"}, {"1001", "1001"}, {"", ""}}
+	for i, f := range parsed.Files {
+		if got := [2]string{f.Owner, f.Group}; got != want[i] {
+			t.Errorf("%s: owner = %v, want %v", f.DestPath, got, want[i])
+		}
+	}
+	if !parsed.Equal(pkg) {
+		t.Errorf("parsed package should be equal to the original one")
+	}
+}
+
+func TestArchiveOwnership(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "owned", Version: "1.0", Architecture: "all"},
+		Files: []File{
+			{DestPath: "/srv/app", IsDir: true},
+			{DestPath: "/srv/app/data", Mode: 0644, Body: "x"},
+			{DestPath: "/srv/app/current", LinkTarget: "data"},
+			{DestPath: "/usr/bin/app", Mode: 0755, Body: "z", Owner: "root", Group: "0"},
+		},
+		Scripts: Scripts{PostInst: "#!/bin/sh\n"},
+	}
+	headers := func() map[string]*tar.Header {
+		var buf bytes.Buffer
+		if _, err := pkg.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+		headers := make(map[string]*tar.Header)
+		arR := ar.NewReader(&buf)
+		for {
+			member, err := arR.Next()
+			if err == io.EOF {
+				return headers
+			}
+			if err != nil {
+				t.Fatalf("reading ar: %v", err)
+			}
+			if !strings.Contains(member.Name, ".tar") {
+				continue
+			}
+			r, release, err := decompress(member.Name, arR)
+			if err != nil {
+				t.Fatalf("decompressing %s: %v", member.Name, err)
+			}
+			tr := tar.NewReader(r)
+			for {
+				h, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("reading %s: %v", member.Name, err)
+				}
+				headers[member.Name+":"+h.Name] = h
+			}
+			release()
+		}
+	}
+
+	got := headers()
+	for _, name := range []string{"control.tar.gz:./control", "control.tar.gz:./postinst", "data.tar.gz:./srv/app/", "data.tar.gz:./srv/app/data", "data.tar.gz:./srv/app/current", "data.tar.gz:./usr/bin/app"} {
+		h := got[name]
+		if h == nil {
+			t.Fatalf("missing entry %s in %v", name, slices.Collect(maps.Keys(got)))
+		}
+		if h.Uid != 0 || h.Gid != 0 || h.Uname != "root" || h.Gname != "root" {
+			t.Errorf("%s: owner = %d/%d %s:%s, want root:root", name, h.Uid, h.Gid, h.Uname, h.Gname)
+		}
+	}
+	if h := got["data.tar.gz:./srv/app/data"]; h.Typeflag != tar.TypeReg {
+		t.Errorf("the typeflag of a regular file is %q", h.Typeflag)
+	}
+
+	pkg.DefaultOwner, pkg.DefaultGroup = "app", "1001"
+	got = headers()
+	if h := got["data.tar.gz:./srv/app/data"]; h.Uname != "app" || h.Gid != 1001 || h.Gname != "" {
+		t.Errorf("the default owner is not applied: %s:%s %d/%d", h.Uname, h.Gname, h.Uid, h.Gid)
+	}
+	if h := got["data.tar.gz:./usr/bin/app"]; h.Uname != "root" || h.Gname != "root" {
+		t.Errorf("an explicit owner should override the default owner: %s:%s", h.Uname, h.Gname)
+	}
+	if h := got["control.tar.gz:./control"]; h.Uname != "root" {
+		t.Errorf("the control archive should be owned by root, not %s", h.Uname)
+	}
+}
+
+func TestNewPackageWithLimits(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "big", Version: "1.0", Architecture: "all", Description: "a package"},
+		Files: []File{
+			{DestPath: "/usr/share/big/a", Mode: 0644, Body: strings.Repeat("a", 4096)},
+			{DestPath: "/usr/share/big/b", Mode: 0644, Body: "b"},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	tests := []struct {
+		limits Limits
+		want   string
+	}{
+		{Limits{MaxBytes: 1024}, "MaxBytes"},
+		{Limits{MaxMemberBytes: 2048}, "MaxMemberBytes"},
+		{Limits{MaxEntries: 4}, "MaxEntries"},
+		{Limits{MaxFieldLength: 4}, "MaxFieldLength"},
+	}
+	for _, tt := range tests {
+		_, err := NewPackageWithLimits(bytes.NewReader(buf.Bytes()), tt.limits)
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != tt.want || !errors.Is(err, ErrLimit) {
+			t.Errorf("NewPackageWithLimits(%+v) error = %v, want a %s LimitError", tt.limits, err, tt.want)
+		}
+	}
+	if _, err := NewPackageWithLimits(bytes.NewReader(buf.Bytes()), Limits{MaxBytes: 64 << 10, MaxMemberBytes: 8 << 10, MaxEntries: 32, MaxFieldLength: 64}); err != nil {
+		t.Errorf("NewPackageWithLimits within the limits failed: %v", err)
+	}
+}
+
+func TestNewPackageWithSpool(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "big", Version: "1.0", Architecture: "all", ExtraFields: map[string]string{}},
+		Files: []File{
+			{DestPath: "/usr/share/big/a", Mode: 0644, Body: strings.Repeat("a", 64<<10)},
+			{DestPath: "/usr/share/big/b", Mode: 0644, Body: "b"},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+	defer spool.Close()
+	parsed, err := NewPackageWithOptions(&buf, ReadOptions{Spool: spool})
+	if err != nil {
+		t.Fatalf("NewPackageWithOptions failed: %v", err)
+	}
+	for _, f := range parsed.Files {
+		if f.Body != "" || f.Content == nil {
+			t.Errorf("%s should be spooled, got Body %d bytes", f.DestPath, len(f.Body))
+		}
+	}
+	if !parsed.Equal(pkg) {
+		t.Errorf("spooled package should be equal to the original one")
+	}
+	body, err := parsed.Files[0].ReadBody()
+	if err != nil || body != pkg.Files[0].Body {
+		t.Errorf("ReadBody() = %d bytes, %v, want the original content", len(body), err)
+	}
+
+	// The spooled files are written again like in memory ones.
+	var again bytes.Buffer
+	if _, err := parsed.WriteTo(&again); err != nil {
+		t.Fatalf("WriteTo of the spooled package failed: %v", err)
+	}
+	reparsed, err := NewPackage(&again)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if !reparsed.Equal(pkg) {
+		t.Errorf("package written from the spool should be equal to the original one")
+	}
+}
+
+// memoryStore is a BlobStore counting the stored files.
+type memoryStore struct{ n int }
+
+func (s *memoryStore) Put(r io.Reader) (Content, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s.n++
+	return memoryContent(body), nil
+}
+
+type memoryContent []byte
+
+func (c memoryContent) Open() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(c)), nil }
+func (c memoryContent) Size() int64                  { return int64(len(c)) }
+
+// missingContent is a Content that cannot be opened, e.g. a blob removed from its BlobStore.
+type missingContent struct{}
+
+func (missingContent) Open() (io.ReadCloser, error) { return nil, fs.ErrNotExist }
+func (missingContent) Size() int64                  { return 1 }
+
+func TestDigestUnreadableContent(t *testing.T) {
+	pkg := func() *Package {
+		return &Package{
+			Metadata: Metadata{Package: "app", Version: "1.0", Architecture: "all"},
+			Files:    []File{{DestPath: "/usr/share/app/data", Mode: 0644, Content: missingContent{}}},
+		}
+	}
+	if _, err := pkg().Digest(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Digest = %v, want %v", err, fs.ErrNotExist)
+	}
+	// Two packages failing the same way are not equal, nor unchanged.
+	if p := pkg(); p.Equal(pkg()) || p.Equal(p) {
+		t.Errorf("packages with unreadable content should not be equal")
+	}
+	if _, err := Diff(pkg(), pkg()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Diff = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestNewPackageSpillThreshold(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "big", Version: "1.0", Architecture: "all"},
+		Files: []File{
+			{DestPath: "/usr/share/big/a", Mode: 0644, Body: strings.Repeat("a", 64<<10)},
+			{DestPath: "/usr/share/big/b", Mode: 0644, Body: "b"},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+	defer spool.Close()
+	store := &memoryStore{}
+	for _, opts := range []ReadOptions{
+		{Spool: spool, SpillThreshold: 1024},
+		{Spool: spool, BlobStore: store, SpillThreshold: 1024},
+	} {
+		parsed, err := NewPackageWithOptions(bytes.NewReader(buf.Bytes()), opts)
+		if err != nil {
+			t.Fatalf("NewPackageWithOptions failed: %v", err)
+		}
+		if a, b := parsed.Files[0], parsed.Files[1]; a.Content == nil || a.Body != "" || b.Content != nil || b.Body != "b" {
+			t.Errorf("only the large file should be stored: %+v", parsed.Files)
+		}
+		if !parsed.Equal(pkg) {
+			t.Errorf("the package should be equal to the original one")
+		}
+	}
+	if store.n != 1 {
+		t.Errorf("the BlobStore stored %d files, want 1", store.n)
+	}
+}
+
+func TestNewPackageControlOnly(t *testing.T) {
+	pkg := &Package{
+		Metadata:    Metadata{Package: "meta", Version: "1.0", Architecture: "all", Description: "a package"},
+		Files:       []File{{DestPath: "/usr/share/meta/a", Mode: 0644, Body: strings.Repeat("a", 4096)}},
+		Scripts:     Scripts{PostInst: "#!/bin/sh\n"},
+		Compression: CompressionXz,
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	content := buf.Bytes()
+	parsed, err := NewPackageWithOptions(bytes.NewReader(content), ReadOptions{ControlOnly: true})
+	if err != nil {
+		t.Fatalf("NewPackageWithOptions failed: %v", err)
+	}
+	if parsed.Metadata.Package != "meta" || parsed.Scripts.PostInst != pkg.Scripts.PostInst || parsed.Compression != CompressionXz {
+		t.Errorf("unexpected package: %+v", parsed)
+	}
+	if len(parsed.Files) != 0 || parsed.InstalledSize() != 4 {
+		t.Errorf("Files = %v, InstalledSize = %d, want no files and the size of the control file", parsed.Files, parsed.InstalledSize())
+	}
+	if _, err := parsed.WriteTo(io.Discard); err == nil {
+		t.Error("WriteTo of a package without payload should fail")
+	}
+	parsed.SetOriginalContent(content)
+	if got, err := parsed.content(WriteOptions{}); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("an unchanged package should be published from its original content (%v)", err)
+	}
+}
+
+func TestSetOriginalContent(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"},
+		Files:    []File{{DestPath: "/usr/share/foo/data", Mode: 0644, Body: "data"}},
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	original := buf.Bytes()
+	parsed, err := NewPackage(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	parsed.SetOriginalContent(original)
+
+	// The timestamps of a regenerated package would differ.
+	setTimeNow(t, time.Now().Add(time.Hour))
+	dir := t.TempDir()
+	repo := &Repository{Packages: []*Package{parsed}}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	written, err := os.ReadFile(filepath.Join(dir, parsed.StandardFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, original) {
+		t.Errorf("the unchanged package should be written with its original content")
+	}
+
+	parsed.Metadata.Maintainer = "Jane Doe <jane@example.com>"
+	if content, err := parsed.content(WriteOptions{}); err != nil || bytes.Equal(content, original) {
+		t.Errorf("a modified package should be regenerated, got error %v", err)
+	}
+}
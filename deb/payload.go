@@ -0,0 +1,128 @@
+package deb
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RemoveFile removes the payload entry at path, e.g. "/usr/share/doc/app/README", and the entries
+// below it, so that removing a directory removes its content. It reports whether an entry was removed.
+func (p *Package) RemoveFile(path string) bool {
+	path = cleanDestPath(path)
+	return p.FilterFiles(func(f File) bool { return !isBelow(f.DestPath, path) }) > 0
+}
+
+// RenameFile moves the payload entry at oldPath to newPath, with the entries below it, so that
+// renaming a directory moves its content. Hard links to the moved entries follow them. It fails if there is no entry at or below oldPath,
+// or if an entry is already at newPath.
+func (p *Package) RenameFile(oldPath, newPath string) error {
+	oldPath, newPath = cleanDestPath(oldPath), cleanDestPath(newPath)
+	if oldPath == "/" || newPath == "/" {
+		return fmt.Errorf("cannot rename the root directory")
+	}
+	if isBelow(newPath, oldPath) {
+		return fmt.Errorf("cannot rename %s into itself", oldPath)
+	}
+	var moved []int
+	for i, f := range p.Files {
+		if isBelow(f.DestPath, newPath) {
+			return fmt.Errorf("cannot rename %s: %s already exists", oldPath, newPath)
+		}
+		if isBelow(f.DestPath, oldPath) {
+			moved = append(moved, i)
+		}
+	}
+	if len(moved) == 0 {
+		return fmt.Errorf("cannot rename %s: no such file", oldPath)
+	}
+	for _, i := range moved {
+		f := &p.Files[i]
+		dest := newPath + strings.TrimPrefix(cleanDestPath(f.DestPath), oldPath)
+		if sum, ok := p.md5sums[md5sumsPath(f.DestPath)]; ok {
+			delete(p.md5sums, md5sumsPath(f.DestPath))
+			p.md5sums[md5sumsPath(dest)] = sum
+		}
+		f.DestPath = dest
+	}
+	for i, f := range p.Files {
+		if f.HardLink != "" && isBelow(f.HardLink, oldPath) {
+			p.Files[i].HardLink = newPath + strings.TrimPrefix(cleanDestPath(f.HardLink), oldPath)
+		}
+	}
+	return nil
+}
+
+// FilterFiles keeps the payload entries for which keep returns true, e.g. to strip the documentation
+// or the examples of a package, and returns the number of entries removed.
+func (p *Package) FilterFiles(keep func(File) bool) int {
+	n := len(p.Files)
+	kept := p.Files[:0]
+	for _, f := range p.Files {
+		if keep(f) {
+			kept = append(kept, f)
+		} else {
+			// The md5sums read by NewPackage no longer list the removed files.
+			delete(p.md5sums, md5sumsPath(f.DestPath))
+		}
+	}
+	clear(p.Files[len(kept):])
+	p.Files = kept
+	return n - len(kept)
+}
+
+// CheckDestPath checks that dest is a valid payload path: absolute and clean, without "." or ".."
+// elements nor repeated or trailing '/', other than the root directory, and without the newlines
+// that the md5sums and conffiles files cannot list.
+func CheckDestPath(dest string) error {
+	switch {
+	case !strings.HasPrefix(dest, "/"):
+		return fmt.Errorf("invalid payload path %q: it must be absolute", dest)
+	case dest == "/":
+		return fmt.Errorf("invalid payload path %q: it is the root directory", dest)
+	case path.Clean(dest) != dest:
+		return fmt.Errorf("invalid payload path %q: it must be clean, e.g. %q", dest, path.Clean(dest))
+	case strings.ContainsAny(dest, "\n\r\x00"):
+		return fmt.Errorf("invalid payload path %q: it contains a newline or a NUL character", dest)
+	}
+	return nil
+}
+
+// checkPayloadPaths checks the paths of files with CheckDestPath, and the hard link targets, and that no
+// two entries have the same path, except directories.
+func checkPayloadPaths(files []File) []error {
+	var errs []error
+	seen := make(map[string]File, len(files))
+	for _, f := range files {
+		if err := CheckDestPath(f.DestPath); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if f.HardLink != "" {
+			if err := CheckDestPath(f.HardLink); err != nil {
+				errs = append(errs, fmt.Errorf("hard link %s: %w", f.DestPath, err))
+			}
+		}
+		if prev, ok := seen[f.DestPath]; ok && !(prev.IsDir && f.IsDir) {
+			errs = append(errs, fmt.Errorf("duplicate payload path %s", f.DestPath))
+		}
+		seen[f.DestPath] = f
+	}
+	return errs
+}
+
+// cleanDestPath returns the absolute, clean form of a payload path.
+func cleanDestPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// isBelow reports whether the payload path dest is dir, or an entry below it.
+func isBelow(dest, dir string) bool {
+	dest = cleanDestPath(dest)
+	return dir == "/" || dest == dir || strings.HasPrefix(dest, dir+"/")
+}
+
+// md5sumsPath returns the path of a payload file in the md5sums file: without leading slash.
+func md5sumsPath(dest string) string {
+	return strings.TrimPrefix(cleanDestPath(dest), "/")
+}
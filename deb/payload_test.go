@@ -0,0 +1,176 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/blakesmith/ar"
+)
+
+// destPaths returns the DestPath of the files.
+func destPaths(files []File) []string {
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.DestPath)
+	}
+	return paths
+}
+
+func TestPayloadMutations(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{Package: "app", Version: "1.0", Architecture: "all", Maintainer: "Jane Doe <jane@example.com>", Description: "app", ExtraFields: map[string]string{}},
+		Files: []File{
+			{DestPath: "/usr/bin/app", Mode: 0755, Body: "app"},
+			{DestPath: "/usr/bin/tool", Mode: 0755, Body: "tool"},
+			{DestPath: "/usr/share/doc/app", IsDir: true},
+			{DestPath: "/usr/share/doc/app/README", Mode: 0644, Body: "readme"},
+			{DestPath: "/usr/share/app/examples/demo.conf", Mode: 0644, Body: "demo"},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	pkg, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+
+	if !pkg.RemoveFile("/usr/share/doc/app") {
+		t.Error("RemoveFile(/usr/share/doc/app) should remove the directory")
+	}
+	if pkg.RemoveFile("/usr/share/doc/app") {
+		t.Error("RemoveFile should report that nothing was removed")
+	}
+	if n := pkg.FilterFiles(func(f File) bool { return !strings.Contains(f.DestPath, "/examples/") }); n != 1 {
+		t.Errorf("FilterFiles removed %d files, want 1", n)
+	}
+	if err := pkg.RenameFile("/usr/bin/tool", "/usr/bin/app-tool"); err != nil {
+		t.Errorf("RenameFile failed: %v", err)
+	}
+	if want := []string{"/usr/bin/app", "/usr/bin/app-tool"}; !slices.Equal(destPaths(pkg.Files), want) {
+		t.Errorf("Files = %q, want %q", destPaths(pkg.Files), want)
+	}
+	// The md5sums read from the package follow the changes.
+	if err := pkg.Verify(); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+
+	if err := pkg.RenameFile("/usr/bin/app", "/usr/bin/app-tool"); err == nil {
+		t.Error("RenameFile should fail when the destination exists")
+	}
+	if err := pkg.RenameFile("/usr/bin/missing", "/usr/bin/other"); err == nil {
+		t.Error("RenameFile should fail when the source does not exist")
+	}
+	if err := pkg.RenameFile("/usr/bin", "/usr/lib/app/bin"); err != nil {
+		t.Errorf("RenameFile of a directory failed: %v", err)
+	}
+	if want := []string{"/usr/lib/app/bin/app", "/usr/lib/app/bin/app-tool"}; !slices.Equal(destPaths(pkg.Files), want) {
+		t.Errorf("Files = %q, want %q", destPaths(pkg.Files), want)
+	}
+}
+
+// withDataArchive returns the package file of pkg, without compression, whose data archive has the entries instead.
+func withDataArchive(t *testing.T, pkg *Package, entries []*tar.Header) []byte {
+	t.Helper()
+	pkg.Compression = CompressionNone
+	var file bytes.Buffer
+	if _, err := pkg.WriteTo(&file); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	var data bytes.Buffer
+	tw := tar.NewWriter(&data)
+	for _, h := range entries {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(make([]byte, h.Size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	r, w := ar.NewReader(&file), ar.NewWriter(&out)
+	if err := w.WriteGlobalHeader(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return out.Bytes()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Name == "data.tar" {
+			body = data.Bytes()
+		}
+		if err := addBufferToAr(w, h.Name, body, h.ModTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPayloadPathValidation(t *testing.T) {
+	for _, dest := range []string{"/usr/bin/app", "/etc/app.d"} {
+		if err := CheckDestPath(dest); err != nil {
+			t.Errorf("CheckDestPath(%q) failed: %v", dest, err)
+		}
+	}
+	for _, dest := range []string{"", "usr/bin/app", "/", "/usr/../etc/passwd", "/../etc/passwd", "/usr/./bin", "/usr//bin", "/usr/bin/", "/usr/bin\n/app"} {
+		if err := CheckDestPath(dest); err == nil {
+			t.Errorf("CheckDestPath(%q) should fail", dest)
+		}
+	}
+
+	meta := Metadata{Package: "paths", Version: "1.0", Architecture: "all", Maintainer: "Dev <dev@example.com>", Description: "d"}
+	for _, files := range [][]File{
+		{{DestPath: "/usr/../../etc/passwd", Mode: 0644, Body: "x"}},
+		{{DestPath: "usr/bin/app", Mode: 0755, Body: "x"}},
+		{{DestPath: "/usr/bin/app", Mode: 0755, Body: "a"}, {DestPath: "/usr/bin/app", LinkTarget: "b"}},
+		{{DestPath: "/usr/bin/app", Mode: 0755, Body: "a"}, {DestPath: "/usr/bin/ln", HardLink: "/usr/bin/../bin/app"}},
+	} {
+		pkg := &Package{Metadata: meta, Files: files}
+		if _, err := pkg.WriteTo(io.Discard); err == nil {
+			t.Errorf("WriteTo should fail with the payload %q", destPaths(files))
+		}
+		if err := pkg.Verify(); err == nil {
+			t.Errorf("Verify should fail with the payload %q", destPaths(files))
+		}
+	}
+	// Redundant directories are harmless.
+	pkg := &Package{Metadata: meta, Files: []File{{DestPath: "/usr/share/paths", IsDir: true}, {DestPath: "/usr/share/paths", IsDir: true}}}
+	if _, err := pkg.WriteTo(io.Discard); err != nil {
+		t.Errorf("WriteTo failed with a duplicate directory: %v", err)
+	}
+
+	for _, entries := range [][]*tar.Header{
+		{{Name: "./../../etc/cron.d/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}},
+		{{Name: "./usr/bin/app", Typeflag: tar.TypeReg, Mode: 0755, Size: 1}, {Name: "./usr/bin/app", Typeflag: tar.TypeSymlink, Linkname: "/bin/sh"}},
+		{{Name: "./usr/bin/app", Typeflag: tar.TypeReg, Mode: 0755, Size: 1}, {Name: "./usr/bin/ln", Typeflag: tar.TypeLink, Linkname: "./../../bin/sh"}},
+	} {
+		content := withDataArchive(t, &Package{Metadata: meta}, entries)
+		if _, err := NewPackage(bytes.NewReader(content)); err == nil || !strings.Contains(err.Error(), "invalid data archive") {
+			t.Errorf("NewPackage = %v, want an invalid data archive with %s", err, entries[len(entries)-1].Name)
+		}
+	}
+	content := withDataArchive(t, &Package{Metadata: meta}, []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "./usr/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "./usr/bin/app", Typeflag: tar.TypeReg, Mode: 0755, Size: 1},
+	})
+	if _, err := NewPackage(bytes.NewReader(content)); err != nil {
+		t.Errorf("NewPackage failed on a valid data archive: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package deb
+
+// Stages of the operations reported in a Progress.
+const (
+	// StagePackage is the generation of a package file, or the reuse of an unchanged one.
+	StagePackage = "package"
+	// StageIndex is the generation of a Packages index.
+	StageIndex = "index"
+	// StageWrite is the writing of a repository file, to a directory or to an archive.
+	StageWrite = "write"
+	// StageDownload is the download of a web resource.
+	StageDownload = "download"
+)
+
+// Progress is the advancement of a step of a long running operation, e.g. writing a repository.
+// The same type is used by the repository writers of this package and by the downloads of the
+// manifest package, so that a single display, or metrics exporter, can follow all of them.
+type Progress struct {
+	// Stage is the kind of step, e.g. StageWrite.
+	Stage string `json:"stage"`
+	// Item is the file name, or URL, being processed.
+	Item string `json:"item,omitempty"`
+	// Bytes is the number of bytes of Item processed so far.
+	Bytes int64 `json:"bytes"`
+	// TotalBytes is the size of Item, in bytes, or -1 when it is not known yet.
+	TotalBytes int64 `json:"total_bytes"`
+	// Items is the number of items of the stage processed so far, including Item,
+	// and TotalItems their total number. Both are zero when the stage is not counted.
+	Items      int `json:"items,omitempty"`
+	TotalItems int `json:"total_items,omitempty"`
+}
+
+// Done reports whether Item is completely processed.
+func (p Progress) Done() bool {
+	return p.TotalBytes >= 0 && p.Bytes >= p.TotalBytes
+}
+
+// ProgressFunc receives the progress of an operation. It is called synchronously, from the
+// goroutine running the operation, so it must be fast.
+type ProgressFunc func(Progress)
+
+// report calls f with p, if f is not nil.
+func (f ProgressFunc) report(p Progress) {
+	if f != nil {
+		f(p)
+	}
+}
+
+// packageProgress returns the progress of the i-th package file of total, with its content.
+func packageProgress(filename string, content []byte, i, total int) Progress {
+	return Progress{Stage: StagePackage, Item: filename, Bytes: int64(len(content)), TotalBytes: int64(len(content)), Items: i + 1, TotalItems: total}
+}
+
+// indexProgress returns the progress of a generated index file.
+func indexProgress(filename string, content []byte) Progress {
+	return Progress{Stage: StageIndex, Item: filename, Bytes: int64(len(content)), TotalBytes: int64(len(content))}
+}
@@ -4,15 +4,20 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -88,22 +93,76 @@ type ArchiveInfo struct {
 	AcquireByHash string
 }
 
+// dated returns the archive info with the Date of t, unless it is already set.
+func (info ArchiveInfo) dated(t time.Time) ArchiveInfo {
+	if info.Date == "" {
+		info.Date = t.UTC().Format(time.RFC1123Z)
+	}
+	return info
+}
+
 // Repository represents a collection of packages
 // that will be assembled into a flat APT repository.
 //
+// Get, Append, AddOverwrite and PackagesByUpstream are safe for concurrent use, e.g. to harvest
+// packages from several goroutines. Reading or modifying Packages directly, and writing the
+// repository, must not happen concurrently with them.
+//
 // Reference: https://wiki.debian.org/DebianRepository/Format#Flat_Repository_Format
 type Repository struct {
 	// ArchiveInfo contains the metadata for the Release file.
 	ArchiveInfo ArchiveInfo
 	// Packages are in-memory package definitions (generated or pre-built) to be included.
 	Packages []*Package
+	// Sources are the source packages to be included, listed in the Sources index.
+	// The Sources index is only written if there are source packages.
+	Sources []*SourcePackage
 	// GPGKey is the ASCII-armored private key used to sign the Release file.
 	GPGKey string
+	// Logger receives the diagnostics of the writers. Nil discards them.
+	Logger *slog.Logger
+	// Progress receives the progress of the writers. It can be nil.
+	Progress ProgressFunc
+	// Hashes are the checksums of the package files in the Packages index, and of the indices
+	// in the Release file. Empty uses DefaultHashes.
+	Hashes []Hash
+	// IndexCompressions are the compressed versions of the Packages and Sources indices written next
+	// to the uncompressed ones, and listed in the Release file, e.g. {CompressionGzip, CompressionXz}.
+	// Empty writes the gzip version only. CompressionNone is ignored.
+	IndexCompressions []Compression
+	// WriteOptions configure the packages built by the writers, e.g. their compression level.
+	// The packages published from their original content are not rebuilt.
+	WriteOptions WriteOptions
+	// DigestHash is the algorithm of the digests of the FileOperation of the writers. Zero uses SHA256.
+	DigestHash Hash
+
+	// mu guards Packages in the methods safe for concurrent use.
+	mu sync.Mutex
+}
+
+// logger returns the logger of the diagnostics, discarding them if none is set.
+func (r *Repository) logger() *slog.Logger {
+	return loggerOrDiscard(r.Logger)
+}
+
+// loggerOrDiscard returns l, or a logger discarding everything if l is nil.
+func loggerOrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return l
 }
 
 // Get finds a package in the repository by its name, version, and architecture.
 // It returns the package and its index if found, otherwise (nil, -1).
 func (r *Repository) Get(name, version, arch string) *Package {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.get(name, version, arch)
+}
+
+// get is Get, with r.mu held.
+func (r *Repository) get(name, version, arch string) *Package {
 	for _, pkg := range r.Packages {
 		if pkg.Metadata.Package == name && pkg.Metadata.Version == version && pkg.Metadata.Architecture == arch {
 			return pkg
@@ -115,24 +174,62 @@ func (r *Repository) Get(name, version, arch string) *Package {
 // Append adds a package to the repository.
 // If there is no conflicting package, it appends the new package and returns (nil, nil).
 // If the existing package is identical to the new one, it returns the existing package and a nil error.
-// If the existing package is different, it returns the existing package and an error.
+// If the existing package is different, it returns the existing package and a *ConflictError.
 func (r *Repository) Append(pkg *Package) (*Package, error) {
-	if existing := r.Get(pkg.Metadata.Package, pkg.Metadata.Version, pkg.Metadata.Architecture); existing != nil {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing := r.get(pkg.Metadata.Package, pkg.Metadata.Version, pkg.Metadata.Architecture); existing != nil {
 		if existing.Equal(pkg) {
 			return existing, nil
 		}
-
-		return existing, fmt.Errorf("package %s version %s for %s already exists", pkg.Metadata.Package, pkg.Metadata.Version, pkg.Metadata.Architecture)
+		return existing, &ConflictError{Existing: existing, Package: pkg}
 	}
 	r.Packages = append(r.Packages, pkg)
 	return nil, nil
 }
 
+// AddSafe adds a package to the repository without ever changing a published package:
+// a package version is immutable. It returns the package actually in the repository:
+// pkg itself, or the existing package with the same name, version and architecture if it
+// has the same content (its Digest), so that identical re-publishes are accepted.
+// If the existing package has a different content, it fails with a *ConflictError.
+func (r *Repository) AddSafe(pkg *Package) (*Package, error) {
+	existing, err := r.Append(pkg)
+	switch {
+	case err != nil:
+		return nil, err
+	case existing != nil:
+		return existing, nil
+	default:
+		return pkg, nil
+	}
+}
+
+// ErrConflict is wrapped by every ConflictError, to test for them with errors.Is.
+var ErrConflict = errors.New("package conflict")
+
+// ConflictError is returned when a package is added to a repository that already has
+// the same name, version and architecture with a different content.
+type ConflictError struct {
+	// Existing is the package in the repository, and Package the rejected one.
+	Existing, Package *Package
+}
+
+func (e *ConflictError) Error() string {
+	m := e.Package.Metadata
+	return fmt.Sprintf("package %s version %s for %s already exists with a different content", m.Package, m.Version, m.Architecture)
+}
+
+// Unwrap returns ErrConflict.
+func (e *ConflictError) Unwrap() error { return ErrConflict }
+
 // FileOperation represents a file system operation performed during repository generation.
 type FileOperation struct {
 	Path      string
 	OldDigest string
 	NewDigest string
+	// Size is the size of the new content, in bytes.
+	Size int64
 }
 
 // Changed reports whether the file content has changed (or is new).
@@ -143,9 +240,11 @@ func (op FileOperation) Changed() bool {
 // AddOverwrite adds a package to the repository, replacing any existing package
 // with the same name, version, and architecture.
 func (r *Repository) AddOverwrite(pkg *Package) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	name, version, arch := pkg.Metadata.Package, pkg.Metadata.Version, pkg.Metadata.Architecture
-	for i, pkg := range r.Packages {
-		if pkg.Metadata.Package == name && pkg.Metadata.Version == version && pkg.Metadata.Architecture == arch {
+	for i, p := range r.Packages {
+		if p.Metadata.Package == name && p.Metadata.Version == version && p.Metadata.Architecture == arch {
 			r.Packages[i] = pkg
 			return
 		}
@@ -153,41 +252,75 @@ func (r *Repository) AddOverwrite(pkg *Package) {
 	r.Packages = append(r.Packages, pkg)
 }
 
+// Remove removes the packages with the given name, version and architecture from the repository,
+// and returns them. An empty version or architecture matches any, e.g. Remove("app", "", "")
+// removes every version of app.
+func (r *Repository) Remove(name, version, arch string) []*Package {
+	return r.RemoveWhere(func(p *Package) bool {
+		return p.Metadata.Package == name &&
+			(version == "" || p.Metadata.Version == version) &&
+			(arch == "" || p.Metadata.Architecture == arch)
+	})
+}
+
+// RemoveWhere removes the packages for which remove returns true from the repository, and returns
+// them, in the repository order.
+func (r *Repository) RemoveWhere(remove func(*Package) bool) []*Package {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var removed []*Package
+	r.Packages = slices.DeleteFunc(r.Packages, func(p *Package) bool {
+		if remove(p) {
+			removed = append(removed, p)
+			return true
+		}
+		return false
+	})
+	return removed
+}
+
 // PackagesByUpstream returns all packages in the repository that match the given name,
 // upstream version, and architecture.
 // The returned list is sorted by version in descending order (most recent first).
 func (r *Repository) PackagesByUpstream(name, upstreamVersion, arch string) []*Package {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	var matches []*Package
 	for _, p := range r.Packages {
 		if p.Metadata.Package == name && p.Metadata.Architecture == arch && p.UpstreamVersion() == upstreamVersion {
 			matches = append(matches, p)
 		}
 	}
-	sort.Slice(matches, func(i, j int) bool {
-		return compareVersions(matches[j].Metadata.Version, matches[i].Metadata.Version)
+	sort.SliceStable(matches, func(i, j int) bool {
+		return CompareVersions(matches[i].Metadata.Version, matches[j].Metadata.Version) > 0
 	})
 	return matches
 }
 
-func splitVersion(v string) (string, string) {
-	lastHyphen := strings.LastIndex(v, "-")
-	if lastHyphen == -1 {
-		return v, ""
+// Versions returns the packages of the repository with the given name and architecture,
+// or of any architecture if arch is "", sorted in Debian version order, the most recent first.
+func (r *Repository) Versions(name, arch string) []*Package {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matches []*Package
+	for _, p := range r.Packages {
+		if p.Metadata.Package == name && (arch == "" || p.Metadata.Architecture == arch) {
+			matches = append(matches, p)
+		}
 	}
-	return v[:lastHyphen], v[lastHyphen+1:]
+	sort.SliceStable(matches, func(i, j int) bool {
+		return CompareVersions(matches[i].Metadata.Version, matches[j].Metadata.Version) > 0
+	})
+	return matches
 }
 
-func compareVersions(v1, v2 string) bool {
-	_, r1 := splitVersion(v1)
-	_, r2 := splitVersion(v2)
-
-	i1, err1 := strconv.Atoi(r1)
-	i2, err2 := strconv.Atoi(r2)
-
-	if err1 == nil && err2 == nil {
-		return i1 < i2
+// Latest returns the most recent version of the package with the given name and architecture,
+// or of any architecture if arch is "", or nil if the repository has none. See Versions.
+func (r *Repository) Latest(name, arch string) *Package {
+	if versions := r.Versions(name, arch); len(versions) > 0 {
+		return versions[0]
 	}
-	return r1 < r2
+	return nil
 }
 
 // repoPackage is an internal struct to hold metadata for the index.
@@ -211,20 +344,32 @@ type repoPackage struct {
 	//
 	// Reference: https://wiki.debian.org/DebianRepository/Format#Packages_Indices
 	Size int64
-	// SHA256 is the SHA256 checksum of the package file.
+	// Checksums are the hex encoded checksums of the package file, one per hash of the index.
 	//
 	// Reference: https://wiki.debian.org/DebianRepository/Format#Packages_Indices
-	SHA256 string
+	Checksums []string
 }
 
 // WriteTo generates the repository and writes it as a tar.gz to the provided writer.
 func (r *Repository) WriteTo(w io.Writer) (int64, error) {
+	return r.WriteToContext(context.Background(), w)
+}
+
+// WriteToContext is like WriteTo, but stops before the next package when ctx is canceled,
+// leaving an incomplete tarball.
+func (r *Repository) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	// The files are stamped with the build time of the packages, so the tarball is reproducible.
+	buildTime, err := DefaultBuildTime()
+	if err != nil {
+		return 0, err
+	}
 	cw := &countingWriter{w: w}
 	gzw := gzip.NewWriter(cw)
 	defer gzw.Close()
 	tw := tar.NewWriter(gzw)
 
 	var index []*repoPackage
+	hashes := hashesOrDefault(r.Hashes)
 
 	// Helper to add file to tar
 	addFile := func(name string, content []byte) error {
@@ -232,29 +377,35 @@ func (r *Repository) WriteTo(w io.Writer) (int64, error) {
 			Name:    name,
 			Size:    int64(len(content)),
 			Mode:    0644,
-			ModTime: time.Now(),
+			ModTime: buildTime,
 		}
 		if err := tw.WriteHeader(header); err != nil {
 			return fmt.Errorf("writing header for %s: %w", name, err)
 		}
-		_, err := tw.Write(content)
-		return err
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+		r.Progress.report(Progress{Stage: StageWrite, Item: name, Bytes: int64(len(content)), TotalBytes: int64(len(content))})
+		return nil
 	}
 
 	// Process Packages
-	for _, pkg := range r.Packages {
-		var buf bytes.Buffer
-		if _, err := pkg.WriteTo(&buf); err != nil {
+	for i, pkg := range r.Packages {
+		if err := ctx.Err(); err != nil {
+			return cw.n, err
+		}
+		content, err := pkg.content(r.WriteOptions)
+		if err != nil {
 			return cw.n, fmt.Errorf("building package: %w", err)
 		}
-		content := buf.Bytes()
+		r.Progress.report(packageProgress(pkg.StandardFilename(), content, i, len(r.Packages)))
 
-		rp, err := parseDeb(content, "")
+		rp, err := parseDeb(content, "", hashes)
 		if err != nil {
 			return cw.n, fmt.Errorf("parsing package: %w", err)
 		}
 
-		rp.Filename = fmt.Sprintf("%s_%s_%s.deb", rp.Package, rp.Version, rp.Architecture)
+		rp.Filename = pkg.StandardFilename()
 		if err := addFile(rp.Filename, content); err != nil {
 			return cw.n, err
 		}
@@ -262,28 +413,39 @@ func (r *Repository) WriteTo(w io.Writer) (int64, error) {
 		index = append(index, rp)
 	}
 
-	// 4. Generate Indices
-	packagesContent := generatePackagesFile(index)
-	if err := addFile("Packages", packagesContent); err != nil {
-		return cw.n, err
+	// Process Sources
+	var sources []*repoSource
+	for _, src := range r.Sources {
+		rs, err := newRepoSource(src, ".")
+		if err != nil {
+			return cw.n, err
+		}
+		for _, f := range rs.Files {
+			if err := addFile(f.Name, f.Content); err != nil {
+				return cw.n, err
+			}
+		}
+		sources = append(sources, rs)
 	}
 
-	var gzBuf bytes.Buffer
-	gw := gzip.NewWriter(&gzBuf)
-	gw.Write(packagesContent)
-	gw.Close()
-	packagesGzContent := gzBuf.Bytes()
-	if err := addFile("Packages.gz", packagesGzContent); err != nil {
+	// 4. Generate Indices
+	indices, err := r.indices(index, sources, hashes)
+	if err != nil {
 		return cw.n, err
 	}
+	for _, f := range indices {
+		if err := addFile(f.name, f.content); err != nil {
+			return cw.n, err
+		}
+	}
 
-	releaseContent := generateReleaseFile(r.ArchiveInfo, packagesContent, packagesGzContent)
+	releaseContent := generateReleaseFile(r.ArchiveInfo.dated(buildTime), hashes, indices)
 	if err := addFile("Release", releaseContent); err != nil {
 		return cw.n, err
 	}
 
 	if r.GPGKey != "" {
-		inRelease, err := signBytes(releaseContent, r.GPGKey)
+		inRelease, err := signBytes(ctx, releaseContent, r.GPGKey)
 		if err != nil {
 			return cw.n, fmt.Errorf("signing InRelease: %w", err)
 		}
@@ -296,12 +458,16 @@ func (r *Repository) WriteTo(w io.Writer) (int64, error) {
 			if err := addFile("public.gpg", pubKey); err != nil {
 				return cw.n, err
 			}
+		} else {
+			r.logger().Warn("Public key not exported", "file", "public.gpg", "error", err)
 		}
 		pubKeyAsc, err := extractPublicKey(r.GPGKey, true)
 		if err == nil {
 			if err := addFile("public.asc", pubKeyAsc); err != nil {
 				return cw.n, err
 			}
+		} else {
+			r.logger().Warn("Public key not exported", "file", "public.asc", "error", err)
 		}
 	}
 
@@ -313,170 +479,299 @@ func (r *Repository) WriteTo(w io.Writer) (int64, error) {
 
 // WriteToDir generates the repository and writes it to the provided directory path.
 func (r *Repository) WriteToDir(path string) ([]FileOperation, error) {
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return nil, err
-	}
-
-	var ops []FileOperation
-	var index []*repoPackage
+	return r.writeToDir(context.Background(), path, false)
+}
 
-	// writeFile writes content to a file and records the operation with checksums.
-	writeFile := func(filename string, content []byte) (*FileOperation, error) {
-		fullPath := filepath.Join(path, filename)
-		op := FileOperation{Path: filename}
+// WriteToDirContext is like WriteToDir, but stops before the next package when ctx is canceled.
+// The files already written are kept, the indices are only written once all the packages are.
+func (r *Repository) WriteToDirContext(ctx context.Context, path string) ([]FileOperation, error) {
+	return r.writeToDir(ctx, path, false)
+}
 
-		h := sha256.Sum256(content)
-		op.NewDigest = hex.EncodeToString(h[:])
+// PlanDir generates the repository like WriteToDir, but without writing anything.
+// It returns the file operations that WriteToDir would perform.
+func (r *Repository) PlanDir(path string) ([]FileOperation, error) {
+	return r.writeToDir(context.Background(), path, true)
+}
 
-		if existing, err := os.ReadFile(fullPath); err == nil {
-			hOld := sha256.Sum256(existing)
-			op.OldDigest = hex.EncodeToString(hOld[:])
-		}
+// PlanDirContext is like PlanDir, but stops before the next package when ctx is canceled.
+func (r *Repository) PlanDirContext(ctx context.Context, path string) ([]FileOperation, error) {
+	return r.writeToDir(ctx, path, true)
+}
 
-		if op.OldDigest != op.NewDigest {
-			if err := os.WriteFile(fullPath, content, 0644); err != nil {
-				return nil, err
-			}
+// writeToDir implements WriteToDir and PlanDir. If dryRun is true, nothing is written to disk.
+func (r *Repository) writeToDir(ctx context.Context, path string, dryRun bool) ([]FileOperation, error) {
+	if !dryRun {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
 		}
-		ops = append(ops, op)
-		return &op, nil
 	}
+	d := &dirWriter{ctx: ctx, log: r.logger(), progress: r.Progress, writeOptions: r.WriteOptions, digestHash: r.DigestHash, path: path, dryRun: dryRun}
+	var index []*repoPackage
+	hashes := hashesOrDefault(r.Hashes)
 
 	// Process Packages
-	for _, pkg := range r.Packages {
-		var rp *repoPackage
-		var content []byte
+	for i, pkg := range r.Packages {
 		filename := pkg.StandardFilename()
-		targetPath := filepath.Join(path, filename)
-
-		// Optimization: Check if we can skip writing
-		currentDigest := pkg.Digest()
-		if f, err := os.Open(targetPath); err == nil {
-			if fileContent, err := io.ReadAll(f); err == nil {
-				h := sha256.Sum256(fileContent)
-				diskDigest := hex.EncodeToString(h[:])
-				if pkg.IsOriginal(currentDigest, diskDigest) {
-					// Content is identical and original, skip regeneration and write
-					ops = append(ops, FileOperation{
-						Path:      filename,
-						OldDigest: diskDigest,
-						NewDigest: diskDigest,
-					})
-					// We still need content for the index parsing (or at least metadata)
-					// Since IsOriginal is true, fileContent is valid.
-					content = fileContent
-				}
-			}
-			f.Close()
-		}
-
-		if content == nil {
-			var buf bytes.Buffer
-			if _, err := pkg.WriteTo(&buf); err != nil {
-				return nil, fmt.Errorf("building package: %w", err)
-			}
-			content = buf.Bytes()
+		content, err := d.packageContent(pkg, filename)
+		if err != nil {
+			return nil, err
 		}
-
-		rp, err := parseDeb(content, "")
+		d.progress.report(packageProgress(filename, content, i, len(r.Packages)))
+		rp, err := parseDeb(content, "", hashes)
 		if err != nil {
 			return nil, fmt.Errorf("parsing package: %w", err)
 		}
-
 		rp.Filename = filename
-		// If content was not nil (skipped), we still need to record the op if we didn't above.
-		// But we handled the skipped case above. If content != nil here and we didn't skip, we write.
-		// We can just use writeFile if we didn't skip.
-		// Let's refactor slightly to use writeFile for the non-skipped case.
-		if len(ops) == 0 || ops[len(ops)-1].Path != filename {
-			if _, err := writeFile(filename, content); err != nil {
+		if _, err := d.write(filename, content); err != nil {
+			return nil, err
+		}
+		index = append(index, rp)
+	}
+
+	// Process Sources
+	var sources []*repoSource
+	for _, src := range r.Sources {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rs, err := newRepoSource(src, ".")
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range rs.Files {
+			if _, err := d.write(f.Name, f.Content); err != nil {
 				return nil, err
 			}
 		}
-
-		index = append(index, rp)
+		sources = append(sources, rs)
 	}
 
 	// Generate Indices
-	packagesContent := generatePackagesFile(index)
-	opPkg, err := writeFile("Packages", packagesContent)
+	indices, err := r.indices(index, sources, hashes)
 	if err != nil {
 		return nil, err
 	}
+	indicesChanged := false
+	for _, f := range indices {
+		op, err := d.write(f.name, f.content)
+		if err != nil {
+			return nil, err
+		}
+		indicesChanged = indicesChanged || op.Changed()
+	}
+	if indicesChanged || r.ArchiveInfo.Date == "" {
+		buildTime, err := DefaultBuildTime()
+		if err != nil {
+			return nil, err
+		}
+		r.ArchiveInfo.Date = buildTime.UTC().Format(time.RFC1123Z)
+	}
 
-	var gzBuf bytes.Buffer
-	gw := gzip.NewWriter(&gzBuf)
-	gw.Write(packagesContent)
-	gw.Close()
-	packagesGzContent := gzBuf.Bytes()
-	opPkgGz, err := writeFile("Packages.gz", packagesGzContent)
+	releaseContent := generateReleaseFile(r.ArchiveInfo, hashes, indices)
+	opRelease, err := d.write("Release", releaseContent)
 	if err != nil {
 		return nil, err
 	}
 
-	packagesChanged := opPkg.Changed() || opPkgGz.Changed()
-	if packagesChanged || r.ArchiveInfo.Date == "" {
-		r.ArchiveInfo.Date = time.Now().UTC().Format(time.RFC1123Z)
+	if r.GPGKey != "" {
+		if err := d.sign("InRelease", releaseContent, opRelease.Changed(), r.GPGKey); err != nil {
+			return nil, err
+		}
 	}
+	return d.ops, nil
+}
+
+// indexFile is an index of a repository, listed in its Release file.
+type indexFile struct {
+	name    string
+	content []byte
+}
 
-	releaseContent := generateReleaseFile(r.ArchiveInfo, packagesContent, packagesGzContent)
-	opRelease, err := writeFile("Release", releaseContent)
+// indices returns the indices of a flat repository: Packages, and Sources if there are source packages,
+// with their compressed versions.
+func (r *Repository) indices(index []*repoPackage, sources []*repoSource, hashes []Hash) ([]indexFile, error) {
+	packages := generatePackagesFile(index, hashes, false)
+	r.Progress.report(indexProgress("Packages", packages))
+	indices, err := compressedIndices("Packages", packages, r.IndexCompressions)
 	if err != nil {
 		return nil, err
 	}
+	if len(sources) > 0 {
+		content := generateSourcesFile(sources, hashes)
+		r.Progress.report(indexProgress("Sources", content))
+		compressed, err := compressedIndices("Sources", content, r.IndexCompressions)
+		if err != nil {
+			return nil, err
+		}
+		indices = append(indices, compressed...)
+	}
+	return indices, nil
+}
 
-	if r.GPGKey != "" {
-		pubKey, err := extractPublicKey(r.GPGKey, false)
-		var pubKeyChanged bool
-		if err == nil {
-			if op, err := writeFile("public.gpg", pubKey); err == nil {
-				pubKeyChanged = op.Changed()
-			}
+// compressedIndices returns the index name with its content, followed by its versions compressed
+// with compressions, CompressionGzip if empty, e.g. Packages, Packages.gz and Packages.xz.
+func compressedIndices(name string, content []byte, compressions []Compression) ([]indexFile, error) {
+	if len(compressions) == 0 {
+		compressions = []Compression{CompressionGzip}
+	}
+	indices := []indexFile{{name, content}}
+	for _, c := range compressions {
+		compressedName := name + c.extension()
+		if c == CompressionNone || slices.ContainsFunc(indices, func(f indexFile) bool { return f.name == compressedName }) {
+			continue
 		}
-		pubKeyAsc, err := extractPublicKey(r.GPGKey, true)
-		if err == nil {
-			writeFile("public.asc", pubKeyAsc)
+		var buf bytes.Buffer
+		w, err := c.newWriter(&buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("compressing %s: %w", name, err)
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, fmt.Errorf("compressing %s: %w", name, err)
 		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("compressing %s: %w", name, err)
+		}
+		indices = append(indices, indexFile{compressedName, buf.Bytes()})
+	}
+	return indices, nil
+}
 
-		var inRelease []byte
-		inReleasePath := filepath.Join(path, "InRelease")
+// dirWriter writes repository files into a directory and records the file operations.
+// Files are only written if their content changed. If dryRun is true, nothing is written to disk.
+type dirWriter struct {
+	// ctx cancels the generation of the packages.
+	ctx      context.Context
+	log      *slog.Logger
+	progress ProgressFunc
+	// writeOptions configure the packages built.
+	writeOptions WriteOptions
+	// digestHash is the algorithm of the digests of the file operations.
+	digestHash Hash
+	path       string
+	dryRun     bool
+	ops        []FileOperation
+}
 
-		// If Release and public key didn't change,
-		// Reuse InRelease to avoid re-signing (which changes timestamp)
-		if !opRelease.Changed() && !pubKeyChanged {
-			existing, err := os.ReadFile(inReleasePath)
-			if err == nil {
-				inRelease = existing
-			} else if !os.IsNotExist(err) {
-				return nil, fmt.Errorf("reading existing InRelease: %w", err)
-			}
-		}
-		if inRelease == nil {
-			inRelease, err = signBytes(releaseContent, r.GPGKey)
-			if err != nil {
-				return nil, fmt.Errorf("signing InRelease: %w", err)
-			}
+// write writes content to filename (a slash separated path relative to the directory),
+// creating parent directories as needed, and records the operation with checksums.
+func (d *dirWriter) write(filename string, content []byte) (*FileOperation, error) {
+	fullPath := filepath.Join(d.path, filepath.FromSlash(filename))
+	op := FileOperation{Path: filename, Size: int64(len(content))}
+
+	hash := digestHashOrDefault(d.digestHash)
+	op.NewDigest = hash.sum(content)
+
+	if existing, err := os.ReadFile(fullPath); err == nil {
+		op.OldDigest = hash.sum(existing)
+	}
+
+	if op.Changed() && !d.dryRun {
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return nil, err
 		}
-		if _, err := writeFile("InRelease", inRelease); err != nil {
+		if err := os.WriteFile(fullPath, content, 0644); err != nil {
 			return nil, err
 		}
 	}
+	d.ops = append(d.ops, op)
+	d.progress.report(Progress{Stage: StageWrite, Item: filename, Bytes: op.Size, TotalBytes: op.Size})
+	return &op, nil
+}
+
+// packageContent returns the .deb content of pkg.
+// If the package is unchanged since it was loaded from filename, the file content is reused
+// instead of regenerating the package (which would change the archive timestamps), and so is
+// its original content, see Package.SetOriginalContent.
+func (d *dirWriter) packageContent(pkg *Package, filename string) ([]byte, error) {
+	if err := d.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if existing, err := os.ReadFile(filepath.Join(d.path, filepath.FromSlash(filename))); err == nil {
+		if pkg.isOriginalFile(existing) {
+			d.log.Debug("Package file reused", "file", filename)
+			return existing, nil
+		}
+	}
+	content, err := pkg.content(d.writeOptions)
+	if err != nil {
+		return nil, fmt.Errorf("building package: %w", err)
+	}
+	return content, nil
+}
 
-	return ops, nil
+// sign writes the public keys, and the release signed with key as inRelease.
+// If neither the release nor the public key changed, the existing signature is reused
+// to avoid re-signing (which changes the signature timestamp).
+func (d *dirWriter) sign(inRelease string, release []byte, releaseChanged bool, key string) error {
+	pubKey, err := extractPublicKey(key, false)
+	var pubKeyChanged bool
+	if err == nil {
+		op, err := d.write("public.gpg", pubKey)
+		if err != nil {
+			return err
+		}
+		pubKeyChanged = op.Changed()
+	} else {
+		d.log.Warn("Public key not exported", "file", "public.gpg", "error", err)
+	}
+	pubKeyAsc, err := extractPublicKey(key, true)
+	if err == nil {
+		if _, err := d.write("public.asc", pubKeyAsc); err != nil {
+			return err
+		}
+	} else {
+		d.log.Warn("Public key not exported", "file", "public.asc", "error", err)
+	}
+
+	var signed []byte
+	if !releaseChanged && !pubKeyChanged {
+		existing, err := os.ReadFile(filepath.Join(d.path, filepath.FromSlash(inRelease)))
+		if err == nil {
+			d.log.Debug("Signature reused", "file", inRelease)
+			signed = existing
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("reading existing InRelease: %w", err)
+		}
+	}
+	if signed == nil {
+		signed, err = signBytes(d.ctx, release, key)
+		if err != nil {
+			return fmt.Errorf("signing InRelease: %w", err)
+		}
+	}
+	_, err = d.write(inRelease, signed)
+	return err
 }
 
-// NewRepository creates a Repository from a tar.gz stream.
+// NewRepository creates a Repository from a tar.gz stream, within the DefaultLimits.
 func NewRepository(r io.Reader) (*Repository, error) {
+	return NewRepositoryWithLimits(r, Limits{})
+}
+
+// NewRepositoryWithLimits is like NewRepository, and fails with a LimitError when the archive,
+// or one of its packages, exceeds limits.
+func NewRepositoryWithLimits(r io.Reader, limits Limits) (*Repository, error) {
+	return NewRepositoryWithOptions(r, ReadOptions{Limits: limits})
+}
+
+// NewRepositoryWithOptions is like NewRepository, with explicit options for the archive and its packages.
+// With a Spool or a BlobStore, the payloads of the packages are stored in it instead of memory.
+// With ControlOnly, the packages have no payload: the repository can be inspected, but not written.
+func NewRepositoryWithOptions(r io.Reader, opts ReadOptions) (*Repository, error) {
+	b := newBudget(opts.Limits)
 	gzr, err := gzip.NewReader(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
 	}
 	defer gzr.Close()
 
-	tr := tar.NewReader(gzr)
+	tr := tar.NewReader(b.reader("repository", gzr))
 	repo := &Repository{
 		Packages: []*Package{},
 	}
+	// The files of the source packages, read once the archive is.
+	sourceFiles := make(map[string][]byte)
 
 	for {
 		header, err := tr.Next()
@@ -486,6 +781,9 @@ func NewRepository(r io.Reader) (*Repository, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := b.member(header.Name, header.Size); err != nil {
+			return nil, err
+		}
 
 		switch {
 		case header.Name == "Release" || header.Name == "./Release":
@@ -496,28 +794,58 @@ func NewRepository(r io.Reader) (*Repository, error) {
 			if err := parseReleaseFile(buf.String(), &repo.ArchiveInfo); err != nil {
 				return nil, fmt.Errorf("parsing Release: %w", err)
 			}
-		case strings.HasSuffix(header.Name, ".deb"):
-			h := sha256.New()
-			trTee := io.TeeReader(tr, h)
-			pkg, err := NewPackage(trTee)
+		case strings.HasSuffix(header.Name, ".deb") || strings.HasSuffix(header.Name, ".udeb"):
+			trTee, digest := digestReader(tr, digestHashOrDefault(opts.DigestHash))
+			pkg, err := NewPackageWithOptions(trTee, opts)
 			if err != nil {
 				return nil, fmt.Errorf("parsing %s: %w", header.Name, err)
 			}
-			pkg.SetOriginalState(pkg.Digest(), hex.EncodeToString(h.Sum(nil)))
+			// The digest is the one of the whole file, even if the payload was not read.
+			if _, err := io.Copy(io.Discard, trTee); err != nil {
+				return nil, err
+			}
+			pkg.setOriginalFile(digest(), digestHashOrDefault(opts.DigestHash))
 			repo.Packages = append(repo.Packages, pkg)
+		case strings.HasSuffix(header.Name, ".dsc") || strings.Contains(path.Base(header.Name), ".tar."):
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			sourceFiles[path.Clean(header.Name)] = content
 		}
 	}
 
+	for _, name := range slices.Sorted(maps.Keys(sourceFiles)) {
+		if !strings.HasSuffix(name, ".dsc") {
+			continue
+		}
+		src, err := readSourcePackage(name, func(name string) ([]byte, error) {
+			if content, ok := sourceFiles[name]; ok {
+				return content, nil
+			}
+			return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", name, err)
+		}
+		repo.Sources = append(repo.Sources, src)
+	}
 	return repo, nil
 }
 
 // NewRepositoryFromDir creates a Repository from a directory.
 func NewRepositoryFromDir(path string) (*Repository, error) {
+	return NewRepositoryFromFS(os.DirFS(path))
+}
+
+// NewRepositoryFromFS creates a Repository from the root directory of fsys, e.g. an embedded
+// file system, or a sub directory with fs.Sub.
+func NewRepositoryFromFS(fsys fs.FS) (*Repository, error) {
 	repo := &Repository{
 		Packages: []*Package{},
 	}
 
-	entries, err := os.ReadDir(path)
+	entries, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return nil, err
 	}
@@ -527,30 +855,27 @@ func NewRepositoryFromDir(path string) (*Repository, error) {
 			continue
 		}
 		name := entry.Name()
-		fullPath := filepath.Join(path, name)
 
 		if name == "Release" {
-			content, err := os.ReadFile(fullPath)
+			content, err := fs.ReadFile(fsys, name)
 			if err != nil {
 				return nil, err
 			}
 			if err := parseReleaseFile(string(content), &repo.ArchiveInfo); err != nil {
 				return nil, fmt.Errorf("parsing Release: %w", err)
 			}
-		} else if strings.HasSuffix(name, ".deb") {
-			f, err := os.Open(fullPath)
+		} else if strings.HasSuffix(name, ".deb") || strings.HasSuffix(name, ".udeb") {
+			pkg, err := loadPackageFile(fsys, name)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("parsing %s: %w", name, err)
 			}
-			h := sha256.New()
-			r := io.TeeReader(f, h)
-			pkg, err := NewPackage(r)
-			f.Close()
+			repo.Packages = append(repo.Packages, pkg)
+		} else if strings.HasSuffix(name, ".dsc") {
+			src, err := NewSourcePackageFromFS(fsys, name)
 			if err != nil {
 				return nil, fmt.Errorf("parsing %s: %w", name, err)
 			}
-			pkg.SetOriginalState(pkg.Digest(), hex.EncodeToString(h.Sum(nil)))
-			repo.Packages = append(repo.Packages, pkg)
+			repo.Sources = append(repo.Sources, src)
 		}
 	}
 
@@ -563,18 +888,65 @@ type StandardRepository struct {
 	ArchiveInfo ArchiveInfo
 	GPGKey      string
 	// Parts is a list of Repositories. Each Repository must have a single Architecture
-	// and Component set in its ArchiveInfo.
+	// and Component set in its ArchiveInfo. The Sources of the parts of a Component are listed
+	// in its Sources index, once.
 	Parts []*Repository
+	// Logger receives the diagnostics of the writers. Nil discards them.
+	Logger *slog.Logger
+	// Progress receives the progress of the writers. It can be nil.
+	Progress ProgressFunc
+	// Hashes are the checksums of the package files in the Packages indices, and of the indices
+	// in the Release file. Empty uses DefaultHashes. The Hashes of the parts are ignored.
+	Hashes []Hash
+	// IndexCompressions are the compressed versions of the indices, see Repository.IndexCompressions.
+	// The IndexCompressions of the parts are ignored.
+	IndexCompressions []Compression
+	// Translations moves the long descriptions of the packages from the Packages indices to the
+	// TranslationEn index of their component, as the Debian archive does: the Packages indices keep
+	// the short descriptions, and the Description-md5 that APT uses to find the long ones.
+	Translations bool
+	// WriteOptions configure the packages built by the writers, see Repository.WriteOptions.
+	// The WriteOptions of the parts are ignored.
+	WriteOptions WriteOptions
+	// DigestHash is the algorithm of the digests of the FileOperation of the writers, see Repository.DigestHash.
+	DigestHash Hash
+}
+
+// logger returns the logger of the diagnostics, discarding them if none is set.
+func (r *StandardRepository) logger() *slog.Logger {
+	return loggerOrDiscard(r.Logger)
+}
+
+// packageCount returns the number of packages of all the parts.
+func (r *StandardRepository) packageCount() int {
+	n := 0
+	for _, part := range r.Parts {
+		n += len(part.Packages)
+	}
+	return n
 }
 
+// releaseFileEntry is an index listed in a Release file, with its checksums in the order of the hashes.
 type releaseFileEntry struct {
-	Path string
-	Size int64
-	Hash string
+	Path      string
+	Size      int64
+	Checksums []string
 }
 
 // WriteTo generates the hierarchical repository and writes it as a tarball.
+// The Release file lists the components and architectures of the parts, unless they are set in ArchiveInfo.
 func (r *StandardRepository) WriteTo(w io.Writer) (int64, error) {
+	return r.WriteToContext(context.Background(), w)
+}
+
+// WriteToContext is like WriteTo, but stops before the next package when ctx is canceled,
+// leaving an incomplete tarball.
+func (r *StandardRepository) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	// The files are stamped with the build time of the packages, so the tarball is reproducible.
+	buildTime, err := DefaultBuildTime()
+	if err != nil {
+		return 0, err
+	}
 	cw := &countingWriter{w: w}
 	tw := tar.NewWriter(cw)
 
@@ -584,6 +956,9 @@ func (r *StandardRepository) WriteTo(w io.Writer) (int64, error) {
 
 	// Track generated indices for the top-level Release file
 	var releaseEntries []releaseFileEntry
+	hashes := hashesOrDefault(r.Hashes)
+	// The Packages index entries of the parts, for the Translation indices.
+	var partIndices [][]*repoPackage
 
 	// Helper to add file to tar
 	addFile := func(name string, content []byte) error {
@@ -591,15 +966,19 @@ func (r *StandardRepository) WriteTo(w io.Writer) (int64, error) {
 			Name:    name,
 			Size:    int64(len(content)),
 			Mode:    0644,
-			ModTime: time.Now(),
+			ModTime: buildTime,
 		}
 		if err := tw.WriteHeader(header); err != nil {
 			return fmt.Errorf("writing header for %s: %w", name, err)
 		}
-		_, err := tw.Write(content)
-		return err
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+		r.Progress.report(Progress{Stage: StageWrite, Item: name, Bytes: int64(len(content)), TotalBytes: int64(len(content))})
+		return nil
 	}
 
+	total, done := r.packageCount(), 0
 	for _, part := range r.Parts {
 		comp := part.ArchiveInfo.Components
 		arch := part.ArchiveInfo.Architectures
@@ -610,13 +989,17 @@ func (r *StandardRepository) WriteTo(w io.Writer) (int64, error) {
 		var index []*repoPackage
 
 		for _, pkg := range part.Packages {
-			var buf bytes.Buffer
-			if _, err := pkg.WriteTo(&buf); err != nil {
+			if err := ctx.Err(); err != nil {
+				return cw.n, err
+			}
+			content, err := pkg.content(r.WriteOptions)
+			if err != nil {
 				return cw.n, fmt.Errorf("building package: %w", err)
 			}
-			content := buf.Bytes()
+			r.Progress.report(packageProgress(pkg.StandardFilename(), content, done, total))
+			done++
 
-			rp, err := parseDeb(content, "")
+			rp, err := parseDeb(content, "", hashes)
 			if err != nil {
 				return cw.n, fmt.Errorf("parsing package: %w", err)
 			}
@@ -625,7 +1008,7 @@ func (r *StandardRepository) WriteTo(w io.Writer) (int64, error) {
 			if pkgName == "" {
 				pkgName = "unknown"
 			}
-			poolPath := fmt.Sprintf("pool/%s/%s/%s", comp, pkgName, fmt.Sprintf("%s_%s_%s.deb", rp.Package, rp.Version, rp.Architecture))
+			poolPath := fmt.Sprintf("pool/%s/%s/%s", comp, pkgName, pkg.StandardFilename())
 
 			if !poolFiles[poolPath] {
 				if err := addFile(poolPath, content); err != nil {
@@ -638,52 +1021,66 @@ func (r *StandardRepository) WriteTo(w io.Writer) (int64, error) {
 		}
 
 		// Generate Indices
-		packagesContent := generatePackagesFile(index)
+		partIndices = append(partIndices, index)
+		packagesContent := generatePackagesFile(index, hashes, r.Translations)
 
 		// Path in tar: dists/<Codename>/<Component>/binary-<Arch>/Packages
 		relDir := fmt.Sprintf("%s/binary-%s", comp, arch)
 		packagesPath := fmt.Sprintf("dists/%s/%s/Packages", r.ArchiveInfo.Codename, relDir)
+		r.Progress.report(indexProgress(packagesPath, packagesContent))
 
-		if err := addFile(packagesPath, packagesContent); err != nil {
+		// Packages, and its compressed versions
+		indices, err := compressedIndices("Packages", packagesContent, r.IndexCompressions)
+		if err != nil {
 			return cw.n, err
 		}
+		for _, f := range indices {
+			if err := addFile(fmt.Sprintf("dists/%s/%s/%s", r.ArchiveInfo.Codename, relDir, f.name), f.content); err != nil {
+				return cw.n, err
+			}
+			releaseEntries = append(releaseEntries, releaseFileEntry{
+				Path:      fmt.Sprintf("%s/%s", relDir, f.name),
+				Size:      int64(len(f.content)),
+				Checksums: checksums(hashes, f.content),
+			})
+		}
+	}
 
-		hash := sha256.Sum256(packagesContent)
-		releaseEntries = append(releaseEntries, releaseFileEntry{
-			Path: fmt.Sprintf("%s/Packages", relDir),
-			Size: int64(len(packagesContent)),
-			Hash: hex.EncodeToString(hash[:]),
-		})
-
-		// Packages.gz
-		var gzBuf bytes.Buffer
-		gw := gzip.NewWriter(&gzBuf)
-		gw.Write(packagesContent)
-		gw.Close()
-		packagesGzContent := gzBuf.Bytes()
-
-		packagesGzPath := fmt.Sprintf("dists/%s/%s/Packages.gz", r.ArchiveInfo.Codename, relDir)
-		if err := addFile(packagesGzPath, packagesGzContent); err != nil {
+	sources, err := r.writeSources(hashes, func(name string, content []byte) error {
+		if poolFiles[name] {
+			return nil
+		}
+		poolFiles[name] = true
+		return addFile(name, content)
+	})
+	if err != nil {
+		return cw.n, err
+	}
+	translations, err := r.translationIndices(partIndices)
+	if err != nil {
+		return cw.n, err
+	}
+	for _, f := range append(sources, translations...) {
+		if err := addFile(fmt.Sprintf("dists/%s/%s", r.ArchiveInfo.Codename, f.name), f.content); err != nil {
 			return cw.n, err
 		}
-
-		hashGz := sha256.Sum256(packagesGzContent)
 		releaseEntries = append(releaseEntries, releaseFileEntry{
-			Path: fmt.Sprintf("%s/Packages.gz", relDir),
-			Size: int64(len(packagesGzContent)),
-			Hash: hex.EncodeToString(hashGz[:]),
+			Path:      f.name,
+			Size:      int64(len(f.content)),
+			Checksums: checksums(hashes, f.content),
 		})
 	}
 
 	// Generate Top-Level Release
-	releaseContent := generateHierarchicalRelease(r.ArchiveInfo, releaseEntries)
+	r.defaultArchiveInfo()
+	releaseContent := generateHierarchicalRelease(r.ArchiveInfo.dated(buildTime), hashes, releaseEntries)
 	releasePath := fmt.Sprintf("dists/%s/Release", r.ArchiveInfo.Codename)
 	if err := addFile(releasePath, releaseContent); err != nil {
 		return cw.n, err
 	}
 
 	if r.GPGKey != "" {
-		inRelease, err := signBytes(releaseContent, r.GPGKey)
+		inRelease, err := signBytes(ctx, releaseContent, r.GPGKey)
 		if err != nil {
 			return cw.n, fmt.Errorf("signing InRelease: %w", err)
 		}
@@ -693,11 +1090,19 @@ func (r *StandardRepository) WriteTo(w io.Writer) (int64, error) {
 
 		pubKey, err := extractPublicKey(r.GPGKey, false)
 		if err == nil {
-			addFile("public.gpg", pubKey)
+			if err := addFile("public.gpg", pubKey); err != nil {
+				return cw.n, err
+			}
+		} else {
+			r.logger().Warn("Public key not exported", "file", "public.gpg", "error", err)
 		}
 		pubKeyAsc, err := extractPublicKey(r.GPGKey, true)
 		if err == nil {
-			addFile("public.asc", pubKeyAsc)
+			if err := addFile("public.asc", pubKeyAsc); err != nil {
+				return cw.n, err
+			}
+		} else {
+			r.logger().Warn("Public key not exported", "file", "public.asc", "error", err)
 		}
 	}
 
@@ -706,3 +1111,405 @@ func (r *StandardRepository) WriteTo(w io.Writer) (int64, error) {
 	}
 	return cw.n, nil
 }
+
+// WriteToDir generates the hierarchical repository and writes it to the provided directory path.
+// The Release file lists the components and architectures of the parts, unless they are set in ArchiveInfo.
+func (r *StandardRepository) WriteToDir(path string) ([]FileOperation, error) {
+	return r.writeToDir(context.Background(), path, false)
+}
+
+// WriteToDirContext is like WriteToDir, but stops before the next package when ctx is canceled.
+func (r *StandardRepository) WriteToDirContext(ctx context.Context, path string) ([]FileOperation, error) {
+	return r.writeToDir(ctx, path, false)
+}
+
+// PlanDir generates the hierarchical repository like WriteToDir, but without writing anything.
+// It returns the file operations that WriteToDir would perform.
+func (r *StandardRepository) PlanDir(path string) ([]FileOperation, error) {
+	return r.writeToDir(context.Background(), path, true)
+}
+
+// PlanDirContext is like PlanDir, but stops before the next package when ctx is canceled.
+func (r *StandardRepository) PlanDirContext(ctx context.Context, path string) ([]FileOperation, error) {
+	return r.writeToDir(ctx, path, true)
+}
+
+// writeToDir implements WriteToDir and PlanDir. If dryRun is true, nothing is written to disk.
+func (r *StandardRepository) writeToDir(ctx context.Context, path string, dryRun bool) ([]FileOperation, error) {
+	if r.ArchiveInfo.Codename == "" {
+		return nil, fmt.Errorf("standard repository requires a codename")
+	}
+	d := &dirWriter{ctx: ctx, log: r.logger(), progress: r.Progress, writeOptions: r.WriteOptions, digestHash: r.DigestHash, path: path, dryRun: dryRun}
+	dists := "dists/" + r.ArchiveInfo.Codename
+
+	var releaseEntries []releaseFileEntry
+	hashes := hashesOrDefault(r.Hashes)
+	// The Packages index entries of the parts, for the Translation indices.
+	var partIndices [][]*repoPackage
+	indicesChanged := false
+	// Packages shared by several parts (e.g. "all" architecture) are written once to the pool.
+	written := make(map[string]bool)
+	total, done := r.packageCount(), 0
+
+	for _, part := range r.Parts {
+		comp := part.ArchiveInfo.Components
+		arch := part.ArchiveInfo.Architectures
+		if comp == "" || arch == "" {
+			return nil, fmt.Errorf("part missing component or architecture")
+		}
+
+		var index []*repoPackage
+		for _, pkg := range part.Packages {
+			poolPath := PoolPath(comp, pkg)
+			content, err := d.packageContent(pkg, poolPath)
+			if err != nil {
+				return nil, err
+			}
+			d.progress.report(packageProgress(poolPath, content, done, total))
+			done++
+			rp, err := parseDeb(content, "", hashes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing package: %w", err)
+			}
+			rp.Filename = poolPath
+			if !written[poolPath] {
+				if _, err := d.write(poolPath, content); err != nil {
+					return nil, err
+				}
+				written[poolPath] = true
+			}
+			index = append(index, rp)
+		}
+
+		relDir := fmt.Sprintf("%s/binary-%s", comp, arch)
+		partIndices = append(partIndices, index)
+		packagesContent := generatePackagesFile(index, hashes, r.Translations)
+		d.progress.report(indexProgress(dists+"/"+relDir+"/Packages", packagesContent))
+		indices, err := compressedIndices("Packages", packagesContent, r.IndexCompressions)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range indices {
+			op, err := d.write(dists+"/"+relDir+"/"+f.name, f.content)
+			if err != nil {
+				return nil, err
+			}
+			indicesChanged = indicesChanged || op.Changed()
+			releaseEntries = append(releaseEntries, releaseFileEntry{
+				Path:      relDir + "/" + f.name,
+				Size:      int64(len(f.content)),
+				Checksums: checksums(hashes, f.content),
+			})
+		}
+	}
+
+	sources, err := r.writeSources(hashes, func(name string, content []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := d.write(name, content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	translations, err := r.translationIndices(partIndices)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range append(sources, translations...) {
+		op, err := d.write(dists+"/"+f.name, f.content)
+		if err != nil {
+			return nil, err
+		}
+		indicesChanged = indicesChanged || op.Changed()
+		releaseEntries = append(releaseEntries, releaseFileEntry{
+			Path:      f.name,
+			Size:      int64(len(f.content)),
+			Checksums: checksums(hashes, f.content),
+		})
+	}
+
+	r.defaultArchiveInfo()
+	if indicesChanged || r.ArchiveInfo.Date == "" {
+		buildTime, err := DefaultBuildTime()
+		if err != nil {
+			return nil, err
+		}
+		r.ArchiveInfo.Date = buildTime.UTC().Format(time.RFC1123Z)
+	}
+
+	releaseContent := generateHierarchicalRelease(r.ArchiveInfo, hashes, releaseEntries)
+	opRelease, err := d.write(dists+"/Release", releaseContent)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.GPGKey != "" {
+		if err := d.sign(dists+"/InRelease", releaseContent, opRelease.Changed(), r.GPGKey); err != nil {
+			return nil, err
+		}
+	}
+	return d.ops, nil
+}
+
+// defaultArchiveInfo sets the components and architectures of the Release file to the ones of the parts,
+// unless they are set in ArchiveInfo.
+func (r *StandardRepository) defaultArchiveInfo() {
+	var components, architectures []string
+	for _, part := range r.Parts {
+		if comp := part.ArchiveInfo.Components; !slices.Contains(components, comp) {
+			components = append(components, comp)
+		}
+		if arch := part.ArchiveInfo.Architectures; !slices.Contains(architectures, arch) {
+			architectures = append(architectures, arch)
+		}
+	}
+	if r.ArchiveInfo.Components == "" {
+		r.ArchiveInfo.Components = strings.Join(components, " ")
+	}
+	if r.ArchiveInfo.Architectures == "" {
+		r.ArchiveInfo.Architectures = strings.Join(architectures, " ")
+	}
+}
+
+// writeSources writes the files of the source packages of the parts in the pool with write, once,
+// and returns the Sources indices of the components with source packages, with their compressed
+// versions, by path relative to the dists/{Codename} directory.
+func (r *StandardRepository) writeSources(hashes []Hash, write func(name string, content []byte) error) ([]indexFile, error) {
+	var components []string
+	index := make(map[string][]*repoSource)
+	written := make(map[string]bool)
+	for _, part := range r.Parts {
+		comp := part.ArchiveInfo.Components
+		for _, src := range part.Sources {
+			dir := SourcePoolDir(comp, src)
+			if written[dir+"/"+src.DscFilename()] {
+				continue
+			}
+			written[dir+"/"+src.DscFilename()] = true
+			rs, err := newRepoSource(src, dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range rs.Files {
+				if err := write(dir+"/"+f.Name, f.Content); err != nil {
+					return nil, err
+				}
+			}
+			if !slices.Contains(components, comp) {
+				components = append(components, comp)
+			}
+			index[comp] = append(index[comp], rs)
+		}
+	}
+
+	var indices []indexFile
+	for _, comp := range components {
+		content := generateSourcesFile(index[comp], hashes)
+		name := comp + "/source/Sources"
+		r.Progress.report(indexProgress("dists/"+r.ArchiveInfo.Codename+"/"+name, content))
+		compressed, err := compressedIndices(name, content, r.IndexCompressions)
+		if err != nil {
+			return nil, err
+		}
+		indices = append(indices, compressed...)
+	}
+	return indices, nil
+}
+
+// translationIndices returns the Translation indices of the components of the parts, with their
+// compressed versions, by path relative to the dists/{Codename} directory, if Translations is set.
+// partIndices are the Packages index entries of the parts, in order.
+func (r *StandardRepository) translationIndices(partIndices [][]*repoPackage) ([]indexFile, error) {
+	if !r.Translations {
+		return nil, nil
+	}
+	var components []string
+	index := make(map[string][]*repoPackage)
+	for i, part := range r.Parts {
+		comp := part.ArchiveInfo.Components
+		if !slices.Contains(components, comp) {
+			components = append(components, comp)
+		}
+		index[comp] = append(index[comp], partIndices[i]...)
+	}
+
+	var indices []indexFile
+	for _, comp := range components {
+		content := generateTranslationFile(index[comp])
+		name := comp + "/" + TranslationEn
+		r.Progress.report(indexProgress("dists/"+r.ArchiveInfo.Codename+"/"+name, content))
+		compressed, err := compressedIndices(name, content, r.IndexCompressions)
+		if err != nil {
+			return nil, err
+		}
+		indices = append(indices, compressed...)
+	}
+	return indices, nil
+}
+
+// SourcePoolDir returns the directory of the files of a source package in the pool of a standard repository.
+// Format: pool/{Component}/{Source}
+func SourcePoolDir(component string, src *SourcePackage) string {
+	name := src.Metadata.Source
+	if name == "" {
+		name = "unknown"
+	}
+	return fmt.Sprintf("pool/%s/%s", component, name)
+}
+
+// PoolPath returns the path of the package file in the pool of a standard repository.
+// Format: pool/{Component}/{Package}/{Package}_{Version}_{Architecture}.deb
+func PoolPath(component string, pkg *Package) string {
+	name := pkg.Metadata.Package
+	if name == "" {
+		name = "unknown"
+	}
+	return fmt.Sprintf("pool/%s/%s/%s", component, name, pkg.StandardFilename())
+}
+
+// alternateEpochFilename returns the other spelling of a package filename whose version has an epoch:
+// with the ':' encoded as in StandardFilename if it is not, and decoded otherwise, e.g. for the files
+// written by other tools, or by previous versions of this package.
+func alternateEpochFilename(filename string) string {
+	dir, base := path.Split(filename)
+	if strings.Contains(base, ":") {
+		return dir + strings.ReplaceAll(base, ":", epochEscape)
+	}
+	return dir + strings.ReplaceAll(base, epochEscape, ":")
+}
+
+// NewStandardRepositoryFromDir creates a StandardRepository from the directory of a
+// hierarchical repository, for the given codename.
+// It reads the Release file, and one part per component and architecture it lists.
+// Packages shared by several parts are loaded once.
+func NewStandardRepositoryFromDir(path, codename string) (*StandardRepository, error) {
+	return NewStandardRepositoryFromFS(os.DirFS(path), codename)
+}
+
+// NewStandardRepositoryFromFS is like NewStandardRepositoryFromDir, for a repository at the root of fsys.
+func NewStandardRepositoryFromFS(fsys fs.FS, codename string) (*StandardRepository, error) {
+	dists := "dists/" + codename
+	content, err := fs.ReadFile(fsys, dists+"/Release")
+	if err != nil {
+		return nil, err
+	}
+	repo := &StandardRepository{}
+	if err := parseReleaseFile(string(content), &repo.ArchiveInfo); err != nil {
+		return nil, fmt.Errorf("parsing Release: %w", err)
+	}
+
+	loaded := make(map[string]*Package)
+	for _, comp := range strings.Fields(repo.ArchiveInfo.Components) {
+		for _, arch := range strings.Fields(repo.ArchiveInfo.Architectures) {
+			index, err := fs.ReadFile(fsys, dists+"/"+comp+"/binary-"+arch+"/Packages")
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries, err := ParsePackagesIndex(string(index))
+			if err != nil {
+				return nil, fmt.Errorf("parsing Packages for %s/%s: %w", comp, arch, err)
+			}
+			part := &Repository{ArchiveInfo: ArchiveInfo{Components: comp, Architectures: arch}}
+			for _, e := range entries {
+				filename := e.Filename
+				if filename == "" {
+					return nil, fmt.Errorf("package %s in %s/%s has no Filename", e.Metadata.Package, comp, arch)
+				}
+				pkg, ok := loaded[filename]
+				if !ok {
+					pkg, err = loadPackageFile(fsys, filename)
+					if alt := alternateEpochFilename(filename); errors.Is(err, fs.ErrNotExist) && alt != filename {
+						pkg, err = loadPackageFile(fsys, alt)
+					}
+					if err != nil {
+						return nil, fmt.Errorf("parsing %s: %w", filename, err)
+					}
+					loaded[filename] = pkg
+				}
+				part.Packages = append(part.Packages, pkg)
+			}
+			repo.Parts = append(repo.Parts, part)
+		}
+
+		sources, err := readSources(fsys, dists+"/"+comp+"/source/Sources")
+		if err != nil {
+			return nil, fmt.Errorf("reading the source packages of %s: %w", comp, err)
+		}
+		if len(sources) == 0 {
+			continue
+		}
+		// The source packages are in the first part of their component.
+		i := slices.IndexFunc(repo.Parts, func(p *Repository) bool { return p.ArchiveInfo.Components == comp })
+		if i < 0 {
+			arch, _, _ := strings.Cut(repo.ArchiveInfo.Architectures, " ")
+			repo.Parts = append(repo.Parts, &Repository{ArchiveInfo: ArchiveInfo{Components: comp, Architectures: arch}})
+			i = len(repo.Parts) - 1
+		}
+		repo.Parts[i].Sources = sources
+	}
+	return repo, nil
+}
+
+// readSources reads the source packages listed in the Sources index name of fsys, if any.
+func readSources(fsys fs.FS, name string) ([]*SourcePackage, error) {
+	f, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sources []*SourcePackage
+	s := newIndexScanner(f, DefaultLimits)
+	for s.next() {
+		var directory, dsc string
+		for _, field := range s.fields {
+			value := strings.TrimSpace(string(s.buf[field.start:field.end]))
+			switch string(s.buf[field.key:field.start]) {
+			case "Directory":
+				directory = value
+			case "Files":
+				list, err := parseSourceList(value)
+				if err != nil {
+					return nil, err
+				}
+				for _, e := range list {
+					if strings.HasSuffix(e.name, ".dsc") {
+						dsc = e.name
+					}
+				}
+			}
+		}
+		if directory == "" || dsc == "" {
+			return nil, fmt.Errorf("a source package has no Directory or .dsc file")
+		}
+		src, err := NewSourcePackageFromFS(fsys, path.Join(directory, dsc))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", dsc, err)
+		}
+		sources = append(sources, src)
+	}
+	return sources, s.err
+}
+
+// loadPackageFile reads the .deb file name of fsys, and records its original state.
+func loadPackageFile(fsys fs.FS, name string) (*Package, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, digest := digestReader(f, SHA256)
+	pkg, err := NewPackage(r)
+	if err != nil {
+		return nil, err
+	}
+	pkg.setOriginalFile(digest(), SHA256)
+	return pkg, nil
+}
@@ -0,0 +1,631 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestPlanDir(t *testing.T) {
+	dir := t.TempDir()
+	repo := &Repository{
+		Packages: []*Package{{
+			Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"},
+			Files:    []File{{DestPath: "/usr/share/foo/data", Mode: 0644, Body: "data"}},
+		}},
+	}
+
+	planned, err := repo.PlanDir(dir)
+	if err != nil {
+		t.Fatalf("PlanDir failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("PlanDir wrote %d files, want none", len(entries))
+	}
+
+	written, err := repo.WriteToDir(dir)
+	if err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	if len(planned) != len(written) {
+		t.Fatalf("PlanDir planned %d operations, WriteToDir performed %d", len(planned), len(written))
+	}
+	for i := range planned {
+		if planned[i].Path != written[i].Path || !planned[i].Changed() {
+			t.Errorf("planned operation %v does not match %v", planned[i], written[i])
+		}
+	}
+}
+
+func TestWriteToDirContextCanceled(t *testing.T) {
+	dir := t.TempDir()
+	repo := &Repository{
+		Packages: []*Package{{
+			Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"},
+			Files:    []File{{DestPath: "/usr/share/foo/data", Mode: 0644, Body: "data"}},
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.WriteToDirContext(ctx, dir); !errors.Is(err, context.Canceled) {
+		t.Fatalf("WriteToDirContext error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Packages")); !os.IsNotExist(err) {
+		t.Errorf("Packages should not be written after a cancellation, got %v", err)
+	}
+	if _, err := repo.WriteToContext(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteToContext error = %v, want context.Canceled", err)
+	}
+	standard := &StandardRepository{
+		ArchiveInfo: ArchiveInfo{Codename: "stable"},
+		Parts:       []*Repository{{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "all"}, Packages: repo.Packages}},
+	}
+	if _, err := standard.WriteToContext(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("StandardRepository.WriteToContext error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRepositoryConcurrentAppend(t *testing.T) {
+	repo := &Repository{}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every package is appended twice, the second one is the existing identical package.
+			pkg := &Package{Metadata: Metadata{Package: fmt.Sprintf("pkg%d", i%25), Version: "1.0", Architecture: "all"}}
+			if _, err := repo.Append(pkg); err != nil {
+				t.Errorf("Append failed: %v", err)
+			}
+			repo.Get(pkg.Metadata.Package, "1.0", "all")
+		}()
+	}
+	wg.Wait()
+	if len(repo.Packages) != 25 {
+		t.Errorf("got %d packages, want 25", len(repo.Packages))
+	}
+}
+
+func TestRepositoryAddSafe(t *testing.T) {
+	repo := &Repository{}
+	newPkg := func(body string) *Package {
+		return &Package{
+			Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"},
+			Files:    []File{{DestPath: "/usr/share/foo/data", Mode: 0644, Body: body}},
+		}
+	}
+	first := newPkg("data")
+	if got, err := repo.AddSafe(first); err != nil || got != first {
+		t.Fatalf("AddSafe = %p, %v, want the new package", got, err)
+	}
+	// An identical re-publish is accepted, and returns the published package.
+	if got, err := repo.AddSafe(newPkg("data")); err != nil || got != first {
+		t.Errorf("AddSafe of an identical package = %p, %v, want the existing package", got, err)
+	}
+	_, err := repo.AddSafe(newPkg("other"))
+	var conflict *ConflictError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &conflict) || conflict.Existing != first {
+		t.Errorf("AddSafe of a different package error = %v, want a ConflictError", err)
+	}
+	if len(repo.Packages) != 1 {
+		t.Errorf("got %d packages, want 1", len(repo.Packages))
+	}
+}
+
+func TestRepositoryRemove(t *testing.T) {
+	newPkg := func(name, version, arch string) *Package {
+		return &Package{Metadata: Metadata{Package: name, Version: version, Architecture: arch}}
+	}
+	repo := &Repository{Packages: []*Package{
+		newPkg("app", "1.0-1", "amd64"),
+		newPkg("app", "1.0-1", "arm64"),
+		newPkg("app", "2.0-1", "amd64"),
+		newPkg("lib", "1.0-1", "amd64"),
+	}}
+	versions := func(pkgs []*Package) []string {
+		var v []string
+		for _, p := range pkgs {
+			v = append(v, p.Metadata.Package+"_"+p.Metadata.Version+"_"+p.Metadata.Architecture)
+		}
+		return v
+	}
+
+	if got := versions(repo.Remove("app", "1.0-1", "arm64")); !slices.Equal(got, []string{"app_1.0-1_arm64"}) {
+		t.Errorf("Remove = %q, want the arm64 package", got)
+	}
+	if got := repo.Remove("app", "3.0-1", ""); got != nil {
+		t.Errorf("Remove of a missing version = %q, want nothing", versions(got))
+	}
+	if got := versions(repo.Remove("app", "", "")); !slices.Equal(got, []string{"app_1.0-1_amd64", "app_2.0-1_amd64"}) {
+		t.Errorf("Remove of every version = %q", got)
+	}
+	if got := versions(repo.Packages); !slices.Equal(got, []string{"lib_1.0-1_amd64"}) {
+		t.Errorf("Packages = %q, want only lib", got)
+	}
+	removed := repo.RemoveWhere(func(p *Package) bool { return strings.HasPrefix(p.Metadata.Package, "li") })
+	if len(removed) != 1 || len(repo.Packages) != 0 {
+		t.Errorf("RemoveWhere removed %q, left %q", versions(removed), versions(repo.Packages))
+	}
+}
+
+func TestWriteToDirHashes(t *testing.T) {
+	dir := t.TempDir()
+	repo := &Repository{
+		Packages: []*Package{{Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"}}},
+		Hashes:   []Hash{SHA256, SHA512},
+	}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	index, err := os.ReadFile(filepath.Join(dir, "Packages"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ParsePackagesIndex(string(index))
+	if err != nil {
+		t.Fatalf("ParsePackagesIndex failed: %v", err)
+	}
+	deb, err := os.ReadFile(filepath.Join(dir, "foo_1.0_all.deb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entries[0].SHA512, SHA512.sum(deb); got != want {
+		t.Errorf("SHA512 field = %q, want %q", got, want)
+	}
+	release, err := os.ReadFile(filepath.Join(dir, "Release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := fmt.Sprintf("SHA512:\n %s %d Packages\n", SHA512.sum(index), len(index))
+	if !strings.Contains(string(release), line) {
+		t.Errorf("Release does not list the SHA512 of Packages:\n%s", release)
+	}
+}
+
+func TestDigestHash(t *testing.T) {
+	repo := &Repository{
+		Packages:   []*Package{{Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"}}},
+		DigestHash: SHA512,
+	}
+	var buf bytes.Buffer
+	if _, err := repo.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	loaded, err := NewRepositoryWithOptions(bytes.NewReader(buf.Bytes()), ReadOptions{DigestHash: SHA512})
+	if err != nil {
+		t.Fatalf("NewRepositoryWithOptions failed: %v", err)
+	}
+	loaded.DigestHash = SHA512
+	dir := t.TempDir()
+	ops, err := loaded.WriteToDir(dir)
+	if err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	for _, op := range ops {
+		if len(op.NewDigest) != 128 {
+			t.Errorf("%s: digest %q is not a SHA512", op.Path, op.NewDigest)
+		}
+	}
+	deb, err := os.ReadFile(filepath.Join(dir, "foo_1.0_all.deb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Packages[0].isOriginalFile(deb) {
+		t.Error("the package file written from the loaded repository is not its original file")
+	}
+	// The default digest of the other repositories is unchanged.
+	if ops, err := (&Repository{}).WriteToDir(t.TempDir()); err != nil || len(ops[0].NewDigest) != 64 {
+		t.Errorf("WriteToDir = %v, %v, want SHA256 digests", ops, err)
+	}
+}
+
+func TestReleaseHashes(t *testing.T) {
+	dir := t.TempDir()
+	pkg := &Package{Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"}}
+	hashes := []Hash{MD5, SHA1, SHA256, SHA512}
+	repo := &Repository{Packages: []*Package{pkg}, Hashes: hashes}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	std := &StandardRepository{
+		ArchiveInfo: ArchiveInfo{Codename: "stable"},
+		Parts:       []*Repository{{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "amd64"}, Packages: []*Package{pkg}}},
+		Hashes:      hashes,
+	}
+	if _, err := std.WriteToDir(filepath.Join(dir, "std")); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+
+	for _, tt := range []struct{ release, index, path string }{
+		{"Release", "Packages", "Packages"},
+		{"std/dists/stable/Release", "std/dists/stable/main/binary-amd64/Packages", "main/binary-amd64/Packages"},
+	} {
+		release, err := os.ReadFile(filepath.Join(dir, tt.release))
+		if err != nil {
+			t.Fatal(err)
+		}
+		index, err := os.ReadFile(filepath.Join(dir, tt.index))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range hashes {
+			section := fmt.Sprintf("\n%s:\n %s %d %s\n", h.Name, h.sum(index), len(index), tt.path)
+			if !strings.Contains(string(release), section) {
+				t.Errorf("%s does not list the %s of %s:\n%s", tt.release, h.Name, tt.path, release)
+			}
+		}
+		for _, field := range []string{"MD5sum: ", "SHA1: ", "SHA256: ", "SHA512: "} {
+			if !strings.Contains(string(index), "\n"+field) {
+				t.Errorf("%s has no %s field:\n%s", tt.index, field, index)
+			}
+		}
+	}
+
+	for _, name := range []string{"md5", "MD5Sum", "sha1"} {
+		if _, err := HashByName(name); err != nil {
+			t.Errorf("HashByName(%q) failed: %v", name, err)
+		}
+	}
+}
+
+func TestIndexCompressions(t *testing.T) {
+	dir := t.TempDir()
+	pkg := &Package{Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"}}
+	compressions := []Compression{CompressionXz, CompressionZstd, CompressionNone, CompressionXz}
+	repo := &Repository{Packages: []*Package{pkg}, IndexCompressions: compressions}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	std := &StandardRepository{
+		ArchiveInfo:       ArchiveInfo{Codename: "stable"},
+		Parts:             []*Repository{{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "amd64"}, Packages: []*Package{pkg}}},
+		IndexCompressions: compressions,
+	}
+	if _, err := std.WriteToDir(filepath.Join(dir, "std")); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+
+	for _, tt := range []struct{ release, dir, prefix string }{
+		{"Release", "", ""},
+		{"std/dists/stable/Release", "std/dists/stable/", "main/binary-amd64/"},
+	} {
+		release, err := os.ReadFile(filepath.Join(dir, tt.release))
+		if err != nil {
+			t.Fatal(err)
+		}
+		index, err := os.ReadFile(filepath.Join(dir, tt.dir+tt.prefix+"Packages"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, tt.dir+tt.prefix+"Packages.gz")); err == nil {
+			t.Errorf("%s: Packages.gz is written, but gzip is not selected", tt.release)
+		}
+		for _, name := range []string{"Packages.xz", "Packages.zst"} {
+			content, err := os.ReadFile(filepath.Join(dir, tt.dir+tt.prefix+name))
+			if err != nil {
+				t.Errorf("%s: %v", tt.release, err)
+				continue
+			}
+			if line := fmt.Sprintf(" %s %d %s%s\n", SHA256.sum(content), len(content), tt.prefix, name); !strings.Contains(string(release), line) {
+				t.Errorf("%s does not list %s:\n%s", tt.release, name, release)
+			}
+			got, err := DecompressIndex(name, content)
+			if err != nil || !bytes.Equal(got, index) {
+				t.Errorf("%s is not the compressed Packages index: %v", name, err)
+			}
+		}
+		if got, err := DecompressIndex("Packages", index); err != nil || !bytes.Equal(got, index) {
+			t.Errorf("DecompressIndex changes the uncompressed Packages index: %v", err)
+		}
+	}
+}
+
+func TestWriteToDirWriteOptions(t *testing.T) {
+	dir := t.TempDir()
+	repo := &Repository{
+		Packages:     []*Package{{Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"}}},
+		WriteOptions: WriteOptions{Compression: CompressionZstd, Level: 1},
+	}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	f, err := os.Open(filepath.Join(dir, "foo_1.0_all.deb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pkg, err := NewPackage(f)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if pkg.Compression != CompressionZstd {
+		t.Errorf("Compression = %q, want %q", pkg.Compression, CompressionZstd)
+	}
+}
+
+func TestWriteToDirProgress(t *testing.T) {
+	var reports []Progress
+	repo := &Repository{
+		Packages: []*Package{
+			{Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"}},
+			{Metadata: Metadata{Package: "bar", Version: "1.0", Architecture: "all"}},
+		},
+		Progress: func(p Progress) { reports = append(reports, p) },
+	}
+	ops, err := repo.WriteToDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+
+	var packages, writes int
+	for _, p := range reports {
+		if !p.Done() {
+			t.Errorf("progress %+v is not done", p)
+		}
+		switch p.Stage {
+		case StagePackage:
+			packages++
+			if p.Items != packages || p.TotalItems != 2 {
+				t.Errorf("package progress %+v, want item %d of 2", p, packages)
+			}
+		case StageWrite:
+			writes++
+		}
+	}
+	if packages != 2 {
+		t.Errorf("got %d package reports, want 2", packages)
+	}
+	if writes != len(ops) {
+		t.Errorf("got %d write reports, want one per file operation (%d)", writes, len(ops))
+	}
+}
+
+func TestStandardRepositoryWriteToDir(t *testing.T) {
+	dir := t.TempDir()
+	all := &Package{Metadata: Metadata{Package: "doc", Version: "1.0", Architecture: "all"}}
+	bin := &Package{Metadata: Metadata{Package: "app", Version: "1.0", Architecture: "amd64"}}
+	repo := &StandardRepository{
+		ArchiveInfo: ArchiveInfo{Origin: "test", Codename: "stable"},
+		Parts: []*Repository{
+			{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "amd64"}, Packages: []*Package{bin, all}},
+			{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "arm64"}, Packages: []*Package{all}},
+		},
+	}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	for _, name := range []string{
+		"dists/stable/Release",
+		"dists/stable/main/binary-amd64/Packages.gz",
+		"dists/stable/main/binary-arm64/Packages",
+		"pool/main/app/app_1.0_amd64.deb",
+		"pool/main/doc/doc_1.0_all.deb",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("missing %s: %v", name, err)
+		}
+	}
+
+	loaded, err := NewStandardRepositoryFromDir(dir, "stable")
+	if err != nil {
+		t.Fatalf("NewStandardRepositoryFromDir failed: %v", err)
+	}
+	if loaded.ArchiveInfo.Components != "main" || loaded.ArchiveInfo.Architectures != "amd64 arm64" {
+		t.Errorf("ArchiveInfo = %+v, want component main and architectures amd64 arm64", loaded.ArchiveInfo)
+	}
+	if len(loaded.Parts) != 2 || len(loaded.Parts[0].Packages) != 2 || loaded.Parts[0].Packages[1] != loaded.Parts[1].Packages[0] {
+		t.Fatalf("Parts = %+v, want the 'all' package shared by both parts", loaded.Parts)
+	}
+
+	ops, err := loaded.WriteToDir(dir)
+	if err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	for _, op := range ops {
+		if op.Changed() {
+			t.Errorf("rewriting an unchanged repository changed %s", op.Path)
+		}
+	}
+}
+
+func TestStandardRepositoryWriteTo(t *testing.T) {
+	all := &Package{Metadata: Metadata{Package: "doc", Version: "1.0", Architecture: "all"}}
+	bin := &Package{Metadata: Metadata{Package: "app", Version: "1.0", Architecture: "amd64"}}
+	repo := &StandardRepository{
+		ArchiveInfo: ArchiveInfo{Origin: "test", Codename: "stable"},
+		Parts: []*Repository{
+			{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "amd64"}, Packages: []*Package{bin, all}},
+			{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "arm64"}, Packages: []*Package{all}},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := repo.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	var release []byte
+	tr := tar.NewReader(&buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading the tarball: %v", err)
+		}
+		if h.Name == "dists/stable/Release" {
+			if release, err = io.ReadAll(tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if release == nil {
+		t.Fatalf("the tarball has no dists/stable/Release")
+	}
+	// Like the directory output, the Release file lists the components and architectures of the parts.
+	for _, want := range []string{"Components: main\n", "Architectures: amd64 arm64\n"} {
+		if !strings.Contains(string(release), want) {
+			t.Errorf("Release does not contain %q:\n%s", want, release)
+		}
+	}
+}
+
+func TestWriteToReproducible(t *testing.T) {
+	epoch := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	t.Setenv("SOURCE_DATE_EPOCH", strconv.FormatInt(epoch.Unix(), 10))
+	pkg := &Package{Metadata: Metadata{Package: "app", Version: "1.0", Architecture: "amd64"}}
+	flat := &Repository{ArchiveInfo: ArchiveInfo{Origin: "test"}, Packages: []*Package{pkg}}
+	standard := &StandardRepository{
+		ArchiveInfo: ArchiveInfo{Origin: "test", Codename: "stable"},
+		Parts:       []*Repository{{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "amd64"}, Packages: []*Package{pkg}}},
+	}
+	for _, tt := range []struct {
+		name  string
+		write func(io.Writer) (int64, error)
+		gzip  bool
+	}{
+		{"Repository", flat.WriteTo, true},
+		{"StandardRepository", standard.WriteTo, false},
+	} {
+		var first, second bytes.Buffer
+		if _, err := tt.write(&first); err != nil {
+			t.Fatalf("%s: WriteTo failed: %v", tt.name, err)
+		}
+		if _, err := tt.write(&second); err != nil {
+			t.Fatalf("%s: WriteTo failed: %v", tt.name, err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("%s: two tarballs of the same repository differ", tt.name)
+		}
+
+		var r io.Reader = &first
+		if tt.gzip {
+			gzr, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			r = gzr
+		}
+		tr := tar.NewReader(r)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: reading the tarball: %v", tt.name, err)
+			}
+			if !h.ModTime.Equal(epoch) {
+				t.Errorf("%s: %s modified at %v, want SOURCE_DATE_EPOCH %v", tt.name, h.Name, h.ModTime, epoch)
+			}
+			if strings.HasSuffix(h.Name, "Release") {
+				content, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := "Date: " + epoch.Format(time.RFC1123Z) + "\n"; !strings.Contains(string(content), want) {
+					t.Errorf("%s: %s does not contain %q:\n%s", tt.name, h.Name, want, content)
+				}
+			}
+		}
+	}
+}
+
+func TestStandardRepositoryEpoch(t *testing.T) {
+	dir := t.TempDir()
+	pkg := &Package{Metadata: Metadata{Package: "app", Version: "2:1.0-1", Architecture: "amd64"}}
+	repo := &StandardRepository{
+		ArchiveInfo: ArchiveInfo{Origin: "test", Codename: "stable"},
+		Parts:       []*Repository{{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "amd64"}, Packages: []*Package{pkg}}},
+	}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	encoded := filepath.Join(dir, "pool/main/app/app_2%3a1.0-1_amd64.deb")
+	if _, err := os.Stat(encoded); err != nil {
+		t.Fatalf("missing the package file with an encoded epoch: %v", err)
+	}
+	index, err := os.ReadFile(filepath.Join(dir, "dists/stable/main/binary-amd64/Packages"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(index), "Filename: pool/main/app/app_2%3a1.0-1_amd64.deb\n") {
+		t.Errorf("Packages should list the encoded filename:\n%s", index)
+	}
+
+	load := func() {
+		t.Helper()
+		loaded, err := NewStandardRepositoryFromDir(dir, "stable")
+		if err != nil {
+			t.Fatalf("NewStandardRepositoryFromDir failed: %v", err)
+		}
+		if got := loaded.Parts[0].Packages[0].Metadata.Version; got != "2:1.0-1" {
+			t.Errorf("Version = %q, want 2:1.0-1", got)
+		}
+	}
+	load()
+
+	// Another tool can keep the ':' in the file name, or in the index.
+	if err := os.Rename(encoded, filepath.Join(dir, "pool/main/app/app_2:1.0-1_amd64.deb")); err != nil {
+		t.Fatal(err)
+	}
+	load()
+	decoded := strings.ReplaceAll(string(index), "%3a", ":")
+	if err := os.WriteFile(filepath.Join(dir, "dists/stable/main/binary-amd64/Packages"), []byte(decoded), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "pool/main/app/app_2:1.0-1_amd64.deb"), encoded); err != nil {
+		t.Fatal(err)
+	}
+	load()
+}
+
+func TestNewRepositoryFromFS(t *testing.T) {
+	pkg := &Package{Metadata: Metadata{Package: "foo", Version: "1.0", Architecture: "all"}}
+	var deb bytes.Buffer
+	if _, err := pkg.WriteTo(&deb); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	fsys := fstest.MapFS{
+		"repo/Release":          {Data: []byte("Origin: test\nLabel: fs\n")},
+		"repo/foo_1.0_all.deb":  {Data: deb.Bytes()},
+		"repo/notes/readme.txt": {Data: []byte("ignored")},
+	}
+	sub, err := fs.Sub(fsys, "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewRepositoryFromFS(sub)
+	if err != nil {
+		t.Fatalf("NewRepositoryFromFS failed: %v", err)
+	}
+	if repo.ArchiveInfo.Origin != "test" || repo.ArchiveInfo.Label != "fs" {
+		t.Errorf("ArchiveInfo = %+v, want the Release fields", repo.ArchiveInfo)
+	}
+	if len(repo.Packages) != 1 || !repo.Packages[0].Equal(pkg) {
+		t.Fatalf("Packages = %v, want the foo package", repo.Packages)
+	}
+	sum := sha256.Sum256(deb.Bytes())
+	digest, err := pkg.Digest()
+	if err != nil {
+		t.Fatalf("Digest failed: %v", err)
+	}
+	if !repo.Packages[0].IsOriginal(digest, hex.EncodeToString(sum[:])) {
+		t.Errorf("the package loaded from the file system should record its original state")
+	}
+}
@@ -0,0 +1,120 @@
+package deb
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// RetentionPolicy selects the old packages that Repository.Prune removes. The most recent version
+// of each package and architecture is always kept, and the zero fields remove nothing.
+type RetentionPolicy struct {
+	// Versions is the number of most recent upstream versions kept for each package and architecture.
+	Versions int
+	// Revisions is the number of most recent Debian revisions kept for each upstream version.
+	Revisions int
+	// MaxAge removes the packages older than this duration, according to Age.
+	MaxAge time.Duration
+	// Age returns the age of a package, e.g. from the modification time of its file. It is required
+	// with MaxAge.
+	Age func(*Package) time.Duration
+	// Pins are relations, e.g. "app (= 1.2-1)" or "lib (>= 3.0)", whose matching packages are never
+	// removed, see Relation.MatchesVersion. The architecture qualifier, if any, must match too.
+	Pins []string
+	// Match, if set, restricts the policy to the packages for which it returns true, e.g. by name.
+	Match func(*Package) bool
+	// Keep, if set, protects the packages for which it returns true, e.g. the ones just built.
+	Keep func(*Package) bool
+}
+
+// Eviction is a package removed by Repository.Prune, and the reason why.
+type Eviction struct {
+	Package *Package
+	// Reason is the rule of the policy that removed the package, e.g. "more than 3 versions".
+	Reason string
+}
+
+// Prune removes the packages that the policy does not keep from the repository, and returns them,
+// grouped by package and architecture, the most recent version first. It fails if the policy is invalid.
+func (r *Repository) Prune(policy RetentionPolicy) ([]Eviction, error) {
+	if policy.Versions < 0 || policy.Revisions < 0 || policy.MaxAge < 0 {
+		return nil, fmt.Errorf("invalid retention policy: negative Versions, Revisions or MaxAge")
+	}
+	if policy.MaxAge > 0 && policy.Age == nil {
+		return nil, fmt.Errorf("invalid retention policy: MaxAge requires Age")
+	}
+	pins := make([]Relation, len(policy.Pins))
+	for i, pin := range policy.Pins {
+		var err error
+		if pins[i], err = ParseRelation(pin); err != nil {
+			return nil, fmt.Errorf("invalid retention pin: %w", err)
+		}
+	}
+	pinned := func(p *Package) bool {
+		return slices.ContainsFunc(pins, func(pin Relation) bool {
+			return pin.Name == p.Metadata.Package && (pin.Arch == "" || pin.Arch == p.Metadata.Architecture) && pin.MatchesVersion(p.Metadata.Version)
+		})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Group the packages by name and architecture, the most recent version first.
+	type key struct{ name, arch string }
+	var keys []key
+	groups := make(map[key][]*Package)
+	for _, p := range r.Packages {
+		if policy.Match != nil && !policy.Match(p) {
+			continue
+		}
+		k := key{p.Metadata.Package, p.Metadata.Architecture}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], p)
+	}
+
+	var evictions []Eviction
+	removed := make(map[*Package]bool)
+	for _, k := range keys {
+		pkgs := groups[k]
+		slices.SortStableFunc(pkgs, func(a, b *Package) int {
+			return CompareVersions(b.Metadata.Version, a.Metadata.Version)
+		})
+		var upstreams []string
+		revisions := make(map[string]int)
+		for i, p := range pkgs {
+			upstream := p.UpstreamVersion()
+			if revisions[upstream] == 0 {
+				upstreams = append(upstreams, upstream)
+			}
+			revisions[upstream]++
+
+			var reason string
+			switch {
+			case i == 0 || policy.Keep != nil && policy.Keep(p) || pinned(p):
+				continue
+			case policy.Versions > 0 && len(upstreams) > policy.Versions:
+				reason = fmt.Sprintf("more than %d versions", policy.Versions)
+			case policy.Revisions > 0 && revisions[upstream] > policy.Revisions:
+				reason = fmt.Sprintf("more than %d revisions of %s", policy.Revisions, upstream)
+			case policy.MaxAge > 0 && policy.Age(p) > policy.MaxAge:
+				reason = "older than " + formatAge(policy.MaxAge)
+			default:
+				continue
+			}
+			removed[p] = true
+			evictions = append(evictions, Eviction{p, reason})
+		}
+	}
+	r.Packages = slices.DeleteFunc(r.Packages, func(p *Package) bool { return removed[p] })
+	return evictions, nil
+}
+
+// formatAge returns a duration as a number of days, e.g. "30d", if it is one, or in the time.Duration format.
+func formatAge(d time.Duration) string {
+	const day = 24 * time.Hour
+	if d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
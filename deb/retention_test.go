@@ -0,0 +1,63 @@
+package deb
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRepositoryPrune(t *testing.T) {
+	newRepo := func() *Repository {
+		var pkgs []*Package
+		for _, v := range []string{"1.0-1", "1.0-2", "1.1-1", "1.1-2", "1.1-3", "2.0-1"} {
+			pkgs = append(pkgs, &Package{Metadata: Metadata{Package: "app", Version: v, Architecture: "amd64"}})
+		}
+		pkgs = append(pkgs, &Package{Metadata: Metadata{Package: "lib", Version: "1.0-1", Architecture: "amd64"}})
+		return &Repository{Packages: pkgs}
+	}
+	// age is 10 days per version before the last one.
+	age := func(p *Package) time.Duration {
+		i := slices.Index([]string{"2.0-1", "1.1-3", "1.1-2", "1.1-1", "1.0-2", "1.0-1"}, p.Metadata.Version)
+		return time.Duration(i) * 10 * 24 * time.Hour
+	}
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+		reason string
+	}{
+		{"versions", RetentionPolicy{Versions: 2}, []string{"1.0-2", "1.0-1"}, "more than 2 versions"},
+		{"revisions", RetentionPolicy{Revisions: 1}, []string{"1.1-2", "1.1-1", "1.0-1"}, "more than 1 revisions of 1.1"},
+		{"max age", RetentionPolicy{MaxAge: 25 * 24 * time.Hour, Age: age}, []string{"1.1-1", "1.0-2", "1.0-1"}, "older than 25d"},
+		{"pins", RetentionPolicy{Versions: 1, Pins: []string{"app (= 1.1-3)", "app:amd64 (<< 1.0-2)"}}, []string{"1.1-2", "1.1-1", "1.0-2"}, "more than 1 versions"},
+		{"keep", RetentionPolicy{Versions: 1, Keep: func(p *Package) bool { return p.Metadata.Version != "1.0-1" }}, []string{"1.0-1"}, "more than 1 versions"},
+		{"match", RetentionPolicy{Versions: 1, Match: func(p *Package) bool { return p.Metadata.Package == "lib" }}, nil, ""},
+		{"none", RetentionPolicy{}, nil, ""},
+	}
+	for _, tt := range tests {
+		repo := newRepo()
+		evictions, err := repo.Prune(tt.policy)
+		if err != nil {
+			t.Fatalf("%s: Prune failed: %v", tt.name, err)
+		}
+		var got []string
+		for _, e := range evictions {
+			got = append(got, e.Package.Metadata.Version)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Prune evicted %q, want %q", tt.name, got, tt.want)
+		}
+		if len(evictions) > 0 && evictions[0].Reason != tt.reason {
+			t.Errorf("%s: Reason = %q, want %q", tt.name, evictions[0].Reason, tt.reason)
+		}
+		if len(repo.Packages) != 7-len(tt.want) {
+			t.Errorf("%s: %d packages left, want %d", tt.name, len(repo.Packages), 7-len(tt.want))
+		}
+	}
+
+	for _, policy := range []RetentionPolicy{{Versions: -1}, {MaxAge: time.Hour}, {Pins: []string{"app (= "}}} {
+		if _, err := newRepo().Prune(policy); err == nil {
+			t.Errorf("Prune(%+v) should fail", policy)
+		}
+	}
+}
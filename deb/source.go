@@ -0,0 +1,546 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source package formats.
+//
+// Reference: https://manpages.debian.org/unstable/dpkg-dev/dpkg-source.1.en.html#SOURCE_PACKAGE_FORMATS
+const (
+	sourceFormatQuilt  = "3.0 (quilt)"
+	sourceFormatNative = "3.0 (native)"
+)
+
+// SourcePackage is a Debian source package: the tarballs of the source tree and the .dsc file
+// describing them, that `dpkg-source -x` unpacks, so that the binary packages can be rebuilt
+// on the target distribution.
+//
+// A Version with a Debian revision (e.g. "1.2-1") makes a "3.0 (quilt)" package: the upstream
+// tarball (.orig.tar.gz) and the tarball of the debian directory (.debian.tar.gz). A Version
+// without revision makes a "3.0 (native)" package, with a single tarball (.tar.gz).
+//
+// Reference: https://manpages.debian.org/unstable/dpkg-dev/dsc.5.en.html
+type SourcePackage struct {
+	Metadata SourceMetadata
+
+	// Upstream are the files of the upstream source tree. Their DestPath is relative
+	// to the root of the tree, e.g. "main.go" or "cmd/app/main.go".
+	Upstream []File
+
+	// Debian are the files of the debian directory. Their DestPath is relative to it,
+	// e.g. "control", "rules" or "changelog". "source/format" is added if missing.
+	Debian []File
+
+	// BuildTime, if set, is the modification time of the tarball entries, see Package.BuildTime.
+	BuildTime time.Time
+
+	// original are the files read by NewSourcePackageFromFS, reused by Files while the package
+	// has the originalDigest.
+	original       []SourceFile
+	originalDigest string
+}
+
+// SourceMetadata maps to the fields of the .dsc file.
+//
+// Reference: https://manpages.debian.org/unstable/dpkg-dev/deb-src-control.5.en.html
+type SourceMetadata struct {
+	// Source is the name of the source package.
+	Source string
+	// Version is the version of the source package: [epoch:]upstream_version[-debian_revision].
+	Version string
+	// Maintainer is the name and email address of the maintainer: "Name <email@address.com>".
+	Maintainer string
+	// Binary are the names of the binary packages built from the source package.
+	Binary []string
+	// Architecture is the space separated list of architectures the binary packages can be built for,
+	// e.g. "any" or "all".
+	Architecture string
+	// Homepage is the URL of the upstream project's home page.
+	Homepage string
+	// StandardsVersion is the version of the Debian policy the package complies with, e.g. "4.6.2".
+	StandardsVersion string
+	// BuildDepends lists the packages needed to build the binary packages, e.g. "golang-go (>= 2:1.22)".
+	BuildDepends []string
+	// ExtraFields are the other fields of the .dsc file, e.g. "Vcs-Git".
+	ExtraFields map[string]string
+}
+
+// SourceFile is a file of a source package.
+type SourceFile struct {
+	// Name is the file name, e.g. "hello_1.0-1.dsc".
+	Name    string
+	Content []byte
+}
+
+// sourceField is a field of a .dsc file, or of a stanza of a Sources index.
+type sourceField struct {
+	name, value string
+}
+
+// IsNative reports whether the package is a native package, i.e. without Debian revision.
+func (s *SourcePackage) IsNative() bool {
+	_, _, revision := splitDebianVersion(s.Metadata.Version)
+	return revision == ""
+}
+
+// Format returns the format of the source package, "3.0 (quilt)" or "3.0 (native)".
+func (s *SourcePackage) Format() string {
+	if s.IsNative() {
+		return sourceFormatNative
+	}
+	return sourceFormatQuilt
+}
+
+// DscFilename returns the name of the .dsc file.
+// Format: {Source}_{Version}.dsc, without the epoch of the version.
+func (s *SourcePackage) DscFilename() string {
+	return s.filename(".dsc")
+}
+
+// filename returns the name of a file of the package: the source name, the version
+// without epoch and ext.
+func (s *SourcePackage) filename(ext string) string {
+	_, upstream, revision := splitDebianVersion(s.Metadata.Version)
+	if revision != "" {
+		upstream += "-" + revision
+	}
+	return s.Metadata.Source + "_" + upstream + ext
+}
+
+// Files returns the files of the source package: the tarballs, then the .dsc file.
+// The files of a package read by NewSourcePackageFromFS are returned as read while the package
+// is unchanged, so that their checksums are stable.
+func (s *SourcePackage) Files() ([]SourceFile, error) {
+	if s.original != nil {
+		digest, err := s.digest()
+		if err != nil {
+			return nil, err
+		}
+		if digest == s.originalDigest {
+			return s.original, nil
+		}
+	}
+	if s.Metadata.Source == "" || s.Metadata.Version == "" {
+		return nil, fmt.Errorf("a source package needs a Source and a Version")
+	}
+	clk, err := newClock(s.BuildTime)
+	if err != nil {
+		return nil, err
+	}
+	debian := s.debianFiles()
+	_, upstream, _ := splitDebianVersion(s.Metadata.Version)
+	root := s.Metadata.Source + "-" + upstream + "/"
+
+	var files []SourceFile
+	if s.IsNative() {
+		content, err := sourceTarball(clk, []sourceTree{{root, s.Upstream}, {root + "debian/", debian}})
+		if err != nil {
+			return nil, fmt.Errorf("building %s: %w", s.filename(".tar.gz"), err)
+		}
+		files = append(files, SourceFile{s.filename(".tar.gz"), content})
+	} else {
+		orig := s.Metadata.Source + "_" + upstream + ".orig.tar.gz"
+		content, err := sourceTarball(clk, []sourceTree{{root, s.Upstream}})
+		if err != nil {
+			return nil, fmt.Errorf("building %s: %w", orig, err)
+		}
+		files = append(files, SourceFile{orig, content})
+
+		name := s.filename(".debian.tar.gz")
+		if content, err = sourceTarball(clk, []sourceTree{{"debian/", debian}}); err != nil {
+			return nil, fmt.Errorf("building %s: %w", name, err)
+		}
+		files = append(files, SourceFile{name, content})
+	}
+	return append(files, SourceFile{s.DscFilename(), s.generateDsc(files)}), nil
+}
+
+// debianFiles returns the files of the debian directory, with debian/source/format.
+func (s *SourcePackage) debianFiles() []File {
+	for _, f := range s.Debian {
+		if f.DestPath == "source/format" {
+			return s.Debian
+		}
+	}
+	return append(slices.Clone(s.Debian), File{DestPath: "source/format", Mode: 0644, Body: s.Format() + "\n"})
+}
+
+// fields returns the fields of the .dsc file, without the lists of files.
+func (s *SourcePackage) fields() []sourceField {
+	m := s.Metadata
+	fields := []sourceField{
+		{"Format", s.Format()},
+		{"Source", m.Source},
+		{"Binary", strings.Join(m.Binary, ", ")},
+		{"Architecture", m.Architecture},
+		{"Version", m.Version},
+		{"Maintainer", m.Maintainer},
+		{"Homepage", m.Homepage},
+		{"Standards-Version", m.StandardsVersion},
+		{"Build-Depends", strings.Join(m.BuildDepends, ", ")},
+	}
+	for _, name := range slices.Sorted(maps.Keys(m.ExtraFields)) {
+		fields = append(fields, sourceField{name, m.ExtraFields[name]})
+	}
+	return fields
+}
+
+// generateDsc returns the content of the .dsc file listing files.
+func (s *SourcePackage) generateDsc(files []SourceFile) []byte {
+	var b bytes.Buffer
+	for _, f := range s.fields() {
+		if f.value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", f.name, f.value)
+		}
+	}
+	writeFileList(&b, checksumsField(SHA256), files, SHA256.sum)
+	writeFileList(&b, "Files", files, md5Sum)
+	return b.Bytes()
+}
+
+// writeFileList writes the field name listing the checksum, size and name of the files.
+func writeFileList(b *bytes.Buffer, name string, files []SourceFile, sum func([]byte) string) {
+	fmt.Fprintf(b, "%s:\n", name)
+	for _, f := range files {
+		fmt.Fprintf(b, " %s %d %s\n", sum(f.Content), len(f.Content), f.Name)
+	}
+}
+
+// md5Sum returns the hex encoded MD5 checksum of content.
+func md5Sum(content []byte) string {
+	sum := md5.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumsField returns the name of the field listing the checksums of the files of a source package, e.g. "Checksums-Sha256".
+func checksumsField(h Hash) string {
+	return "Checksums-" + h.Name[:1] + strings.ToLower(h.Name[1:])
+}
+
+// digest returns a digest of the metadata and of the files of the package.
+func (s *SourcePackage) digest() (string, error) {
+	h := sha256.New()
+	for _, f := range s.fields() {
+		fmt.Fprintf(h, "%s: %s\n", f.name, f.value)
+	}
+	for _, tree := range [][]File{s.Upstream, s.Debian} {
+		fmt.Fprintf(h, "tree\n")
+		for _, f := range tree {
+			content, err := f.contentDigest()
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s %o %t %s %s\n", f.DestPath, f.Mode, f.IsDir, f.LinkTarget, content)
+		}
+	}
+	fmt.Fprintf(h, "%d\n", s.BuildTime.Unix())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sourceTree is a tree of files of a tarball, under a prefix, e.g. "hello-1.0/".
+type sourceTree struct {
+	prefix string
+	files  []File
+}
+
+// sourceTarball returns the tar.gz archive of the trees.
+func sourceTarball(clk clock, trees []sourceTree) ([]byte, error) {
+	var buf bytes.Buffer
+	gw, err := CompressionGzip.newWriter(&buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(gw)
+	for _, tree := range trees {
+		for _, f := range tree.files {
+			name := tree.prefix + strings.TrimPrefix(path.Clean("/"+f.DestPath), "/")
+			header := &tar.Header{Name: name, Mode: f.Mode, ModTime: clk.modTime(f.ModTime)}
+			setOwner(header, "", "")
+			switch {
+			case f.LinkTarget != "":
+				header.Typeflag, header.Linkname, header.Mode = tar.TypeSymlink, f.LinkTarget, 0777
+			case f.IsDir:
+				header.Typeflag, header.Name, header.Mode = tar.TypeDir, strings.TrimSuffix(name, "/")+"/", f.dirMode()
+			default:
+				header.Typeflag, header.Size = tar.TypeReg, f.Size()
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return nil, err
+			}
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("opening %s: %w", f.DestPath, err)
+			}
+			_, err = io.Copy(tw, r)
+			r.Close()
+			if err != nil {
+				return nil, fmt.Errorf("writing %s: %w", f.DestPath, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// NewSourcePackageFromFS reads the source package described by the .dsc file name of fsys, and the
+// files it lists, in the same directory. The .dsc file can be clearsigned, the signature is not checked.
+// The "3.0 (quilt)" and "3.0 (native)" formats are supported, with gzip, xz or zstd tarballs.
+func NewSourcePackageFromFS(fsys fs.FS, name string) (*SourcePackage, error) {
+	return readSourcePackage(name, func(name string) ([]byte, error) { return fs.ReadFile(fsys, name) })
+}
+
+// readSourcePackage reads the source package of the .dsc file name, reading the files with read.
+func readSourcePackage(name string, read func(name string) ([]byte, error)) (*SourcePackage, error) {
+	dsc, err := read(name)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := parseSourceFields(stripSignature(dsc))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	s := &SourcePackage{Metadata: SourceMetadata{ExtraFields: make(map[string]string)}}
+	var format string
+	var list []sourceListEntry
+	for _, f := range fields {
+		switch f.name {
+		case "Format":
+			format = f.value
+		case "Source":
+			s.Metadata.Source = f.value
+		case "Binary":
+			s.Metadata.Binary = splitList(f.value)
+		case "Architecture":
+			s.Metadata.Architecture = f.value
+		case "Version":
+			s.Metadata.Version = f.value
+		case "Maintainer":
+			s.Metadata.Maintainer = f.value
+		case "Homepage":
+			s.Metadata.Homepage = f.value
+		case "Standards-Version":
+			s.Metadata.StandardsVersion = f.value
+		case "Build-Depends":
+			s.Metadata.BuildDepends = splitList(f.value)
+		case "Files":
+			if list, err = parseSourceList(f.value); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", name, err)
+			}
+		case "Checksums-Sha1", "Checksums-Sha256", "Checksums-Sha512":
+			// Checked with the MD5 sums of Files.
+		default:
+			s.Metadata.ExtraFields[f.name] = f.value
+		}
+	}
+	if format != s.Format() {
+		return nil, fmt.Errorf("%s: unsupported format %q for version %q", name, format, s.Metadata.Version)
+	}
+
+	dir := path.Dir(name)
+	for _, e := range list {
+		content, err := read(path.Join(dir, e.name))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(content)) != e.size || md5Sum(content) != e.sum {
+			return nil, fmt.Errorf("%s: checksum mismatch for %s", name, e.name)
+		}
+		s.original = append(s.original, SourceFile{e.name, content})
+		if err := s.readTarball(e.name, content); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", e.name, err)
+		}
+	}
+	s.original = append(s.original, SourceFile{path.Base(name), dsc})
+	if s.originalDigest, err = s.digest(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// readTarball reads the files of a tarball of the package into Upstream and Debian.
+func (s *SourcePackage) readTarball(name string, content []byte) error {
+	debianOnly := strings.Contains(name, ".debian.tar.")
+	if !debianOnly && !strings.Contains(name, ".tar.") {
+		return fmt.Errorf("unsupported source file")
+	}
+	r, release, err := decompress(name, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	defer release()
+	b := newBudget(DefaultLimits)
+	tr := tar.NewReader(b.reader(name, r))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := b.member(h.Name, h.Size); err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(path.Clean("/"+h.Name), "/")
+		if !debianOnly {
+			// The upstream tree is in a top level directory, whatever its name.
+			_, rel, _ = strings.Cut(rel, "/")
+		}
+		tree := &s.Upstream
+		// The debian directory of a native package is in its single tarball.
+		if debianOnly || s.IsNative() && (rel == "debian" || strings.HasPrefix(rel, "debian/")) {
+			tree = &s.Debian
+			rel = strings.TrimPrefix(strings.TrimPrefix(rel, "debian"), "/")
+		}
+		if rel == "" {
+			continue
+		}
+		f := File{DestPath: rel, Mode: h.Mode, ModTime: h.ModTime}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			f.IsDir = true
+		case tar.TypeSymlink:
+			f.LinkTarget, f.Mode = h.Linkname, 0
+		case tar.TypeReg:
+			body, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			f.Body = string(body)
+		default:
+			continue
+		}
+		*tree = append(*tree, f)
+	}
+}
+
+// stripSignature returns the message of a clearsigned content, or the content itself.
+func stripSignature(content []byte) []byte {
+	const begin = "-----BEGIN PGP SIGNED MESSAGE-----"
+	if !bytes.HasPrefix(content, []byte(begin)) {
+		return content
+	}
+	// The armor headers end at the first empty line.
+	_, msg, _ := bytes.Cut(content, []byte("\n\n"))
+	msg, _, _ = bytes.Cut(msg, []byte("-----BEGIN PGP SIGNATURE-----"))
+	return msg
+}
+
+// parseSourceFields returns the fields of the first stanza of content, in order.
+func parseSourceFields(content []byte) ([]sourceField, error) {
+	s := newIndexScanner(bytes.NewReader(content), DefaultLimits)
+	if !s.next() {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, fmt.Errorf("no fields")
+	}
+	var fields []sourceField
+	for _, f := range s.fields {
+		fields = append(fields, sourceField{string(s.buf[f.key:f.start]), strings.TrimSpace(string(s.buf[f.start:f.end]))})
+	}
+	return fields, nil
+}
+
+// sourceListEntry is an entry of a list of files of a source package: the checksum, size and name of a file.
+type sourceListEntry struct {
+	sum  string
+	size int64
+	name string
+}
+
+// parseSourceList parses a list of files, e.g. the Files field.
+func parseSourceList(value string) ([]sourceListEntry, error) {
+	var list []sourceListEntry
+	for line := range strings.SplitSeq(value, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) != 3 || strings.Contains(parts[2], "/") {
+			return nil, fmt.Errorf("invalid file entry %q", line)
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file entry %q", line)
+		}
+		list = append(list, sourceListEntry{parts[0], size, parts[2]})
+	}
+	return list, nil
+}
+
+// repoSource is a source package as listed in a Sources index.
+type repoSource struct {
+	// Fields are the fields of the .dsc file, without the lists of files.
+	Fields []sourceField
+	// Directory is the directory of the files, relative to the repository root.
+	Directory string
+	// Files are the files of the package, the .dsc file last.
+	Files []SourceFile
+}
+
+// newRepoSource returns the index entry of a source package, whose files are in directory.
+func newRepoSource(src *SourcePackage, directory string) (*repoSource, error) {
+	files, err := src.Files()
+	if err != nil {
+		return nil, fmt.Errorf("building source package %s: %w", src.Metadata.Source, err)
+	}
+	dsc := files[len(files)-1]
+	fields, err := parseSourceFields(stripSignature(dsc.Content))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", dsc.Name, err)
+	}
+	fields = slices.DeleteFunc(fields, func(f sourceField) bool {
+		return f.name == "Files" || strings.HasPrefix(f.name, "Checksums-")
+	})
+	return &repoSource{Fields: fields, Directory: directory, Files: files}, nil
+}
+
+// generateSourcesFile generates the content of a Sources index. The .dsc Source field is the Package field.
+//
+// Reference: https://wiki.debian.org/DebianRepository/Format#A.22Sources.22_Indices
+func generateSourcesFile(index []*repoSource, hashes []Hash) []byte {
+	var b bytes.Buffer
+	for _, s := range index {
+		for _, f := range s.Fields {
+			name := f.name
+			if name == "Source" {
+				name = "Package"
+			}
+			fmt.Fprintf(&b, "%s: %s\n", name, f.value)
+		}
+		fmt.Fprintf(&b, "Directory: %s\n", s.Directory)
+		writeFileList(&b, "Files", s.Files, md5Sum)
+		for _, h := range hashes {
+			if h.Name == MD5.Name {
+				// The Files field already lists the MD5 checksums.
+				continue
+			}
+			writeFileList(&b, checksumsField(h), s.Files, h.sum)
+		}
+		b.WriteString("\n")
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,200 @@
+package deb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testSourcePackage returns a source package of version.
+func testSourcePackage(version string) *SourcePackage {
+	return &SourcePackage{
+		Metadata: SourceMetadata{
+			Source:       "hello",
+			Version:      version,
+			Maintainer:   "Jane Doe <jane@example.com>",
+			Binary:       []string{"hello"},
+			Architecture: "any",
+			BuildDepends: []string{"debhelper-compat (= 13)", "golang-go"},
+			ExtraFields:  map[string]string{"Vcs-Git": "https://example.com/hello.git"},
+		},
+		Upstream: []File{
+			{DestPath: "main.go", Mode: 0644, Body: "package main\n"},
+			{DestPath: "debian/upstream-file", Mode: 0644, Body: "not packaging\n"},
+		},
+		Debian: []File{
+			{DestPath: "control", Mode: 0644, Body: "Source: hello\n"},
+			{DestPath: "rules", Mode: 0755, Body: "#!/usr/bin/make -f\n"},
+		},
+		BuildTime: time.Unix(1700000000, 0),
+	}
+}
+
+func TestSourcePackageRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		names   []string
+		format  string
+	}{
+		{"1:1.0-1", []string{"hello_1.0.orig.tar.gz", "hello_1.0-1.debian.tar.gz", "hello_1.0-1.dsc"}, "3.0 (quilt)"},
+		{"1.0", []string{"hello_1.0.tar.gz", "hello_1.0.dsc"}, "3.0 (native)"},
+	} {
+		src := testSourcePackage(tc.version)
+		files, err := src.Files()
+		if err != nil {
+			t.Fatalf("%s: Files failed: %v", tc.version, err)
+		}
+		fsys := fstest.MapFS{}
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+			fsys["src/"+f.Name] = &fstest.MapFile{Data: f.Content}
+		}
+		if !slices.Equal(names, tc.names) {
+			t.Errorf("%s: files = %q, want %q", tc.version, names, tc.names)
+		}
+		dsc := string(files[len(files)-1].Content)
+		for _, want := range []string{"Format: " + tc.format + "\n", "Version: " + tc.version + "\n", "Build-Depends: debhelper-compat (= 13), golang-go\n", "Vcs-Git: ", "Checksums-Sha256:\n", "Files:\n"} {
+			if !strings.Contains(dsc, want) {
+				t.Errorf("%s: the .dsc file has no %q:\n%s", tc.version, want, dsc)
+			}
+		}
+
+		loaded, err := NewSourcePackageFromFS(fsys, "src/"+src.DscFilename())
+		if err != nil {
+			t.Fatalf("%s: NewSourcePackageFromFS failed: %v", tc.version, err)
+		}
+		if loaded.Metadata.Source != "hello" || loaded.Metadata.Maintainer != src.Metadata.Maintainer || !slices.Equal(loaded.Metadata.BuildDepends, src.Metadata.BuildDepends) {
+			t.Errorf("%s: Metadata = %+v", tc.version, loaded.Metadata)
+		}
+		var upstream, debian []string
+		for _, f := range loaded.Upstream {
+			upstream = append(upstream, f.DestPath)
+		}
+		for _, f := range loaded.Debian {
+			debian = append(debian, f.DestPath)
+		}
+		if want := []string{"main.go", "debian/upstream-file"}; tc.format == "3.0 (quilt)" && !slices.Equal(upstream, want) {
+			t.Errorf("%s: Upstream = %q, want %q", tc.version, upstream, want)
+		}
+		if !slices.Contains(debian, "rules") || !slices.Contains(debian, "source/format") {
+			t.Errorf("%s: Debian = %q, want rules and source/format", tc.version, debian)
+		}
+
+		reloaded, err := loaded.Files()
+		if err != nil || !slices.EqualFunc(reloaded, files, func(a, b SourceFile) bool { return a.Name == b.Name && bytes.Equal(a.Content, b.Content) }) {
+			t.Errorf("%s: the files of an unchanged package should be the original ones (%v)", tc.version, err)
+		}
+		loaded.Metadata.Version = strings.Replace(tc.version, "1.0", "1.1", 1)
+		if changed, err := loaded.Files(); err != nil || changed[len(changed)-1].Name == src.DscFilename() {
+			t.Errorf("%s: the files of a changed package should be regenerated (%v)", tc.version, err)
+		}
+	}
+}
+
+func TestSourcePackageChecksumMismatch(t *testing.T) {
+	files, err := testSourcePackage("1.0-1").Files()
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	fsys := fstest.MapFS{}
+	for _, f := range files {
+		fsys[f.Name] = &fstest.MapFile{Data: f.Content}
+	}
+	fsys["hello_1.0.orig.tar.gz"] = &fstest.MapFile{Data: []byte("corrupted")}
+	if _, err := NewSourcePackageFromFS(fsys, "hello_1.0-1.dsc"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("NewSourcePackageFromFS = %v, want a checksum mismatch", err)
+	}
+}
+
+func TestRepositorySources(t *testing.T) {
+	dir := t.TempDir()
+	repo := &Repository{Sources: []*SourcePackage{testSourcePackage("1.0-1")}}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	sources, err := os.ReadFile(filepath.Join(dir, "Sources"))
+	if err != nil {
+		t.Fatalf("reading Sources: %v", err)
+	}
+	for _, want := range []string{"Package: hello\n", "Directory: .\n", "Files:\n", "Checksums-Sha256:\n", " hello_1.0-1.dsc\n"} {
+		if !strings.Contains(string(sources), want) {
+			t.Errorf("the Sources index has no %q:\n%s", want, sources)
+		}
+	}
+	release, err := os.ReadFile(filepath.Join(dir, "Release"))
+	if err != nil || !strings.Contains(string(release), " Sources.gz\n") {
+		t.Errorf("the Release file should list Sources.gz (%v):\n%s", err, release)
+	}
+
+	loaded, err := NewRepositoryFromDir(dir)
+	if err != nil {
+		t.Fatalf("NewRepositoryFromDir failed: %v", err)
+	}
+	if len(loaded.Sources) != 1 || loaded.Sources[0].Metadata.Version != "1.0-1" {
+		t.Fatalf("Sources = %+v, want the hello source package", loaded.Sources)
+	}
+
+	var buf bytes.Buffer
+	if _, err := loaded.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	archived, err := NewRepository(&buf)
+	if err != nil {
+		t.Fatalf("NewRepository failed: %v", err)
+	}
+	if len(archived.Sources) != 1 {
+		t.Errorf("Sources = %+v, want the hello source package", archived.Sources)
+	}
+}
+
+func TestStandardRepositorySources(t *testing.T) {
+	dir := t.TempDir()
+	src := testSourcePackage("1.0-1")
+	bin := &Package{Metadata: Metadata{Package: "hello", Version: "1.0-1", Architecture: "amd64"}}
+	repo := &StandardRepository{
+		ArchiveInfo: ArchiveInfo{Codename: "stable"},
+		Parts: []*Repository{
+			{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "amd64"}, Packages: []*Package{bin}, Sources: []*SourcePackage{src}},
+			{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "arm64"}, Sources: []*SourcePackage{src}},
+		},
+	}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	sources, err := os.ReadFile(filepath.Join(dir, "dists/stable/main/source/Sources"))
+	if err != nil {
+		t.Fatalf("reading Sources: %v", err)
+	}
+	if n := strings.Count(string(sources), "Package: hello\n"); n != 1 {
+		t.Errorf("the Sources index lists hello %d times, want once:\n%s", n, sources)
+	}
+	if !strings.Contains(string(sources), "Directory: pool/main/hello\n") {
+		t.Errorf("the Sources index has no pool directory:\n%s", sources)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pool/main/hello/hello_1.0.orig.tar.gz")); err != nil {
+		t.Errorf("missing the upstream tarball: %v", err)
+	}
+
+	loaded, err := NewStandardRepositoryFromDir(dir, "stable")
+	if err != nil {
+		t.Fatalf("NewStandardRepositoryFromDir failed: %v", err)
+	}
+	if len(loaded.Parts) == 0 || len(loaded.Parts[0].Sources) != 1 {
+		t.Fatalf("Parts = %+v, want the source package in the first part", loaded.Parts)
+	}
+	ops, err := loaded.WriteToDir(dir)
+	if err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	for _, op := range ops {
+		if op.Changed() {
+			t.Errorf("%s changed, want the unchanged repository to be written as is", op.Path)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package deb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Content is the content of a payload file that is not held in memory, see File.Content.
+type Content interface {
+	// Open returns a new reader of the whole content.
+	Open() (io.ReadCloser, error)
+	// Size returns the length of the content, in bytes.
+	Size() int64
+}
+
+// BlobStore stores the payload files of parsed packages out of memory, see ReadOptions.BlobStore,
+// e.g. in an object storage. Spool is a BlobStore in a temporary file.
+type BlobStore interface {
+	// Put stores the content read from r, and returns it.
+	Put(r io.Reader) (Content, error)
+}
+
+// Spool is a temporary file storing the payload of parsed packages, so that huge packages
+// can be read, modified and written again without holding their files in memory.
+// The files of the packages parsed with a Spool are only valid until it is closed.
+// It is safe for concurrent use.
+type Spool struct {
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewSpool creates a Spool in a new temporary file of dir, or of the default temporary directory if dir is "".
+func NewSpool(dir string) (*Spool, error) {
+	f, err := os.CreateTemp(dir, "deb-spool-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating spool: %w", err)
+	}
+	return &Spool{file: f}, nil
+}
+
+// Close removes the temporary file of the spool.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.file.Close()
+	if rerr := os.Remove(s.file.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// Put appends the content of r to the spool, and returns it.
+func (s *Spool) Put(r io.Reader) (Content, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := io.Copy(io.NewOffsetWriter(s.file, s.size), r)
+	if err != nil {
+		return nil, fmt.Errorf("writing spool: %w", err)
+	}
+	c := spooled{file: s.file, offset: s.size, size: n}
+	s.size += n
+	return c, nil
+}
+
+// spooled is a region of a spool file.
+type spooled struct {
+	file         *os.File
+	offset, size int64
+}
+
+func (c spooled) Open() (io.ReadCloser, error) {
+	return io.NopCloser(io.NewSectionReader(c.file, c.offset, c.size)), nil
+}
+
+func (c spooled) Size() int64 { return c.size }
+
+// Size returns the size of the file content, from Content, or Body.
+func (f File) Size() int64 {
+	if f.Content != nil {
+		return f.Content.Size()
+	}
+	return int64(len(f.Body))
+}
+
+// Open returns a reader of the file content, from Content, or Body.
+func (f File) Open() (io.ReadCloser, error) {
+	if f.Content != nil {
+		return f.Content.Open()
+	}
+	return io.NopCloser(strings.NewReader(f.Body)), nil
+}
+
+// ReadBody returns the file content, reading Content in memory if it is set.
+func (f File) ReadBody() (string, error) {
+	if f.Content == nil {
+		return f.Body, nil
+	}
+	r, err := f.Content.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	var b strings.Builder
+	if _, err := io.Copy(&b, r); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// contentDigest returns the hex encoded SHA256 checksum of the file content.
+func (f File) contentDigest() (string, error) {
+	r, err := f.Open()
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", f.DestPath, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("reading %s: %w", f.DestPath, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,131 @@
+package deb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SystemdUnitDir is the directory where packages install their systemd units.
+const SystemdUnitDir = "/lib/systemd/system"
+
+// SystemdService is a systemd service shipped by a package, see Package.AddSystemdService.
+type SystemdService struct {
+	// UnitName is the unit file name, e.g. "app.service".
+	UnitName string
+	// UnitContent is the content of the unit file.
+	UnitContent string
+	// EnableOnInstall enables the service when the package is installed.
+	EnableOnInstall bool
+	// StartOnInstall starts the service when the package is installed.
+	StartOnInstall bool
+	// RestartOnUpgrade restarts the service after an upgrade, if StartOnInstall is set.
+	// Otherwise the service keeps running the old binary until it is restarted.
+	RestartOnUpgrade bool
+}
+
+// AddSystemdService installs the unit file of s in SystemdUnitDir, and extends the maintainer scripts
+// with the snippets that enable, start, stop and clean up the service, the same way debhelper's
+// dh_installsystemd does, with deb-systemd-helper and deb-systemd-invoke. See AppendSnippet.
+//
+// Reference: https://manpages.debian.org/unstable/debhelper/dh_installsystemd.1.en.html
+func (p *Package) AddSystemdService(s SystemdService) error {
+	name := s.UnitName
+	if name == "" || strings.ContainsAny(name, "/'") {
+		return fmt.Errorf("invalid service name %q", name)
+	}
+	if s.UnitContent == "" {
+		return fmt.Errorf("service %s has no unit content", name)
+	}
+	p.Files = append(p.Files, File{
+		DestPath: SystemdUnitDir + "/" + name,
+		Mode:     0644,
+		Body:     s.UnitContent,
+	})
+
+	var postinst strings.Builder
+	postinst.WriteString(`if [ "$1" = "configure" ] || [ "$1" = "abort-upgrade" ] || [ "$1" = "abort-deconfigure" ] || [ "$1" = "abort-remove" ] ; then
+`)
+	if s.EnableOnInstall {
+		fmt.Fprintf(&postinst, `	# This will only remove masks created by d-s-h on package removal.
+	deb-systemd-helper unmask '%[1]s' >/dev/null || true
+
+	# was-enabled defaults to true, so new installations run enable.
+	if deb-systemd-helper --quiet was-enabled '%[1]s'; then
+		# Enables the unit on first installation, creates new
+		# symlinks on upgrades if the unit file has changed.
+		deb-systemd-helper enable '%[1]s' >/dev/null || true
+	else
+		# Update the statefile to add new symlinks (if any), which need to be
+		# cleaned up on purge. Also remove old symlinks.
+		deb-systemd-helper update-state '%[1]s' >/dev/null || true
+	fi
+`, name)
+	} else {
+		fmt.Fprintf(&postinst, "\tdeb-systemd-helper update-state '%s' >/dev/null || true\n", name)
+	}
+	postinst.WriteString("fi\n")
+	if s.StartOnInstall {
+		action := `		if [ -n "$2" ]; then
+			_dh_action=restart
+		else
+			_dh_action=start
+		fi
+		deb-systemd-invoke $_dh_action '%[1]s' >/dev/null || true
+`
+		if !s.RestartOnUpgrade {
+			action = `		if [ -z "$2" ]; then
+			deb-systemd-invoke start '%[1]s' >/dev/null || true
+		fi
+`
+		}
+		fmt.Fprintf(&postinst, `if [ "$1" = "configure" ] || [ "$1" = "abort-upgrade" ] || [ "$1" = "abort-deconfigure" ] || [ "$1" = "abort-remove" ] ; then
+	if [ -d /run/systemd/system ]; then
+		systemctl --system daemon-reload >/dev/null || true
+`+action+`	fi
+fi
+`, name)
+	}
+
+	prerm := fmt.Sprintf(`if [ -d /run/systemd/system ] && [ "$1" = remove ]; then
+	deb-systemd-invoke stop '%s' >/dev/null || true
+fi
+`, name)
+
+	postrm := fmt.Sprintf(`if [ -d /run/systemd/system ]; then
+	systemctl --system daemon-reload >/dev/null || true
+fi
+if [ "$1" = "remove" ]; then
+	if [ -x "/usr/bin/deb-systemd-helper" ]; then
+		deb-systemd-helper mask '%[1]s' >/dev/null || true
+	fi
+fi
+if [ "$1" = "purge" ]; then
+	if [ -x "/usr/bin/deb-systemd-helper" ]; then
+		deb-systemd-helper purge '%[1]s' >/dev/null || true
+		deb-systemd-helper unmask '%[1]s' >/dev/null || true
+	fi
+fi
+`, name)
+
+	p.Scripts.PostInst = AppendSnippet(p.Scripts.PostInst, postinst.String())
+	p.Scripts.PreRm = AppendSnippet(p.Scripts.PreRm, prerm)
+	p.Scripts.PostRm = AppendSnippet(p.Scripts.PostRm, postrm)
+	return nil
+}
+
+// AppendSnippet adds a generated snippet to a maintainer script.
+// If the script has a "#DEBHELPER#" token, the snippet is inserted before it, where debhelper
+// would put it. The token, a shell comment, is kept so that the next snippets are inserted there
+// too, after this one. Otherwise the snippet is appended. An empty script gets a shell header.
+func AppendSnippet(script, snippet string) string {
+	if script == "" {
+		return "#!/bin/sh\nset -e\n\n" + snippet
+	}
+	if strings.Contains(script, "#DEBHELPER#") {
+		return strings.Replace(script, "#DEBHELPER#", snippet+"#DEBHELPER#", 1)
+	}
+	if !strings.HasSuffix(script, "\n") {
+		script += "\n"
+	}
+	return script + snippet
+}
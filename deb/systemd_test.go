@@ -0,0 +1,54 @@
+package deb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddSystemdService(t *testing.T) {
+	pkg := &Package{Scripts: Scripts{PostInst: "#!/bin/sh\nset -e\n#DEBHELPER#\necho done\n"}}
+	err := pkg.AddSystemdService(SystemdService{
+		UnitName:         "app.service",
+		UnitContent:      "[Service]\nExecStart=/usr/bin/app\n",
+		EnableOnInstall:  true,
+		StartOnInstall:   true,
+		RestartOnUpgrade: true,
+	})
+	if err != nil {
+		t.Fatalf("AddSystemdService failed: %v", err)
+	}
+	if len(pkg.Files) != 1 || pkg.Files[0].DestPath != "/lib/systemd/system/app.service" {
+		t.Errorf("Files = %+v, want the unit file", pkg.Files)
+	}
+	postinst := pkg.Scripts.PostInst
+	for _, want := range []string{"deb-systemd-helper enable 'app.service'", "_dh_action=restart", "#DEBHELPER#\necho done\n"} {
+		if !strings.Contains(postinst, want) {
+			t.Errorf("postinst has no %q:\n%s", want, postinst)
+		}
+	}
+	// The snippet is inserted before the token, which is kept for the next snippets.
+	if !strings.HasPrefix(postinst, "#!/bin/sh\nset -e\n") || strings.Index(postinst, "deb-systemd-helper") > strings.Index(postinst, "#DEBHELPER#") {
+		t.Errorf("the snippet is not inserted before #DEBHELPER#:\n%s", postinst)
+	}
+	postinst = AppendSnippet(postinst, "echo next\n")
+	if !strings.HasSuffix(postinst, "echo next\n#DEBHELPER#\necho done\n") || strings.Index(postinst, "echo next") < strings.Index(postinst, "deb-systemd-helper") {
+		t.Errorf("the next snippet is not inserted after the first one, before #DEBHELPER#:\n%s", postinst)
+	}
+	if !strings.HasPrefix(pkg.Scripts.PreRm, "#!/bin/sh\n") || !strings.Contains(pkg.Scripts.PreRm, "deb-systemd-invoke stop 'app.service'") {
+		t.Errorf("prerm should stop the service:\n%s", pkg.Scripts.PreRm)
+	}
+	if !strings.Contains(pkg.Scripts.PostRm, "deb-systemd-helper purge 'app.service'") {
+		t.Errorf("postrm should purge the service:\n%s", pkg.Scripts.PostRm)
+	}
+
+	pkg = &Package{}
+	if err := pkg.AddSystemdService(SystemdService{UnitName: "app.service", UnitContent: "[Service]\n"}); err != nil {
+		t.Fatalf("AddSystemdService failed: %v", err)
+	}
+	if strings.Contains(pkg.Scripts.PostInst, "deb-systemd-invoke") || strings.Contains(pkg.Scripts.PostInst, "deb-systemd-helper enable") {
+		t.Errorf("a service neither enabled nor started should only update its state:\n%s", pkg.Scripts.PostInst)
+	}
+	if err := pkg.AddSystemdService(SystemdService{UnitName: "../app.service", UnitContent: "[Service]\n"}); err == nil {
+		t.Error("AddSystemdService should reject an invalid unit name")
+	}
+}
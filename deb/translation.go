@@ -0,0 +1,88 @@
+package deb
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// The Translation index of the long descriptions in English.
+//
+// Reference: https://wiki.debian.org/DebianRepository/Format#Translation_indices
+const (
+	// TranslationEn is the path of the English Translation index of a component, relative to its directory.
+	TranslationEn = "i18n/Translation-en"
+	// fieldDescriptionMD5 is the checksum of the long description in the Packages and Translation indices.
+	fieldDescriptionMD5 = "Description-md5"
+)
+
+// description returns the raw value of the Description field of a control file, with its continuation
+// lines, e.g. "app\n A long description.\n", and whether the control file has one.
+func description(control string) (string, bool) {
+	for _, f := range splitControlFields(control) {
+		if f.name == string(FieldDescription) {
+			return strings.TrimSpace(strings.TrimPrefix(f.text, f.name+":")) + "\n", true
+		}
+	}
+	return "", false
+}
+
+// descriptionMD5 returns the Description-md5 of a raw description: the hex encoded MD5 checksum
+// of the description, short and long, as APT computes it.
+func descriptionMD5(desc string) string {
+	sum := md5.Sum([]byte(desc))
+	return hex.EncodeToString(sum[:])
+}
+
+// indexControl returns the control file of a package as listed in a Packages index: with the
+// Description-md5 of its description, and without its long description if translated is true,
+// since the Translation index lists it.
+func indexControl(control string, translated bool) string {
+	desc, ok := description(control)
+	if !ok {
+		return control
+	}
+	fields := splitControlFields(control)
+	for _, f := range fields {
+		if f.name == fieldDescriptionMD5 {
+			return control
+		}
+	}
+	var b strings.Builder
+	for _, f := range fields {
+		if f.name != string(FieldDescription) {
+			b.WriteString(f.text)
+			continue
+		}
+		if translated {
+			short, _, _ := strings.Cut(desc, "\n")
+			fmt.Fprintf(&b, "%s: %s\n", FieldDescription, short)
+		} else {
+			b.WriteString(f.text)
+		}
+		fmt.Fprintf(&b, "%s: %s\n", fieldDescriptionMD5, descriptionMD5(desc))
+	}
+	return b.String()
+}
+
+// generateTranslationFile generates the content of the Translation-en index of the packages in index:
+// one stanza per package and description, with the Description-md5 that the Packages index lists.
+func generateTranslationFile(index []*repoPackage) []byte {
+	var b bytes.Buffer
+	seen := make(map[string]bool)
+	for _, p := range index {
+		desc, ok := description(p.Control)
+		if !ok {
+			continue
+		}
+		sum := descriptionMD5(desc)
+		if seen[p.Package+" "+sum] {
+			continue
+		}
+		seen[p.Package+" "+sum] = true
+		fmt.Fprintf(&b, "%s: %s\n%s: %s\n%s-en: %s\n", FieldPackage, p.Package, fieldDescriptionMD5, sum, FieldDescription, desc)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,71 @@
+package deb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTranslations(t *testing.T) {
+	dir := t.TempDir()
+	app := &Package{Metadata: Metadata{Package: "app", Version: "1.0", Architecture: "all", Description: "app\nA long description.\n\nSecond paragraph."}}
+	short := &Package{Metadata: Metadata{Package: "short", Version: "1.0", Architecture: "all", Description: "short only"}}
+	repo := &StandardRepository{
+		ArchiveInfo: ArchiveInfo{Codename: "stable"},
+		Parts: []*Repository{
+			{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "amd64"}, Packages: []*Package{app, short}},
+			{ArchiveInfo: ArchiveInfo{Components: "main", Architectures: "arm64"}, Packages: []*Package{app, short}},
+		},
+		Translations: true,
+	}
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	read := func(name string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	// The checksum of "app\n A long description.\n .\n Second paragraph.\n".
+	const sum = "877ff8665b7edbc5812c8ef5507729d2"
+	packages := read("dists/stable/main/binary-amd64/Packages")
+	if want := "Description: app\nDescription-md5: " + sum + "\n"; !strings.Contains(packages, want) {
+		t.Errorf("Packages does not contain %q:\n%s", want, packages)
+	}
+	if strings.Contains(packages, "A long description") {
+		t.Errorf("Packages lists the long description:\n%s", packages)
+	}
+
+	translation := read("dists/stable/main/i18n/Translation-en")
+	want := "Package: app\nDescription-md5: " + sum + "\nDescription-en: app\n A long description.\n .\n Second paragraph.\n\n" +
+		"Package: short\nDescription-md5: " + descriptionMD5("short only\n") + "\nDescription-en: short only\n\n"
+	if translation != want {
+		t.Errorf("Translation-en = %q, want %q", translation, want)
+	}
+	if release := read("dists/stable/Release"); !strings.Contains(release, " main/i18n/Translation-en.gz\n") {
+		t.Errorf("Release does not list the Translation index:\n%s", release)
+	}
+
+	entries, err := ParsePackagesIndex(packages)
+	if err != nil {
+		t.Fatalf("ParsePackagesIndex failed: %v", err)
+	}
+	if _, ok := entries[0].Metadata.ExtraFields["Description-md5"]; ok {
+		t.Error("ParsePackagesIndex keeps Description-md5 in the metadata")
+	}
+
+	// Without translations, the Packages indices keep the long descriptions.
+	repo.Translations = false
+	if _, err := repo.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	packages = read("dists/stable/main/binary-amd64/Packages")
+	if want := " Second paragraph.\nDescription-md5: " + sum + "\n"; !strings.Contains(packages, want) {
+		t.Errorf("Packages does not contain %q:\n%s", want, packages)
+	}
+}
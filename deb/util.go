@@ -3,11 +3,13 @@ package deb
 import (
 	"archive/tar"
 	"bytes"
-	"compress/gzip"
-	"crypto/sha256"
-	"encoding/hex"
+	"cmp"
+	"context"
+	"crypto"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -17,6 +19,7 @@ import (
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/blakesmith/ar"
 )
 
@@ -35,14 +38,14 @@ func (cw *countingWriter) Write(p []byte) (int, error) {
 	return n, err
 }
 
-// addBufferToAr writes a named byte slice as a file entry to the AR archive.
+// addBufferToAr writes a named byte slice as a file entry to the AR archive, modified at modTime.
 // It constructs the AR header with mode 0644 and the current timestamp.
-func addBufferToAr(w *ar.Writer, name string, body []byte) error {
+func addBufferToAr(w *ar.Writer, name string, body []byte, modTime time.Time) error {
 	header := &ar.Header{
 		Name:    name,
 		Size:    int64(len(body)),
 		Mode:    0644,
-		ModTime: time.Now(),
+		ModTime: modTime,
 	}
 	if err := w.WriteHeader(header); err != nil {
 		return err
@@ -52,13 +55,10 @@ func addBufferToAr(w *ar.Writer, name string, body []byte) error {
 }
 
 // parseDeb parses the binary content of a .deb file.
-// It calculates the SHA256 hash of the file and extracts the control metadata,
+// It calculates the checksums of the file, one per hash, and extracts the control metadata,
 // returning a repoPackage struct suitable for inclusion in an APT index.
-func parseDeb(content []byte, filename string) (*repoPackage, error) {
-	hash := sha256.Sum256(content)
-	shaStr := hex.EncodeToString(hash[:])
-
-	control, err := extractControlFromBytes(content)
+func parseDeb(content []byte, filename string, hashes []Hash) (*repoPackage, error) {
+	control, err := extractControlFromBytes(content, DefaultLimits)
 	if err != nil {
 		return nil, err
 	}
@@ -72,14 +72,15 @@ func parseDeb(content []byte, filename string) (*repoPackage, error) {
 		Control:      control,
 		Filename:     filename,
 		Size:         int64(len(content)),
-		SHA256:       shaStr,
+		Checksums:    checksums(hashes, content),
 	}, nil
 }
 
 // extractControlFromBytes iterates through the AR archive structure of a .deb file
-// to locate and decompress the 'control.tar.gz' (or 'control.tar') member,
-// and then extracts the 'control' file content from within that tarball.
-func extractControlFromBytes(data []byte) (string, error) {
+// to locate and decompress the 'control.tar.gz' (or 'control.tar', 'control.tar.zst', 'control.tar.xz') member,
+// and then extracts the 'control' file content from within that tarball, within limits.
+func extractControlFromBytes(data []byte, limits Limits) (string, error) {
+	b := newBudget(limits)
 	r := bytes.NewReader(data)
 	arR := ar.NewReader(r)
 
@@ -91,9 +92,11 @@ func extractControlFromBytes(data []byte) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		if err := b.member(header.Name, header.Size); err != nil {
+			return "", err
+		}
 
 		if strings.HasPrefix(header.Name, "control.tar") {
-			var tr *tar.Reader
 			// Read the tar content
 			tarData := make([]byte, header.Size)
 			if _, err := io.ReadFull(arR, tarData); err != nil {
@@ -101,16 +104,12 @@ func extractControlFromBytes(data []byte) (string, error) {
 			}
 			tarR := bytes.NewReader(tarData)
 
-			if strings.HasSuffix(header.Name, ".gz") {
-				gzr, err := gzip.NewReader(tarR)
-				if err != nil {
-					return "", err
-				}
-				defer gzr.Close()
-				tr = tar.NewReader(gzr)
-			} else {
-				tr = tar.NewReader(tarR)
+			zr, closeZr, err := decompress(header.Name, tarR)
+			if err != nil {
+				return "", err
 			}
+			defer closeZr()
+			tr := tar.NewReader(b.reader(header.Name, zr))
 
 			for {
 				th, err := tr.Next()
@@ -120,6 +119,9 @@ func extractControlFromBytes(data []byte) (string, error) {
 				if err != nil {
 					return "", err
 				}
+				if err := b.member(th.Name, th.Size); err != nil {
+					return "", err
+				}
 				if filepath.Base(th.Name) == "control" {
 					var buf bytes.Buffer
 					if _, err := io.Copy(&buf, tr); err != nil {
@@ -151,24 +153,30 @@ func parseControlFields(control string) (string, string, string) {
 }
 
 // generatePackagesFile generates the content of the 'Packages' index file.
-// It concatenates the control stanzas of all packages in the index and appends
-// the mandatory Filename, Size, and SHA256 fields.
-func generatePackagesFile(index []*repoPackage) []byte {
+// It concatenates the control stanzas of all packages in the index, with their Description-md5,
+// and appends the mandatory Filename and Size fields, and one checksum field per hash.
+// If translated is true, the long descriptions are left to the Translation index.
+func generatePackagesFile(index []*repoPackage, hashes []Hash, translated bool) []byte {
 	var b bytes.Buffer
 	for _, p := range index {
-		b.WriteString(p.Control)
-		if !strings.HasSuffix(p.Control, "\n") {
+		control := indexControl(p.Control, translated)
+		b.WriteString(control)
+		if !strings.HasSuffix(control, "\n") {
 			b.WriteString("\n")
 		}
-		fmt.Fprintf(&b, "Filename: %s\nSize: %d\nSHA256: %s\n\n", p.Filename, p.Size, p.SHA256)
+		fmt.Fprintf(&b, "Filename: %s\nSize: %d\n", p.Filename, p.Size)
+		for i, h := range hashes {
+			fmt.Fprintf(&b, "%s: %s\n", h.field(), p.Checksums[i])
+		}
+		b.WriteString("\n")
 	}
 	return b.Bytes()
 }
 
 // generateReleaseFile generates the content of the 'Release' file for a flat repository.
 // It includes repository metadata (Origin, Label, etc.) and the checksums for the
-// Packages and Packages.gz files.
-func generateReleaseFile(info ArchiveInfo, packages, packagesGz []byte) []byte {
+// indices, e.g. the Packages and Packages.gz files, one section per hash.
+func generateReleaseFile(info ArchiveInfo, hashes []Hash, indices []indexFile) []byte {
 	var b bytes.Buffer
 	writeField := func(key ReleaseField, value string) {
 		if value != "" {
@@ -193,33 +201,225 @@ func generateReleaseFile(info ArchiveInfo, packages, packagesGz []byte) []byte {
 	writeField(RelNotAutomatic, info.NotAutomatic)
 	writeField(RelButAutomaticUpgrades, info.ButAutomaticUpgrades)
 	writeField(RelAcquireByHash, info.AcquireByHash)
-	fmt.Fprintf(&b, "%s:\n", RelSHA256)
-
-	hPkg := sha256.Sum256(packages)
-	fmt.Fprintf(&b, " %x %d %s\n", hPkg, len(packages), "Packages")
 
-	hGz := sha256.Sum256(packagesGz)
-	fmt.Fprintf(&b, " %x %d %s\n", hGz, len(packagesGz), "Packages.gz")
+	for _, h := range hashes {
+		fmt.Fprintf(&b, "%s:\n", h.Name)
+		for _, f := range indices {
+			fmt.Fprintf(&b, " %s %d %s\n", h.sum(f.content), len(f.content), f.name)
+		}
+	}
 
 	return b.Bytes()
 }
 
-// signBytes signs the provided input bytes using the provided ASCII-armored PGP private key.
-// It returns the signed message in ASCII-armored format (clearsigned).
-func signBytes(input []byte, key string) ([]byte, error) {
+// ClearSign signs input with the ASCII-armored PGP private key, and returns the clearsigned message,
+// e.g. an InRelease file from a Release file.
+func ClearSign(input []byte, key string) ([]byte, error) {
+	return signBytes(context.Background(), input, key)
+}
+
+// ClearSignContext is like ClearSign, but does not sign when ctx is canceled.
+func ClearSignContext(ctx context.Context, input []byte, key string) ([]byte, error) {
+	return signBytes(ctx, input, key)
+}
+
+// PublicKey returns the public key of an ASCII-armored PGP private key, ASCII-armored or binary.
+func PublicKey(key string, armored bool) ([]byte, error) {
+	return extractPublicKey(key, armored)
+}
+
+// VerifyClearSigned checks the signature of a clearsigned message, e.g. an InRelease file,
+// against keyring, one or more public keys, ASCII-armored or binary.
+// It returns the signed text.
+func VerifyClearSigned(signed, keyring []byte) ([]byte, error) {
+	block, _ := clearsign.Decode(signed)
+	if block == nil {
+		return nil, fmt.Errorf("no clearsigned message found")
+	}
+	keys, err := readKeyRing(keyring)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := block.VerifySignature(keys, nil); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	return block.Plaintext, nil
+}
+
+// ClearSignedText returns the signed text of a clearsigned message, e.g. an InRelease file,
+// without checking its signature. Use VerifyClearSigned to check it.
+func ClearSignedText(signed []byte) ([]byte, error) {
+	block, _ := clearsign.Decode(signed)
+	if block == nil {
+		return nil, fmt.Errorf("no clearsigned message found")
+	}
+	return block.Plaintext, nil
+}
+
+// VerifyDetached checks the detached signature of content, e.g. a Release.gpg file,
+// against keyring, one or more public keys, ASCII-armored or binary. The signature can be ASCII-armored or binary.
+func VerifyDetached(content, signature, keyring []byte) error {
+	keys, err := readKeyRing(keyring)
+	if err != nil {
+		return err
+	}
+	check := openpgp.CheckDetachedSignature
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	if _, err := check(keys, bytes.NewReader(content), bytes.NewReader(signature), nil); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return nil
+}
+
+// ErrUnsigned is returned by VerifyPackageSignature for a package without signature.
+var ErrUnsigned = errors.New("package is not signed")
+
+// VerifyPackageSignature checks the _gpgorigin member of a .deb file, see Package.SigningKey,
+// against keyring, one or more public keys, ASCII-armored or binary. The signature is the detached
+// signature of the concatenated debian-binary, control and data members, as made by debsigs.
+func VerifyPackageSignature(r io.Reader, keyring []byte) error {
+	var signed, signature []byte
+	arR := ar.NewReader(r)
+	for {
+		header, err := arR.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading ar header: %w", err)
+		}
+		body, err := io.ReadAll(arR)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", header.Name, err)
+		}
+		switch name := strings.TrimSuffix(header.Name, "/"); {
+		case name == string(PkgGpgOrigin):
+			signature = body
+		case name == string(PkgDebianBinary), strings.HasPrefix(name, "control.tar"), strings.HasPrefix(name, "data.tar"):
+			signed = append(signed, body...)
+		}
+	}
+	if signature == nil {
+		return ErrUnsigned
+	}
+	return VerifyDetached(signed, signature, keyring)
+}
+
+// readKeyRing reads an ASCII-armored or binary keyring.
+func readKeyRing(keyring []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(keyring), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(keyring))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(keyring))
+}
+
+// DetachSign signs input with the ASCII-armored PGP private key, and returns the ASCII-armored
+// detached signature, e.g. a Release.gpg file from a Release file.
+func DetachSign(input []byte, key string) ([]byte, error) {
+	return DetachSignContext(context.Background(), input, key)
+}
+
+// DetachSignContext is like DetachSign, but does not sign when ctx is canceled.
+func DetachSignContext(ctx context.Context, input []byte, key string) ([]byte, error) {
+	signer, err := signingEntity(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&out, signer, bytes.NewReader(input), nil); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Key algorithms supported by GenerateKey.
+const (
+	KeyAlgorithmRSA     = "rsa"
+	KeyAlgorithmEd25519 = "ed25519"
+)
+
+// KeyOptions configures the signing keys created by GenerateKey.
+type KeyOptions struct {
+	// Name, Comment and Email form the identity of the key, e.g. "MyOrg (APT) <apt@example.com>".
+	Name    string
+	Comment string
+	Email   string
+	// Algorithm is KeyAlgorithmRSA (the default) or KeyAlgorithmEd25519.
+	Algorithm string
+	// RSABits is the size of RSA keys. Defaults to 4096.
+	RSABits int
+	// Lifetime is the validity of the key. Zero means that the key never expires.
+	Lifetime time.Duration
+}
+
+// GenerateKey creates a repository signing key, and returns it as an ASCII-armored PGP private key,
+// as expected by the GPGKey fields.
+func GenerateKey(opts KeyOptions) (string, error) {
+	config := &packet.Config{
+		DefaultHash:     crypto.SHA256,
+		RSABits:         cmp.Or(opts.RSABits, 4096),
+		KeyLifetimeSecs: uint32(opts.Lifetime / time.Second),
+	}
+	switch opts.Algorithm {
+	case "", KeyAlgorithmRSA:
+		config.Algorithm = packet.PubKeyAlgoRSA
+	case KeyAlgorithmEd25519:
+		config.Algorithm = packet.PubKeyAlgoEdDSA
+		config.Curve = packet.Curve25519
+	default:
+		return "", fmt.Errorf("unsupported key algorithm %q, expected %q or %q", opts.Algorithm, KeyAlgorithmRSA, KeyAlgorithmEd25519)
+	}
+	if opts.Lifetime < 0 || opts.Lifetime/time.Second > math.MaxUint32 {
+		return "", fmt.Errorf("invalid key lifetime %s", opts.Lifetime)
+	}
+	entity, err := openpgp.NewEntity(opts.Name, opts.Comment, opts.Email, config)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := entity.SerializePrivate(w, config); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// signingEntity returns the first entity with a private key of an ASCII-armored PGP key.
+func signingEntity(key string) (*openpgp.Entity, error) {
 	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
 	if err != nil {
 		return nil, err
 	}
-	var signer *openpgp.Entity
 	for _, e := range entities {
 		if e.PrivateKey != nil {
-			signer = e
-			break
+			return e, nil
 		}
 	}
-	if signer == nil {
-		return nil, fmt.Errorf("no private key found")
+	return nil, fmt.Errorf("no private key found")
+}
+
+// signBytes signs the provided input bytes using the provided ASCII-armored PGP private key.
+// It returns the signed message in ASCII-armored format (clearsigned), or ctx's error if ctx is canceled
+// before signing.
+func signBytes(ctx context.Context, input []byte, key string) ([]byte, error) {
+	signer, err := signingEntity(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	var out bytes.Buffer
@@ -236,20 +436,10 @@ func signBytes(input []byte, key string) ([]byte, error) {
 // If armored is true, it returns the public key in ASCII-armored format.
 // Otherwise, it returns the binary serialized public key.
 func extractPublicKey(key string, armored bool) ([]byte, error) {
-	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	signer, err := signingEntity(key)
 	if err != nil {
 		return nil, err
 	}
-	var signer *openpgp.Entity
-	for _, e := range entities {
-		if e.PrivateKey != nil {
-			signer = e
-			break
-		}
-	}
-	if signer == nil {
-		return nil, fmt.Errorf("no private key found")
-	}
 
 	var buf bytes.Buffer
 	if armored {
@@ -271,8 +461,9 @@ func extractPublicKey(key string, armored bool) ([]byte, error) {
 
 // generateHierarchicalRelease generates the content of the 'Release' file for a
 // standard hierarchical repository (dists/...). It lists the checksums for all
-// files in the repository structure (Packages, Packages.gz, etc.).
-func generateHierarchicalRelease(info ArchiveInfo, entries []releaseFileEntry) []byte {
+// files in the repository structure (Packages, Packages.gz, etc.), one section per hash.
+// The checksums of the entries are in the order of hashes.
+func generateHierarchicalRelease(info ArchiveInfo, hashes []Hash, entries []releaseFileEntry) []byte {
 	var b bytes.Buffer
 	writeField := func(key ReleaseField, value string) {
 		if value != "" {
@@ -297,15 +488,17 @@ func generateHierarchicalRelease(info ArchiveInfo, entries []releaseFileEntry) [
 	writeField(RelNotAutomatic, info.NotAutomatic)
 	writeField(RelButAutomaticUpgrades, info.ButAutomaticUpgrades)
 	writeField(RelAcquireByHash, info.AcquireByHash)
-	fmt.Fprintf(&b, "%s:\n", RelSHA256)
 
 	// Sort entries for deterministic output
 	sort.Slice(entries, func(i, j int) bool {
 		return entries[i].Path < entries[j].Path
 	})
 
-	for _, e := range entries {
-		fmt.Fprintf(&b, " %s %d %s\n", e.Hash, e.Size, e.Path)
+	for i, h := range hashes {
+		fmt.Fprintf(&b, "%s:\n", h.Name)
+		for _, e := range entries {
+			fmt.Fprintf(&b, " %s %d %s\n", e.Checksums[i], e.Size, e.Path)
+		}
 	}
 
 	return b.Bytes()
@@ -313,62 +506,20 @@ func generateHierarchicalRelease(info ArchiveInfo, entries []releaseFileEntry) [
 
 // parseControlFile parses the content of a Debian control file and populates the Metadata struct.
 // It handles standard fields mapping to struct fields and puts unknown fields into ExtraFields.
-// It also handles multiline values (folded fields).
-func parseControlFile(content string, m *Metadata) error {
+// It also handles multiline values (folded fields), and fails with a LimitError on values longer than limits allow.
+func parseControlFile(content string, m *Metadata, limits Limits) error {
 	var currentKey string
 	var currentValue strings.Builder
 
-	flush := func() {
+	flush := func() error {
 		if currentKey != "" {
 			val := strings.TrimSpace(currentValue.String())
-			switch ControlField(currentKey) {
-			case FieldPackage:
-				m.Package = val
-			case FieldVersion:
-				m.Version = val
-			case FieldArchitecture:
-				m.Architecture = val
-			case FieldMaintainer:
-				m.Maintainer = val
-			case FieldDescription:
-				m.Description = val
-			case FieldSection:
-				m.Section = val
-			case FieldPriority:
-				m.Priority = val
-			case FieldHomepage:
-				m.Homepage = val
-			case FieldEssential:
-				m.Essential = (val == "yes")
-			case FieldDepends:
-				m.Depends = splitList(val)
-			case FieldPreDepends:
-				m.PreDepends = splitList(val)
-			case FieldRecommends:
-				m.Recommends = splitList(val)
-			case FieldSuggests:
-				m.Suggests = splitList(val)
-			case FieldEnhances:
-				m.Enhances = splitList(val)
-			case FieldConflicts:
-				m.Conflicts = splitList(val)
-			case FieldBreaks:
-				m.Breaks = splitList(val)
-			case FieldReplaces:
-				m.Replaces = splitList(val)
-			case FieldProvides:
-				m.Provides = splitList(val)
-			case FieldBuiltUsing:
-				m.BuiltUsing = val
-			case FieldSource:
-				m.Source = val
-			case FieldInstalledSize:
-				//ignore installed size when reading
-
-			default:
-				m.ExtraFields[currentKey] = val
+			if err := limits.checkField(currentKey, val); err != nil {
+				return err
 			}
+			m.setField(currentKey, val)
 		}
+		return nil
 	}
 
 	lines := strings.Split(content, "\n")
@@ -376,15 +527,86 @@ func parseControlFile(content string, m *Metadata) error {
 		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
 			currentValue.WriteString("\n" + line)
 		} else if strings.Contains(line, ":") {
-			flush()
+			if err := flush(); err != nil {
+				return err
+			}
 			parts := strings.SplitN(line, ":", 2)
 			currentKey = parts[0]
 			currentValue.Reset()
 			currentValue.WriteString(strings.TrimSpace(parts[1]))
 		}
 	}
-	flush()
-	return nil
+	return flush()
+}
+
+// splitControlFields returns the fields of the content of a control file, in order, with their
+// continuation lines, as parseControlFile reads them.
+func splitControlFields(content string) []controlField {
+	var fields []controlField
+	for line := range strings.SplitSeq(content, "\n") {
+		switch {
+		case strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t"):
+			if len(fields) > 0 {
+				fields[len(fields)-1].text += line + "\n"
+			}
+		case strings.Contains(line, ":"):
+			name, _, _ := strings.Cut(line, ":")
+			fields = append(fields, controlField{name, line + "\n"})
+		}
+	}
+	return fields
+}
+
+// setField sets the control field key to val: standard fields map to the struct fields,
+// unknown ones go into ExtraFields.
+func (m *Metadata) setField(key, val string) {
+	switch ControlField(key) {
+	case FieldPackage:
+		m.Package = val
+	case FieldVersion:
+		m.Version = val
+	case FieldArchitecture:
+		m.Architecture = val
+	case FieldMaintainer:
+		m.Maintainer = val
+	case FieldDescription:
+		m.Description = val
+	case FieldSection:
+		m.Section = val
+	case FieldPriority:
+		m.Priority = val
+	case FieldHomepage:
+		m.Homepage = val
+	case FieldEssential:
+		m.Essential = (val == "yes")
+	case FieldDepends:
+		m.Depends = splitList(val)
+	case FieldPreDepends:
+		m.PreDepends = splitList(val)
+	case FieldRecommends:
+		m.Recommends = splitList(val)
+	case FieldSuggests:
+		m.Suggests = splitList(val)
+	case FieldEnhances:
+		m.Enhances = splitList(val)
+	case FieldConflicts:
+		m.Conflicts = splitList(val)
+	case FieldBreaks:
+		m.Breaks = splitList(val)
+	case FieldReplaces:
+		m.Replaces = splitList(val)
+	case FieldProvides:
+		m.Provides = splitList(val)
+	case FieldBuiltUsing:
+		m.BuiltUsing = val
+	case FieldSource:
+		m.Source = val
+	case FieldInstalledSize:
+		m.InstalledSize, _ = strconv.ParseInt(val, 10, 64)
+
+	default:
+		m.ExtraFields[key] = val
+	}
 }
 
 // splitList splits a comma-separated string into a slice of strings, trimming whitespace from each element.
@@ -393,9 +615,8 @@ func splitList(s string) []string {
 	if s == "" {
 		return nil
 	}
-	parts := strings.Split(s, ",")
-	var res []string
-	for _, p := range parts {
+	res := make([]string, 0, strings.Count(s, ",")+1)
+	for p := range strings.SplitSeq(s, ",") {
 		res = append(res, strings.TrimSpace(p))
 	}
 	return res
@@ -415,6 +636,9 @@ func parseReleaseFile(content string, info *ArchiveInfo) error {
 		}
 		key := strings.TrimSpace(parts[0])
 		val := strings.TrimSpace(parts[1])
+		if err := DefaultLimits.checkField(key, val); err != nil {
+			return err
+		}
 
 		switch ReleaseField(key) {
 		case RelOrigin:
@@ -448,46 +672,108 @@ func parseReleaseFile(content string, info *ArchiveInfo) error {
 	return nil
 }
 
-// parsePackagesIndex parses a Packages index file content.
-// It splits the content into stanzas (separated by blank lines) and parses each stanza into a Package struct.
-// It also handles special fields like Filename (mapping to ExternalURL) and removes index-specific fields
-// (Size, SHA256, etc.) from the metadata to keep it clean.
-func parsePackagesIndex(content string) ([]*Package, error) {
-	var pkgs []*Package
-	stanzas := strings.Split(content, "\n\n")
-	for _, stanza := range stanzas {
-		if strings.TrimSpace(stanza) == "" {
+// ParseRelease parses the archive fields of a Release (or InRelease plaintext) file.
+// The checksum sections are ignored, see ParseReleaseEntries.
+func ParseRelease(content string) (ArchiveInfo, error) {
+	var info ArchiveInfo
+	err := parseReleaseFile(content, &info)
+	return info, err
+}
+
+// ReleaseEntry is an index file listed in the checksum sections of a Release file.
+//
+// Reference: https://wiki.debian.org/DebianRepository/Format#MD5Sum.2C_SHA1.2C_SHA256
+type ReleaseEntry struct {
+	// Path is the path of the file, relative to the directory of the Release file.
+	Path string
+	// Size is the size of the file in bytes.
+	Size int64
+	// SHA256 is the hex encoded SHA256 checksum of the file, if the Release file lists it.
+	SHA256 string
+	// Checksums are the hex encoded checksums of the file listed by the Release file, by hash name,
+	// e.g. "SHA512", see HashByName.
+	Checksums map[string]string
+}
+
+// ParseReleaseEntries returns the files listed in the MD5Sum, SHA1, SHA256 and SHA512 sections of the
+// content of a Release file, in the order they are first listed.
+// It fails with a LimitError if there are more entries than DefaultLimits.MaxEntries.
+func ParseReleaseEntries(content string) ([]ReleaseEntry, error) {
+	var entries []ReleaseEntry
+	index := make(map[string]int)
+	b := newBudget(DefaultLimits)
+	var section *Hash
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			key, _, _ := strings.Cut(line, ":")
+			section = nil
+			for _, h := range []Hash{MD5, SHA1, SHA256, SHA512} {
+				if h.Name == strings.TrimSpace(key) {
+					section = &h
+				}
+			}
 			continue
 		}
-		pkg := &Package{
-			Metadata: Metadata{ExtraFields: make(map[string]string)},
+		if section == nil {
+			continue
 		}
-		if err := parseControlFile(stanza, &pkg.Metadata); err != nil {
-			return nil, err
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid %s entry %q", section.Name, strings.TrimSpace(line))
 		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in %s entry %q", section.Name, strings.TrimSpace(line))
+		}
+		i, ok := index[fields[2]]
+		if !ok {
+			if err := b.entry(fields[2]); err != nil {
+				return nil, err
+			}
+			i = len(entries)
+			index[fields[2]] = i
+			entries = append(entries, ReleaseEntry{Path: fields[2], Size: size, Checksums: make(map[string]string)})
+		}
+		if entries[i].Size != size {
+			return nil, fmt.Errorf("%s: the %s section lists a size of %d, not %d", fields[2], section.Name, size, entries[i].Size)
+		}
+		entries[i].Checksums[section.Name] = fields[0]
+		if section.Name == SHA256.Name {
+			entries[i].SHA256 = fields[0]
+		}
+	}
+	return entries, nil
+}
 
-		delete(pkg.Metadata.ExtraFields, "Filename")
-
-		// Clean up other index-only fields from ExtraFields
-		delete(pkg.Metadata.ExtraFields, "Size")
-		delete(pkg.Metadata.ExtraFields, "SHA256")
-		delete(pkg.Metadata.ExtraFields, "MD5sum")
-		delete(pkg.Metadata.ExtraFields, "SHA1")
-
-		pkgs = append(pkgs, pkg)
+// Verify checks the size and the checksums of content against the entry. Every listed checksum
+// is checked, and a SHA256 or SHA512 checksum is required, since MD5 and SHA1 are broken.
+func (e ReleaseEntry) Verify(content []byte) error {
+	if int64(len(content)) != e.Size {
+		return fmt.Errorf("size is %d, Release says %d", len(content), e.Size)
+	}
+	if e.Checksums[SHA256.Name] == "" && e.Checksums[SHA512.Name] == "" {
+		return fmt.Errorf("the Release file has no %s or %s checksum", SHA256.Name, SHA512.Name)
 	}
-	return pkgs, nil
+	for _, h := range []Hash{MD5, SHA1, SHA256, SHA512} {
+		want, ok := e.Checksums[h.Name]
+		if !ok {
+			continue
+		}
+		if got := h.sum(content); !strings.EqualFold(got, want) {
+			return fmt.Errorf("%s is %s, Release says %s", h.Name, got, want)
+		}
+	}
+	return nil
 }
 
 // BumpVersion increments the iteration number of a Debian version string.
-// It ensures the new version is considered newer by Debian sorting rules.
+// The new version is always newer by the dpkg ordering, see CompareVersions.
 //
 // Strategy:
 //  1. If no iteration (no hyphen), append "-1".
-//  2. If iteration is purely numeric, increment it (e.g. "1.0-1" -> "1.0-2").
-//  3. Otherwise, find the last alphanumeric character in the iteration and bump it
-//     using the range 0-9, a-z. (e.g. "1.0-1a" -> "1.0-1b", "1.0-19" -> "1.0-1a").
-//     If the character is 'z', '0' is appended ("1.0-1z" -> "1.0-1z0").
+//  2. If the iteration ends with a number, increment it (e.g. "1.0-1" -> "1.0-2",
+//     "1.0-1ubuntu9" -> "1.0-1ubuntu10").
+//  3. Otherwise, append "1" (e.g. "1.0-1z" -> "1.0-1z1", "1.0-1~rc" -> "1.0-1~rc1").
 func BumpVersion(v string) string {
 	idx := strings.LastIndex(v, "-")
 	if idx == -1 {
@@ -499,30 +785,108 @@ func BumpVersion(v string) string {
 		return prefix + "1"
 	}
 
-	// Try numeric bump
-	if i, err := strconv.Atoi(rev); err == nil {
-		return prefix + strconv.Itoa(i+1)
+	// The trailing number of the iteration, compared numerically by dpkg.
+	start := len(rev)
+	for start > 0 && rev[start-1] >= '0' && rev[start-1] <= '9' {
+		start--
 	}
+	if n, err := strconv.ParseUint(rev[start:], 10, 63); err == nil {
+		return prefix + rev[:start] + strconv.FormatUint(n+1, 10)
+	}
+	// No trailing number (or too large to increment): a trailing "1" sorts after the end of the iteration.
+	return v + "1"
+}
 
-	// Alphanumeric bump
-	runes := []rune(rev)
-	for i := len(runes) - 1; i >= 0; i-- {
-		c := runes[i]
-		if c >= '0' && c < '9' {
-			runes[i]++
-			return prefix + string(runes)
-		}
-		if c == '9' {
-			runes[i] = 'a'
-			return prefix + string(runes)
+// CompareVersions compares two Debian version strings following the dpkg rules, like Version.Compare,
+// and returns -1, 0 or +1 when a is older than, equal to, or newer than b. The syntax of the versions
+// is not checked.
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-controlfields.html#version
+func CompareVersions(a, b string) int {
+	ea, ua, ra := splitDebianVersion(a)
+	eb, ub, rb := splitDebianVersion(b)
+	if c := compareNumbers(ea, eb); c != 0 {
+		return c
+	}
+	if c := compareVersionPart(ua, ub); c != 0 {
+		return c
+	}
+	return compareVersionPart(ra, rb)
+}
+
+// splitDebianVersion splits a version into its epoch, upstream version and revision.
+func splitDebianVersion(v string) (epoch, upstream, revision string) {
+	if i := strings.Index(v, ":"); i >= 0 {
+		epoch, v = v[:i], v[i+1:]
+	}
+	upstream, revision = v, ""
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		upstream, revision = v[:i], v[i+1:]
+	}
+	return epoch, upstream, revision
+}
+
+// compareVersionPart compares upstream versions or revisions: alternately the non-digit prefixes,
+// character by character, and the numeric prefixes.
+func compareVersionPart(a, b string) int {
+	for a != "" || b != "" {
+		var na, nb string
+		na, a = splitPrefix(a, false)
+		nb, b = splitPrefix(b, false)
+		if c := compareNonDigits(na, nb); c != 0 {
+			return c
 		}
-		if c >= 'a' && c < 'z' {
-			runes[i]++
-			return prefix + string(runes)
+		na, a = splitPrefix(a, true)
+		nb, b = splitPrefix(b, true)
+		if c := compareNumbers(na, nb); c != 0 {
+			return c
 		}
-		if c == 'z' {
-			return prefix + string(runes[:i+1]) + "0" + string(runes[i+1:])
+	}
+	return 0
+}
+
+// splitPrefix returns the longest prefix of s made of digits (or non-digits), and the rest.
+func splitPrefix(s string, digits bool) (string, string) {
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9') == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareNonDigits compares non-digit strings: '~' sorts before anything, even the end of the string,
+// and letters sort before other characters.
+func compareNonDigits(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if c := versionOrder(a, i) - versionOrder(b, i); c != 0 {
+			return cmp.Compare(c, 0)
 		}
 	}
-	return v + "1"
+	return 0
+}
+
+// versionOrder returns the sort weight of the i-th character of s.
+func versionOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case c == '~':
+		return -1
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+// compareNumbers compares strings of digits numerically. An empty string is zero.
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if c := cmp.Compare(len(a), len(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
 }
@@ -4,10 +4,16 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"errors"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/ProtonMail/go-crypto/openpgp/armor"
@@ -43,7 +49,7 @@ func TestAddBufferToAr(t *testing.T) {
 	}
 
 	content := []byte("content")
-	if err := addBufferToAr(arW, "test.txt", content); err != nil {
+	if err := addBufferToAr(arW, "test.txt", content, time.Now()); err != nil {
 		t.Fatalf("addBufferToAr failed: %v", err)
 	}
 
@@ -69,7 +75,7 @@ func createMockDebBytes(t *testing.T, controlContent string) []byte {
 	arW.WriteGlobalHeader()
 
 	// debian-binary
-	addBufferToAr(arW, string(PkgDebianBinary), []byte("2.0\n"))
+	addBufferToAr(arW, string(PkgDebianBinary), []byte("2.0\n"), time.Now())
 
 	// control.tar.gz
 	var cBuf bytes.Buffer
@@ -84,7 +90,7 @@ func createMockDebBytes(t *testing.T, controlContent string) []byte {
 	tw.Write([]byte(controlContent))
 	tw.Close()
 	gw.Close()
-	addBufferToAr(arW, string(PkgControlTarGz), cBuf.Bytes())
+	addBufferToAr(arW, string(PkgControlTarGz), cBuf.Bytes(), time.Now())
 
 	return buf.Bytes()
 }
@@ -93,7 +99,7 @@ func TestParseDeb(t *testing.T) {
 	control := "Package: test\nVersion: 1.0\nArchitecture: amd64\n"
 	debBytes := createMockDebBytes(t, control)
 
-	pkg, err := parseDeb(debBytes, "test.deb")
+	pkg, err := parseDeb(debBytes, "test.deb", []Hash{SHA256, SHA512})
 	if err != nil {
 		t.Fatalf("parseDeb failed: %v", err)
 	}
@@ -112,9 +118,10 @@ func TestParseDeb(t *testing.T) {
 	}
 
 	hash := sha256.Sum256(debBytes)
-	expectedHash := hex.EncodeToString(hash[:])
-	if pkg.SHA256 != expectedHash {
-		t.Errorf("hash mismatch")
+	hash512 := sha512.Sum512(debBytes)
+	want := []string{hex.EncodeToString(hash[:]), hex.EncodeToString(hash512[:])}
+	if !slices.Equal(pkg.Checksums, want) {
+		t.Errorf("checksums %v, want %v", pkg.Checksums, want)
 	}
 }
 
@@ -122,7 +129,7 @@ func TestExtractControlFromBytes(t *testing.T) {
 	expected := "Package: foo\n"
 	debBytes := createMockDebBytes(t, expected)
 
-	got, err := extractControlFromBytes(debBytes)
+	got, err := extractControlFromBytes(debBytes, DefaultLimits)
 	if err != nil {
 		t.Fatalf("extractControlFromBytes failed: %v", err)
 	}
@@ -142,13 +149,13 @@ func TestParseControlFields(t *testing.T) {
 func TestGeneratePackagesFile(t *testing.T) {
 	pkgs := []*repoPackage{
 		{
-			Control:  "Package: a\n",
-			Filename: "a.deb",
-			Size:     100,
-			SHA256:   "hash",
+			Control:   "Package: a\n",
+			Filename:  "a.deb",
+			Size:      100,
+			Checksums: []string{"hash", "hash512"},
 		},
 	}
-	out := generatePackagesFile(pkgs)
+	out := generatePackagesFile(pkgs, []Hash{SHA256, SHA512}, false)
 	s := string(out)
 	if !strings.Contains(s, "Package: a") {
 		t.Error("missing control content")
@@ -156,14 +163,14 @@ func TestGeneratePackagesFile(t *testing.T) {
 	if !strings.Contains(s, "Filename: a.deb") {
 		t.Error("missing filename")
 	}
-	if !strings.Contains(s, "SHA256: hash") {
+	if !strings.Contains(s, "SHA256: hash\nSHA512: hash512\n") {
 		t.Error("missing hash")
 	}
 }
 
 func TestGenerateReleaseFile(t *testing.T) {
 	info := ArchiveInfo{Origin: "TestOrigin", Codename: "stable"}
-	out := generateReleaseFile(info, []byte("pkgs"), []byte("pkgsgz"))
+	out := generateReleaseFile(info, DefaultHashes, []indexFile{{"Packages", []byte("pkgs")}, {"Packages.gz", []byte("pkgsgz")}})
 	s := string(out)
 
 	if !strings.Contains(s, "Origin: TestOrigin") {
@@ -204,7 +211,7 @@ func TestSignBytes(t *testing.T) {
 	key := generateTestKey(t)
 	data := []byte("sign me")
 
-	signed, err := signBytes(data, key)
+	signed, err := signBytes(context.Background(), data, key)
 	if err != nil {
 		t.Fatalf("signBytes failed: %v", err)
 	}
@@ -212,6 +219,15 @@ func TestSignBytes(t *testing.T) {
 	if !strings.Contains(string(signed), "-----BEGIN PGP SIGNED MESSAGE-----") {
 		t.Error("output does not look like a signed message")
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := signBytes(ctx, data, key); !errors.Is(err, context.Canceled) {
+		t.Errorf("signBytes with a canceled context: %v", err)
+	}
+	if _, err := DetachSignContext(ctx, data, key); !errors.Is(err, context.Canceled) {
+		t.Errorf("DetachSignContext with a canceled context: %v", err)
+	}
 }
 
 func TestExtractPublicKey(t *testing.T) {
@@ -239,11 +255,11 @@ func TestExtractPublicKey(t *testing.T) {
 func TestGenerateHierarchicalRelease(t *testing.T) {
 	info := ArchiveInfo{Origin: "Hierarchical"}
 	entries := []releaseFileEntry{
-		{Path: "main/binary-amd64/Packages", Size: 100, Hash: "h1"},
-		{Path: "main/binary-arm64/Packages", Size: 200, Hash: "h2"},
+		{Path: "main/binary-amd64/Packages", Size: 100, Checksums: []string{"h1"}},
+		{Path: "main/binary-arm64/Packages", Size: 200, Checksums: []string{"h2"}},
 	}
 
-	out := generateHierarchicalRelease(info, entries)
+	out := generateHierarchicalRelease(info, DefaultHashes, entries)
 	s := string(out)
 
 	if !strings.Contains(s, "Origin: Hierarchical") {
@@ -266,7 +282,7 @@ Extra: value
 `
 	var m Metadata
 	m.ExtraFields = make(map[string]string)
-	if err := parseControlFile(content, &m); err != nil {
+	if err := parseControlFile(content, &m, DefaultLimits); err != nil {
 		t.Fatalf("parseControlFile failed: %v", err)
 	}
 
@@ -334,6 +350,23 @@ Description: Test Description
 	}
 }
 
+func TestParseRelease(t *testing.T) {
+	content := `Origin: TestOrigin
+Label: TestLabel
+Codename: bookworm
+Components: main contrib
+SHA256:
+ 0123 42 main/binary-amd64/Packages
+`
+	info, err := ParseRelease(content)
+	if err != nil {
+		t.Fatalf("ParseRelease failed: %v", err)
+	}
+	if info.Origin != "TestOrigin" || info.Label != "TestLabel" || info.Codename != "bookworm" || info.Components != "main contrib" {
+		t.Errorf("unexpected archive info %+v", info)
+	}
+}
+
 func TestParsePackagesIndex(t *testing.T) {
 	content := `Package: pkg1
 Version: 1.0
@@ -362,27 +395,259 @@ Filename: http://example.com/pkg2.deb
 	}
 }
 
+func TestExportedParsePackagesIndex(t *testing.T) {
+	content := `Package: pkg1
+Version: 1.0
+Architecture: amd64
+Filename: pool/main/p/pkg1/pkg1.deb
+Size: 1024
+SHA256: hash1
+`
+	entries, err := ParsePackagesIndex(content)
+	if err != nil {
+		t.Fatalf("ParsePackagesIndex failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Filename != "pool/main/p/pkg1/pkg1.deb" || e.Size != 1024 || e.SHA256 != "hash1" || e.Metadata.Package != "pkg1" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if len(e.Metadata.ExtraFields) != 0 {
+		t.Errorf("index fields should be removed from ExtraFields, got %v", e.Metadata.ExtraFields)
+	}
+}
+
 func TestBumpVersion(t *testing.T) {
 	tests := []struct {
 		input string
 		want  string
 	}{
 		{"1.0", "1.0-1"},
+		{"1:1.0", "1:1.0-1"},
 		{"1.0-1", "1.0-2"},
 		{"1.0-9", "1.0-10"},
+		{"1.0-19", "1.0-20"},
 		{"1.0-1.2", "1.0-1.3"},
-		{"1.0-1.9", "1.0-1.a"},
-		{"1.0-a", "1.0-b"},
-		{"1.0-z", "1.0-z0"},
+		{"1.0-1.9", "1.0-1.10"},
+		{"1.0-1.09", "1.0-1.10"},
+		{"1.0-a", "1.0-a1"},
+		{"1.0-z", "1.0-z1"},
+		{"1.0-1z", "1.0-1z1"},
 		{"1.0-1ubuntu1", "1.0-1ubuntu2"},
-		{"1.0-1ubuntu9", "1.0-1ubuntua"},
+		{"1.0-1ubuntu9", "1.0-1ubuntu10"},
+		{"1.0-1~rc", "1.0-1~rc1"},
 		{"1.0-", "1.0-1"},
-		{"1.0-foo+", "1.0-fop+"},
+		{"1.0-foo+", "1.0-foo+1"},
+		{"1.0-99999999999999999999", "1.0-999999999999999999991"},
 	}
 
 	for _, tt := range tests {
-		if got := BumpVersion(tt.input); got != tt.want {
+		got := BumpVersion(tt.input)
+		if got != tt.want {
 			t.Errorf("BumpVersion(%q) = %q, want %q", tt.input, got, tt.want)
 		}
+		if CompareVersions(got, tt.input) <= 0 {
+			t.Errorf("BumpVersion(%q) = %q, which is not newer", tt.input, got)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-10", "1.0-9", 1},
+		{"1.0", "1.0-0", 0},
+		{"1:1.0", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0+", -1},
+		{"1.01", "1.1", 0},
+		{"2.0-1ubuntu1", "2.0-1", 1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestVerifyClearSigned(t *testing.T) {
+	key := generateTestKey(t)
+	release := []byte("Origin: Test\nSHA256:\n abc 3 Packages\n")
+	signed, err := ClearSign(release, key)
+	if err != nil {
+		t.Fatalf("ClearSign failed: %v", err)
+	}
+	for _, armored := range []bool{true, false} {
+		pub, err := PublicKey(key, armored)
+		if err != nil {
+			t.Fatalf("PublicKey failed: %v", err)
+		}
+		text, err := VerifyClearSigned(signed, pub)
+		if err != nil {
+			t.Fatalf("VerifyClearSigned (armored: %v) failed: %v", armored, err)
+		}
+		if got, want := string(text), string(release); got != want {
+			t.Errorf("VerifyClearSigned() = %q, want %q", got, want)
+		}
+	}
+
+	other, err := PublicKey(generateTestKey(t), true)
+	if err != nil {
+		t.Fatalf("PublicKey failed: %v", err)
+	}
+	if _, err := VerifyClearSigned(signed, other); err == nil {
+		t.Error("VerifyClearSigned with another key should fail")
+	}
+	tampered := bytes.Replace(signed, []byte("Origin: Test"), []byte("Origin: Evil"), 1)
+	pub, _ := PublicKey(key, true)
+	if _, err := VerifyClearSigned(tampered, pub); err == nil {
+		t.Error("VerifyClearSigned of a tampered message should fail")
+	}
+}
+
+func TestParseReleaseEntries(t *testing.T) {
+	content := `Origin: Test
+MD5Sum:
+ d41d8cd98f00b204e9800998ecf8427e 0 Packages
+SHA256:
+ aaa 10 main/binary-amd64/Packages
+ bbb 20 main/binary-amd64/Packages.gz
+SHA512:
+ ccc 10 main/binary-amd64/Packages
+Description: after
+`
+	entries, err := ParseReleaseEntries(content)
+	if err != nil {
+		t.Fatalf("ParseReleaseEntries failed: %v", err)
+	}
+	want := []ReleaseEntry{
+		{Path: "Packages", Size: 0, Checksums: map[string]string{"MD5Sum": "d41d8cd98f00b204e9800998ecf8427e"}},
+		{Path: "main/binary-amd64/Packages", Size: 10, SHA256: "aaa", Checksums: map[string]string{"SHA256": "aaa", "SHA512": "ccc"}},
+		{Path: "main/binary-amd64/Packages.gz", Size: 20, SHA256: "bbb", Checksums: map[string]string{"SHA256": "bbb"}},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %+v, want %+v", entries, want)
+	}
+
+	if err := entries[0].Verify(nil); err == nil {
+		t.Error("Verify should fail without SHA256 or SHA512 checksum")
+	}
+	empty := ReleaseEntry{Path: "Packages", Checksums: map[string]string{"SHA512": SHA512.sum(nil), "MD5Sum": MD5.sum(nil)}}
+	if err := empty.Verify(nil); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	if err := empty.Verify([]byte("x")); err == nil {
+		t.Error("Verify should fail with a wrong size")
+	}
+	empty.Checksums["MD5Sum"] = "0"
+	if err := empty.Verify(nil); err == nil {
+		t.Error("Verify should fail with a wrong checksum")
+	}
+
+	if _, err := ParseReleaseEntries("SHA256:\n aaa x Packages\n"); err == nil {
+		t.Error("expected an error for an invalid size")
+	}
+}
+
+func TestDetachSign(t *testing.T) {
+	key := generateTestKey(t)
+	release := []byte("Origin: Test\n")
+	sig, err := DetachSign(release, key)
+	if err != nil {
+		t.Fatalf("DetachSign failed: %v", err)
+	}
+	if !strings.Contains(string(sig), "-----BEGIN PGP SIGNATURE-----") {
+		t.Error("output does not look like an armored signature")
+	}
+	pub, err := PublicKey(key, false)
+	if err != nil {
+		t.Fatalf("PublicKey failed: %v", err)
+	}
+	if err := VerifyDetached(release, sig, pub); err != nil {
+		t.Errorf("VerifyDetached failed: %v", err)
+	}
+	if err := VerifyDetached([]byte("Origin: Evil\n"), sig, pub); err == nil {
+		t.Error("VerifyDetached of another content should fail")
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	for _, algo := range []string{KeyAlgorithmRSA, KeyAlgorithmEd25519} {
+		t.Run(algo, func(t *testing.T) {
+			key, err := GenerateKey(KeyOptions{Name: "Test", Email: "test@example.com", Algorithm: algo, RSABits: 2048, Lifetime: 24 * time.Hour})
+			if err != nil {
+				t.Fatalf("GenerateKey failed: %v", err)
+			}
+			signed, err := ClearSign([]byte("Origin: Test\n"), key)
+			if err != nil {
+				t.Fatalf("ClearSign failed: %v", err)
+			}
+			pub, err := PublicKey(key, true)
+			if err != nil {
+				t.Fatalf("PublicKey failed: %v", err)
+			}
+			if _, err := VerifyClearSigned(signed, pub); err != nil {
+				t.Errorf("VerifyClearSigned failed: %v", err)
+			}
+		})
+	}
+
+	if _, err := GenerateKey(KeyOptions{Name: "Test", Algorithm: "dsa"}); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+}
+
+func TestVerifyPackageSignature(t *testing.T) {
+	key := generateTestKey(t)
+	pub, err := PublicKey(key, false)
+	if err != nil {
+		t.Fatalf("PublicKey failed: %v", err)
+	}
+	pkg := &Package{
+		Metadata:   Metadata{Package: "signed", Version: "1.0", Architecture: "all", Maintainer: "Test <test@example.com>", Description: "Signed"},
+		Files:      []File{{DestPath: "/usr/share/signed/README", Mode: 0644, Body: "hello\n"}},
+		SigningKey: key,
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	signed := slices.Clone(buf.Bytes())
+	if err := VerifyPackageSignature(bytes.NewReader(signed), pub); err != nil {
+		t.Errorf("VerifyPackageSignature failed: %v", err)
+	}
+	if _, err := NewPackage(bytes.NewReader(signed)); err != nil {
+		t.Errorf("NewPackage of a signed package failed: %v", err)
+	}
+
+	pkg.Files[0].Body = "tampered\n"
+	pkg.SigningKey = ""
+	buf.Reset()
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if err := VerifyPackageSignature(bytes.NewReader(buf.Bytes()), pub); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("VerifyPackageSignature = %v, want ErrUnsigned", err)
+	}
+	// Replace the signed data member by the tampered one.
+	tampered := append(buf.Bytes(), signed[bytes.LastIndex(signed, []byte(PkgGpgOrigin)):]...)
+	if err := VerifyPackageSignature(bytes.NewReader(tampered), pub); err == nil {
+		t.Error("VerifyPackageSignature of a tampered package should fail")
 	}
 }
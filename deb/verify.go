@@ -0,0 +1,193 @@
+package deb
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// packageNameRe is the syntax of the package names.
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-controlfields.html#s-f-package
+var packageNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+
+// Verify checks the package before it reaches dpkg: the mandatory control fields, the syntax of the
+// package name and version, the shebang of the maintainer scripts, the absence of configuration
+// files in udebs, the targets of the hard links, and, for a package read by
+// NewPackage, the md5sums file it contained against the payload files.
+// It returns every problem found, joined, or nil.
+func (p *Package) Verify() error {
+	var errs []error
+	m := p.Metadata
+	for _, f := range []struct {
+		field ControlField
+		value string
+	}{
+		{FieldPackage, m.Package},
+		{FieldVersion, m.Version},
+		{FieldArchitecture, m.Architecture},
+		{FieldMaintainer, m.Maintainer},
+		{FieldDescription, m.Description},
+	} {
+		if strings.TrimSpace(f.value) == "" {
+			errs = append(errs, fmt.Errorf("missing mandatory field %s", f.field))
+		}
+	}
+	if m.Package != "" {
+		if err := CheckPackageName(m.Package); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if m.Version != "" {
+		if err := checkVersion(m.Version); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, s := range p.maintainerScripts() {
+		if s.content != "" && !strings.HasPrefix(s.content, "#!") {
+			errs = append(errs, fmt.Errorf("maintainer script %s has no shebang", s.name))
+		}
+	}
+
+	if p.Udeb {
+		for _, f := range p.Files {
+			if f.IsConf {
+				errs = append(errs, fmt.Errorf("udebs cannot have configuration files: %s", f.DestPath))
+			}
+		}
+	}
+
+	errs = append(errs, checkPayloadPaths(p.Files)...)
+
+	regular := make(map[string]bool, len(p.Files))
+	for _, f := range p.Files {
+		switch {
+		case f.HardLink != "" && !regular[f.HardLink]:
+			errs = append(errs, fmt.Errorf("hard link %s: %s is not a previous regular file", f.DestPath, f.HardLink))
+		case f.HardLink == "" && f.LinkTarget == "" && !f.IsDir:
+			regular[f.DestPath] = true
+		}
+	}
+
+	if p.md5sums != nil {
+		errs = append(errs, p.verifyMd5sums()...)
+	}
+	return errors.Join(errs...)
+}
+
+// CheckPackageName checks the syntax of a package name, e.g. the Package field of a control file.
+func CheckPackageName(name string) error {
+	if !packageNameRe.MatchString(name) {
+		return fmt.Errorf("invalid package name %q: it must be at least two lower case letters, digits, '+', '-' or '.', starting with a letter or a digit", name)
+	}
+	return nil
+}
+
+// checkVersion checks the syntax of a version: [epoch:]upstream_version[-debian_revision].
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-controlfields.html#version
+func checkVersion(version string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid version %q: %s", version, reason)
+	}
+	upstream := version
+	if epoch, rest, ok := strings.Cut(version, ":"); ok {
+		if epoch == "" || strings.Trim(epoch, "0123456789") != "" {
+			return invalid("the epoch must be a number")
+		}
+		upstream = rest
+	}
+	if i := strings.LastIndex(upstream, "-"); i >= 0 {
+		revision := upstream[i+1:]
+		upstream = upstream[:i]
+		if revision == "" || strings.Trim(revision, versionChars+"+.~") != "" {
+			return invalid("the revision must be letters, digits, '+', '.' or '~'")
+		}
+	}
+	if upstream == "" || upstream[0] < '0' || upstream[0] > '9' {
+		return invalid("the upstream version must start with a digit")
+	}
+	if strings.Trim(upstream, versionChars+".+~-") != "" {
+		return invalid("the upstream version must be letters, digits, '.', '+', '~' or '-'")
+	}
+	return nil
+}
+
+// versionChars are the alphanumerics allowed in every part of a version.
+const versionChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// verifyMd5sums checks the payload files against the md5sums read by NewPackage.
+func (p *Package) verifyMd5sums() []error {
+	var errs []error
+	listed := make(map[string]bool, len(p.md5sums))
+	regular := make(map[string]File, len(p.Files))
+	for _, f := range p.Files {
+		if f.LinkTarget != "" || f.IsDir {
+			continue
+		}
+		path := strings.TrimPrefix(f.DestPath, "/")
+		if f.HardLink != "" {
+			// Hard links have the content of their target.
+			target, ok := regular[f.HardLink]
+			if !ok {
+				continue
+			}
+			f = target
+		} else {
+			regular[f.DestPath] = f
+		}
+		want, ok := p.md5sums[path]
+		if !ok {
+			// md5sums may omit files, e.g. the conffiles.
+			continue
+		}
+		listed[path] = true
+		r, err := f.Open()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("opening %s: %w", f.DestPath, err))
+			continue
+		}
+		h := md5.New()
+		_, err = io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading %s: %w", f.DestPath, err))
+			continue
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			errs = append(errs, fmt.Errorf("md5sum mismatch for %s: md5sums lists %s, the content is %s", f.DestPath, want, got))
+		}
+	}
+	var missing []string
+	for path := range p.md5sums {
+		if !listed[path] {
+			missing = append(missing, path)
+		}
+	}
+	sort.Strings(missing)
+	for _, path := range missing {
+		errs = append(errs, fmt.Errorf("md5sums lists /%s, which is not a file of the payload", path))
+	}
+	return errs
+}
+
+// parseMd5sums parses the content of an md5sums control file, as a map from path,
+// without leading slash, to the hex encoded MD5 sum.
+func parseMd5sums(content string) map[string]string {
+	sums := make(map[string]string)
+	for line := range strings.SplitSeq(content, "\n") {
+		sum, path, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		path = strings.TrimPrefix(strings.TrimSpace(path), "./")
+		sums[strings.TrimPrefix(path, "/")] = strings.ToLower(sum)
+	}
+	return sums
+}
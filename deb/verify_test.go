@@ -0,0 +1,75 @@
+package deb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	pkg := &Package{
+		Metadata: Metadata{
+			Package:      "verified",
+			Version:      "1:2.0~rc1-1+b1",
+			Architecture: "all",
+			Maintainer:   "Dev <dev@example.com>",
+			Description:  "a verified package",
+			ExtraFields:  map[string]string{},
+		},
+		Scripts: Scripts{PostInst: "#!/bin/sh\nexit 0\n"},
+		Files:   []File{{DestPath: "/usr/share/verified/data", Mode: 0644, Body: "data"}},
+	}
+	if err := pkg.Verify(); err != nil {
+		t.Errorf("Verify failed on a valid package: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	parsed, err := NewPackage(&buf)
+	if err != nil {
+		t.Fatalf("NewPackage failed: %v", err)
+	}
+	if err := parsed.Verify(); err != nil {
+		t.Errorf("Verify failed on a parsed package: %v", err)
+	}
+	// The md5sums read no longer match a modified payload.
+	parsed.Files[0].Body = "tampered"
+	if err := parsed.Verify(); err == nil || !strings.Contains(err.Error(), "md5sum mismatch for /usr/share/verified/data") {
+		t.Errorf("Verify = %v, want an md5sum mismatch", err)
+	}
+
+	broken := &Package{
+		Metadata: Metadata{Package: "Bad_Name", Version: "v1.0", Architecture: "all"},
+		Scripts:  Scripts{PreRm: "echo no shebang\n"},
+	}
+	err = broken.Verify()
+	if err == nil {
+		t.Fatal("Verify should fail on a broken package")
+	}
+	for _, want := range []string{
+		"missing mandatory field Maintainer",
+		"missing mandatory field Description",
+		`invalid package name "Bad_Name"`,
+		`invalid version "v1.0"`,
+		"maintainer script prerm has no shebang",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Verify error %q should contain %q", err, want)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	for _, v := range []string{"1.0", "1:1.0", "1.0-1", "2.36.1-8+deb12u1", "1.0~rc1", "1.0-1-2", "0"} {
+		if err := checkVersion(v); err != nil {
+			t.Errorf("checkVersion(%q) failed: %v", v, err)
+		}
+	}
+	for _, v := range []string{"", "a1.0", "x:1.0", ":1.0", "1.0-", "1.0-1_2", "1.0 beta", "1:"} {
+		if err := checkVersion(v); err == nil {
+			t.Errorf("checkVersion(%q) should fail", v)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package deb
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed Debian version, [epoch:]upstream_version[-debian_revision], ordered like dpkg:
+// the epochs numerically, then the upstream versions and the revisions, alternately comparing their
+// non-digit parts, where '~' sorts before anything and letters before other characters, and their
+// numeric parts.
+//
+// Reference: https://www.debian.org/doc/debian-policy/ch-controlfields.html#version
+type Version struct {
+	// Epoch is the epoch, 0 if the version has none.
+	Epoch int
+	// Upstream is the upstream version, e.g. "1.2.3~rc1".
+	Upstream string
+	// Revision is the Debian revision, e.g. "1", or "" for a native package.
+	Revision string
+}
+
+// ParseVersion parses a version, e.g. "1:2.0~rc1-3", and checks its syntax.
+func ParseVersion(s string) (Version, error) {
+	if err := checkVersion(s); err != nil {
+		return Version{}, err
+	}
+	epoch, upstream, revision := splitDebianVersion(s)
+	v := Version{Upstream: upstream, Revision: revision}
+	if epoch != "" {
+		n, err := strconv.Atoi(epoch)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid version %q: %w", s, err)
+		}
+		v.Epoch = n
+	}
+	return v, nil
+}
+
+// String returns the version as written in a control file, without a zero epoch.
+func (v Version) String() string {
+	s := v.Upstream
+	if v.Epoch != 0 {
+		s = strconv.Itoa(v.Epoch) + ":" + s
+	}
+	if v.Revision != "" {
+		s += "-" + v.Revision
+	}
+	return s
+}
+
+// Compare returns -1, 0 or +1 when v is older than, equal to, or newer than w.
+// A missing revision is equal to the "0" revision.
+func (v Version) Compare(w Version) int {
+	if c := cmp.Compare(v.Epoch, w.Epoch); c != 0 {
+		return c
+	}
+	if c := compareVersionPart(v.Upstream, w.Upstream); c != 0 {
+		return c
+	}
+	return compareVersionPart(v.Revision, w.Revision)
+}
+
+// Constraint is a version constraint, e.g. ">= 1.2.3-1", as in the relationship fields.
+type Constraint struct {
+	// Operator is "<<", "<=", "=", ">=" or ">>".
+	Operator string
+	// Version is the version the operator compares with.
+	Version Version
+}
+
+// ParseConstraint parses a version constraint, e.g. ">= 1.2.3-1" or "<< 2.0~". A version alone,
+// e.g. "1.0-1", means "=". The obsolete "<" and ">" operators are read as "<=" and ">=".
+func ParseConstraint(s string) (Constraint, error) {
+	s = strings.TrimSpace(s)
+	end := strings.IndexFunc(s, func(c rune) bool { return !strings.ContainsRune("<=>", c) })
+	if end < 0 {
+		end = len(s)
+	}
+	op, err := parseOperator(s[:end])
+	if err != nil {
+		return Constraint{}, fmt.Errorf("invalid constraint %q: %w", s, err)
+	}
+	v, err := ParseVersion(strings.TrimSpace(s[end:]))
+	if err != nil {
+		return Constraint{}, fmt.Errorf("invalid constraint %q: %w", s, err)
+	}
+	return Constraint{op, v}, nil
+}
+
+// parseOperator returns the version operator op, "=" if it is empty.
+func parseOperator(op string) (string, error) {
+	switch op {
+	case "":
+		return "=", nil
+	case "<":
+		return "<=", nil
+	case ">":
+		return ">=", nil
+	case "<<", "<=", "=", ">=", ">>":
+		return op, nil
+	}
+	return "", fmt.Errorf("unknown operator %q", op)
+}
+
+// Matches reports whether version satisfies the constraint. The syntax of version is not checked,
+// see CompareVersions.
+func (c Constraint) Matches(version string) bool {
+	return matchOperator(c.Operator, CompareVersions(version, c.Version.String()))
+}
+
+// String returns the constraint as written in a relationship field, e.g. ">= 1.2.3-1".
+func (c Constraint) String() string {
+	return c.Operator + " " + c.Version.String()
+}
+
+// matchOperator reports whether the result of a version comparison satisfies op.
+func matchOperator(op string, c int) bool {
+	switch op {
+	case "<<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case "=":
+		return c == 0
+	case ">=":
+		return c >= 0
+	case ">>":
+		return c > 0
+	}
+	return false
+}
+
+// BumpLevel is the part of an upstream version incremented by BumpUpstream.
+type BumpLevel string
+
+// The levels of BumpUpstream, like semantic versioning.
+const (
+	BumpMajor BumpLevel = "major"
+	BumpMinor BumpLevel = "minor"
+	BumpPatch BumpLevel = "patch"
+)
+
+// BumpUpstream increments the upstream version of version, made of numbers separated by dots,
+// at level: the lower numbers are reset to zero, the epoch is kept and the revision, if any, is reset
+// to "1", e.g. "1:1.2.3-4" gives "1:1.3.0-1" at BumpMinor. Missing numbers are zero.
+//
+// A pre-release, e.g. "2.0.0~rc2-1" (see BumpPrerelease), is released first: it gives "2.0.0-1"
+// at the levels whose increment it already is, like semantic versioning.
+func BumpUpstream(version string, level BumpLevel) (string, error) {
+	v, numbers, channel, err := parseSemanticVersion(version)
+	if err != nil {
+		return "", err
+	}
+	i := slices.Index([]BumpLevel{BumpMajor, BumpMinor, BumpPatch}, level)
+	if i < 0 {
+		return "", fmt.Errorf("unknown level %q, expected %s, %s or %s", level, BumpMajor, BumpMinor, BumpPatch)
+	}
+	// A pre-release of x.y.0 is released as is at BumpMinor, but not at BumpMajor if y is not 0.
+	if channel == "" || slices.ContainsFunc(numbers[i+1:], func(n int) bool { return n != 0 }) {
+		numbers[i]++
+		clear(numbers[i+1:])
+	}
+	v.Upstream = fmt.Sprintf("%d.%d.%d", numbers[0], numbers[1], numbers[2])
+	v.resetRevision()
+	return v.String(), nil
+}
+
+// BumpPrerelease returns the next pre-release of version in channel, e.g. "rc": the next number of
+// the pre-release if version is already one in channel ("2.0.0~rc1-1" gives "2.0.0~rc2-1"),
+// otherwise the first pre-release of the version bumped at level ("1.2.3-4" gives "2.0.0~rc1-1"
+// at BumpMajor). With '~', the pre-releases sort before the release.
+func BumpPrerelease(version string, level BumpLevel, channel string) (string, error) {
+	if channel == "" || strings.Trim(channel, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return "", fmt.Errorf("invalid pre-release channel %q: it must be lower case letters", channel)
+	}
+	v, numbers, current, err := parseSemanticVersion(version)
+	if err != nil {
+		return "", err
+	}
+	if name, n, ok := splitPrerelease(current); ok && name == channel {
+		v.Upstream = fmt.Sprintf("%d.%d.%d~%s%d", numbers[0], numbers[1], numbers[2], channel, n+1)
+		v.resetRevision()
+		return v.String(), nil
+	}
+	released, err := BumpUpstream(version, level)
+	if err != nil {
+		return "", err
+	}
+	v, _ = ParseVersion(released)
+	v.Upstream += "~" + channel + "1"
+	return v.String(), nil
+}
+
+// resetRevision resets the revision of a new upstream version to "1", unless the version is native.
+func (v *Version) resetRevision() {
+	if v.Revision != "" {
+		v.Revision = "1"
+	}
+}
+
+// parseSemanticVersion parses version, whose upstream version is up to three numbers separated
+// by dots, optionally followed by a pre-release, e.g. "1.2~rc1". It returns the version, the three
+// numbers, and the pre-release without '~'.
+func parseSemanticVersion(version string) (v Version, numbers []int, prerelease string, err error) {
+	if v, err = ParseVersion(version); err != nil {
+		return v, nil, "", err
+	}
+	release, prerelease, _ := strings.Cut(v.Upstream, "~")
+	parts := strings.Split(release, ".")
+	if len(parts) > 3 {
+		return v, nil, "", fmt.Errorf("invalid version %q: the upstream version has more than three numbers", version)
+	}
+	numbers = make([]int, 3)
+	for i, p := range parts {
+		if numbers[i], err = strconv.Atoi(p); err != nil || strings.Trim(p, "0123456789") != "" {
+			return v, nil, "", fmt.Errorf("invalid version %q: the upstream version must be numbers separated by dots", version)
+		}
+	}
+	return v, numbers, prerelease, nil
+}
+
+// splitPrerelease splits a pre-release, e.g. "rc2", into its channel and number.
+func splitPrerelease(prerelease string) (channel string, n int, ok bool) {
+	i := strings.IndexAny(prerelease, "0123456789")
+	if i <= 0 {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(prerelease[i:])
+	return prerelease[:i], n, err == nil
+}
@@ -0,0 +1,202 @@
+package deb
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+		str  string
+	}{
+		{"1.0", Version{Upstream: "1.0"}, "1.0"},
+		{"1:2.0~rc1-3", Version{Epoch: 1, Upstream: "2.0~rc1", Revision: "3"}, "1:2.0~rc1-3"},
+		{"0:1.0-1-2", Version{Upstream: "1.0-1", Revision: "2"}, "1.0-1-2"},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseVersion(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+		if got.String() != tt.str {
+			t.Errorf("ParseVersion(%q).String() = %q, want %q", tt.in, got.String(), tt.str)
+		}
+	}
+	for _, in := range []string{"", "a1.0", "x:1.0", "1.0-", "1.0_1"} {
+		if _, err := ParseVersion(in); err == nil {
+			t.Errorf("ParseVersion(%q) should fail", in)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// In dpkg order.
+	ordered := []string{"1.0~~", "1.0~rc1", "1.0~rc2", "1.0", "1.0-1", "1.0-1ubuntu1", "1.0-2", "1.0-10", "1.0a", "1.0+dfsg", "1.1", "1.10", "1:0.1"}
+	for i, a := range ordered {
+		va, err := ParseVersion(a)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q) failed: %v", a, err)
+		}
+		for j, b := range ordered {
+			vb, _ := ParseVersion(b)
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got := va.Compare(vb); got != want {
+				t.Errorf("Compare(%q, %q) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestPackagesByUpstream(t *testing.T) {
+	repo := &Repository{}
+	for _, v := range []string{"1.0-2", "1.0-10", "1.0-1", "1.0-1ubuntu1", "1.1-1"} {
+		repo.Packages = append(repo.Packages, &Package{Metadata: Metadata{Package: "foo", Version: v, Architecture: "all"}})
+	}
+	var got []string
+	for _, p := range repo.PackagesByUpstream("foo", "1.0", "all") {
+		got = append(got, p.Metadata.Version)
+	}
+	if want := []string{"1.0-10", "1.0-2", "1.0-1ubuntu1", "1.0-1"}; !slices.Equal(got, want) {
+		t.Errorf("PackagesByUpstream = %q, want %q", got, want)
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		constraint, version string
+		want                bool
+	}{
+		{">= 1.2.3-1", "1.2.3-1", true},
+		{">= 1.2.3-1", "1.2.3-0", false},
+		{">=1.2.3-1", "1:0.1", true},
+		{"<< 2.0", "2.0~rc1", true},
+		{"<< 2.0", "2.0", false},
+		{">> 2.0", "2.0-1", true},
+		{"<= 2.0", "2.0", true},
+		{"< 2.0", "2.0", true},
+		{"1.0-1", "1.0-1", true},
+		{"= 1.0", "1.0-1", false},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatalf("ParseConstraint(%q) failed: %v", tt.constraint, err)
+		}
+		if got := c.Matches(tt.version); got != tt.want {
+			t.Errorf("ParseConstraint(%q).Matches(%q) = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+	if c, _ := ParseConstraint("<2.0"); c.String() != "<= 2.0" {
+		t.Errorf("String() = %q, want %q", c.String(), "<= 2.0")
+	}
+	for _, in := range []string{"", ">=", "~= 1.0", ">= a1.0", "=> 1.0"} {
+		if _, err := ParseConstraint(in); err == nil {
+			t.Errorf("ParseConstraint(%q) should fail", in)
+		}
+	}
+}
+
+func TestRepositoryLatest(t *testing.T) {
+	repo := &Repository{}
+	for _, p := range []struct{ version, arch string }{
+		{"1.0-1", "amd64"}, {"1.0~rc1-1", "amd64"}, {"1:0.9-1", "amd64"}, {"2.0-1", "arm64"}, {"1.10-1", "amd64"},
+	} {
+		repo.Packages = append(repo.Packages, &Package{Metadata: Metadata{Package: "foo", Version: p.version, Architecture: p.arch}})
+	}
+	var got []string
+	for _, p := range repo.Versions("foo", "amd64") {
+		got = append(got, p.Metadata.Version)
+	}
+	if want := []string{"1:0.9-1", "1.10-1", "1.0-1", "1.0~rc1-1"}; !slices.Equal(got, want) {
+		t.Errorf("Versions = %q, want %q", got, want)
+	}
+	if latest := repo.Latest("foo", "amd64"); latest == nil || latest.Metadata.Version != "1:0.9-1" {
+		t.Errorf("Latest = %v, want 1:0.9-1", latest)
+	}
+	if n := len(repo.Versions("foo", "")); n != 5 {
+		t.Errorf("Versions of any architecture = %d packages, want 5", n)
+	}
+	if latest := repo.Latest("bar", ""); latest != nil {
+		t.Errorf("Latest of a missing package = %v, want nil", latest)
+	}
+}
+
+func TestBumpUpstream(t *testing.T) {
+	tests := []struct {
+		version string
+		level   BumpLevel
+		want    string
+	}{
+		{"1.2.3-4", BumpMajor, "2.0.0-1"},
+		{"1.2.3-4", BumpMinor, "1.3.0-1"},
+		{"1.2.3-4", BumpPatch, "1.2.4-1"},
+		{"2:1.2-1", BumpPatch, "2:1.2.1-1"},
+		{"1", BumpMinor, "1.1.0"},
+		{"2.0.0~rc2-1", BumpMajor, "2.0.0-1"},
+		{"1.3.0~rc1-1", BumpMinor, "1.3.0-1"},
+		{"1.3.0~rc1-1", BumpMajor, "2.0.0-1"},
+		{"1.3.0~rc1-1", BumpPatch, "1.3.0-1"},
+	}
+	for _, tt := range tests {
+		got, err := BumpUpstream(tt.version, tt.level)
+		if err != nil {
+			t.Errorf("BumpUpstream(%q, %s) failed: %v", tt.version, tt.level, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("BumpUpstream(%q, %s) = %q, want %q", tt.version, tt.level, got, tt.want)
+		}
+	}
+	for _, v := range []string{"1.2.3.4-1", "1.2+dfsg-1", "1.x-1", ""} {
+		if _, err := BumpUpstream(v, BumpPatch); err == nil {
+			t.Errorf("BumpUpstream(%q) should fail", v)
+		}
+	}
+	if _, err := BumpUpstream("1.2-1", "build"); err == nil {
+		t.Error("an unknown level should fail")
+	}
+}
+
+func TestBumpPrerelease(t *testing.T) {
+	tests := []struct {
+		version string
+		level   BumpLevel
+		channel string
+		want    string
+	}{
+		{"1.2.3-4", BumpMinor, "rc", "1.3.0~rc1-1"},
+		{"1.3.0~rc1-1", BumpMinor, "rc", "1.3.0~rc2-1"},
+		{"1.3.0~rc9-2", BumpMajor, "rc", "1.3.0~rc10-1"},
+		{"1.3.0~beta2-1", BumpMinor, "rc", "1.3.0~rc1-1"},
+		{"1:1.2", BumpPatch, "alpha", "1:1.2.1~alpha1"},
+	}
+	for _, tt := range tests {
+		got, err := BumpPrerelease(tt.version, tt.level, tt.channel)
+		if err != nil {
+			t.Errorf("BumpPrerelease(%q, %s, %s) failed: %v", tt.version, tt.level, tt.channel, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("BumpPrerelease(%q, %s, %s) = %q, want %q", tt.version, tt.level, tt.channel, got, tt.want)
+		}
+	}
+	// The pre-releases sort before the release.
+	rc, _ := BumpPrerelease("1.2.3-1", BumpMinor, "rc")
+	release, _ := BumpUpstream(rc, BumpMinor)
+	if CompareVersions("1.2.3-1", rc) >= 0 || CompareVersions(rc, release) >= 0 {
+		t.Errorf("want 1.2.3-1 < %s < %s", rc, release)
+	}
+	for _, channel := range []string{"", "RC", "rc1", "r-c"} {
+		if _, err := BumpPrerelease("1.0-1", BumpMinor, channel); err == nil {
+			t.Errorf("channel %q should fail", channel)
+		}
+	}
+}
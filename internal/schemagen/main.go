@@ -0,0 +1,368 @@
+// Command schemagen generates the JSON Schemas of the manifest files from the manifest package sources.
+//
+// Descriptions are the Go doc comments of the types and fields, properties are the fields
+// with a json tag, and fields tagged `jsonschema:"required"` are required.
+// Types with an UnmarshalJSON method also accept a JSON string, for their first field.
+//
+// Usage:
+//
+//	schemagen -pkg manifest -out manifest -out .
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// roots are the generated schemas: file name, root type, and title.
+var roots = []struct {
+	file, typ, title string
+}{
+	{"repository.schema.json", "Repository", "Repositoryfile"},
+	{"package.schema.json", "Package", "Packagefile"},
+}
+
+type outputs []string
+
+func (o *outputs) String() string     { return strings.Join(*o, ",") }
+func (o *outputs) Set(v string) error { *o = append(*o, v); return nil }
+
+func main() {
+	pkgDir := flag.String("pkg", ".", "directory of the manifest package")
+	var outs outputs
+	flag.Var(&outs, "out", "directory where the schemas are written (repeatable)")
+	flag.Parse()
+
+	g, err := load(*pkgDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range roots {
+		content, err := g.schema(r.typ, r.title)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, dir := range outs {
+			if err := os.WriteFile(filepath.Join(dir, r.file), content, 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+// generator holds the parsed types of the package.
+type generator struct {
+	types     map[string]*ast.TypeSpec
+	docs      map[string]string
+	unmarshal map[string]bool
+}
+
+// load parses the non test Go files of the package in dir.
+func load(dir string) (*generator, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	g := &generator{
+		types:     make(map[string]*ast.TypeSpec),
+		docs:      make(map[string]string),
+		unmarshal: make(map[string]bool),
+	}
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				switch d := decl.(type) {
+				case *ast.GenDecl:
+					for _, spec := range d.Specs {
+						ts, ok := spec.(*ast.TypeSpec)
+						if !ok {
+							continue
+						}
+						g.types[ts.Name.Name] = ts
+						doc := ts.Doc
+						if doc == nil {
+							doc = d.Doc
+						}
+						g.docs[ts.Name.Name] = text(doc)
+					}
+				case *ast.FuncDecl:
+					if d.Recv != nil && d.Name.Name == "UnmarshalJSON" {
+						if star, ok := d.Recv.List[0].Type.(*ast.StarExpr); ok {
+							g.unmarshal[star.X.(*ast.Ident).Name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+// text returns a comment group as a single line.
+func text(c *ast.CommentGroup) string {
+	if c == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(c.Text()), " ")
+}
+
+// schema returns the JSON Schema of the root type.
+func (g *generator) schema(root, title string) ([]byte, error) {
+	defs := &object{}
+	queue := []string{}
+	seen := map[string]bool{root: true}
+	ref := func(name string) string {
+		if name == root {
+			// Recursive reference to the schema itself.
+			return "#"
+		}
+		if !seen[name] {
+			seen[name] = true
+			queue = append(queue, name)
+		}
+		return "#/definitions/" + snake(name)
+	}
+
+	s, err := g.typeSchema(root, ref)
+	if err != nil {
+		return nil, err
+	}
+	top := &object{}
+	top.set("$schema", "http://json-schema.org/draft-07/schema#")
+	top.set("title", title)
+	if doc := g.docs[root]; doc != "" {
+		top.set("description", doc)
+	}
+	for _, e := range s.kvs {
+		if e.key != "description" {
+			top.kvs = append(top.kvs, e)
+		}
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		d, err := g.typeSchema(name, ref)
+		if err != nil {
+			return nil, err
+		}
+		defs.set(snake(name), d)
+	}
+	if len(defs.kvs) > 0 {
+		top.set("definitions", defs)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(top); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// typeSchema returns the schema of a named struct type of the package.
+func (g *generator) typeSchema(name string, ref func(string) string) (*object, error) {
+	ts, ok := g.types[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %s", name)
+	}
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return nil, fmt.Errorf("type %s is not a struct", name)
+	}
+
+	props := &object{}
+	var required []string
+	var first *ast.Field
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 || !f.Names[0].IsExported() {
+			continue
+		}
+		if first == nil {
+			first = f
+		}
+		tag := reflect.StructTag("")
+		if f.Tag != nil {
+			v, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(v)
+		}
+		key, _, _ := strings.Cut(tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		p, err := g.exprSchema(f.Type, ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", name, f.Names[0].Name, err)
+		}
+		if doc := text(f.Doc); doc != "" {
+			p.set("description", doc)
+		}
+		for _, n := range f.Names {
+			props.set(snakeKey(key, n.Name, len(f.Names) > 1), p)
+			if tag.Get("jsonschema") == "required" {
+				required = append(required, snakeKey(key, n.Name, len(f.Names) > 1))
+			}
+		}
+	}
+
+	s := &object{}
+	if len(props.kvs) > 0 {
+		s.set("type", "object")
+		if len(required) > 0 {
+			s.set("required", required)
+		}
+		s.set("additionalProperties", false)
+		s.set("properties", props)
+	}
+	if g.unmarshal[name] {
+		// The type also accepts a string for its first field.
+		alt := &object{}
+		alt.set("type", "string")
+		if first != nil {
+			if doc := text(first.Doc); doc != "" {
+				alt.set("description", doc)
+			}
+		}
+		var other *object
+		if len(props.kvs) > 0 {
+			other = s
+		} else {
+			// Without tagged fields, the other form is the value of the second field (e.g. a list).
+			fields := st.Fields.List
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("type %s: cannot infer its alternative forms", name)
+			}
+			o, err := g.exprSchema(fields[1].Type, ref)
+			if err != nil {
+				return nil, err
+			}
+			if doc := text(fields[1].Doc); doc != "" {
+				o.set("description", doc)
+			}
+			other = o
+		}
+		s = &object{}
+		s.set("oneOf", []*object{alt, other})
+	}
+	if doc := g.docs[name]; doc != "" {
+		s.set("description", doc)
+	}
+	return s, nil
+}
+
+// exprSchema returns the schema of a Go type expression.
+func (g *generator) exprSchema(expr ast.Expr, ref func(string) string) (*object, error) {
+	s := &object{}
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return g.exprSchema(t.X, ref)
+	case *ast.ArrayType:
+		items, err := g.exprSchema(t.Elt, ref)
+		if err != nil {
+			return nil, err
+		}
+		s.set("type", "array")
+		s.set("items", items)
+	case *ast.MapType:
+		values, err := g.exprSchema(t.Value, ref)
+		if err != nil {
+			return nil, err
+		}
+		s.set("type", "object")
+		s.set("additionalProperties", values)
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			s.set("type", "string")
+		case "bool":
+			s.set("type", "boolean")
+		case "int", "int64":
+			s.set("type", "integer")
+		default:
+			if _, ok := g.types[t.Name]; !ok {
+				return nil, fmt.Errorf("unsupported type %s", t.Name)
+			}
+			s.set("$ref", ref(t.Name))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type expression %T", expr)
+	}
+	return s, nil
+}
+
+// snake converts a Go type name to snake case (e.g. "PackageRef" to "package_ref").
+func snake(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// snakeKey returns the property name of a field. Fields declared together share their tag,
+// so their name is derived from the field name instead.
+func snakeKey(key, field string, shared bool) string {
+	if shared {
+		return snake(field)
+	}
+	return key
+}
+
+// object is a JSON object that keeps its keys in insertion order.
+type object struct {
+	kvs []kv
+}
+
+type kv struct {
+	key   string
+	value any
+}
+
+func (o *object) set(key string, value any) {
+	o.kvs = append(o.kvs, kv{key, value})
+}
+
+// MarshalJSON writes the keys in insertion order.
+func (o *object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, e := range o.kvs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(e.key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		var vb bytes.Buffer
+		enc := json.NewEncoder(&vb)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(e.value); err != nil {
+			return nil, err
+		}
+		buf.Write(bytes.TrimSpace(vb.Bytes()))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,31 @@
+package manifest
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// expandArch returns one definition per entry of the Arch overrides, in alphabetical order,
+// merged like a definition extending this one, and built for the entry architecture.
+// Without Arch overrides, it returns the definition itself.
+func (p Package) expandArch() ([]Package, error) {
+	if len(p.Arch) == 0 {
+		return []Package{p}, nil
+	}
+	if _, ok := p.Meta["Architecture"]; ok {
+		return nil, fmt.Errorf("meta.Architecture cannot be used with 'arch', the architectures are its keys")
+	}
+	var variants []Package
+	for _, arch := range slices.Sorted(maps.Keys(p.Arch)) {
+		override := p.Arch[arch]
+		if override.Extends != "" || len(override.Arch) > 0 || len(override.Matrix) > 0 {
+			return nil, fmt.Errorf("arch.%s: 'extends', 'arch' and 'matrix' are not supported in architecture overrides", arch)
+		}
+		base := p
+		base.Arch = nil
+		base.Meta = mergeMaps(p.Meta, map[string]string{"Architecture": arch})
+		variants = append(variants, extend(base, override))
+	}
+	return variants, nil
+}
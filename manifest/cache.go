@@ -0,0 +1,124 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// httpCache stores web resources on disk, keyed by URL and by the request headers and credentials selecting
+// their representation (see fetcher.cacheVary), with their validators (ETag and Last-Modified)
+// so that they can be revalidated instead of downloaded again.
+type httpCache struct {
+	dir string
+	// offline only uses the cache, without any request.
+	offline bool
+}
+
+// cacheEntry is the metadata of a cached resource.
+type cacheEntry struct {
+	URL          string `json:"url"`
+	Resolved     string `json:"resolved,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// SHA256 is the checksum of the content, so that content and metadata of different writes are never mixed.
+	SHA256 string `json:"sha256"`
+}
+
+// paths returns the paths of the metadata and content files of url, requested with vary.
+func (c *httpCache) paths(url, vary string) (meta, body string) {
+	h := sha256.Sum256([]byte(url + "\n" + vary))
+	key := hex.EncodeToString(h[:])
+	return filepath.Join(c.dir, key+".json"), filepath.Join(c.dir, key)
+}
+
+// get returns the cached entry and content of url, requested with vary, or false if it is not cached.
+func (c *httpCache) get(url, vary string) (cacheEntry, []byte, bool) {
+	var e cacheEntry
+	metaPath, bodyPath := c.paths(url, vary)
+	meta, err := os.ReadFile(metaPath)
+	if err != nil || json.Unmarshal(meta, &e) != nil || e.URL != url {
+		return e, nil, false
+	}
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return e, nil, false
+	}
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != e.SHA256 {
+		// The content and the metadata are from different, interrupted or concurrent, writes.
+		return e, nil, false
+	}
+	return e, body, true
+}
+
+// put stores the content of url, requested with vary and fetched from resolved, with the validators
+// of the response header.
+// Both files are written to temporary files before they replace the cached ones, and the metadata has
+// the checksum of the content, so that a partial or concurrent write is never used.
+func (c *httpCache) put(url, vary, resolved string, header http.Header, content []byte) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+	sum := sha256.Sum256(content)
+	meta, err := json.Marshal(cacheEntry{
+		URL:          url,
+		Resolved:     resolved,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		SHA256:       hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return err
+	}
+	bodyTemp, err := c.temp(content)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(bodyTemp)
+	metaTemp, err := c.temp(meta)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(metaTemp)
+	metaPath, bodyPath := c.paths(url, vary)
+	if err := os.Rename(bodyTemp, bodyPath); err != nil {
+		return fmt.Errorf("writing cache: %w", err)
+	}
+	if err := os.Rename(metaTemp, metaPath); err != nil {
+		return fmt.Errorf("writing cache: %w", err)
+	}
+	return nil
+}
+
+// temp writes content to a new temporary file of the cache directory, and returns its path.
+func (c *httpCache) temp(content []byte) (string, error) {
+	f, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("writing cache: %w", err)
+	}
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing cache: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// conditional returns the request headers to revalidate a cached entry.
+func (e cacheEntry) conditional() http.Header {
+	h := make(http.Header)
+	if e.ETag != "" {
+		h.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		h.Set("If-Modified-Since", e.LastModified)
+	}
+	return h
+}
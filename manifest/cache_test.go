@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestHTTPCache(t *testing.T) {
+	c := &httpCache{dir: t.TempDir()}
+	if err := c.put("https://example.com/a", "", "https://example.com/b", http.Header{"Etag": {`"v1"`}}, []byte("json")); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if err := c.put("https://example.com/a", "Accept: octet-stream", "", nil, []byte("binary")); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	e, body, ok := c.get("https://example.com/a", "")
+	if !ok || string(body) != "json" || e.ETag != `"v1"` || e.Resolved != "https://example.com/b" {
+		t.Errorf("get = %+v, %q, %v, want the first entry", e, body, ok)
+	}
+	if _, body, ok := c.get("https://example.com/a", "Accept: octet-stream"); !ok || string(body) != "binary" {
+		t.Errorf("get with another vary = %q, %v, want %q", body, ok, "binary")
+	}
+	if _, _, ok := c.get("https://example.com/c", ""); ok {
+		t.Errorf("get of a missing entry succeeded")
+	}
+
+	// A content that does not match the metadata, e.g. from an interrupted write, is not used.
+	_, bodyPath := c.paths("https://example.com/a", "")
+	if err := os.WriteFile(bodyPath, []byte("other"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := c.get("https://example.com/a", ""); ok {
+		t.Errorf("get of a mismatched content succeeded")
+	}
+}
+
+func TestFetcherCache(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("accept " + r.Header.Get("Accept")))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	f := &fetcher{cache: &httpCache{dir: dir}}
+	fetch := func(f *fetcher, accept string) (string, error) {
+		t.Helper()
+		content, err := f.fetchWith(server.URL, http.Header{"Accept": {accept}})
+		return string(content), err
+	}
+	for range 2 {
+		for _, accept := range []string{"json", "binary"} {
+			if got, err := fetch(f, accept); err != nil || got != "accept "+accept {
+				t.Errorf("fetch(%q) = %q, %v, want %q", accept, got, err, "accept "+accept)
+			}
+		}
+	}
+	if requests != 4 {
+		t.Errorf("%d requests, want 4: 2 downloads, and 2 revalidations", requests)
+	}
+
+	offline := &fetcher{cache: &httpCache{dir: dir, offline: true}}
+	if got, err := fetch(offline, "binary"); err != nil || got != "accept binary" {
+		t.Errorf("offline fetch = %q, %v, want the cached content", got, err)
+	}
+	if _, err := fetch(offline, "text"); !errors.Is(err, errNotCached) {
+		t.Errorf("offline fetch of an uncached resource = %v, want %v", err, errNotCached)
+	}
+	if requests != 4 {
+		t.Errorf("offline fetches sent %d request(s)", requests-4)
+	}
+
+	// Credentials select separate entries.
+	auth := &fetcher{cache: &httpCache{dir: dir, offline: true}, auth: []Auth{{URL: server.URL, Bearer: "token"}}}
+	if _, err := fetch(auth, "binary"); !errors.Is(err, errNotCached) {
+		t.Errorf("offline fetch with other credentials = %v, want %v", err, errNotCached)
+	}
+}
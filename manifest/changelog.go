@@ -0,0 +1,129 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+	"go.yaml.in/yaml/v3"
+)
+
+// Changelog is the changelog shipped by the package.
+// In configuration files, it is either the path to a changelog file in the Debian format,
+// or a list of entries, the most recent first.
+type Changelog struct {
+	// Src is the path to a changelog file (relative to the package definition file) or a web URL.
+	Src string
+	// Entries are the changelog entries, the most recent first.
+	Entries []ChangelogEntry
+}
+
+// ChangelogEntry is a single release in the changelog. All the fields are templates.
+type ChangelogEntry struct {
+	// Version is the version of the release. Defaults to the package version for the first entry.
+	Version string `json:"version" yaml:"version"`
+	// Distribution is the target distribution. Defaults to "unstable".
+	Distribution string `json:"distribution" yaml:"distribution"`
+	// Urgency is the upload urgency. Defaults to "medium".
+	Urgency string `json:"urgency" yaml:"urgency"`
+	// Changes are the change descriptions, one per bullet.
+	Changes []string `json:"changes" yaml:"changes"`
+	// Maintainer is the author of the entry. Defaults to the package maintainer.
+	Maintainer string `json:"maintainer" yaml:"maintainer"`
+	// Date is the date of the release, as "2006-01-02", RFC 3339 or RFC 5322.
+	Date string `json:"date" yaml:"date" jsonschema:"required"`
+}
+
+// UnmarshalJSON accepts either a JSON string or a JSON array of entries.
+func (c *Changelog) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Src); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &c.Entries)
+}
+
+// UnmarshalYAML accepts either a YAML scalar or a YAML sequence of entries.
+func (c *Changelog) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&c.Src)
+	}
+	for _, entry := range node.Content {
+		if err := checkKnownFields(entry, "version", "distribution", "urgency", "changes", "maintainer", "date"); err != nil {
+			return err
+		}
+	}
+	return node.Decode(&c.Entries)
+}
+
+// changelogDateLayouts are the accepted layouts for ChangelogEntry.Date.
+var changelogDateLayouts = []string{"2006-01-02", time.RFC3339, "Mon, 02 Jan 2006 15:04:05 -0700"}
+
+// parseChangelogDate parses a ChangelogEntry.Date.
+func parseChangelogDate(s string) (time.Time, error) {
+	for _, layout := range changelogDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected 2006-01-02, RFC 3339 or RFC 5322", s)
+}
+
+// renderChangelog returns the changelog content in the Debian format.
+func (p *Package) renderChangelog(pkg *deb.Package) (string, error) {
+	c := p.Changelog
+	if c.Src != "" {
+		src, err := p.engine.render("changelog", c.Src)
+		if err != nil {
+			return "", err
+		}
+		return p.loadResource(src, false, "")
+	}
+
+	entries := make([]deb.ChangelogEntry, len(c.Entries))
+	for i, e := range c.Entries {
+		name := fmt.Sprintf("changelog[%d]", i)
+		render := func(field, text string) (string, error) { return p.engine.render(name+"."+field, text) }
+		var err error
+		entry := &entries[i]
+		for _, f := range []struct {
+			field string
+			text  string
+			dst   *string
+		}{
+			{"version", e.Version, &entry.Version},
+			{"distribution", e.Distribution, &entry.Distribution},
+			{"urgency", e.Urgency, &entry.Urgency},
+			{"maintainer", e.Maintainer, &entry.Maintainer},
+		} {
+			if *f.dst, err = render(f.field, f.text); err != nil {
+				return "", err
+			}
+		}
+		for j, change := range e.Changes {
+			val, err := render(fmt.Sprintf("changes[%d]", j), change)
+			if err != nil {
+				return "", err
+			}
+			entry.Changes = append(entry.Changes, val)
+		}
+		date, err := render("date", e.Date)
+		if err != nil {
+			return "", err
+		}
+		if entry.Date, err = parseChangelogDate(date); err != nil {
+			return "", fmt.Errorf("%s.date: %w", name, err)
+		}
+
+		if entry.Version == "" && i == 0 {
+			entry.Version = pkg.Metadata.Version
+		}
+		if entry.Version == "" {
+			return "", fmt.Errorf("%s.version is required", name)
+		}
+		if entry.Maintainer == "" {
+			entry.Maintainer = pkg.Metadata.Maintainer
+		}
+	}
+	return deb.FormatChangelog(pkg.Metadata.Package, entries), nil
+}
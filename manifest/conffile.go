@@ -0,0 +1,79 @@
+package manifest
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Conffile policies decide how a configuration file is handled on upgrade and removal,
+// beyond the dpkg conffile behavior (File.Conffile), where dpkg prompts when both the user
+// and the package changed the file, and removes it on purge.
+const (
+	// ConffilePolicyKeepUserChanges installs the file only if it does not exist yet: it is never
+	// updated afterwards, and user changes are kept without prompting, even after a purge.
+	// The package ships the file in /usr/share/<Package>/defaults, and postinst copies it.
+	ConffilePolicyKeepUserChanges = "keep-user-changes"
+	// ConffilePolicyRemoveOnPurge is like ConffilePolicyKeepUserChanges, but the file is removed on purge.
+	ConffilePolicyRemoveOnPurge = "remove-on-purge"
+	// ConffilePolicyForceOverwrite replaces the file on every upgrade, without prompting.
+	// The file is not a conffile: user changes are lost.
+	ConffilePolicyForceOverwrite = "force-overwrite"
+)
+
+// checkConffilePolicy checks that policy is a known conffile policy.
+func checkConffilePolicy(policy string) error {
+	switch policy {
+	case "", ConffilePolicyKeepUserChanges, ConffilePolicyRemoveOnPurge, ConffilePolicyForceOverwrite:
+		return nil
+	}
+	return fmt.Errorf("unknown conffile policy %q, expected one of %s, %s, %s", policy, ConffilePolicyKeepUserChanges, ConffilePolicyRemoveOnPurge, ConffilePolicyForceOverwrite)
+}
+
+// checkConffile checks the conffile fields of a file of a package, a udeb or not:
+// an injected file if inject is true, or a maintainer script or control file.
+func (f File) checkConffile(inject, udeb bool) error {
+	switch {
+	case udeb && (f.Conffile || f.ConffilePolicy != ""):
+		return fmt.Errorf("udebs cannot have conffiles")
+	case f.ConffilePolicy == "":
+		return nil
+	case !inject || f.Link != "" || f.Dir:
+		return fmt.Errorf("'conffile_policy' is only supported in injects files")
+	case f.Conffile:
+		return fmt.Errorf("'conffile' and 'conffile_policy' cannot be used together")
+	}
+	return checkConffilePolicy(f.ConffilePolicy)
+}
+
+// defaultsPath returns where a file with the keep-user-changes or remove-on-purge policy is shipped.
+func defaultsPath(pkg, dst string) string {
+	return path.Join("/usr/share", pkg, "defaults", dst)
+}
+
+// applyConffilePolicy adds to pkg the maintainer scripts snippets of a file installed at dst.
+func applyConffilePolicy(pkg *deb.Package, policy, dst string) error {
+	if policy != ConffilePolicyKeepUserChanges && policy != ConffilePolicyRemoveOnPurge {
+		return nil
+	}
+	if strings.Contains(dst, "'") {
+		return fmt.Errorf("invalid conffile path %q", dst)
+	}
+	postinst := fmt.Sprintf(`if [ "$1" = "configure" ] && [ ! -e '%[1]s' ]; then
+	mkdir -p '%[2]s'
+	cp -p '%[3]s' '%[1]s'
+fi
+`, dst, path.Dir(dst), defaultsPath(pkg.Metadata.Package, dst))
+	pkg.Scripts.PostInst = deb.AppendSnippet(pkg.Scripts.PostInst, postinst)
+
+	if policy == ConffilePolicyRemoveOnPurge {
+		postrm := fmt.Sprintf(`if [ "$1" = "purge" ]; then
+	rm -f '%s'
+fi
+`, dst)
+		pkg.Scripts.PostRm = deb.AppendSnippet(pkg.Scripts.PostRm, postrm)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package manifest
+
+import (
+	"fmt"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Copyright is the machine-readable copyright file (DEP-5) shipped by the package, installed as
+// /usr/share/doc/<Package>/copyright. All the fields are templates.
+type Copyright struct {
+	// UpstreamName is the name upstream uses for the software. Defaults to the package name.
+	UpstreamName string `json:"upstream_name" yaml:"upstream_name"`
+	// UpstreamContact is the preferred address to reach upstream, e.g. "Name <email>" or a URL.
+	UpstreamContact string `json:"upstream_contact" yaml:"upstream_contact"`
+	// Source is where the upstream source comes from, typically a URL.
+	Source string `json:"source" yaml:"source"`
+	// Files are the copyright and license of the files, the most general first.
+	Files []CopyrightFiles `json:"files" yaml:"files" jsonschema:"required"`
+	// Licenses are the texts of the licenses referenced by Files. MIT, ISC, BSD-2-Clause, BSD-3-Clause
+	// and the licenses of /usr/share/common-licenses (Apache-2.0, GPL-3.0-or-later...) are built in.
+	Licenses []License `json:"licenses" yaml:"licenses"`
+}
+
+// CopyrightFiles is the copyright and license of a set of files.
+type CopyrightFiles struct {
+	// Files are the file name patterns. Defaults to "*".
+	Files []string `json:"files" yaml:"files"`
+	// Copyright are the copyright holders, e.g. "2024 Jane Doe <jane@example.com>".
+	Copyright []string `json:"copyright" yaml:"copyright" jsonschema:"required"`
+	// License is the SPDX identifier of the license, e.g. "MIT" or "Apache-2.0".
+	License string `json:"license" yaml:"license" jsonschema:"required"`
+	// Comment is an optional comment.
+	Comment string `json:"comment" yaml:"comment"`
+}
+
+// License is the text of a license that has no built-in text.
+type License struct {
+	// Name is the license name, as referenced by the files license.
+	Name string `json:"name" yaml:"name" jsonschema:"required"`
+	// Src is the path to the license text file (relative to the package definition file) or a web URL.
+	// The file is not templated.
+	Src string `json:"src" yaml:"src"`
+	// Text is the license text, if Src is not set.
+	Text string `json:"text" yaml:"text"`
+}
+
+// renderCopyright returns the copyright of the package.
+func (p *Package) renderCopyright(pkg *deb.Package) (deb.Copyright, error) {
+	c := p.Copyright
+	var out deb.Copyright
+	var err error
+	for _, f := range []struct {
+		field, text string
+		dst         *string
+	}{
+		{"upstream_name", c.UpstreamName, &out.UpstreamName},
+		{"upstream_contact", c.UpstreamContact, &out.UpstreamContact},
+		{"source", c.Source, &out.Source},
+	} {
+		if *f.dst, err = p.engine.render("copyright."+f.field, f.text); err != nil {
+			return out, err
+		}
+	}
+	if out.UpstreamName == "" {
+		out.UpstreamName = pkg.Metadata.Package
+	}
+
+	renderAll := func(name string, texts []string) ([]string, error) {
+		var values []string
+		for i, text := range texts {
+			v, err := p.engine.render(fmt.Sprintf("%s[%d]", name, i), text)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	for i, f := range c.Files {
+		name := fmt.Sprintf("copyright.files[%d]", i)
+		files := deb.CopyrightFiles{}
+		if files.Patterns, err = renderAll(name+".files", f.Files); err != nil {
+			return out, err
+		}
+		if len(files.Patterns) == 0 {
+			files.Patterns = []string{"*"}
+		}
+		if len(f.Copyright) == 0 {
+			return out, fmt.Errorf("%s.copyright is required", name)
+		}
+		if files.Copyright, err = renderAll(name+".copyright", f.Copyright); err != nil {
+			return out, err
+		}
+		if files.License, err = p.engine.render(name+".license", f.License); err != nil {
+			return out, err
+		}
+		if files.Comment, err = p.engine.render(name+".comment", f.Comment); err != nil {
+			return out, err
+		}
+		out.Files = append(out.Files, files)
+	}
+
+	for i, l := range c.Licenses {
+		name := fmt.Sprintf("copyright.licenses[%d]", i)
+		license := deb.License{}
+		if license.Name, err = p.engine.render(name+".name", l.Name); err != nil {
+			return out, err
+		}
+		switch {
+		case l.Src != "" && l.Text != "":
+			return out, fmt.Errorf("%s: 'src' and 'text' cannot be used together", name)
+		case l.Src == "" && l.Text == "":
+			return out, fmt.Errorf("%s: 'src' or 'text' is required", name)
+		}
+		if l.Src != "" {
+			src, err := p.engine.render(name+".src", l.Src)
+			if err != nil {
+				return out, err
+			}
+			if license.Text, err = p.loadResource(src, true, ""); err != nil {
+				return out, err
+			}
+		} else if license.Text, err = p.engine.render(name+".text", l.Text); err != nil {
+			return out, err
+		}
+		out.Licenses = append(out.Licenses, license)
+	}
+	return out, nil
+}
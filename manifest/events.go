@@ -3,6 +3,10 @@ package manifest
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
 )
 
 // Listener is a callback function that receives events during the build process.
@@ -15,6 +19,17 @@ func jsonString(v interface{}) string {
 	return string(b)
 }
 
+// syncListener returns a listener that can be called from several goroutines,
+// and calls l with one event at a time.
+func syncListener(l Listener) Listener {
+	var mu sync.Mutex
+	return func(e fmt.Stringer) {
+		mu.Lock()
+		defer mu.Unlock()
+		l(e)
+	}
+}
+
 // EventRepositoryLoadSuccess is emitted when the repository is successfully loaded.
 type EventRepositoryLoadSuccess struct {
 	Path string `json:"path,omitempty"`
@@ -35,6 +50,8 @@ func (e EventPackageApplySuccess) String() string { return jsonString(e) }
 // EventRepositorySaveSuccess is emitted when the repository is successfully saved.
 type EventRepositorySaveSuccess struct {
 	Path string `json:"path,omitempty"`
+	// Duration is the time spent compiling the repository, from loading to saving.
+	Duration time.Duration `json:"duration,omitempty"`
 }
 
 func (e EventRepositorySaveSuccess) String() string { return jsonString(e) }
@@ -56,6 +73,139 @@ type EventFileOperation struct {
 	NewDigest string `json:"new_digest,omitempty"`
 	Created   bool   `json:"created,omitempty"`
 	Updated   bool   `json:"updated,omitempty"`
+	// Size is the size of the file, in bytes.
+	Size int64 `json:"size,omitempty"`
 }
 
 func (e EventFileOperation) String() string { return jsonString(e) }
+
+// EventPackageBuildStarted is emitted when the build of a package definition starts.
+// Packages are built concurrently, so events of several builds can interleave.
+type EventPackageBuildStarted struct {
+	FilePath string `json:"file_path,omitempty"`
+}
+
+func (e EventPackageBuildStarted) String() string { return jsonString(e) }
+
+// EventPackageBuildFinished is emitted when the build of a package definition ends, successfully or not.
+type EventPackageBuildFinished struct {
+	FilePath     string `json:"file_path,omitempty"`
+	Package      string `json:"package,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	// Files is the number of files in the package.
+	Files int `json:"files,omitempty"`
+	// InstalledSize is the total size of the package files, in bytes.
+	InstalledSize int64 `json:"installed_size,omitempty"`
+	// Duration is the time spent building the package.
+	Duration time.Duration `json:"duration,omitempty"`
+	// Error is the build error message, if the build failed.
+	Error string `json:"error,omitempty"`
+}
+
+func (e EventPackageBuildFinished) String() string { return jsonString(e) }
+
+// EventPackageLint is emitted for each finding of deb.Lint on a built package (see CompileOptions.Lint).
+type EventPackageLint struct {
+	FilePath string `json:"file_path,omitempty"`
+	Package  string `json:"package,omitempty"`
+	// Severity is "error" or "warning".
+	Severity string `json:"severity,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	// Path is the payload file or the control file concerned, if any.
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e EventPackageLint) String() string { return jsonString(e) }
+
+// EventPackageFailure is emitted when a package fails and the compilation continues without it
+// (see CompileOptions.ContinueOnError).
+type EventPackageFailure struct {
+	FilePath string `json:"file_path,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (e EventPackageFailure) String() string { return jsonString(e) }
+
+// EventResourceFetched is emitted when a web resource has been downloaded, or read from the cache.
+type EventResourceFetched struct {
+	URL string `json:"url,omitempty"`
+	// Size is the size of the downloaded content, in bytes.
+	Size int64 `json:"size,omitempty"`
+	// Duration is the time spent downloading the resource.
+	Duration time.Duration `json:"duration,omitempty"`
+	// Cached reports that the content comes from the cache (revalidated, or offline).
+	Cached bool `json:"cached,omitempty"`
+}
+
+func (e EventResourceFetched) String() string { return jsonString(e) }
+
+// EventPackageOutput is emitted when a package file is written (or would be, in plan mode)
+// to its output directory, or skipped because it is up to date.
+type EventPackageOutput struct {
+	FilePath string `json:"file_path,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Created  bool   `json:"created,omitempty"`
+	Updated  bool   `json:"updated,omitempty"`
+}
+
+func (e EventPackageOutput) String() string { return jsonString(e) }
+
+// EventUpstreamImport is emitted when a package is imported from an upstream repository.
+type EventUpstreamImport struct {
+	URL          string `json:"url,omitempty"`
+	Package      string `json:"package,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+}
+
+func (e EventUpstreamImport) String() string { return jsonString(e) }
+
+// EventPackagePrune is emitted when the retention removes a package from the repository,
+// or would remove it, in plan mode.
+type EventPackagePrune struct {
+	Package      string `json:"package,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	// Path is the package file, deleted once the repository is saved.
+	Path string `json:"path,omitempty"`
+	// Reason explains why the package is removed (e.g. "more than 3 versions").
+	Reason string `json:"reason,omitempty"`
+}
+
+func (e EventPackagePrune) String() string { return jsonString(e) }
+
+// Actions reported by EventPackagePlan.
+const (
+	// PlanAdd is a package that is not yet in the repository, in any version.
+	PlanAdd = "add"
+	// PlanBump is a new version of a package already in the repository.
+	PlanBump = "bump"
+	// PlanUnchanged is a package already in the repository with the same content.
+	PlanUnchanged = "unchanged"
+)
+
+// EventPackagePlan is emitted in plan mode to describe what the build does with a package.
+type EventPackagePlan struct {
+	FilePath     string `json:"file_path,omitempty"`
+	Package      string `json:"package,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	// Action is one of PlanAdd, PlanBump or PlanUnchanged.
+	Action string `json:"action,omitempty"`
+	// Previous is the version already in the repository, for a PlanBump.
+	Previous string `json:"previous,omitempty"`
+	// Changes describes the differences with the Previous version (see deb.Diff).
+	Changes []string `json:"changes,omitempty"`
+}
+
+func (e EventPackagePlan) String() string { return jsonString(e) }
+
+// EventProgress is emitted as the package files and indices are generated and written,
+// and as web resources are downloaded. It is emitted often: a display only needs the last one.
+type EventProgress struct {
+	deb.Progress
+}
+
+func (e EventProgress) String() string { return jsonString(e) }
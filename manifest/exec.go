@@ -0,0 +1,110 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// loadFile returns the content of a file entry, checked with File.check: its Src resource, or the
+// output of its Exec command. In ModeValidate, the command is only looked up, and the content is empty.
+// name is the entry name in error messages (e.g. "injects[0]"), src and sum are the rendered Src and SHA256.
+func (p *Package) loadFile(name string, f File, src, sum string) (string, error) {
+	if err := checkSHA256Syntax(name+".sha256", sum); err != nil {
+		return "", err
+	}
+	opts, err := f.textOptions()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	if len(f.Exec) == 0 {
+		content, err := p.loadResource(src, true, sum)
+		if err != nil || f.Raw {
+			return content, err
+		}
+		return p.engine.renderText(src, content, opts)
+	}
+	if p.mode == ModeValidate {
+		return "", p.checkExec(name, f.Exec)
+	}
+	if !p.allowExec {
+		return "", fmt.Errorf("%s.exec: running commands is not allowed (see CompileOptions.AllowExec, or the deb-pm -allow-exec flag)", name)
+	}
+	args, err := p.renderExec(name, f.Exec)
+	if err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(p.fetcher.context(), args[0], args[1:]...)
+	cmd.Dir = p.resolve(".")
+	p.fetcher.logger().Debug("Running command", "file", name, "command", args, "dir", cmd.Dir)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s.exec: command %s failed: %w\n%s", name, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	content := stdout.Bytes()
+	if sum != "" {
+		if err := verifySHA256(name+".exec", content, sum); err != nil {
+			return "", err
+		}
+	}
+	if f.Raw {
+		return string(content), nil
+	}
+	return p.engine.renderText(name+".exec", string(content), opts)
+}
+
+// textOptions returns the template options of the file content.
+func (f File) textOptions() (textOptions, error) {
+	var o textOptions
+	switch len(f.Delims) {
+	case 0:
+	case 2:
+		if f.Delims[0] == "" || f.Delims[1] == "" {
+			return o, fmt.Errorf("'delims' cannot be empty")
+		}
+		o.left, o.right = f.Delims[0], f.Delims[1]
+	default:
+		return o, fmt.Errorf("'delims' must be a left and a right delimiter, got %d values", len(f.Delims))
+	}
+	switch f.MissingKey {
+	case "", "error", "zero", "default":
+		o.missingKey = f.MissingKey
+	default:
+		return o, fmt.Errorf("unknown 'missing_key' %q, expected one of error, zero, default", f.MissingKey)
+	}
+	return o, nil
+}
+
+// renderExec renders the command and arguments of a file entry.
+func (p *Package) renderExec(name string, command []string) ([]string, error) {
+	args := make([]string, len(command))
+	for i, arg := range command {
+		val, err := p.engine.render(fmt.Sprintf("%s.exec[%d]", name, i), arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = val
+	}
+	if args[0] == "" {
+		return nil, fmt.Errorf("%s.exec: the command is empty", name)
+	}
+	return args, nil
+}
+
+// checkExec checks that the command of a file entry is allowed and can be found, without running it.
+func (p *Package) checkExec(name string, command []string) error {
+	if !p.allowExec {
+		return fmt.Errorf("%s.exec: running commands is not allowed (see CompileOptions.AllowExec, or the deb-pm -allow-exec flag)", name)
+	}
+	args, err := p.renderExec(name, command)
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath(args[0]); err != nil && !strings.Contains(args[0], "/") {
+		return fmt.Errorf("%s.exec: %w", name, err)
+	}
+	return nil
+}
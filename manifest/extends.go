@@ -0,0 +1,157 @@
+package manifest
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// loadExtends returns pkg, loaded from path, merged with the definitions it extends.
+// seen holds the definitions already loaded, to detect cycles.
+func (a *Repository) loadExtends(path string, pkg Package, seen []string) (Package, error) {
+	if pkg.Extends == "" {
+		return pkg, nil
+	}
+	ext, err := a.engine.render("extends", pkg.Extends)
+	if err != nil {
+		return pkg, fmt.Errorf("rendering extends: %w", err)
+	}
+	basePath := relativeTo(path, ext)
+	if !isURL(basePath) && a.fsys == nil {
+		// Base paths are rebased on an absolute path, so that they resolve from the extending file too.
+		if basePath, err = filepath.Abs(basePath); err != nil {
+			return pkg, err
+		}
+	}
+	if slices.Contains(seen, basePath) {
+		return pkg, fmt.Errorf("cycle in extends: %s -> %s", strings.Join(seen, " -> "), basePath)
+	}
+
+	var content []byte
+	if isURL(basePath) {
+		content, err = a.fetcher.fetch(basePath)
+	} else {
+		content, err = readLocal(a.fsys, basePath)
+	}
+	if err != nil {
+		return pkg, fmt.Errorf("reading base definition %s: %w", basePath, err)
+	}
+	var base Package
+	if err := unmarshal(basePath, content, &base); err != nil {
+		return pkg, fmt.Errorf("parsing base definition %s: %w", basePath, err)
+	}
+	if err := checkRemoteHooks(basePath, base); err != nil {
+		return pkg, err
+	}
+	base.rebase(basePath)
+	base, err = a.loadExtends(basePath, base, append(seen, basePath))
+	if err != nil {
+		return pkg, err
+	}
+	return extend(base, pkg), nil
+}
+
+// relativeTo resolves path relatively to the definition file at from, a file path or a web URL.
+// Absolute paths and URLs are returned as-is.
+func relativeTo(from, path string) string {
+	if path == "" || filepath.IsAbs(path) || isURL(path) || IsGitHubAsset(path) {
+		return path
+	}
+	if isURL(from) {
+		base, err := url.Parse(from)
+		if err != nil {
+			return path
+		}
+		ref, err := url.Parse(filepath.ToSlash(path))
+		if err != nil {
+			return path
+		}
+		return base.ResolveReference(ref).String()
+	}
+	return filepath.Join(filepath.Dir(from), path)
+}
+
+// rebase makes the relative source paths of a base definition relative to its own file, at path,
+// so that they still resolve once merged into another definition.
+// Paths starting with a template action are left unchanged, they are resolved relatively to the extending file.
+func (p *Package) rebase(path string) {
+	fix := func(src *string) {
+		if !strings.HasPrefix(*src, "{{") {
+			*src = relativeTo(path, *src)
+		}
+	}
+	fix(&p.Input)
+	fix(&p.Output)
+	for _, files := range [][]File{p.Injects, p.Scripts, p.ControlFiles} {
+		for i := range files {
+			fix(&files[i].Src)
+		}
+	}
+	if p.Service != nil {
+		fix(&p.Service.Src)
+	}
+	if p.Changelog != nil {
+		fix(&p.Changelog.Src)
+	}
+	if p.Copyright != nil {
+		for i := range p.Copyright.Licenses {
+			if p.Copyright.Licenses[i].Src != "" {
+				fix(&p.Copyright.Licenses[i].Src)
+			}
+		}
+	}
+}
+
+// extend returns the child definition merged with its base definition.
+// Defines and meta are merged (the child wins), injects, scripts and control files are appended,
+// replacing the base entries with the same destination, and images and hooks are appended.
+// Other fields of the child replace the base ones when they are set.
+func extend(base, child Package) Package {
+	merged := child
+	merged.Extends = ""
+	merged.Input = cmp.Or(child.Input, base.Input)
+	merged.InputSHA256 = cmp.Or(child.InputSHA256, base.InputSHA256)
+	merged.Defines = mergeMaps(base.Defines, child.Defines)
+	merged.Meta = mergeMaps(base.Meta, child.Meta)
+	if child.Matrix == nil {
+		merged.Matrix = base.Matrix
+	}
+	merged.When = cmp.Or(child.When, base.When)
+	merged.Remove = append(slices.Clone(base.Remove), child.Remove...)
+	merged.Rename = mergeMaps(base.Rename, child.Rename)
+	merged.Injects = mergeFiles(base.Injects, child.Injects)
+	merged.Images = append(slices.Clone(base.Images), child.Images...)
+	merged.Scripts = mergeFiles(base.Scripts, child.Scripts)
+	merged.ControlFiles = mergeFiles(base.ControlFiles, child.ControlFiles)
+	merged.Service = cmp.Or(child.Service, base.Service)
+	merged.Changelog = cmp.Or(child.Changelog, base.Changelog)
+	merged.Copyright = cmp.Or(child.Copyright, base.Copyright)
+	merged.Strategy = cmp.Or(child.Strategy, base.Strategy)
+	merged.Compression = cmp.Or(child.Compression, base.Compression)
+	merged.Udeb = child.Udeb || base.Udeb
+	merged.Output = cmp.Or(child.Output, base.Output)
+	merged.Before = append(slices.Clone(base.Before), child.Before...)
+	merged.After = append(slices.Clone(base.After), child.After...)
+	return merged
+}
+
+// mergeFiles returns the base files, where the entries with the same Dst as an override entry are replaced,
+// followed by the other override entries.
+func mergeFiles(base, override []File) []File {
+	if len(base) == 0 {
+		return override
+	}
+	merged := slices.Clone(base)
+	for _, f := range override {
+		i := slices.IndexFunc(merged[:len(base)], func(b File) bool { return b.Dst == f.Dst })
+		if i >= 0 {
+			merged[i] = f
+			continue
+		}
+		merged = append(merged, f)
+	}
+	return merged
+}
@@ -0,0 +1,35 @@
+package manifest
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestExtendImages(t *testing.T) {
+	const image = "images:\n  - ref: alpine:3.20\n    paths:\n      - src: /bin/busybox\n"
+	fsys := fstest.MapFS{
+		"repository.yml": {Data: []byte("path: repo\npackages:\n  - app.yml\n  - variant.yml\n")},
+		"app.yml":        {Data: []byte("meta:\n  Package: app\n  Version: \"1.0\"\narch:\n  amd64: {}\n  arm64: {}\n" + image)},
+		"base.yml":       {Data: []byte(image)},
+		"variant.yml":    {Data: []byte("extends: base.yml\nmeta:\n  Package: variant\n  Version: \"1.0\"\n  Architecture: all\nimages:\n  - ref: busybox\n    paths:\n      - src: /bin/sh\n")},
+	}
+	a, err := NewRepositoryFromFS(fsys, "repository.yml", nil)
+	if err != nil {
+		t.Fatalf("NewRepositoryFromFS failed: %v", err)
+	}
+	pkgs, err := a.LoadPackages()
+	if err != nil {
+		t.Fatalf("LoadPackages failed: %v", err)
+	}
+	if len(pkgs) != 3 {
+		t.Fatalf("LoadPackages returned %d packages, want 3", len(pkgs))
+	}
+	for _, p := range pkgs[:2] {
+		if len(p.Images) != 1 || p.Images[0].Ref != "alpine:3.20" {
+			t.Errorf("arch %s: images = %v, want the base image", p.Meta["Architecture"], p.Images)
+		}
+	}
+	if images := pkgs[2].Images; len(images) != 2 || images[0].Ref != "alpine:3.20" || images[1].Ref != "busybox" {
+		t.Errorf("extends: images = %v, want the base image then the child one", images)
+	}
+}
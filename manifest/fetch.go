@@ -0,0 +1,444 @@
+package manifest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Auth configures the credentials sent when fetching web resources.
+// All the fields are templates, so credentials can come from env or secret.
+type Auth struct {
+	// URL is the prefix of the resource URLs the credentials apply to (e.g. "https://github.com/org/"):
+	// they must have the same scheme and host, and a path below its path, by whole path segments.
+	// When several entries match, the longest prefix wins.
+	URL string `json:"url" yaml:"url" jsonschema:"required"`
+	// Bearer is a token sent in an "Authorization: Bearer" header.
+	Bearer string `json:"bearer" yaml:"bearer"`
+	// Username and Password are sent using HTTP basic authentication.
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	// Headers are additional request headers (e.g. "Accept: application/octet-stream").
+	Headers map[string]string `json:"headers" yaml:"headers"`
+}
+
+// fetcher downloads web resources, with the credentials configured for their URL.
+// A nil fetcher downloads without credentials.
+type fetcher struct {
+	auth []Auth
+	// l receives an EventResourceFetched for every download. It can be nil.
+	l Listener
+	// cache stores the downloaded resources. It can be nil.
+	cache *httpCache
+	// lock records the fetched resources. It can be nil.
+	lock *resourceLock
+	// ctx cancels the requests, and the commands. It can be nil.
+	ctx context.Context
+	// log receives the diagnostics of the requests and commands. It can be nil.
+	log *slog.Logger
+	// limits bounds the size of the resources, and of the packages and indices parsed from them.
+	limits deb.Limits
+	// fsys is the file system of the local resources, nil for the OS one.
+	fsys fs.FS
+}
+
+// statusError is the error of a response with an unexpected status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return e.status }
+
+// errNotCached is the error of a resource that is not in the cache in offline mode.
+var errNotCached = errors.New("not in the cache (offline mode)")
+
+// isNotFound reports whether err is the error of a missing resource: a 404 response, or a resource
+// that is not in the cache in offline mode.
+func isNotFound(err error) bool {
+	var s *statusError
+	return errors.Is(err, errNotCached) || errors.As(err, &s) && s.code == http.StatusNotFound
+}
+
+// context returns the context of the requests and commands.
+func (f *fetcher) context() context.Context {
+	if f == nil || f.ctx == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
+// logger returns the logger of the diagnostics, discarding them if none is set.
+func (f *fetcher) logger() *slog.Logger {
+	if f == nil || f.log == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return f.log
+}
+
+// parseLimits returns the limits of the resources, and of the packages and indices parsed from them.
+func (f *fetcher) parseLimits() deb.Limits {
+	if f == nil {
+		return deb.Limits{}
+	}
+	return f.limits
+}
+
+// limitReader returns a reader of r, the content of name, failing with a deb.LimitError
+// after the MaxBytes limit of the fetcher.
+func (f *fetcher) limitReader(name string, r io.Reader) io.Reader {
+	max := cmp.Or(f.parseLimits().MaxBytes, deb.DefaultLimits.MaxBytes)
+	return &limitedReader{r: io.LimitReader(r, max+1), name: name, max: max}
+}
+
+// limitedReader is a reader of at most max bytes, and one more to detect the overflow.
+type limitedReader struct {
+	r    io.Reader
+	name string
+	max  int64
+	n    int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, &deb.LimitError{Limit: "MaxBytes", Max: l.max, Name: l.name}
+	}
+	return n, err
+}
+
+// readFile returns the content of the local resource at path, within the MaxBytes limit.
+func (f *fetcher) readFile(path string) ([]byte, error) {
+	var fsys fs.FS
+	if f != nil {
+		fsys = f.fsys
+	}
+	file, err := openLocal(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(f.limitReader(path, file))
+}
+
+// openLocal opens the local file at path, from fsys, or from the OS file system if fsys is nil.
+// Paths of fsys are relative to its root, and use the OS separator like the ones of the OS file system.
+func openLocal(fsys fs.FS, path string) (fs.File, error) {
+	if fsys == nil {
+		return os.Open(path)
+	}
+	return fsys.Open(filepath.ToSlash(filepath.Clean(path)))
+}
+
+// readLocal returns the content of the local file at path, see openLocal.
+func readLocal(fsys fs.FS, path string) ([]byte, error) {
+	file, err := openLocal(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// newFetcher renders the auth entries and returns a fetcher using them.
+func newFetcher(e *templateEngine, auth []Auth) (*fetcher, error) {
+	f := &fetcher{}
+	for i, a := range auth {
+		name := fmt.Sprintf("auth[%d]", i)
+		var rendered Auth
+		for _, field := range []struct {
+			name string
+			text string
+			dst  *string
+		}{
+			{"url", a.URL, &rendered.URL},
+			{"bearer", a.Bearer, &rendered.Bearer},
+			{"username", a.Username, &rendered.Username},
+			{"password", a.Password, &rendered.Password},
+		} {
+			val, err := e.render(name+"."+field.name, field.text)
+			if err != nil {
+				return nil, err
+			}
+			*field.dst = val
+		}
+		if rendered.URL == "" {
+			return nil, fmt.Errorf("%s.url is required", name)
+		}
+		if u, err := url.Parse(rendered.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("%s.url %q must be an absolute URL, e.g. https://host/path", name, rendered.URL)
+		}
+		if rendered.Bearer != "" && rendered.Username != "" {
+			return nil, fmt.Errorf("%s: 'bearer' and 'username' cannot be used together", name)
+		}
+		for _, k := range slices.Sorted(maps.Keys(a.Headers)) {
+			val, err := e.render(name+".headers."+k, a.Headers[k])
+			if err != nil {
+				return nil, err
+			}
+			if rendered.Headers == nil {
+				rendered.Headers = make(map[string]string)
+			}
+			rendered.Headers[k] = val
+		}
+		f.auth = append(f.auth, rendered)
+	}
+	return f, nil
+}
+
+// match returns the auth entry with the longest URL prefix of rawURL, or nil.
+func (f *fetcher) match(rawURL string) *Auth {
+	if f == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	var best *Auth
+	for i, a := range f.auth {
+		if urlBelow(u, a.URL) && (best == nil || len(a.URL) > len(best.URL)) {
+			best = &f.auth[i]
+		}
+	}
+	return best
+}
+
+// urlBelow reports whether u has the scheme and host of the URL prefix, and a path equal to
+// its path, or below it.
+func urlBelow(u *url.URL, prefix string) bool {
+	p, err := url.Parse(prefix)
+	if err != nil || !strings.EqualFold(u.Scheme, p.Scheme) || !strings.EqualFold(u.Host, p.Host) {
+		return false
+	}
+	dir := strings.TrimSuffix(p.EscapedPath(), "/")
+	path := u.EscapedPath()
+	return dir == "" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// do sends a request for url with the matching credentials and the additional header,
+// and checks the response status: 200, or 304 for a conditional request.
+// The additional header wins over the headers of the credentials.
+// Credentials, and the headers of the credentials, are not forwarded when the server redirects to another host.
+func (f *fetcher) do(method, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(f.context(), method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// authHeaders are the headers set by the credentials, removed on cross-host redirects.
+	var authHeaders []string
+	if a := f.match(url); a != nil {
+		for k, v := range a.Headers {
+			if req.Header.Get(k) == "" {
+				req.Header.Set(k, v)
+				authHeaders = append(authHeaders, k)
+			}
+		}
+		switch {
+		case req.Header.Get("Authorization") != "":
+		case a.Bearer != "":
+			req.Header.Set("Authorization", "Bearer "+a.Bearer)
+			authHeaders = append(authHeaders, "Authorization")
+		case a.Username != "":
+			req.SetBasicAuth(a.Username, a.Password)
+			authHeaders = append(authHeaders, "Authorization")
+		}
+	}
+	client := &http.Client{CheckRedirect: func(r *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		if !strings.EqualFold(r.URL.Host, via[0].URL.Host) {
+			for _, k := range authHeaders {
+				r.Header.Del(k)
+			}
+		}
+		return nil
+	}}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		f.logger().Debug(method, "url", url, "error", err, "duration", time.Since(start))
+		return nil, err
+	}
+	f.logger().Debug(method, "url", url, "status", resp.StatusCode, "duration", time.Since(start))
+	conditional := header.Get("If-None-Match") != "" || header.Get("If-Modified-Since") != ""
+	if resp.StatusCode != http.StatusOK && !(resp.StatusCode == http.StatusNotModified && conditional) {
+		resp.Body.Close()
+		return nil, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	return resp, nil
+}
+
+// fetch returns the content of the web resource at url.
+// With a cache, a cached resource is revalidated, and only downloaded again if it changed.
+func (f *fetcher) fetch(url string) ([]byte, error) {
+	return f.fetchWith(url, nil)
+}
+
+// fetchWith is like fetch, with additional request headers.
+// The resource is recorded in the lock, if any.
+func (f *fetcher) fetchWith(url string, extra http.Header) ([]byte, error) {
+	content, resolved, err := f.download(url, extra)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		if err := f.lock.record(url, resolved, content); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// download is like fetchWith, without recording the resource in the lock, and also returns the URL
+// the content was fetched from, after redirects.
+func (f *fetcher) download(url string, extra http.Header) ([]byte, string, error) {
+	start := time.Now()
+	var cache *httpCache
+	if f != nil {
+		cache = f.cache
+	}
+	var entry cacheEntry
+	var cached []byte
+	var ok bool
+	vary := f.cacheVary(url, extra)
+	if cache != nil {
+		entry, cached, ok = cache.get(url, vary)
+	}
+	if cache != nil && cache.offline {
+		if !ok {
+			return nil, "", fmt.Errorf("failed to fetch resource %s: %w", url, errNotCached)
+		}
+		f.logger().Debug("Resource read from the cache (offline mode)", "url", url)
+		f.fetched(url, cached, start, true)
+		return cached, entry.Resolved, nil
+	}
+
+	header := extra.Clone()
+	if ok {
+		if header == nil {
+			header = make(http.Header)
+		}
+		for k, v := range entry.conditional() {
+			header[k] = v
+		}
+	}
+	resp, err := f.do(http.MethodGet, url, header)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch resource %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		f.logger().Debug("Resource not modified, read from the cache", "url", url)
+		f.fetched(url, cached, start, true)
+		return cached, entry.Resolved, nil
+	}
+	content, err := io.ReadAll(f.limitReader(url, f.progressReader(url, resp.Body, resp.ContentLength)))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read resource body %s: %w", url, err)
+	}
+	resolved := resp.Request.URL.String()
+	if cache != nil {
+		if err := cache.put(url, vary, resolved, resp.Header, content); err != nil {
+			return nil, "", err
+		}
+	}
+	f.fetched(url, content, start, false)
+	return content, resolved, nil
+}
+
+// cacheVary returns the request headers and credentials that select the representation of url, cached
+// separately: the additional headers, and the credentials of url with their headers.
+// An Authorization header in the additional ones is a short-lived token (e.g. of a container registry),
+// which is left out not to miss the cache on every build.
+func (f *fetcher) cacheVary(url string, extra http.Header) string {
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(extra)) {
+		if k != "Authorization" {
+			fmt.Fprintf(&b, "%s: %q\n", k, extra[k])
+		}
+	}
+	if a := f.match(url); a != nil {
+		fmt.Fprintf(&b, "auth: %q %q %q\n", a.Bearer, a.Username, a.Password)
+		for _, k := range slices.Sorted(maps.Keys(a.Headers)) {
+			fmt.Fprintf(&b, "auth %s: %q\n", http.CanonicalHeaderKey(k), a.Headers[k])
+		}
+	}
+	return b.String()
+}
+
+// fetched reports a fetched resource to the listener, if any.
+func (f *fetcher) fetched(url string, content []byte, start time.Time, cached bool) {
+	if f != nil && f.l != nil {
+		f.l(EventResourceFetched{URL: url, Size: int64(len(content)), Duration: time.Since(start), Cached: cached})
+	}
+}
+
+// progressInterval is the minimum time between two progress events of a download.
+const progressInterval = 100 * time.Millisecond
+
+// progressReader returns a reader of r that reports the download of url to the listener, if any.
+// size is the size of the resource, or -1 if unknown.
+func (f *fetcher) progressReader(url string, r io.Reader, size int64) io.Reader {
+	if f == nil || f.l == nil {
+		return r
+	}
+	return &downloadProgress{r: r, l: f.l, p: deb.Progress{Stage: deb.StageDownload, Item: url, TotalBytes: size}}
+}
+
+// downloadProgress is a reader emitting EventProgress as it is read, and at the end.
+type downloadProgress struct {
+	r    io.Reader
+	l    Listener
+	p    deb.Progress
+	last time.Time
+}
+
+func (d *downloadProgress) Read(b []byte) (int, error) {
+	n, err := d.r.Read(b)
+	d.p.Bytes += int64(n)
+	if err == io.EOF {
+		d.p.TotalBytes = d.p.Bytes
+	}
+	if err == io.EOF || time.Since(d.last) >= progressInterval {
+		d.last = time.Now()
+		d.l(EventProgress{d.p})
+	}
+	return n, err
+}
+
+// check queries the web resource at url, without downloading it.
+// In offline mode, the resource must be in the cache.
+func (f *fetcher) check(url string) error {
+	if f != nil && f.cache != nil && f.cache.offline {
+		if _, _, ok := f.cache.get(url, f.cacheVary(url, nil)); !ok {
+			return fmt.Errorf("failed to query resource %s: %w", url, errNotCached)
+		}
+		return nil
+	}
+	resp, err := f.do(http.MethodHead, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to query resource %s: %w", url, err)
+	}
+	resp.Body.Close()
+	return nil
+}
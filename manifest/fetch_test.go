@@ -0,0 +1,70 @@
+package manifest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetcherMatch(t *testing.T) {
+	f := &fetcher{auth: []Auth{
+		{URL: "https://host.example"},
+		{URL: "https://host.example/org/"},
+		{URL: "https://other.example/repo"},
+	}}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://host.example/file", "https://host.example"},
+		{"https://HOST.example/file", "https://host.example"},
+		{"https://host.example/org/file", "https://host.example/org/"},
+		{"https://host.example/organization/file", "https://host.example"},
+		{"https://host.example.evil.com/file", ""},
+		{"http://host.example/file", ""},
+		{"https://host.example:8443/file", ""},
+		{"https://other.example/repo", "https://other.example/repo"},
+		{"https://other.example/repo/file", "https://other.example/repo"},
+		{"https://other.example/repository/file", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if a := f.match(tt.url); a != nil {
+			got = a.URL
+		}
+		if got != tt.want {
+			t.Errorf("match(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestFetcherRedirect(t *testing.T) {
+	var forwarded http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Clone()
+	}))
+	defer other.Close()
+	var received http.Header
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		http.Redirect(w, r, other.URL+"/file", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	f := &fetcher{auth: []Auth{{URL: origin.URL, Bearer: "token", Headers: map[string]string{"X-Api-Key": "secret"}}}}
+	resp, err := f.do(http.MethodGet, origin.URL+"/file", http.Header{"Accept": {"application/octet-stream"}})
+	if err != nil {
+		t.Fatalf("do failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if received.Get("Authorization") != "Bearer token" || received.Get("X-Api-Key") != "secret" {
+		t.Errorf("the origin did not receive the credentials: %v", received)
+	}
+	if forwarded.Get("Authorization") != "" || forwarded.Get("X-Api-Key") != "" {
+		t.Errorf("the credentials were forwarded to another host: %v", forwarded)
+	}
+	if forwarded.Get("Accept") != "application/octet-stream" {
+		t.Errorf("the request headers were not forwarded: %v", forwarded)
+	}
+}
@@ -0,0 +1,169 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// builtinFuncs returns the functions available to every template.
+//
+// Functions taking the piped value use it as their last argument, so that
+// `{{ .NAME | replace "-" "_" | upper }}` works as expected.
+func builtinFuncs() template.FuncMap {
+	return template.FuncMap{
+		"default":    defaultValue,
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"sha256":     sha256Hex,
+		"b64enc":     func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":     b64dec,
+		"now":        time.Now,
+		"date":       func(layout string, t time.Time) string { return t.Format(layout) },
+		"semver":     parseSemver,
+		"indent":     indent,
+		// Debian versions.
+		"bumpVersion":     deb.BumpVersion,
+		"bumpUpstream":    bumpUpstream,
+		"bumpPrerelease":  bumpPrerelease,
+		"compareVersions": deb.CompareVersions,
+		"matchVersion":    matchVersion,
+		"upstreamOf":      upstreamOf,
+		"iterationOf":     iterationOf,
+	}
+}
+
+// bumpUpstream returns the next upstream version of v at level: "major", "minor" or "patch".
+func bumpUpstream(level, v string) (string, error) {
+	return deb.BumpUpstream(v, deb.BumpLevel(level))
+}
+
+// bumpPrerelease returns the next pre-release of v in channel, e.g. "rc", bumping v at level first
+// if it is not a pre-release of channel yet.
+func bumpPrerelease(channel, level, v string) (string, error) {
+	return deb.BumpPrerelease(v, deb.BumpLevel(level), channel)
+}
+
+// matchVersion reports whether the Debian version v satisfies constraint, e.g. ">= 1.2-1".
+func matchVersion(constraint, v string) (bool, error) {
+	c, err := deb.ParseConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	return c.Matches(v), nil
+}
+
+// upstreamOf returns the upstream part of a Debian version: without the epoch (everything up to the
+// first colon) and the Debian revision (everything from the last hyphen).
+func upstreamOf(v string) string {
+	if i := strings.Index(v, ":"); i >= 0 {
+		v = v[i+1:]
+	}
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		return v[:i]
+	}
+	return v
+}
+
+// iterationOf returns the Debian revision of a version (everything after the last hyphen), or "".
+func iterationOf(v string) string {
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		return v[i+1:]
+	}
+	return ""
+}
+
+// defaultValue returns value, or def if value is empty.
+func defaultValue(def, value string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// sha256Hex returns the hex encoded SHA256 checksum of s.
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// b64dec decodes a standard base64 string.
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("decoding base64: %w", err)
+	}
+	return string(b), nil
+}
+
+// indent prefixes every non empty line of s with n spaces.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = pad + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Semver is a parsed semantic version, as returned by the semver template function.
+//
+// Reference: https://semver.org
+type Semver struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+}
+
+// String returns the canonical form of the version, without the leading 'v'.
+func (v Semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// parseSemver parses a semantic version like "v1.2.3-rc.1+build.5".
+// The leading 'v' is optional, and so are the minor and patch numbers.
+func parseSemver(s string) (Semver, error) {
+	var v Semver
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.Index(rest, "+"); i >= 0 {
+		rest, v.Build = rest[:i], rest[i+1:]
+	}
+	if i := strings.Index(rest, "-"); i >= 0 {
+		rest, v.Prerelease = rest[:i], rest[i+1:]
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid semantic version %q", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid semantic version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
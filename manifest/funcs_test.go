@@ -0,0 +1,120 @@
+package manifest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuiltinFuncs(t *testing.T) {
+	e, err := newTemplateEngine(map[string]string{"NAME": "my-app", "EMPTY": ""}, nil)
+	if err != nil {
+		t.Fatalf("newTemplateEngine failed: %v", err)
+	}
+	for _, tt := range []struct {
+		text string
+		want string
+		// err is a part of the error message, if rendering must fail.
+		err string
+	}{
+		{text: `{{ .EMPTY | default "none" }}`, want: "none"},
+		{text: `{{ .NAME | default "none" }}`, want: "my-app"},
+		{text: `{{ .NAME | upper }}`, want: "MY-APP"},
+		{text: `{{ "My-App" | lower }}`, want: "my-app"},
+		{text: `{{ " my-app\n" | trim }}`, want: "my-app"},
+		{text: `{{ .NAME | trimPrefix "my-" }}`, want: "app"},
+		{text: `{{ .NAME | trimSuffix "-app" }}`, want: "my"},
+		{text: `{{ .NAME | trimSuffix "-other" }}`, want: "my-app"},
+		{text: `{{ .NAME | replace "-" "_" | upper }}`, want: "MY_APP"},
+		{text: `{{ "abc" | sha256 }}`, want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{text: `{{ .NAME | b64enc }}`, want: "bXktYXBw"},
+		{text: `{{ "bXktYXBw" | b64dec }}`, want: "my-app"},
+		{text: `{{ .NAME | b64enc | b64dec }}`, want: "my-app"},
+		{text: `{{ "not base64!" | b64dec }}`, err: "decoding base64"},
+		{text: `{{ now | date "2006" }}`, want: time.Now().Format("2006")},
+		{text: `{{ date "2006" "today" }}`, err: "time.Time"},
+		{text: `{{ (semver "v1.2.3-rc.1+build.5").Minor }}`, want: "2"},
+		{text: `{{ semver "1.2" }}`, want: "1.2.0"},
+		{text: `{{ with semver "v2" }}{{ .Major }}.{{ .Patch }}{{ end }}`, want: "2.0"},
+		{text: `{{ semver "1.2.3.4" }}`, err: "invalid semantic version"},
+		{text: `{{ semver "1.x" }}`, err: "invalid semantic version"},
+		{text: `{{ "a\n\nb" | indent 2 }}`, want: "  a\n\n  b"},
+		{text: `{{ indent "two" "a" }}`, err: "expected integer"},
+	} {
+		got, err := e.render("test", tt.text)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("render(%s) = %q, %v, want an error containing %q", tt.text, got, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("render(%s) failed: %v", tt.text, err)
+		case got != tt.want:
+			t.Errorf("render(%s) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestVersionFuncs(t *testing.T) {
+	e, err := newTemplateEngine(map[string]string{"VERSION": "1:1.2.3-4"}, nil)
+	if err != nil {
+		t.Fatalf("newTemplateEngine failed: %v", err)
+	}
+	for _, tt := range []struct {
+		text string
+		want string
+		// err is a part of the error message, if rendering must fail.
+		err string
+	}{
+		{text: `{{ .VERSION | bumpUpstream "major" }}`, want: "1:2.0.0-1"},
+		{text: `{{ .VERSION | bumpUpstream "minor" }}`, want: "1:1.3.0-1"},
+		{text: `{{ .VERSION | bumpUpstream "patch" }}`, want: "1:1.2.4-1"},
+		{text: `{{ "1.2" | bumpUpstream "patch" }}`, want: "1.2.1"},
+		{text: `{{ "2.0.0~rc2-1" | bumpUpstream "major" }}`, want: "2.0.0-1"},
+		{text: `{{ .VERSION | bumpUpstream "huge" }}`, err: "unknown level"},
+		{text: `{{ "1.2.3.4-1" | bumpUpstream "patch" }}`, err: "1.2.3.4"},
+		{text: `{{ .VERSION | bumpPrerelease "rc" "minor" }}`, want: "1:1.3.0~rc1-1"},
+		{text: `{{ "2.0.0~rc1-3" | bumpPrerelease "rc" "major" }}`, want: "2.0.0~rc2-1"},
+		{text: `{{ "2.0.0~beta2-1" | bumpPrerelease "rc" "major" }}`, want: "2.0.0~rc1-1"},
+		{text: `{{ .VERSION | bumpPrerelease "RC" "minor" }}`, err: "invalid pre-release channel"},
+		{text: `{{ compareVersions .VERSION "1.9" }}`, want: "1"},
+		{text: `{{ compareVersions "1.0~rc1-1" "1.0-1" }}`, want: "-1"},
+		{text: `{{ compareVersions "1.0-1" "1.0-1" }}`, want: "0"},
+		{text: `{{ .VERSION | matchVersion ">= 1:1.2" }}`, want: "true"},
+		{text: `{{ .VERSION | matchVersion "<< 2.0" }}`, want: "false"},
+		{text: `{{ "1.0~rc1-1" | matchVersion "<< 1.0" }}`, want: "true"},
+		{text: `{{ "1.0-1" | matchVersion "1.0-1" }}`, want: "true"},
+		{text: `{{ .VERSION | matchVersion "=> 1.0" }}`, err: "invalid constraint"},
+		{text: `{{ .VERSION | matchVersion ">= " }}`, err: "invalid constraint"},
+		{text: `{{ .VERSION | iterationOf }}`, want: "4"},
+		{text: `{{ "2:1.0-rc-1" | iterationOf }}`, want: "1"},
+		{text: `{{ "1:1.0~beta1" | iterationOf }}`, want: ""},
+	} {
+		got, err := e.render("test", tt.text)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("render(%s) = %q, %v, want an error containing %q", tt.text, got, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("render(%s) failed: %v", tt.text, err)
+		case got != tt.want:
+			t.Errorf("render(%s) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamOf(t *testing.T) {
+	for v, want := range map[string]string{
+		"1.2":         "1.2",
+		"1.2-3":       "1.2",
+		"1:2.0-1":     "2.0",
+		"2:1.0-rc-1":  "1.0-rc",
+		"1:2.0":       "2.0",
+		"1.0~beta1-2": "1.0~beta1",
+	} {
+		if got := upstreamOf(v); got != want {
+			t.Errorf("upstreamOf(%q) = %q, want %q", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// githubAPI is the base URL of the GitHub REST API. Credentials for private repositories are
+// configured with an Auth entry for this URL (e.g. "https://api.github.com/repos/org/").
+const githubAPI = "https://api.github.com"
+
+// githubAsset is a release asset slug: "github.com/<owner>/<repo>/tags/<tag>/<asset>",
+// or "github.com/<owner>/<repo>/latest/<asset>" for the latest release.
+type githubAsset struct {
+	owner, repo, tag, name string
+}
+
+// parseGitHubAsset parses a release asset slug. It returns false if s is not a slug.
+func parseGitHubAsset(s string) (githubAsset, bool) {
+	rest, ok := strings.CutPrefix(s, "github.com/")
+	if !ok {
+		return githubAsset{}, false
+	}
+	parts := strings.Split(rest, "/")
+	switch {
+	case len(parts) == 5 && parts[2] == "tags":
+		return githubAsset{parts[0], parts[1], parts[3], parts[4]}, !slices.Contains(parts, "")
+	case len(parts) == 4 && parts[2] == "latest":
+		return githubAsset{parts[0], parts[1], "", parts[3]}, !slices.Contains(parts, "")
+	}
+	return githubAsset{}, false
+}
+
+// IsGitHubAsset reports whether path is a GitHub release asset slug:
+// "github.com/<owner>/<repo>/tags/<tag>/<asset>" or "github.com/<owner>/<repo>/latest/<asset>".
+func IsGitHubAsset(path string) bool {
+	_, ok := parseGitHubAsset(path)
+	return ok
+}
+
+// releaseURL returns the API URL of the release of the asset.
+func (a githubAsset) releaseURL() string {
+	base := fmt.Sprintf("%s/repos/%s/%s/releases/", githubAPI, url.PathEscape(a.owner), url.PathEscape(a.repo))
+	if a.tag == "" {
+		return base + "latest"
+	}
+	return base + "tags/" + url.PathEscape(a.tag)
+}
+
+// resolveGitHubAsset returns the API URL of the release asset designated by slug.
+func (f *fetcher) resolveGitHubAsset(slug string) (string, error) {
+	a, ok := parseGitHubAsset(slug)
+	if !ok {
+		return "", fmt.Errorf("invalid GitHub release asset %q, expected github.com/<owner>/<repo>/tags/<tag>/<asset>", slug)
+	}
+	// The release metadata changes with its download counts, only the asset is recorded in the lock.
+	content, _, err := f.download(a.releaseURL(), http.Header{"Accept": {"application/vnd.github+json"}})
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", slug, err)
+	}
+	var release struct {
+		Assets []struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		} `json:"assets"`
+	}
+	if err := json.Unmarshal(content, &release); err != nil {
+		return "", fmt.Errorf("resolving %s: parsing release: %w", slug, err)
+	}
+	for _, asset := range release.Assets {
+		if asset.Name == a.name {
+			return asset.URL, nil
+		}
+	}
+	return "", fmt.Errorf("resolving %s: the release has no asset %q", slug, a.name)
+}
+
+// fetchGitHubAsset returns the content of the release asset designated by slug.
+func (f *fetcher) fetchGitHubAsset(slug string) ([]byte, error) {
+	assetURL, err := f.resolveGitHubAsset(slug)
+	if err != nil {
+		return nil, err
+	}
+	return f.fetchWith(assetURL, http.Header{"Accept": {"application/octet-stream"}})
+}
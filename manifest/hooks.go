@@ -0,0 +1,203 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Hook is a step executed before or after a build.
+// Exactly one of Run, Download or Checksum must be set. All the fields are templates.
+type Hook struct {
+	// Run is a shell command, executed with "sh -c" in the directory of the definition file.
+	Run string `json:"run" yaml:"run"`
+	// Download is a web URL to download into Dst.
+	Download string `json:"download" yaml:"download"`
+	// Dst is the path where Download is saved, relative to the definition file.
+	Dst string `json:"dst" yaml:"dst"`
+	// Checksum is the path of a file, relative to the definition file, that must match SHA256.
+	Checksum string `json:"checksum" yaml:"checksum"`
+	// SHA256 is the expected hex encoded SHA256 checksum of Checksum, or of the Download content.
+	SHA256 string `json:"sha256" yaml:"sha256"`
+	// When is an optional condition. The hook is skipped if it renders to a false value.
+	When string `json:"when" yaml:"when"`
+}
+
+// runHooks runs the hooks of a section of the repository file.
+// Unless in ModeBuild, the hooks are only checked and rendered, not executed.
+func (a *Repository) runHooks(mode CompileMode, section string, hooks []Hook) error {
+	if mode != ModeBuild {
+		return errors.Join(checkHooks(a.engine, section, hooks)...)
+	}
+	return runHooks(a.engine, a.fetcher, section, hooks, a.resolve)
+}
+
+// runHooks executes the hooks in order, and stops at the first failure.
+// section names the hooks list in error messages (e.g. "before"),
+// and resolve turns paths relative to the definition file into usable paths.
+func runHooks(e *templateEngine, f *fetcher, section string, hooks []Hook, resolve func(string) string) error {
+	for i, h := range hooks {
+		name := fmt.Sprintf("%s[%d]", section, i)
+		if err := h.run(e, f, name, resolve); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// run executes a single hook.
+func (h Hook) run(e *templateEngine, f *fetcher, name string, resolve func(string) string) error {
+	ok, err := e.eval(name+".when", h.When)
+	if err != nil || !ok {
+		return err
+	}
+	if err := h.check(); err != nil {
+		return err
+	}
+	render := func(field, text string) (string, error) { return e.render(name+"."+field, text) }
+	sum, err := render("sha256", h.SHA256)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case h.Run != "":
+		script, err := render("run", h.Run)
+		if err != nil {
+			return err
+		}
+		cmd := exec.CommandContext(f.context(), "sh", "-c", script)
+		cmd.Dir = resolve(".")
+		f.logger().Debug("Running hook", "hook", name, "command", script, "dir", cmd.Dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("command failed: %w\n%s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+
+	case h.Download != "":
+		url, err := render("download", h.Download)
+		if err != nil {
+			return err
+		}
+		dst, err := render("dst", h.Dst)
+		if err != nil {
+			return err
+		}
+		if err := checkHookPath("dst", dst); err != nil {
+			return err
+		}
+		return download(f, url, resolve(dst), sum)
+
+	default:
+		path, err := render("checksum", h.Checksum)
+		if err != nil {
+			return err
+		}
+		if err := checkHookPath("checksum", path); err != nil {
+			return err
+		}
+		content, err := os.ReadFile(resolve(path))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		return verifySHA256(path, content, sum)
+	}
+}
+
+// checkHooks checks the hooks structure and renders their templates, without executing them.
+func checkHooks(e *templateEngine, section string, hooks []Hook) []error {
+	var errs []error
+	for i, h := range hooks {
+		name := fmt.Sprintf("%s[%d]", section, i)
+		ok, err := e.eval(name+".when", h.When)
+		if err != nil || !ok {
+			if err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err := h.check(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		for _, f := range []struct{ field, text string }{{"run", h.Run}, {"download", h.Download}, {"dst", h.Dst}, {"checksum", h.Checksum}} {
+			v, err := e.render(name+"."+f.field, f.text)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if f.field == "dst" || f.field == "checksum" {
+				if err := checkHookPath(f.field, v); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				}
+			}
+		}
+		if sum, err := e.render(name+".sha256", h.SHA256); err != nil {
+			errs = append(errs, err)
+		} else if err := checkSHA256Syntax(name+".sha256", sum); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// check reports a hook that does not have exactly one action, or misses a required field.
+func (h Hook) check() error {
+	n := 0
+	for _, action := range []string{h.Run, h.Download, h.Checksum} {
+		if action != "" {
+			n++
+		}
+	}
+	switch {
+	case n != 1:
+		return fmt.Errorf("hook must have exactly one of 'run', 'download' or 'checksum'")
+	case h.Download != "" && h.Dst == "":
+		return fmt.Errorf("download hook requires 'dst'")
+	case h.Checksum != "" && h.SHA256 == "":
+		return fmt.Errorf("checksum hook requires 'sha256'")
+	}
+	return nil
+}
+
+// checkHookPath reports a rendered dst or checksum path that is not inside the directory of the definition
+// file: an absolute path, or a path with a ".." element.
+func checkHookPath(field, path string) error {
+	if path == "" {
+		return nil
+	}
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "/") || slices.Contains(strings.Split(filepath.ToSlash(path), "/"), "..") {
+		return fmt.Errorf("%s %q must be a relative path inside the directory of the definition file", field, path)
+	}
+	return nil
+}
+
+// checkRemoteHooks reports the hooks of a definition loaded from path, if it is a URL:
+// they could write files and run commands on the build machine.
+func checkRemoteHooks(path string, pkg Package) error {
+	if isURL(path) && len(pkg.Before)+len(pkg.After) > 0 {
+		return fmt.Errorf("%s: hooks are not allowed in definitions loaded from a URL", path)
+	}
+	return nil
+}
+
+// download saves the content of url into dst, creating parent directories as needed.
+// If sum is not empty, the content must match this SHA256 checksum, otherwise dst is not written.
+func download(f *fetcher, url, dst, sum string) error {
+	content, err := f.fetch(url)
+	if err != nil {
+		return err
+	}
+	if sum != "" {
+		if err := verifySHA256(url, content, sum); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, content, 0644)
+}
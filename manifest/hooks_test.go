@@ -0,0 +1,128 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPackageHooksPlan(t *testing.T) {
+	fsys := fstest.MapFS{
+		"repository.yml": {Data: []byte("path: repo\npackages:\n  - app.yml\n")},
+		"app.yml":        {Data: []byte("meta:\n  Package: app\n  Version: \"1.0\"\n  Architecture: all\nbefore:\n  - run: \"exit 1\"\n")},
+	}
+	a, err := NewRepositoryFromFS(fsys, "repository.yml", nil)
+	if err != nil {
+		t.Fatalf("NewRepositoryFromFS failed: %v", err)
+	}
+	pkgs, err := a.LoadPackages()
+	if err != nil {
+		t.Fatalf("LoadPackages failed: %v", err)
+	}
+	p := pkgs[0]
+	if _, err := p.Build(); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Build ran a hook without AllowExec: %v", err)
+	}
+	p.allowExec = true
+	if _, err := p.Build(); err == nil {
+		t.Error("Build did not run the failing hook")
+	}
+	p.mode = ModePlan
+	if _, err := p.Build(); err != nil {
+		t.Errorf("Build ran the hook in plan mode: %v", err)
+	}
+	p.Before = []Hook{{Run: "true", Download: "https://example.com/file"}}
+	if _, err := p.Build(); err == nil {
+		t.Error("Build did not check the hook in plan mode")
+	}
+}
+
+func TestRepositoryHooksPlan(t *testing.T) {
+	for _, tt := range []struct {
+		name, file string
+	}{
+		{"repository", "path: repo\nbefore:\n  - run: \"touch before\"\nafter:\n  - run: \"touch after\"\n"},
+		{"repositories", "before:\n  - run: \"touch before\"\nafter:\n  - run: \"touch after\"\nrepositories:\n  - path: repo\n"},
+	} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "repository.yml")
+		if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		a, err := NewRepository(path)
+		if err != nil {
+			t.Fatalf("%s: NewRepository failed: %v", tt.name, err)
+		}
+		if err := a.CompileContext(context.Background(), CompileOptions{Mode: ModePlan}, func(fmt.Stringer) {}); err != nil {
+			t.Fatalf("%s: CompileContext failed: %v", tt.name, err)
+		}
+		for _, marker := range []string{"before", "after"} {
+			if _, err := os.Stat(filepath.Join(dir, marker)); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s: the %s hook ran in plan mode: %v", tt.name, marker, err)
+			}
+		}
+	}
+}
+
+func TestRemoteDefinitionHooks(t *testing.T) {
+	const hook = "before:\n  - download: https://example.com/payload\n    dst: hook.sh\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.yml":
+			w.Write([]byte("meta:\n  Package: app\n  Version: \"1.0\"\n" + hook))
+		case "/base.yml":
+			w.Write([]byte(hook))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"remote definition": {
+			"repository.yml": {Data: []byte("path: repo\npackages:\n  - " + server.URL + "/app.yml\n")},
+		},
+		"remote base": {
+			"repository.yml": {Data: []byte("path: repo\npackages:\n  - app.yml\n")},
+			"app.yml":        {Data: []byte("extends: " + server.URL + "/base.yml\nmeta:\n  Package: app\n  Version: \"1.0\"\n")},
+		},
+	} {
+		a, err := NewRepositoryFromFS(fsys, "repository.yml", nil)
+		if err != nil {
+			t.Fatalf("%s: NewRepositoryFromFS failed: %v", name, err)
+		}
+		if _, err := a.LoadPackages(); err == nil || !strings.Contains(err.Error(), "hooks are not allowed") {
+			t.Errorf("%s: LoadPackages = %v, want the hooks refused", name, err)
+		}
+	}
+}
+
+func TestHookPaths(t *testing.T) {
+	e, err := newTemplateEngine(nil, nil)
+	if err != nil {
+		t.Fatalf("newTemplateEngine failed: %v", err)
+	}
+	for _, h := range []Hook{
+		{Download: "https://example.com/file", Dst: "/etc/cron.d/evil"},
+		{Download: "https://example.com/file", Dst: "../outside"},
+		{Checksum: "dir/../../outside", SHA256: strings.Repeat("0", 64)},
+	} {
+		if errs := checkHooks(e, "before", []Hook{h}); len(errs) == 0 {
+			t.Errorf("checkHooks(%+v) accepts a path outside of the definition directory", h)
+		}
+		if err := h.run(e, nil, "before[0]", func(p string) string { return p }); err == nil || !strings.Contains(err.Error(), "relative path") {
+			t.Errorf("run(%+v) = %v, want the path refused", h, err)
+		}
+	}
+	if errs := checkHooks(e, "before", []Hook{{Download: "https://example.com/file", Dst: "bin/tool"}}); len(errs) != 0 {
+		t.Errorf("checkHooks refuses a relative path: %v", errs)
+	}
+}
@@ -0,0 +1,534 @@
+package manifest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/etnz/apt-repo-builder/deb"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Image extracts files from the filesystem of a container image (OCI or Docker) into the package payload,
+// e.g. to repackage the binary of a vendor container as a .deb.
+// The image is pulled with the registry HTTP API, using the auth entries of the registry or its token service.
+type Image struct {
+	// Ref is the image reference: "[registry/]repository[:tag|@digest]", e.g. "alpine:3.20",
+	// "ghcr.io/org/tool:v1.2.3" or "quay.io/org/tool@sha256:...". Docker Hub is the default registry.
+	Ref string `json:"ref" yaml:"ref" jsonschema:"required"`
+	// Platform selects the image of a multi-platform image, as "os/arch[/variant]" (e.g. "linux/arm64").
+	// Defaults to linux and the package architecture.
+	Platform string `json:"platform" yaml:"platform"`
+	// Paths are the paths of the image filesystem to extract. Directories are extracted recursively.
+	Paths []ImagePath `json:"paths" yaml:"paths" jsonschema:"required"`
+	// When is an optional condition. The image is skipped if it renders to a false value.
+	When string `json:"when" yaml:"when"`
+}
+
+// ImagePath is a path to extract from an image.
+type ImagePath struct {
+	// Src is the absolute path of a file or directory in the image filesystem.
+	Src string `json:"src" yaml:"src" jsonschema:"required"`
+	// Dst is the absolute path in the package. Defaults to Src.
+	Dst string `json:"dst" yaml:"dst"`
+}
+
+// imageRef is a parsed image reference.
+type imageRef struct {
+	registry, repository, reference string
+}
+
+// parseImageRef parses an image reference, with the Docker Hub defaults.
+func parseImageRef(ref string) (imageRef, error) {
+	var r imageRef
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.reference = name[:i], name[i+1:]
+	}
+	if r.reference == "" {
+		r.reference = "latest"
+	}
+	first, rest, found := strings.Cut(name, "/")
+	switch {
+	case found && first == "docker.io":
+		r.registry, r.repository = "registry-1.docker.io", rest
+		if !strings.Contains(rest, "/") {
+			r.repository = "library/" + rest
+		}
+	case found && (strings.ContainsAny(first, ".:") || first == "localhost"):
+		r.registry, r.repository = first, rest
+	default:
+		r.registry, r.repository = "registry-1.docker.io", name
+		if !found {
+			r.repository = "library/" + name
+		}
+	}
+	if r.repository == "" || strings.ContainsAny(r.repository, " \t") {
+		return r, fmt.Errorf("invalid image reference %q", ref)
+	}
+	return r, nil
+}
+
+// api returns the base URL of the registry API. Local registries are accessed over plain HTTP.
+func (r imageRef) api() string {
+	scheme := "https"
+	if strings.HasPrefix(r.registry, "localhost") || strings.HasPrefix(r.registry, "127.0.0.1") {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/", scheme, r.registry)
+}
+
+// url returns the URL of a registry API endpoint of the repository ("manifests/<ref>" or "blobs/<digest>").
+func (r imageRef) url(endpoint string) string {
+	return r.api() + r.repository + "/" + endpoint
+}
+
+// Media types of the image manifests and layers.
+const (
+	mediaOCIIndex          = "application/vnd.oci.image.index.v1+json"
+	mediaOCIManifest       = "application/vnd.oci.image.manifest.v1+json"
+	mediaDockerList        = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaDockerManifest    = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaOCILayerGzip      = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaOCILayer          = "application/vnd.oci.image.layer.v1.tar"
+	mediaOCILayerZstd      = "application/vnd.oci.image.layer.v1.tar+zstd"
+	mediaDockerLayerGzip   = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	mediaDockerForeignGzip = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+)
+
+// imageManifest is an image manifest or an image index.
+type imageManifest struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Platform  struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+	Layers []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"layers"`
+}
+
+// debianPlatforms maps Debian architectures to image platforms.
+var debianPlatforms = map[string]string{
+	"amd64":    "linux/amd64",
+	"arm64":    "linux/arm64",
+	"armhf":    "linux/arm/v7",
+	"armel":    "linux/arm/v6",
+	"i386":     "linux/386",
+	"ppc64el":  "linux/ppc64le",
+	"s390x":    "linux/s390x",
+	"riscv64":  "linux/riscv64",
+	"mips64el": "linux/mips64le",
+}
+
+// imagePuller pulls images from a registry.
+type imagePuller struct {
+	f   *fetcher
+	ref imageRef
+	// token is the registry bearer token, if the registry requires one.
+	token string
+}
+
+// newImagePuller returns a puller for ref, authenticated with the registry token service if it has one.
+func newImagePuller(f *fetcher, ref imageRef) (*imagePuller, error) {
+	p := &imagePuller{f: f, ref: ref}
+	if f != nil && f.cache != nil && f.cache.offline {
+		return p, nil
+	}
+	// The API base endpoint tells whether the registry requires a token, and where to get it.
+	req, err := http.NewRequestWithContext(f.context(), http.MethodGet, ref.api(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("contacting registry %s: %w", ref.registry, err)
+	}
+	resp.Body.Close()
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return p, nil
+	}
+	params := parseChallenge(challenge[len("bearer "):])
+	q := url.Values{}
+	q.Set("scope", "repository:"+ref.repository+":pull")
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	tokenURL := params["realm"] + "?" + q.Encode()
+	tr, err := f.do(http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting registry token from %s: %w", params["realm"], err)
+	}
+	defer tr.Body.Close()
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(tr.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("parsing registry token: %w", err)
+	}
+	p.token = token.Token
+	if p.token == "" {
+		p.token = token.AccessToken
+	}
+	return p, nil
+}
+
+// parseChallenge parses the parameters of a WWW-Authenticate challenge (e.g. realm="...",service="...").
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		key, rest, ok := strings.Cut(strings.TrimLeft(s, ", "), "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, s = rest[1:end+1], rest[end+2:]
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return params
+}
+
+// get returns the content of a registry endpoint.
+func (p *imagePuller) get(endpoint string, accept ...string) ([]byte, error) {
+	header := http.Header{}
+	for _, a := range accept {
+		header.Add("Accept", a)
+	}
+	if p.token != "" {
+		header.Set("Authorization", "Bearer "+p.token)
+	}
+	return p.f.fetchWith(p.ref.url(endpoint), header)
+}
+
+// getDigest returns the content of a registry endpoint addressed by digest ("manifests/<digest>" or
+// "blobs/<digest>"), and fails if the content does not match the digest.
+func (p *imagePuller) getDigest(endpoint, digest string, accept ...string) ([]byte, error) {
+	content, err := p.get(endpoint, accept...)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDigest(digest, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// checkDigest checks that content matches the digest, e.g. "sha256:<hex>".
+func checkDigest(digest string, content []byte) error {
+	algorithm, want, ok := strings.Cut(digest, ":")
+	if !ok {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
+	var h deb.Hash
+	switch algorithm {
+	case "sha256":
+		h = deb.SHA256
+	case "sha512":
+		h = deb.SHA512
+	default:
+		return fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	w := h.New()
+	w.Write(content)
+	if got := hex.EncodeToString(w.Sum(nil)); got != want {
+		return fmt.Errorf("content digest %s:%s does not match %s", algorithm, got, digest)
+	}
+	return nil
+}
+
+// layers returns the layer descriptors of the image for platform, resolving image indices.
+func (p *imagePuller) layers(platform string) ([]string, []string, error) {
+	accept := []string{mediaOCIIndex, mediaOCIManifest, mediaDockerList, mediaDockerManifest}
+	var content []byte
+	var err error
+	if strings.Contains(p.ref.reference, ":") {
+		content, err = p.getDigest("manifests/"+p.ref.reference, p.ref.reference, accept...)
+	} else {
+		content, err = p.get("manifests/"+p.ref.reference, accept...)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var m imageManifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, nil, fmt.Errorf("parsing image manifest: %w", err)
+	}
+	if len(m.Manifests) > 0 {
+		var digest string
+		var available []string
+		for _, d := range m.Manifests {
+			pl := d.Platform.OS + "/" + d.Platform.Architecture
+			if d.Platform.Variant != "" {
+				pl += "/" + d.Platform.Variant
+			}
+			available = append(available, pl)
+			if digest == "" && (pl == platform || d.Platform.OS+"/"+d.Platform.Architecture == platform) {
+				digest = d.Digest
+			}
+		}
+		if digest == "" {
+			return nil, nil, fmt.Errorf("the image has no %s platform, available: %s", platform, strings.Join(available, ", "))
+		}
+		if content, err = p.getDigest("manifests/"+digest, digest, mediaOCIManifest, mediaDockerManifest); err != nil {
+			return nil, nil, err
+		}
+		m = imageManifest{}
+		if err := json.Unmarshal(content, &m); err != nil {
+			return nil, nil, fmt.Errorf("parsing image manifest: %w", err)
+		}
+	}
+	var digests, types []string
+	for _, l := range m.Layers {
+		digests = append(digests, l.Digest)
+		types = append(types, l.MediaType)
+	}
+	return digests, types, nil
+}
+
+// imageEntry is a file of the image filesystem.
+type imageEntry struct {
+	header *tar.Header
+	body   []byte
+}
+
+// extract applies the layers in order, and returns the entries of the image filesystem under the roots.
+func (p *imagePuller) extract(platform string, roots []string) (map[string]*imageEntry, error) {
+	digests, types, err := p.layers(platform)
+	if err != nil {
+		return nil, err
+	}
+	under := func(name string) bool {
+		for _, r := range roots {
+			if name == r || strings.HasPrefix(name, strings.TrimSuffix(r, "/")+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	fsys := make(map[string]*imageEntry)
+	for i, digest := range digests {
+		blob, err := p.getDigest("blobs/"+digest, digest)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", digest, err)
+		}
+		var r io.Reader = bytes.NewReader(blob)
+		release := func() {}
+		switch types[i] {
+		case mediaOCILayerGzip, mediaDockerLayerGzip, mediaDockerForeignGzip:
+			if r, err = gzip.NewReader(r); err != nil {
+				return nil, fmt.Errorf("layer %s: %w", digest, err)
+			}
+		case mediaOCILayerZstd:
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, fmt.Errorf("layer %s: %w", digest, err)
+			}
+			r, release = zr, zr.Close
+		case mediaOCILayer:
+		default:
+			return nil, fmt.Errorf("layer %s: unsupported media type %s", digest, types[i])
+		}
+		err = applyLayer(fsys, tar.NewReader(p.f.limitReader("layer "+digest, r)))
+		release()
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", digest, err)
+		}
+	}
+	// The whole filesystem is applied, so that hard links find their target in any layer and path.
+	for name := range fsys {
+		if !under(name) {
+			delete(fsys, name)
+		}
+	}
+	return fsys, nil
+}
+
+// applyLayer applies the entries of a layer to fsys, including whiteouts.
+// A hard link becomes a copy of its target, which must be a regular file of fsys.
+func applyLayer(fsys map[string]*imageEntry, tr *tar.Reader) error {
+	// added are the entries of this layer, that its opaque whiteouts do not hide.
+	added := make(map[string]bool)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + h.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == ".wh..wh..opq":
+			// Opaque directory: the content of the lower layers is hidden.
+			for k := range fsys {
+				if strings.HasPrefix(k, dir) && !added[k] {
+					delete(fsys, k)
+				}
+			}
+			continue
+		case strings.HasPrefix(base, ".wh."):
+			removed := dir + strings.TrimPrefix(base, ".wh.")
+			for k := range fsys {
+				if k == removed || strings.HasPrefix(k, removed+"/") {
+					delete(fsys, k)
+				}
+			}
+			continue
+		}
+		e := &imageEntry{header: h}
+		switch h.Typeflag {
+		case tar.TypeReg:
+			if e.body, err = io.ReadAll(tr); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, ok := fsys[path.Clean("/"+h.Linkname)]
+			if !ok || target.header.Typeflag != tar.TypeReg {
+				return fmt.Errorf("%s: hard link to %s, which is not a file of the image", name, h.Linkname)
+			}
+			e = &imageEntry{header: h, body: target.body}
+			e.header.Typeflag = tar.TypeReg
+		case tar.TypeSymlink, tar.TypeDir:
+		default:
+			continue
+		}
+		fsys[name] = e
+		added[name] = true
+	}
+}
+
+// applyImages adds the files extracted from the images to pkg.
+func (p *Package) applyImages(pkg *deb.Package) error {
+	for i, img := range p.Images {
+		name := fmt.Sprintf("images[%d]", i)
+		ok, err := p.engine.eval(name+".when", img.When)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		files, err := p.pullImage(name, img, pkg.Metadata.Architecture)
+		if err != nil {
+			return err
+		}
+		pkg.Files = append(pkg.Files, files...)
+	}
+	return nil
+}
+
+// pullImage returns the files extracted from an image, for a package of architecture arch.
+func (p *Package) pullImage(name string, img Image, arch string) ([]deb.File, error) {
+	refText, err := p.engine.render(name+".ref", img.Ref)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := parseImageRef(refText)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	platform, err := p.engine.render(name+".platform", img.Platform)
+	if err != nil {
+		return nil, err
+	}
+	if platform == "" {
+		if platform = debianPlatforms[arch]; platform == "" {
+			return nil, fmt.Errorf("%s.platform is required for the %q architecture", name, arch)
+		}
+	}
+
+	if len(img.Paths) == 0 {
+		return nil, fmt.Errorf("%s.paths is required", name)
+	}
+	type mapping struct{ src, dst string }
+	var mappings []mapping
+	var roots []string
+	for j, ip := range img.Paths {
+		src, err := p.engine.render(fmt.Sprintf("%s.paths[%d].src", name, j), ip.Src)
+		if err != nil {
+			return nil, err
+		}
+		dst, err := p.engine.render(fmt.Sprintf("%s.paths[%d].dst", name, j), ip.Dst)
+		if err != nil {
+			return nil, err
+		}
+		if !path.IsAbs(src) {
+			return nil, fmt.Errorf("%s.paths[%d].src %q must be an absolute path", name, j, src)
+		}
+		if dst != "" && !path.IsAbs(dst) {
+			return nil, fmt.Errorf("%s.paths[%d].dst %q must be an absolute path", name, j, dst)
+		}
+		src = path.Clean(src)
+		if dst == "" {
+			dst = src
+		}
+		mappings = append(mappings, mapping{src, path.Clean(dst)})
+		roots = append(roots, src)
+	}
+
+	puller, err := newImagePuller(p.fetcher, ref)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	fsys, err := puller.extract(platform, roots)
+	if err != nil {
+		return nil, fmt.Errorf("%s: pulling %s: %w", name, refText, err)
+	}
+
+	var files []deb.File
+	for j, m := range mappings {
+		found := false
+		for _, k := range slices.Sorted(maps.Keys(fsys)) {
+			if k != m.src && !strings.HasPrefix(k, m.src+"/") {
+				continue
+			}
+			found = true
+			e := fsys[k]
+			if e.header.Typeflag == tar.TypeDir {
+				continue
+			}
+			f := deb.File{
+				DestPath: m.dst + strings.TrimPrefix(k, m.src),
+				Mode:     e.header.Mode & 07777,
+				Body:     string(e.body),
+			}
+			if e.header.Typeflag == tar.TypeSymlink {
+				f.LinkTarget = e.header.Linkname
+			}
+			files = append(files, f)
+		}
+		if !found {
+			return nil, fmt.Errorf("%s.paths[%d]: %s not found in %s", name, j, m.src, refText)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,183 @@
+package manifest
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestParseImageRef(t *testing.T) {
+	for _, tt := range []struct {
+		ref  string
+		want imageRef
+	}{
+		{"alpine", imageRef{"registry-1.docker.io", "library/alpine", "latest"}},
+		{"alpine:3.20", imageRef{"registry-1.docker.io", "library/alpine", "3.20"}},
+		{"org/tool:v1", imageRef{"registry-1.docker.io", "org/tool", "v1"}},
+		{"docker.io/alpine", imageRef{"registry-1.docker.io", "library/alpine", "latest"}},
+		{"ghcr.io/org/tool:v1.2.3", imageRef{"ghcr.io", "org/tool", "v1.2.3"}},
+		{"localhost:5000/tool", imageRef{"localhost:5000", "tool", "latest"}},
+		{"quay.io/org/tool@sha256:abc", imageRef{"quay.io", "org/tool", "sha256:abc"}},
+	} {
+		got, err := parseImageRef(tt.ref)
+		if err != nil {
+			t.Errorf("parseImageRef(%q) failed: %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseImageRef(%q) = %+v, want %+v", tt.ref, got, tt.want)
+		}
+	}
+	if _, err := parseImageRef("ghcr.io/"); err == nil {
+		t.Errorf("parseImageRef(%q) succeeded, want an error", "ghcr.io/")
+	}
+}
+
+// layerTar returns a tar layer with the entries, files or directories if their name ends with a '/',
+// or hard links for "name=>target".
+func layerTar(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		h := &tar.Header{Name: name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(name))}
+		if strings.HasSuffix(name, "/") {
+			h = &tar.Header{Name: name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if name, target, ok := strings.Cut(name, "=>"); ok {
+			h = &tar.Header{Name: name, Mode: 0o755, Typeflag: tar.TypeLink, Linkname: target}
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			tw.Write([]byte(name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestApplyLayer(t *testing.T) {
+	fsys := make(map[string]*imageEntry)
+	for _, layer := range [][]byte{
+		layerTar(t, "etc/", "etc/a", "etc/b", "opt/", "opt/old", "opt/sub/", "opt/sub/x"),
+		// Whiteouts hide lower entries, and directories recursively.
+		// The opaque whiteout comes after a new entry of its layer, which it must keep.
+		layerTar(t, "etc/.wh.a", "opt/sub/.wh..wh..opq", "opt/.wh.old", "opt/sub/y", "opt/sub/.wh..wh..opq"),
+		// Hard links are copies of their target, from any layer.
+		layerTar(t, "opt/b=>etc/b"),
+	} {
+		if err := applyLayer(fsys, tar.NewReader(bytes.NewReader(layer))); err != nil {
+			t.Fatalf("applyLayer failed: %v", err)
+		}
+	}
+	want := []string{"/etc", "/etc/b", "/opt", "/opt/b", "/opt/sub", "/opt/sub/y"}
+	if got := slices.Sorted(maps.Keys(fsys)); !slices.Equal(got, want) {
+		t.Errorf("applyLayer entries = %q, want %q", got, want)
+	}
+	if e := fsys["/opt/b"]; e.header.Typeflag != tar.TypeReg || string(e.body) != "etc/b" {
+		t.Errorf("hard link = %c %q, want a copy of /etc/b", e.header.Typeflag, e.body)
+	}
+	for _, target := range []string{"etc/a", "etc/"} {
+		if err := applyLayer(fsys, tar.NewReader(bytes.NewReader(layerTar(t, "opt/c=>"+target)))); err == nil {
+			t.Errorf("applyLayer of a hard link to %s succeeded", target)
+		}
+	}
+}
+
+// digestOf returns the sha256 digest of content.
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// testRegistry serves an image index with an amd64 and an arm64 image of a single layer each.
+// The amd64 layer is compressed with zstd, and the arm64 layer is served corrupted.
+// /bin/tool-<arch> is a hard link to /lib/tool-<arch>.
+func testRegistry(t *testing.T) *httptest.Server {
+	t.Helper()
+	blobs := make(map[string][]byte)
+	index := map[string]any{"mediaType": mediaOCIIndex}
+	var manifests []any
+	for _, arch := range []string{"amd64", "arm64"} {
+		layer, mediaType := layerTar(t, "lib/", "lib/tool-"+arch, "bin/", "bin/tool-"+arch+"=>lib/tool-"+arch), mediaOCILayer
+		if arch == "amd64" {
+			zw, err := zstd.NewWriter(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			layer, mediaType = zw.EncodeAll(layer, nil), mediaOCILayerZstd
+		}
+		digest := digestOf(layer)
+		manifest, _ := json.Marshal(map[string]any{
+			"mediaType": mediaOCIManifest,
+			"layers":    []any{map[string]any{"mediaType": mediaType, "digest": digest}},
+		})
+		if arch == "arm64" {
+			layer = append(layer, 0)
+		}
+		blobs["/v2/org/tool/blobs/"+digest] = layer
+		blobs["/v2/org/tool/manifests/"+digestOf(manifest)] = manifest
+		manifests = append(manifests, map[string]any{
+			"mediaType": mediaOCIManifest,
+			"digest":    digestOf(manifest),
+			"platform":  map[string]any{"os": "linux", "architecture": arch},
+		})
+	}
+	index["manifests"] = manifests
+	blobs["/v2/org/tool/manifests/v1"], _ = json.Marshal(index)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		content, ok := blobs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestImagePull(t *testing.T) {
+	server := testRegistry(t)
+	ref, err := parseImageRef(strings.TrimPrefix(server.URL, "http://") + "/org/tool:v1")
+	if err != nil {
+		t.Fatalf("parseImageRef failed: %v", err)
+	}
+	p, err := newImagePuller(&fetcher{}, ref)
+	if err != nil {
+		t.Fatalf("newImagePuller failed: %v", err)
+	}
+	fsys, err := p.extract("linux/amd64", []string{"/bin"})
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+	if want := []string{"/bin", "/bin/tool-amd64"}; !slices.Equal(slices.Sorted(maps.Keys(fsys)), want) {
+		t.Errorf("extract entries = %q, want %q", slices.Sorted(maps.Keys(fsys)), want)
+	}
+	if e := fsys["/bin/tool-amd64"]; e == nil || string(e.body) != "lib/tool-amd64" {
+		t.Errorf("the hard link to a file outside of the extracted paths should be a copy of it")
+	}
+	if _, err := p.extract("linux/riscv64", []string{"/bin"}); err == nil || !strings.Contains(err.Error(), "linux/amd64, linux/arm64") {
+		t.Errorf("extract of a missing platform = %v, want the available platforms", err)
+	}
+	if _, err := p.extract("linux/arm64", []string{"/bin"}); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("extract of a corrupted layer = %v, want a digest mismatch", err)
+	}
+}
@@ -0,0 +1,175 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Repository layouts.
+const (
+	// LayoutFlat writes all the packages and indices in a single directory.
+	LayoutFlat = "flat"
+	// LayoutStandard writes a hierarchical repository, with dists/ and pool/ directories.
+	LayoutStandard = "standard"
+)
+
+// defaultComponent is the component of a standard layout that does not configure any.
+const defaultComponent = "main"
+
+// checkLayout checks the layout configuration.
+func (a *Repository) checkLayout() error {
+	switch a.Layout {
+	case "", LayoutFlat:
+		if a.Suite != "" || a.Codename != "" || len(a.Components) > 0 || len(a.Architectures) > 0 || a.Translations {
+			return fmt.Errorf("'suite', 'codename', 'components', 'architectures' and 'translations' require 'layout: %s'", LayoutStandard)
+		}
+	case LayoutStandard:
+		if a.codename() == "" {
+			return fmt.Errorf("'layout: %s' requires 'suite' or 'codename'", LayoutStandard)
+		}
+	default:
+		return fmt.Errorf("unknown layout %q, expected %q or %q", a.Layout, LayoutFlat, LayoutStandard)
+	}
+	return nil
+}
+
+// checkComponent checks the component of a package entry or an upstream.
+func (a *Repository) checkComponent(component string) error {
+	switch {
+	case component == "":
+		return nil
+	case a.Layout != LayoutStandard:
+		return fmt.Errorf("'component' requires 'layout: %s'", LayoutStandard)
+	case len(a.Components) > 0 && !slices.Contains(a.Components, component):
+		return fmt.Errorf("component %q is not one of the repository components %v", component, a.Components)
+	}
+	return nil
+}
+
+// codename returns the codename of the standard layout distribution.
+func (a *Repository) codename() string {
+	if a.Codename != "" {
+		return a.Codename
+	}
+	return a.Suite
+}
+
+// defaultComponent returns the component of packages whose entry does not set one.
+func (a *Repository) defaultComponent() string {
+	if len(a.Components) > 0 {
+		return a.Components[0]
+	}
+	return defaultComponent
+}
+
+// componentOf returns the component of a package in the standard layout.
+func (a *Repository) componentOf(pkg *deb.Package) string {
+	if c := a.components[pkg]; c != "" {
+		return c
+	}
+	return a.defaultComponent()
+}
+
+// loadStandardRepository loads a standard layout from Path, as a single repository holding
+// the packages of all the components and architectures.
+func (a *Repository) loadStandardRepository() (*deb.Repository, error) {
+	std, err := deb.NewStandardRepositoryFromDir(a.resolve(a.Path), a.codename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &deb.Repository{
+				ArchiveInfo: deb.ArchiveInfo{
+					Origin: "deb-pm",
+					Label:  "Managed Repository",
+				},
+			}, nil
+		}
+		return nil, err
+	}
+	repo := &deb.Repository{ArchiveInfo: std.ArchiveInfo}
+	for _, part := range std.Parts {
+		for _, pkg := range part.Packages {
+			if _, ok := a.components[pkg]; !ok {
+				a.components[pkg] = part.ArchiveInfo.Components
+				repo.Packages = append(repo.Packages, pkg)
+			}
+		}
+		for _, src := range part.Sources {
+			if _, ok := a.sourceComponents[src]; !ok {
+				a.sourceComponents[src] = part.ArchiveInfo.Components
+				repo.Sources = append(repo.Sources, src)
+			}
+		}
+	}
+	return repo, nil
+}
+
+// standardRepository splits the packages of repo into the parts of a standard layout,
+// one per component and architecture. Architecture independent packages are in every part of their component.
+func (a *Repository) standardRepository(repo *deb.Repository) (*deb.StandardRepository, error) {
+	components := slices.Clone(a.Components)
+	if len(components) == 0 {
+		components = []string{defaultComponent}
+	}
+	architectures := slices.Clone(a.Architectures)
+	for _, pkg := range repo.Packages {
+		// Keep the components of existing packages, even if they are no longer configured.
+		if c := a.componentOf(pkg); !slices.Contains(components, c) {
+			components = append(components, c)
+		}
+		if len(a.Architectures) == 0 && pkg.Metadata.Architecture != "all" && !slices.Contains(architectures, pkg.Metadata.Architecture) {
+			architectures = append(architectures, pkg.Metadata.Architecture)
+		}
+	}
+	for _, src := range repo.Sources {
+		if c := a.sourceComponent(src); !slices.Contains(components, c) {
+			components = append(components, c)
+		}
+	}
+	if len(a.Architectures) == 0 {
+		slices.Sort(architectures)
+	}
+	if len(architectures) == 0 && (len(repo.Packages) > 0 || len(repo.Sources) > 0) {
+		return nil, fmt.Errorf("'architectures' is required when all packages are architecture independent")
+	}
+
+	info := repo.ArchiveInfo
+	info.Suite = a.Suite
+	info.Codename = a.codename()
+	info.Components = strings.Join(components, " ")
+	info.Architectures = strings.Join(architectures, " ")
+	std := &deb.StandardRepository{ArchiveInfo: info, GPGKey: repo.GPGKey, Logger: repo.Logger, Progress: repo.Progress, Hashes: repo.Hashes, IndexCompressions: repo.IndexCompressions, Translations: a.Translations}
+
+	for _, comp := range components {
+		for _, arch := range architectures {
+			part := &deb.Repository{ArchiveInfo: deb.ArchiveInfo{Components: comp, Architectures: arch}}
+			for _, pkg := range repo.Packages {
+				pkgArch := pkg.Metadata.Architecture
+				if a.componentOf(pkg) == comp && (pkgArch == arch || pkgArch == "all") {
+					part.Packages = append(part.Packages, pkg)
+				}
+			}
+			// The source packages are in the first part of their component.
+			if arch == architectures[0] {
+				for _, src := range repo.Sources {
+					if a.sourceComponent(src) == comp {
+						part.Sources = append(part.Sources, src)
+					}
+				}
+			}
+			std.Parts = append(std.Parts, part)
+		}
+	}
+	return std, nil
+}
+
+// sourceComponent returns the component of a source package in the standard layout.
+func (a *Repository) sourceComponent(src *deb.SourcePackage) string {
+	if c := a.sourceComponents[src]; c != "" {
+		return c
+	}
+	return a.defaultComponent()
+}
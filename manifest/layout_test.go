@@ -0,0 +1,21 @@
+package manifest
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestComponentRequiresStandardLayout(t *testing.T) {
+	fsys := fstest.MapFS{
+		"repository.yml": {Data: []byte("path: repo\npackages:\n  - path: app.yml\n    component: contrib\n")},
+		"app.yml":        {Data: []byte("meta:\n  Package: app\n  Version: \"1.0\"\n  Architecture: all\n")},
+	}
+	a, err := NewRepositoryFromFS(fsys, "repository.yml", nil)
+	if err != nil {
+		t.Fatalf("NewRepositoryFromFS failed: %v", err)
+	}
+	if _, err := a.LoadPackages(); err == nil || !strings.Contains(err.Error(), "'component' requires 'layout: standard'") {
+		t.Errorf("LoadPackages of a component in a flat layout: %v", err)
+	}
+}
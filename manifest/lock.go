@@ -0,0 +1,130 @@
+package manifest
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// errFrozen is the error of a resource that does not match the lock file in frozen mode.
+var errFrozen = errors.New("frozen mode")
+
+// lockedResource is a web resource recorded in a lock file.
+type lockedResource struct {
+	// URL is the requested URL.
+	URL string `json:"url"`
+	// Resolved is the URL after redirects.
+	Resolved string `json:"resolved"`
+	// Size is the content size in bytes.
+	Size int64 `json:"size"`
+	// SHA256 is the hex encoded SHA256 checksum of the content.
+	SHA256 string `json:"sha256"`
+}
+
+// lockFile is the content of a lock file.
+type lockFile struct {
+	Resources []lockedResource `json:"resources"`
+}
+
+// resourceLock records the web resources used by a compilation. It is safe for concurrent use.
+type resourceLock struct {
+	path string
+	// frozen fails on any resource that is not in the lock file, or differs from it.
+	frozen bool
+	// locked are the resources of the existing lock file, by URL.
+	locked map[string]lockedResource
+
+	mu   sync.Mutex
+	used map[string]lockedResource
+}
+
+// newResourceLock reads the lock file at path, if it exists. In frozen mode, it must exist.
+func newResourceLock(path string, frozen bool) (*resourceLock, error) {
+	l := &resourceLock{
+		path:   path,
+		frozen: frozen,
+		locked: make(map[string]lockedResource),
+		used:   make(map[string]lockedResource),
+	}
+	content, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err) && !frozen:
+		return l, nil
+	case err != nil:
+		return nil, fmt.Errorf("reading lock file: %w", err)
+	}
+	var f lockFile
+	if err := json.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("parsing lock file %s: %w", path, err)
+	}
+	for _, r := range f.Resources {
+		l.locked[r.URL] = r
+	}
+	return l, nil
+}
+
+// record records the content of the resource at url, fetched from resolved.
+// In frozen mode, it fails if the resource is not locked, or its content changed.
+func (l *resourceLock) record(url, resolved string, content []byte) error {
+	if l == nil {
+		return nil
+	}
+	h := sha256.Sum256(content)
+	r := lockedResource{
+		URL:      url,
+		Resolved: cmp.Or(resolved, url),
+		Size:     int64(len(content)),
+		SHA256:   hex.EncodeToString(h[:]),
+	}
+	if l.frozen {
+		locked, ok := l.locked[url]
+		switch {
+		case !ok:
+			return fmt.Errorf("resource %s is not in the lock file %s: %w", url, l.path, errFrozen)
+		case locked.SHA256 != r.SHA256:
+			return fmt.Errorf("resource %s changed since the lock file %s (sha256 %s, locked %s): %w", url, l.path, r.SHA256, locked.SHA256, errFrozen)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.used[url] = r
+	return nil
+}
+
+// keep records the locked resource at url as used without fetching it, e.g. an upstream package already
+// in the repository, so that the lock file keeps it.
+func (l *resourceLock) keep(url string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.locked[url]; ok {
+		l.used[url] = r
+	}
+}
+
+// write writes the used resources to the lock file, sorted by URL.
+func (l *resourceLock) write() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var f lockFile
+	for _, url := range slices.Sorted(maps.Keys(l.used)) {
+		f.Resources = append(f.Resources, l.used[url])
+	}
+	content, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(l.path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing lock file: %w", err)
+	}
+	return nil
+}
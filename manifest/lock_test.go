@@ -0,0 +1,67 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+func TestLockIncrementalBuild(t *testing.T) {
+	upstream := &deb.StandardRepository{
+		ArchiveInfo: deb.ArchiveInfo{Codename: "stable"},
+		Parts: []*deb.Repository{{
+			ArchiveInfo: deb.ArchiveInfo{Components: "main", Architectures: "amd64"},
+			Packages:    []*deb.Package{{Metadata: deb.Metadata{Package: "app", Version: "1.0", Architecture: "amd64", Maintainer: "Test <test@example.com>", Description: "app"}}},
+		}},
+	}
+	served := t.TempDir()
+	if _, err := upstream.WriteToDir(served); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(served)))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "repository.yml")
+	content := fmt.Sprintf("path: repo\nupstream:\n  - url: %s\n    suite: stable\n    components: [main]\n    architectures: [amd64]\n", server.URL)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lockFile := filepath.Join(dir, "deb-pm.lock")
+	build := func(frozen bool) []byte {
+		t.Helper()
+		a, err := NewRepository(path)
+		if err != nil {
+			t.Fatalf("NewRepository failed: %v", err)
+		}
+		if err := a.CompileContext(context.Background(), CompileOptions{LockFile: lockFile, Frozen: frozen}, func(fmt.Stringer) {}); err != nil {
+			t.Fatalf("CompileContext failed: %v", err)
+		}
+		lock, err := os.ReadFile(lockFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lock
+	}
+	first := build(false)
+	if !strings.Contains(string(first), "app_1.0_amd64.deb") {
+		t.Fatalf("the lock file does not list the upstream package:\n%s", first)
+	}
+	// The package is already in the repository, it is not downloaded again.
+	if second := build(false); !bytes.Equal(first, second) {
+		t.Errorf("the lock file changed on an incremental build:\n%s\nwant:\n%s", second, first)
+	}
+	// A fresh checkout, with the lock file but without the repository.
+	if err := os.RemoveAll(filepath.Join(dir, "repo")); err != nil {
+		t.Fatal(err)
+	}
+	build(true)
+}
@@ -0,0 +1,201 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Metrics receives the measures of the compilations, e.g. to monitor a long running service
+// that builds repositories. See CompileOptions.Metrics, and Prometheus for an implementation.
+// Its methods can be called from several goroutines.
+type Metrics interface {
+	// Add adds value to the counter name, with labels.
+	Add(name string, value float64, labels map[string]string)
+	// Observe records the duration d of the timing name, with labels.
+	Observe(name string, d time.Duration, labels map[string]string)
+}
+
+// The metrics of a compilation.
+const (
+	// MetricCompilations counts the compilations, labeled with their "result": "success" or "failure".
+	MetricCompilations = "compilations_total"
+	// MetricCompileDuration is the duration of the compilations.
+	MetricCompileDuration = "compile_duration"
+	// MetricPackagesBuilt counts the packages built from their definition file.
+	MetricPackagesBuilt = "packages_built_total"
+	// MetricPackageBuildDuration is the duration of the successful package builds.
+	MetricPackageBuildDuration = "package_build_duration"
+	// MetricBytesDownloaded counts the bytes of the web resources downloaded, not read from the cache.
+	MetricBytesDownloaded = "downloaded_bytes_total"
+	// MetricFetchDuration is the duration of the web resource fetches, labeled with "cached": "true" or "false".
+	MetricFetchDuration = "fetch_duration"
+	// MetricCacheHits and MetricCacheMisses count the web resources read from the cache, or downloaded,
+	// when a cache is configured.
+	MetricCacheHits   = "cache_hits_total"
+	MetricCacheMisses = "cache_misses_total"
+	// MetricSignatures counts the Release files signed. Unchanged signatures are reused, and not counted.
+	MetricSignatures = "signatures_total"
+	// MetricErrors counts the errors, labeled with their "type": "package" for the packages failing with
+	// ContinueOnError, "canceled", "limit", "frozen", or "compile" for the other ones.
+	MetricErrors = "errors_total"
+)
+
+// metricsListener returns a listener recording the metrics of the events in m before passing them to l.
+// cached reports whether a web cache is configured, and plan whether the compilation is in ModePlan,
+// where the file operations are only planned: nothing is signed.
+func metricsListener(m Metrics, cached, plan bool, l Listener) Listener {
+	return func(e fmt.Stringer) {
+		switch v := e.(type) {
+		case EventPackageBuildFinished:
+			if v.Error == "" {
+				m.Add(MetricPackagesBuilt, 1, nil)
+				m.Observe(MetricPackageBuildDuration, v.Duration, nil)
+			}
+		case EventPackageFailure:
+			m.Add(MetricErrors, 1, map[string]string{"type": "package"})
+		case EventResourceFetched:
+			m.Observe(MetricFetchDuration, v.Duration, map[string]string{"cached": fmt.Sprint(v.Cached)})
+			if !v.Cached {
+				m.Add(MetricBytesDownloaded, float64(v.Size), nil)
+			}
+			switch {
+			case cached && v.Cached:
+				m.Add(MetricCacheHits, 1, nil)
+			case cached:
+				m.Add(MetricCacheMisses, 1, nil)
+			}
+		case EventFileOperation:
+			if !plan && (v.Created || v.Updated) && (v.Path == "InRelease" || strings.HasSuffix(v.Path, "/InRelease")) {
+				m.Add(MetricSignatures, 1, nil)
+			}
+		}
+		l(e)
+	}
+}
+
+// recordCompilation records the result and duration of a compilation in m.
+func recordCompilation(m Metrics, err error, d time.Duration) {
+	m.Observe(MetricCompileDuration, d, nil)
+	if err == nil {
+		m.Add(MetricCompilations, 1, map[string]string{"result": "success"})
+		return
+	}
+	m.Add(MetricCompilations, 1, map[string]string{"result": "failure"})
+	kind := "compile"
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		kind = "canceled"
+	case errors.Is(err, deb.ErrLimit):
+		kind = "limit"
+	case errors.Is(err, errFrozen):
+		kind = "frozen"
+	}
+	m.Add(MetricErrors, 1, map[string]string{"type": kind})
+}
+
+// Prometheus is a Metrics keeping the counters and timings in memory, and writing them in the
+// Prometheus text exposition format, e.g. for the node exporter textfile collector, or served over HTTP.
+// Counters are exported as counters, and timings as summaries in seconds, without quantiles.
+// Metric names are prefixed with Namespace and an underscore, if it is not empty.
+type Prometheus struct {
+	Namespace string
+
+	mu       sync.Mutex
+	counters map[string]map[string]float64
+	timings  map[string]map[string]*timing
+}
+
+// timing is the count and sum of the samples of a timing.
+type timing struct {
+	count int64
+	sum   time.Duration
+}
+
+// Add implements Metrics.
+func (p *Prometheus) Add(name string, value float64, labels map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.counters == nil {
+		p.counters = make(map[string]map[string]float64)
+	}
+	if p.counters[name] == nil {
+		p.counters[name] = make(map[string]float64)
+	}
+	p.counters[name][promLabels(labels)] += value
+}
+
+// Observe implements Metrics.
+func (p *Prometheus) Observe(name string, d time.Duration, labels map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timings == nil {
+		p.timings = make(map[string]map[string]*timing)
+	}
+	if p.timings[name] == nil {
+		p.timings[name] = make(map[string]*timing)
+	}
+	key := promLabels(labels)
+	t := p.timings[name][key]
+	if t == nil {
+		t = &timing{}
+		p.timings[name][key] = t
+	}
+	t.count++
+	t.sum += d
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format, sorted by name and labels.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var b strings.Builder
+	prefix := ""
+	if p.Namespace != "" {
+		prefix = p.Namespace + "_"
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.counters)) {
+		fmt.Fprintf(&b, "# TYPE %s%s counter\n", prefix, name)
+		for _, labels := range slices.Sorted(maps.Keys(p.counters[name])) {
+			fmt.Fprintf(&b, "%s%s%s %g\n", prefix, name, labels, p.counters[name][labels])
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.timings)) {
+		fmt.Fprintf(&b, "# TYPE %s%s_seconds summary\n", prefix, name)
+		for _, labels := range slices.Sorted(maps.Keys(p.timings[name])) {
+			t := p.timings[name][labels]
+			fmt.Fprintf(&b, "%s%s_seconds_sum%s %g\n", prefix, name, labels, t.sum.Seconds())
+			fmt.Fprintf(&b, "%s%s_seconds_count%s %d\n", prefix, name, labels, t.count)
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// promLabels returns the labels in the Prometheus format, e.g. `{type="limit"}`, or "" if there are none.
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var pairs []string
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[k])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, v))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,29 @@
+package manifest
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPromLabels(t *testing.T) {
+	got := promLabels(map[string]string{"type": `a "quoted" \ value`, "cached": "true"})
+	want := `{cached="true",type="a \"quoted\" \\ value"}`
+	if got != want {
+		t.Errorf("promLabels = %s, want %s", got, want)
+	}
+}
+
+func TestMetricSignaturesPlan(t *testing.T) {
+	for _, plan := range []bool{false, true} {
+		p := &Prometheus{}
+		l := metricsListener(p, false, plan, func(fmt.Stringer) {})
+		l(EventFileOperation{Path: "dists/stable/InRelease", Updated: true})
+		want := 1.0
+		if plan {
+			want = 0
+		}
+		if got := p.counters[MetricSignatures][""]; got != want {
+			t.Errorf("plan %v: %s = %v, want %v", plan, MetricSignatures, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// packageOutput is a built package to copy into an artifacts directory.
+type packageOutput struct {
+	filePath string
+	dir      string
+	pkg      *deb.Package
+}
+
+// outputDir returns the directory where the package built from p is also written, or "" if none.
+// The package output overrides the repository one.
+func (a *Repository) outputDir(p *Package) (string, error) {
+	if p.Output != "" {
+		dir, err := p.engine.render("output", p.Output)
+		if err != nil {
+			return "", err
+		}
+		return p.resolve(dir), nil
+	}
+	if a.Output != "" {
+		dir, err := a.engine.render("output", a.Output)
+		if err != nil {
+			return "", err
+		}
+		return a.resolve(dir), nil
+	}
+	return "", nil
+}
+
+// packageFile returns the path of the package file in the repository.
+func (a *Repository) packageFile(pkg *deb.Package) string {
+	if a.Layout == LayoutStandard {
+		return filepath.Join(a.resolve(a.Path), filepath.FromSlash(deb.PoolPath(a.componentOf(pkg), pkg)))
+	}
+	return filepath.Join(a.resolve(a.Path), pkg.StandardFilename())
+}
+
+// writeOutput copies the package file from the repository into the output directory,
+// so that both are identical. If dryRun is true, nothing is written.
+func (a *Repository) writeOutput(out packageOutput, dryRun bool) (EventPackageOutput, error) {
+	dst := filepath.Join(out.dir, out.pkg.StandardFilename())
+	e := EventPackageOutput{FilePath: out.filePath, Path: dst}
+
+	content, err := os.ReadFile(a.packageFile(out.pkg))
+	if os.IsNotExist(err) && dryRun {
+		// The repository has not been written, build the package instead.
+		var buf bytes.Buffer
+		_, err = out.pkg.WriteTo(&buf)
+		content = buf.Bytes()
+	}
+	if err != nil {
+		return e, fmt.Errorf("reading package %s: %w", out.pkg.StandardFilename(), err)
+	}
+
+	existing, err := os.ReadFile(dst)
+	switch {
+	case os.IsNotExist(err):
+		e.Created = true
+	case err != nil:
+		return e, err
+	case !bytes.Equal(existing, content):
+		e.Updated = true
+	}
+	if dryRun || !(e.Created || e.Updated) {
+		return e, nil
+	}
+	if err := os.MkdirAll(out.dir, 0755); err != nil {
+		return e, err
+	}
+	return e, os.WriteFile(dst, content, 0644)
+}
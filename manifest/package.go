@@ -1,13 +1,19 @@
 package manifest
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"maps"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/etnz/apt-repo-builder/deb"
 )
@@ -16,21 +22,79 @@ import (
 // It contains metadata, file injections, scripts, and other build instructions
 // loaded from a configuration file.
 type Package struct {
-	// Input is the path to an optional source .deb package to patch.
+	// Extends is the path (relative to this file) or web URL of a package definition this one is based on.
+	// Defines and meta are merged, injects, scripts and control files are appended, replacing the base
+	// entries with the same 'dst', images and hooks are appended, and the other fields replace the base ones when set.
+	// Relative paths in the base definition are relative to the base file.
+	Extends string `json:"extends" yaml:"extends"`
+	// Input is the path to an optional source .deb package to patch: a file path (relative to this file),
+	// a web URL, or a GitHub release asset as "github.com/<owner>/<repo>/tags/<tag>/<asset>"
+	// (or "github.com/<owner>/<repo>/latest/<asset>"), downloaded through the GitHub API.
 	Input string `json:"input" yaml:"input"`
+	// InputSHA256 is the optional expected SHA256 checksum of the Input package.
+	InputSHA256 string `json:"input_sha256" yaml:"input_sha256"`
 	// Defines is a map of local variables available to templates in this package.
 	Defines map[string]string `json:"defines" yaml:"defines"`
+	// Matrix expands the definition into one package per combination of its axes values.
+	// Each axis is available to templates as a variable named after the axis.
+	// Every axis needs values, and a define cannot have the name of an axis.
+	Matrix map[string][]string `json:"matrix" yaml:"matrix"`
+	// When is an optional condition. The package is skipped if it renders to a false value.
+	When string `json:"when" yaml:"when"`
+	// Arch builds one package per architecture key, e.g. "amd64" and "arm64". Each value overrides
+	// this definition for its architecture (a different input, different injected binaries...)
+	// with the same rules as Extends. The meta Architecture is the key.
+	Arch map[string]Package `json:"arch" yaml:"arch"`
 	// Meta contains fields to set or override in the package control file.
 	Meta map[string]string `json:"meta" yaml:"meta"`
+	// Remove are patterns of payload paths removed from the Input package before the injects,
+	// e.g. "/usr/share/doc/*" to strip its documentation. A matching directory is removed with its content.
+	// The patterns use the syntax of path.Match.
+	Remove []string `json:"remove" yaml:"remove"`
+	// Rename moves payload paths of the Input package to new paths before the injects, e.g. a binary
+	// conflicting with another package. A directory is moved with its content.
+	Rename map[string]string `json:"rename" yaml:"rename"`
 	// Injects is a list of files to add to the package payload.
 	Injects []File `json:"injects" yaml:"injects"`
+	// Images are container images whose files are extracted into the package payload.
+	Images []Image `json:"images" yaml:"images"`
 	// Scripts is a list of maintainer scripts to add to the package.
 	Scripts []File `json:"scripts" yaml:"scripts"`
 	// ControlFiles is a list of auxiliary control files to add.
 	ControlFiles []File `json:"control_files" yaml:"control_files"`
+	// Service is an optional systemd service shipped by the package.
+	Service *Service `json:"service" yaml:"service"`
+	// Changelog is an optional changelog, installed as /usr/share/doc/<Package>/changelog.Debian.gz.
+	Changelog *Changelog `json:"changelog" yaml:"changelog"`
+	// Copyright is an optional machine-readable copyright file, installed as /usr/share/doc/<Package>/copyright.
+	Copyright *Copyright `json:"copyright" yaml:"copyright"`
+	// Strategy decides what happens when the package version is already in the repository
+	// with a different content: StrategyStrict (the default), StrategyBump, StrategyOverwrite or StrategySafe.
+	Strategy string `json:"strategy" yaml:"strategy"`
+	// Compression is the compression of the package archives: "gzip" (the default), "zstd", "xz" or "none".
+	// Packages patched from an Input keep its compression by default.
+	Compression string `json:"compression" yaml:"compression"`
+	// Udeb builds a Debian installer component (.udeb): it has no md5sums nor conffiles.
+	Udeb bool `json:"udeb" yaml:"udeb"`
+	// Output is an optional directory, relative to the package definition file, where the built package
+	// is also written with its standard file name. It overrides the repository output.
+	Output string `json:"output" yaml:"output"`
+	// Before are hooks executed before building the package.
+	Before []Hook `json:"before" yaml:"before"`
+	// After are hooks executed after the package has been built.
+	After []Hook `json:"after" yaml:"after"`
 
-	filePath string
-	engine   *templateEngine
+	filePath  string
+	component string
+	engine    *templateEngine
+	fetcher   *fetcher
+	// allowExec allows files with an Exec command.
+	allowExec bool
+	// metaDefaults are the repository MetaDefaults.
+	metaDefaults map[string]string
+	// mode is the mode of the compilation building the package. Hooks only run in ModeBuild,
+	// and are only checked otherwise. In ModeValidate, commands are not run either, see loadFile.
+	mode CompileMode
 }
 
 func (p *Package) resolve(path string) string {
@@ -40,57 +104,179 @@ func (p *Package) resolve(path string) string {
 	return filepath.Join(filepath.Dir(p.filePath), path)
 }
 
-func (p *Package) loadResource(path string, raw bool) (string, error) {
+// loadResource reads the resource at path, a local file, a web URL or a GitHub release asset.
+// If sum is not empty, the resource content must match this SHA256 checksum.
+// Unless raw is true, the content is then rendered as a template.
+func (p *Package) loadResource(path string, raw bool, sum string) (string, error) {
 	var content []byte
 	var err error
 
-	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
-		//TODO: design a permanent cache for http resources (but it depends on the source capability to handle etag)
-		resp, err := http.Get(path)
+	switch {
+	case IsGitHubAsset(path):
+		content, err = p.fetcher.fetchGitHubAsset(path)
 		if err != nil {
-			return "", fmt.Errorf("failed to fetch resource %s: %w", path, err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("failed to fetch resource %s: %s", path, resp.Status)
+			return "", err
 		}
-
-		content, err = io.ReadAll(resp.Body)
+	case isURL(path):
+		content, err = p.fetcher.fetch(path)
 		if err != nil {
-			return "", fmt.Errorf("failed to read resource body %s: %w", path, err)
+			return "", err
 		}
-	} else {
+	default:
 		resolved := p.resolve(path)
-		content, err = os.ReadFile(resolved)
+		content, err = p.fetcher.readFile(resolved)
 		if err != nil {
 			return "", fmt.Errorf("reading resource %s: %w", resolved, err)
 		}
 	}
 
+	if sum != "" {
+		if err := verifySHA256(path, content, sum); err != nil {
+			return "", err
+		}
+	}
+
 	if raw {
 		return string(content), nil
 	}
 	return p.engine.render(path, string(content))
 }
 
+// expandMatrix returns every combination of the matrix axes values, as a map from axis name to value.
+// Axes are iterated in alphabetical order, so that the result is deterministic.
+// An empty matrix expands to a single empty combination.
+func expandMatrix(matrix map[string][]string) []map[string]string {
+	axes := make([]string, 0, len(matrix))
+	for axis := range matrix {
+		axes = append(axes, axis)
+	}
+	sort.Strings(axes)
+
+	combinations := []map[string]string{nil}
+	for _, axis := range axes {
+		var next []map[string]string
+		for _, c := range combinations {
+			for _, value := range matrix[axis] {
+				m := make(map[string]string, len(c)+1)
+				for k, v := range c {
+					m[k] = v
+				}
+				m[axis] = value
+				next = append(next, m)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
 // File represents a file resource to be injected into the package.
 type File struct {
 	// Src is the path to the source file (relative to the package definition file).
+	// Exactly one of Src, Exec, Link or Dir must be set.
 	Src string `json:"src" yaml:"src"`
+	// Exec is a command and its arguments, run in the directory of the package definition file,
+	// whose standard output is the file content. Commands only run if the compilation allows them.
+	Exec []string `json:"exec" yaml:"exec"`
+	// Link makes Dst a symbolic link to this target (e.g. "../lib/my-app/my-app"). Only for injects.
+	Link string `json:"link" yaml:"link"`
+	// Dir makes Dst a directory, e.g. an empty directory or one with a specific mode or owner.
+	// Parent directories of the files need no entry. Only for injects.
+	Dir bool `json:"dir" yaml:"dir"`
 	// Dst is the absolute path where the file will be installed on the target system.
-	Dst string `json:"dst" yaml:"dst"`
+	Dst string `json:"dst" yaml:"dst" jsonschema:"required"`
 	// Raw indicates whether the file should be treated as raw content (true) or processed as a template (false).
 	Raw bool `json:"raw" yaml:"raw"`
+	// Delims are the template delimiters of the content (e.g. ["[[", "]]"]), so that a file containing
+	// literal "{{" (an nginx configuration, a Go template) can still use variables. Default to "{{" and "}}".
+	Delims []string `json:"delims" yaml:"delims"`
+	// MissingKey controls references to undefined variables in the content: "error" (the default) fails,
+	// "zero" renders an empty string, and "default" renders "<no value>".
+	MissingKey string `json:"missing_key" yaml:"missing_key"`
 	// Mode is the file permissions in octal string format (e.g., "0755").
 	Mode string `json:"mode" yaml:"mode"`
 	// Conffile indicates if the file should be marked as a configuration file.
 	Conffile bool `json:"conffile" yaml:"conffile"`
+	// ConffilePolicy is an alternative to Conffile for configuration files: ConffilePolicyKeepUserChanges,
+	// ConffilePolicyRemoveOnPurge or ConffilePolicyForceOverwrite. Only for injects.
+	ConffilePolicy string `json:"conffile_policy" yaml:"conffile_policy"`
+	// Owner and Group are the user and group owning the file, as names or numeric ids. Default to root.
+	// Only for injects. The user must exist when dpkg unpacks the package (e.g. created in preinst).
+	Owner string `json:"owner" yaml:"owner"`
+	Group string `json:"group" yaml:"group"`
+	// SHA256 is the optional expected SHA256 checksum of the source content, before templating.
+	SHA256 string `json:"sha256" yaml:"sha256"`
+	// When is an optional condition. The file is skipped if it renders to a false value.
+	When string `json:"when" yaml:"when"`
+}
+
+// check checks the fields of a file of a package, a udeb or not, before they are rendered:
+// an injected file if inject is true, or a maintainer script or control file.
+func (f File) check(inject, udeb bool) error {
+	switch {
+	case !inject && (f.Owner != "" || f.Group != ""):
+		return fmt.Errorf("'owner' and 'group' are only supported in injects")
+	case f.Link != "" && !inject:
+		return fmt.Errorf("'link' is only supported in injects")
+	case f.Link != "" && (f.Src != "" || len(f.Exec) > 0 || f.Dir):
+		return fmt.Errorf("'link' cannot be used with 'src', 'exec' or 'dir'")
+	case f.Dir && !inject:
+		return fmt.Errorf("'dir' is only supported in injects")
+	case f.Dir && (f.Src != "" || len(f.Exec) > 0):
+		return fmt.Errorf("'dir' cannot be used with 'src' or 'exec'")
+	case f.Dir && f.Conffile:
+		return fmt.Errorf("a directory cannot be a conffile")
+	case len(f.Exec) > 0 && f.Src != "":
+		return fmt.Errorf("'src' and 'exec' cannot be used together")
+	case f.Link == "" && !f.Dir && len(f.Exec) == 0 && f.Src == "":
+		return fmt.Errorf("'src' is required")
+	}
+	if _, err := f.textOptions(); err != nil {
+		return err
+	}
+	return f.checkConffile(inject, udeb)
+}
+
+// verifySHA256 checks that content matches the expected hex encoded SHA256 checksum.
+func verifySHA256(path string, content []byte, expected string) error {
+	h := sha256.Sum256(content)
+	actual := hex.EncodeToString(h[:])
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", path, expected, actual)
+	}
+	return nil
+}
+
+// verifySHA512 checks that content matches the expected hex encoded SHA512 checksum.
+func verifySHA512(path string, content []byte, expected string) error {
+	h := sha512.Sum512(content)
+	actual := hex.EncodeToString(h[:])
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return fmt.Errorf("checksum mismatch for %s: expected sha512 %s, got %s", path, expected, actual)
+	}
+	return nil
 }
 
 // Apply generates a deb.Package from the definition and adds it to the provided repository.
 // It renders templates, loads resources, and populates the package structure.
 func (p *Package) Apply(repo *deb.Repository) (*deb.Package, error) {
+	pkg, err := p.Build()
+	if err != nil {
+		return nil, err
+	}
+	return addPackage(repo, pkg, p.Strategy)
+}
+
+// Build generates a deb.Package from the definition, without adding it to any repository.
+// It does not modify the definition, so several packages can be built concurrently.
+func (p *Package) Build() (*deb.Package, error) {
+	if err := checkStrategy(p.Strategy); err != nil {
+		return nil, err
+	}
+	if err := p.runHooks("before", p.Before); err != nil {
+		return nil, err
+	}
+
 	input, err := p.engine.render("input", p.Input)
 	if err != nil {
 		return nil, fmt.Errorf("rendering input: %w", err)
@@ -100,13 +286,20 @@ func (p *Package) Apply(repo *deb.Repository) (*deb.Package, error) {
 	if input == "" {
 		pkg = &deb.Package{Metadata: deb.Metadata{ExtraFields: make(map[string]string)}}
 	} else {
+		sum, err := p.engine.render("input_sha256", p.InputSHA256)
+		if err != nil {
+			return nil, fmt.Errorf("rendering input_sha256: %w", err)
+		}
+		if err := checkSHA256Syntax("input_sha256", sum); err != nil {
+			return nil, err
+		}
 		// The input .deb is a binary resource, so it should not be templated.
 		// We pass `true` for the `raw` parameter to load it as-is.
-		content, err := p.loadResource(input, true)
+		content, err := p.loadResource(input, true, sum)
 		if err != nil {
 			return nil, fmt.Errorf("reading input package %s: %w", input, err)
 		}
-		pkg, err = deb.NewPackage(strings.NewReader(content))
+		pkg, err = deb.NewPackageWithLimits(strings.NewReader(content), p.fetcher.parseLimits())
 		if err != nil {
 			return nil, fmt.Errorf("parsing input package %s: %w", input, err)
 		}
@@ -119,8 +312,64 @@ func (p *Package) Apply(repo *deb.Repository) (*deb.Package, error) {
 		}
 		pkg.Set(k, val)
 	}
+	for k, v := range p.metaDefaults {
+		if _, ok := p.Meta[k]; ok || pkg.Get(k) != "" {
+			continue
+		}
+		val, err := p.engine.render("meta_defaults."+k, v)
+		if err != nil {
+			return nil, fmt.Errorf("rendering meta default %s: %w", k, err)
+		}
+		pkg.Set(k, val)
+	}
+
+	if input == "" {
+		for _, k := range []string{"Package", "Version", "Architecture"} {
+			if pkg.Get(k) == "" {
+				return nil, fmt.Errorf("meta.%s is required when there is no input package", k)
+			}
+		}
+		if len(p.Remove) > 0 || len(p.Rename) > 0 {
+			return nil, fmt.Errorf("'remove' and 'rename' apply to the input package, there is none")
+		}
+	}
+
+	if err := p.applyRemove(pkg); err != nil {
+		return nil, err
+	}
+	for _, k := range slices.Sorted(maps.Keys(p.Rename)) {
+		from, err := p.engine.render("rename.key", k)
+		if err != nil {
+			return nil, err
+		}
+		to, err := p.engine.render("rename."+k, p.Rename[k])
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range []string{from, to} {
+			if !strings.HasPrefix(v, "/") || path.Clean(v) == "/" {
+				return nil, fmt.Errorf("rename.%s: %q is not an absolute path", k, v)
+			}
+		}
+		if err := pkg.RenameFile(from, to); err != nil {
+			return nil, err
+		}
+	}
 
+	// policies are the conffile policies of the injected files, by destination path.
+	type policy struct{ policy, dst string }
+	var policies []policy
 	for i, f := range p.Injects {
+		ok, err := p.engine.eval(fmt.Sprintf("injects[%d].when", i), f.When)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := f.check(true, p.Udeb); err != nil {
+			return nil, fmt.Errorf("injects[%d]: %w", i, err)
+		}
 		src, err := p.engine.render(fmt.Sprintf("injects[%d].src", i), f.Src)
 		if err != nil {
 			return nil, err
@@ -129,6 +378,33 @@ func (p *Package) Apply(repo *deb.Repository) (*deb.Package, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := deb.CheckDestPath(dst); err != nil {
+			return nil, fmt.Errorf("injects[%d].dst: %w", i, err)
+		}
+		owner, err := p.engine.render(fmt.Sprintf("injects[%d].owner", i), f.Owner)
+		if err != nil {
+			return nil, err
+		}
+		group, err := p.engine.render(fmt.Sprintf("injects[%d].group", i), f.Group)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range []struct{ field, value string }{{"owner", owner}, {"group", group}} {
+			if strings.ContainsAny(id.value, ": \t\n") {
+				return nil, fmt.Errorf("injects[%d].%s %q is not a valid user or group", i, id.field, id.value)
+			}
+		}
+		if f.Link != "" {
+			target, err := p.engine.render(fmt.Sprintf("injects[%d].link", i), f.Link)
+			if err != nil {
+				return nil, err
+			}
+			if target == "" {
+				return nil, fmt.Errorf("injects[%d].link is empty", i)
+			}
+			pkg.Files = append(pkg.Files, deb.File{DestPath: dst, LinkTarget: target, Owner: owner, Group: group})
+			continue
+		}
 
 		var mode int64 = 0644
 		if f.Mode != "" {
@@ -137,24 +413,58 @@ func (p *Package) Apply(repo *deb.Repository) (*deb.Package, error) {
 				return nil, err
 			}
 			mode, err = strconv.ParseInt(modeStr, 8, 64)
-			if err != nil {
-				return nil, fmt.Errorf("parsing mode %s: %w", modeStr, err)
+			if err != nil || mode < 0 || mode > 07777 {
+				return nil, fmt.Errorf("injects[%d].mode %q is not a valid octal file mode", i, modeStr)
+			}
+		}
+		if f.Dir {
+			if f.Mode == "" {
+				mode = 0755
 			}
+			pkg.Files = append(pkg.Files, deb.File{DestPath: dst, Mode: mode, IsDir: true, Owner: owner, Group: group})
+			continue
 		}
 
-		content, err := p.loadResource(src, f.Raw)
+		sum, err := p.engine.render(fmt.Sprintf("injects[%d].sha256", i), f.SHA256)
 		if err != nil {
 			return nil, err
 		}
+		content, err := p.loadFile(fmt.Sprintf("injects[%d]", i), f, src, sum)
+		if err != nil {
+			return nil, err
+		}
+		if f.ConffilePolicy != "" {
+			policies = append(policies, policy{f.ConffilePolicy, dst})
+			if f.ConffilePolicy != ConffilePolicyForceOverwrite {
+				// The file is installed by postinst.
+				dst = defaultsPath(pkg.Metadata.Package, dst)
+			}
+		}
 		pkg.Files = append(pkg.Files, deb.File{
 			DestPath: dst,
 			Mode:     mode,
 			Body:     content,
 			IsConf:   f.Conffile,
+			Owner:    owner,
+			Group:    group,
 		})
 	}
 
+	if err := p.applyImages(pkg); err != nil {
+		return nil, err
+	}
+
 	for i, f := range p.Scripts {
+		ok, err := p.engine.eval(fmt.Sprintf("scripts[%d].when", i), f.When)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := f.check(false, p.Udeb); err != nil {
+			return nil, fmt.Errorf("scripts[%d]: %w", i, err)
+		}
 		src, err := p.engine.render(fmt.Sprintf("scripts[%d].src", i), f.Src)
 		if err != nil {
 			return nil, err
@@ -163,7 +473,11 @@ func (p *Package) Apply(repo *deb.Repository) (*deb.Package, error) {
 		if err != nil {
 			return nil, err
 		}
-		content, err := p.loadResource(src, f.Raw)
+		sum, err := p.engine.render(fmt.Sprintf("scripts[%d].sha256", i), f.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		content, err := p.loadFile(fmt.Sprintf("scripts[%d]", i), f, src, sum)
 		if err != nil {
 			return nil, err
 		}
@@ -180,11 +494,21 @@ func (p *Package) Apply(repo *deb.Repository) (*deb.Package, error) {
 		case "config":
 			pkg.Scripts.Config = content
 		default:
-			return nil, fmt.Errorf("unknown script dst: %s", dst)
+			return nil, fmt.Errorf("scripts[%d].dst %q must be one of preinst, postinst, prerm, postrm, config", i, dst)
 		}
 	}
 
 	for i, f := range p.ControlFiles {
+		ok, err := p.engine.eval(fmt.Sprintf("control_files[%d].when", i), f.When)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := f.check(false, p.Udeb); err != nil {
+			return nil, fmt.Errorf("control_files[%d]: %w", i, err)
+		}
 		src, err := p.engine.render(fmt.Sprintf("control_files[%d].src", i), f.Src)
 		if err != nil {
 			return nil, err
@@ -193,7 +517,14 @@ func (p *Package) Apply(repo *deb.Repository) (*deb.Package, error) {
 		if err != nil {
 			return nil, err
 		}
-		content, err := p.loadResource(src, f.Raw)
+		if dst == "" || strings.Contains(dst, "/") {
+			return nil, fmt.Errorf("control_files[%d].dst %q must be a plain file name", i, dst)
+		}
+		sum, err := p.engine.render(fmt.Sprintf("control_files[%d].sha256", i), f.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		content, err := p.loadFile(fmt.Sprintf("control_files[%d]", i), f, src, sum)
 		if err != nil {
 			return nil, err
 		}
@@ -203,13 +534,111 @@ func (p *Package) Apply(repo *deb.Repository) (*deb.Package, error) {
 		pkg.ExtraControlFiles[dst] = content
 	}
 
-	existing, err := repo.Append(pkg)
-	switch {
-	case existing != nil && err == nil:
-		return existing, nil
-	case err != nil:
+	for _, c := range policies {
+		if err := applyConffilePolicy(pkg, c.policy, c.dst); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Changelog != nil {
+		content, err := p.renderChangelog(pkg)
+		if err != nil {
+			return nil, fmt.Errorf("rendering changelog: %w", err)
+		}
+		if err := pkg.SetChangelog(content); err != nil {
+			return nil, fmt.Errorf("setting changelog: %w", err)
+		}
+	}
+
+	if p.Copyright != nil {
+		c, err := p.renderCopyright(pkg)
+		if err != nil {
+			return nil, fmt.Errorf("rendering copyright: %w", err)
+		}
+		if err := pkg.SetCopyright(c); err != nil {
+			return nil, fmt.Errorf("setting copyright: %w", err)
+		}
+	}
+
+	if p.Service != nil {
+		if err := p.applyService(pkg); err != nil {
+			return nil, fmt.Errorf("applying service: %w", err)
+		}
+	}
+
+	if p.Compression != "" {
+		if pkg.Compression, err = deb.ParseCompression(p.Compression); err != nil {
+			return nil, err
+		}
+	}
+	if p.Udeb {
+		pkg.Udeb = true
+	}
+
+	if err := p.runHooks("after", p.After); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// packageHooks serializes the hooks of the packages built concurrently, which run commands and
+// write files in shared directories, e.g. the directory of the repository file.
+var packageHooks sync.Mutex
+
+// runHooks runs the hooks of a section of the package, while no other package runs its hooks.
+// Unless in ModeBuild, the hooks are only checked and rendered, not executed.
+// Run hooks are refused unless commands are allowed, like exec files.
+func (p *Package) runHooks(section string, hooks []Hook) error {
+	for i, h := range hooks {
+		if h.Run != "" && !p.allowExec {
+			return fmt.Errorf("%s[%d].run: running commands is not allowed (see CompileOptions.AllowExec, or the deb-pm -allow-exec flag)", section, i)
+		}
+	}
+	if p.mode != ModeBuild {
+		return errors.Join(checkHooks(p.engine, section, hooks)...)
+	}
+	packageHooks.Lock()
+	defer packageHooks.Unlock()
+	return runHooks(p.engine, p.fetcher, section, hooks, p.resolve)
+}
+
+// applyRemove removes the payload entries of pkg matching the Remove patterns, or below a matching directory.
+func (p *Package) applyRemove(pkg *deb.Package) error {
+	var patterns []string
+	for i, pattern := range p.Remove {
+		v, err := p.engine.render(fmt.Sprintf("remove[%d]", i), pattern)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, v)
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+	for i, v := range patterns {
+		if _, err := path.Match(v, ""); err != nil || !strings.HasPrefix(v, "/") {
+			return fmt.Errorf("remove[%d]: %q is not an absolute path pattern", i, v)
+		}
+	}
+	pkg.FilterFiles(func(f deb.File) bool {
+		for d := path.Clean("/" + f.DestPath); d != "/"; d = path.Dir(d) {
+			for _, pattern := range patterns {
+				if ok, _ := path.Match(pattern, d); ok {
+					return false
+				}
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// appendPackage adds pkg to repo, and returns the package actually in the repository:
+// pkg itself, or the existing identical package.
+func appendPackage(repo *deb.Repository, pkg *deb.Package) (*deb.Package, error) {
+	added, err := repo.AddSafe(pkg)
+	if err != nil {
 		return nil, fmt.Errorf("appending package %s: %w", pkg.Metadata.Package, err)
-	default:
-		return pkg, nil
 	}
+	return added, nil
 }
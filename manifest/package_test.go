@@ -0,0 +1,58 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	got := expandMatrix(map[string][]string{"os": {"linux", "darwin"}, "edition": {"ce", "ee"}})
+	want := "[map[edition:ce os:linux] map[edition:ce os:darwin] map[edition:ee os:linux] map[edition:ee os:darwin]]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("expandMatrix = %v, want %v", got, want)
+	}
+	if got := expandMatrix(nil); len(got) != 1 || len(got[0]) != 0 {
+		t.Errorf("expandMatrix(nil) = %v, want a single empty combination", got)
+	}
+}
+
+func TestLoadPackagesMatrix(t *testing.T) {
+	load := func(def string) ([]Package, error) {
+		t.Helper()
+		fsys := fstest.MapFS{
+			"repository.yml": {Data: []byte("path: repo\npackages:\n  - app.yml\n")},
+			"app.yml":        {Data: []byte(def)},
+		}
+		a, err := NewRepositoryFromFS(fsys, "repository.yml", nil)
+		if err != nil {
+			t.Fatalf("NewRepositoryFromFS failed: %v", err)
+		}
+		return a.LoadPackages()
+	}
+	pkgs, err := load("meta:\n  Package: app-{{.edition}}\nmatrix:\n  edition: [ce, ee, pro]\nwhen: '{{ne .edition \"pro\"}}'\ndefines:\n  NAME: app-{{.edition}}\n")
+	if err != nil {
+		t.Fatalf("LoadPackages failed: %v", err)
+	}
+	var names []string
+	for _, p := range pkgs {
+		name, err := p.engine.render("name", "{{.NAME}}")
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		names = append(names, name)
+	}
+	if got := strings.Join(names, " "); got != "app-ce app-ee" {
+		t.Errorf("LoadPackages packages = %q, want %q", got, "app-ce app-ee")
+	}
+
+	for def, want := range map[string]string{
+		"matrix:\n  edition: []\n":                            `matrix axis "edition" has no values`,
+		"matrix:\n  edition: [ce]\ndefines:\n  edition: ee\n": `define "edition" clashes with the matrix axis`,
+	} {
+		if _, err := load(def); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadPackages(%q) = %v, want an error containing %q", def, err, want)
+		}
+	}
+}
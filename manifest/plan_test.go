@@ -0,0 +1,21 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+func TestPlanPackagePrevious(t *testing.T) {
+	pkg := func(version string) *deb.Package {
+		return &deb.Package{Metadata: deb.Metadata{Package: "app", Version: version, Architecture: "amd64"}}
+	}
+	before := []*deb.Package{pkg("1.10-1"), pkg("1.9-1"), pkg("1.2-1")}
+	e, err := planPackage(before, "app.yml", pkg("1.11-1"))
+	if err != nil {
+		t.Fatalf("planPackage failed: %v", err)
+	}
+	if e.Action != PlanBump || e.Previous != "1.10-1" {
+		t.Errorf("planPackage = %s, previous %q, want %s, previous %q", e.Action, e.Previous, PlanBump, "1.10-1")
+	}
+}
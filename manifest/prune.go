@@ -0,0 +1,86 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Prune applies the retention of the repository, or of every repository of the file, to the packages
+// already published, without building anything: the old packages are removed from the indices, and their files deleted.
+// With ModePlan, the removals are only reported. Only the GPGKey and Mode options are used.
+// It returns the packages kept in the repositories.
+func (a *Repository) Prune(opts CompileOptions, l Listener) ([]*deb.Package, error) {
+	return a.PruneContext(context.Background(), opts, l)
+}
+
+// PruneContext is like Prune, and stops before writing the repository when ctx is canceled.
+func (a *Repository) PruneContext(ctx context.Context, opts CompileOptions, l Listener) ([]*deb.Package, error) {
+	if l == nil {
+		l = func(fmt.Stringer) {}
+	}
+	repos := []*Repository{a}
+	if len(a.repositories) > 0 {
+		repos = a.repositories
+	}
+	var kept []*deb.Package
+	for i, r := range repos {
+		r.fetcher.ctx = ctx
+		r.fetcher.log = r.redactor.logger(opts.Logger)
+		pkgs, err := r.prune(ctx, opts, r.redactor.listener(l))
+		if err != nil {
+			if len(a.repositories) > 0 {
+				err = fmt.Errorf("repositories[%d] (%s): %w", i, r.Path, err)
+			}
+			return nil, a.redactor.error(err)
+		}
+		kept = append(kept, pkgs...)
+	}
+	return kept, nil
+}
+
+// prune applies the retention to the packages of the repository, and returns the packages kept.
+func (a *Repository) prune(ctx context.Context, opts CompileOptions, l Listener) ([]*deb.Package, error) {
+	start := time.Now()
+	repo, err := a.LoadRepository()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repo: %w", err)
+	}
+	l(EventRepositoryLoadSuccess{Path: a.Path})
+	repo.GPGKey = opts.GPGKey
+	repo.Logger = a.fetcher.log
+	repo.Progress = func(p deb.Progress) { l(EventProgress{p}) }
+	repo.Hashes = opts.Hashes
+	repo.IndexCompressions = opts.IndexCompressions
+
+	pruned, err := a.applyRetention(repo, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply retention: %w", err)
+	}
+	if len(pruned) == 0 {
+		return repo.Packages, nil
+	}
+	for _, e := range pruned {
+		l(e)
+	}
+
+	ops, err := a.saveRepository(ctx, repo, opts.Mode == ModePlan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save repo: %w", err)
+	}
+	for _, op := range ops {
+		l(fileOperationEvent(op))
+	}
+	if opts.Mode == ModePlan {
+		return repo.Packages, nil
+	}
+	for _, e := range pruned {
+		if err := removePrunedFile(e); err != nil {
+			return nil, fmt.Errorf("failed to apply retention: %w", err)
+		}
+	}
+	l(EventRepositorySaveSuccess{Path: a.Path, Duration: time.Since(start)})
+	return repo.Packages, nil
+}
@@ -0,0 +1,123 @@
+package manifest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// initRepositories merges every entry of Repositories with this file, and initializes them.
+func (a *Repository) initRepositories() error {
+	if a.Path != "" {
+		return fmt.Errorf("'path' and 'repositories' cannot be used together, set 'path' on each repository")
+	}
+	for i, r := range a.Repositories {
+		if len(r.Repositories) > 0 {
+			return fmt.Errorf("repositories[%d]: nested 'repositories' are not supported", i)
+		}
+		child := a.inherit(r)
+		if err := child.init(a.filePath); err != nil {
+			return fmt.Errorf("repositories[%d]: %w", i, err)
+		}
+		if err := child.check(); err != nil {
+			return fmt.Errorf("repositories[%d]: %w", i, err)
+		}
+		a.repositories = append(a.repositories, child)
+	}
+	return nil
+}
+
+// inherit returns r completed with the fields of a.
+// Defines, meta defaults, values, env, secrets, auth, packages and upstream are merged (r's maps win),
+// the other fields of a are used when r does not set them. Hooks are not inherited:
+// the hooks of a run once, before and after all the repositories.
+func (a *Repository) inherit(r Repository) *Repository {
+	merged := r
+	merged.Defines = mergeMaps(a.Defines, r.Defines)
+	merged.Secrets = mergeMaps(a.Secrets, r.Secrets)
+	merged.MetaDefaults = mergeMaps(a.MetaDefaults, r.MetaDefaults)
+	merged.Values = append(slices.Clone(a.Values), r.Values...)
+	merged.overrides = a.overrides
+	merged.fsys = a.fsys
+	merged.Env = append(slices.Clone(a.Env), r.Env...)
+	merged.Auth = append(slices.Clone(a.Auth), r.Auth...)
+	merged.Packages = append(slices.Clone(a.Packages), r.Packages...)
+	merged.Upstream = append(slices.Clone(a.Upstream), r.Upstream...)
+	merged.Output = cmp.Or(r.Output, a.Output)
+	merged.Layout = cmp.Or(r.Layout, a.Layout)
+	merged.Suite = cmp.Or(r.Suite, a.Suite)
+	merged.Codename = cmp.Or(r.Codename, a.Codename)
+	merged.Translations = r.Translations || a.Translations
+	if r.Components == nil {
+		merged.Components = a.Components
+	}
+	if r.Architectures == nil {
+		merged.Architectures = a.Architectures
+	}
+	if r.Retention == nil {
+		merged.Retention = a.Retention
+	}
+	return &merged
+}
+
+// mergeMaps returns the union of base and override. Values in override win.
+func mergeMaps[V any](base, override map[string]V) map[string]V {
+	if base == nil && override == nil {
+		return nil
+	}
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = make(map[string]V)
+	}
+	maps.Copy(merged, override)
+	return merged
+}
+
+// compileRepositories compiles every repository in order, between the hooks of this file.
+// It stops at the first repository that fails, unless opts.ContinueOnError is set.
+func (a *Repository) compileRepositories(ctx context.Context, opts CompileOptions, l Listener) error {
+	if err := a.runHooks(opts.Mode, "before", a.Before); err != nil {
+		return fmt.Errorf("failed to run repository hooks: %w", err)
+	}
+	var errs []error
+	for i, r := range a.repositories {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// The options (cache, lock...) are already applied to every repository.
+		if err := r.redactor.error(r.compile(ctx, opts, r.redactor.listener(l))); err != nil {
+			err = fmt.Errorf("repositories[%d] (%s): %w", i, r.Path, err)
+			if !opts.ContinueOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	if opts.Mode == ModePlan {
+		return errors.Join(errs...)
+	}
+	if err := a.runHooks(opts.Mode, "after", a.After); err != nil {
+		return fmt.Errorf("failed to run repository hooks: %w", err)
+	}
+	return errors.Join(errs...)
+}
+
+// validateRepositories validates the hooks of this file, and every repository.
+func (a *Repository) validateRepositories() error {
+	var errs []error
+	for _, err := range checkHooks(a.engine, "before", a.Before) {
+		errs = append(errs, fmt.Errorf("%s: %w", a.filePath, err))
+	}
+	for _, err := range checkHooks(a.engine, "after", a.After) {
+		errs = append(errs, fmt.Errorf("%s: %w", a.filePath, err))
+	}
+	for i, r := range a.repositories {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("repositories[%d] (%s): %w", i, r.Path, r.redactor.error(err)))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -3,11 +3,21 @@ package manifest
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/etnz/apt-repo-builder/deb"
 	"go.yaml.in/yaml/v3"
@@ -16,7 +26,27 @@ import (
 // NewRepository loads and parses a Repository configuration from the specified file path.
 // It supports both JSON and YAML formats based on the file extension.
 func NewRepository(path string) (*Repository, error) {
-	content, err := os.ReadFile(path)
+	return NewRepositoryWithOverrides(path, nil)
+}
+
+// NewRepositoryWithOverrides is like NewRepository, but overrides take precedence over
+// the Defines and Values of the file, and over the package defines (e.g. to inject the version from CI).
+func NewRepositoryWithOverrides(path string, overrides map[string]string) (*Repository, error) {
+	return newRepository(nil, path, overrides)
+}
+
+// NewRepositoryFromFS is like NewRepositoryWithOverrides, for the repository file name of fsys,
+// e.g. an embedded file system or test fixtures. The values, secret files, package definitions
+// and local resources are read from fsys too, with slash separated paths relative to its root.
+// The repository is still generated on the OS file system, in its path relative to the current directory,
+// and hooks and commands run there.
+func NewRepositoryFromFS(fsys fs.FS, name string, overrides map[string]string) (*Repository, error) {
+	return newRepository(fsys, name, overrides)
+}
+
+// newRepository loads the repository file at path, from fsys, or from the OS file system if fsys is nil.
+func newRepository(fsys fs.FS, path string, overrides map[string]string) (*Repository, error) {
+	content, err := readLocal(fsys, path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read archivefile: %w", err)
 	}
@@ -25,36 +55,173 @@ func NewRepository(path string) (*Repository, error) {
 	if err := unmarshal(path, content, &archive); err != nil {
 		return nil, fmt.Errorf("failed to parse archivefile: %w", err)
 	}
+	archive.overrides = overrides
+	archive.fsys = fsys
 
-	archive.filePath = path
-	archive.engine, err = newTemplateEngine(archive.Defines)
+	if err := archive.init(path); err != nil {
+		return nil, err
+	}
+	if len(archive.Repositories) > 0 {
+		if err := archive.initRepositories(); err != nil {
+			return nil, err
+		}
+		return &archive, nil
+	}
+	if err := archive.check(); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// init prepares a repository loaded from the file at path: secrets, templates and credentials.
+func (a *Repository) init(path string) error {
+	a.filePath = path
+	secrets, secretErrs := a.resolveSecrets()
+	a.redactor = newRedactor(secrets)
+	defines, err := a.loadValues(a.Defines)
+	if err != nil {
+		return err
+	}
+	if len(a.overrides) > 0 {
+		defines = mergeMaps(defines, a.overrides)
+	}
+	a.engine, err = newTemplateEngine(defines, template.FuncMap{
+		"env":         envFunc(a.Env),
+		"secret":      secretFunc(secrets, secretErrs),
+		"repoVersion": a.repoVersion,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize template engine: %w", err)
+	}
+	// Overrides also take precedence over the package defines.
+	a.engine.pin(slices.Collect(maps.Keys(a.overrides)))
+	a.fetcher, err = newFetcher(a.engine, a.Auth)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize template engine: %w", err)
+		return a.redactor.error(fmt.Errorf("failed to configure auth: %w", err))
 	}
+	a.fetcher.fsys = a.fsys
+	return nil
+}
 
-	if archive.Path == "" {
-		return nil, fmt.Errorf("archivefile must specify 'repo'")
+// check checks the configuration of a repository to compile.
+func (a *Repository) check() error {
+	if a.Path == "" {
+		return fmt.Errorf("archivefile must specify 'repo'")
 	}
-	return &archive, nil
+	if err := a.checkLayout(); err != nil {
+		return err
+	}
+	if a.Retention != nil {
+		if err := a.Retention.check(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Repository represents the configuration for an APT repository archive.
 // It defines the output directory, global variables, and the list of packages to include.
 type Repository struct {
 	// Path is the directory path where the repository will be generated.
+	// It is required, unless the file defines Repositories.
 	Path string `json:"path" yaml:"path"`
 	// Defines is a map of global variables available to templates.
 	Defines map[string]string `json:"defines" yaml:"defines"`
-	// Packages is a list of paths to package definition files to include in the repository.
-	Packages []string `json:"packages" yaml:"packages"`
+	// Values are YAML or JSON files, relative to the repository file, whose keys are merged into Defines.
+	// Keys of later files override earlier ones, and all of them override Defines.
+	Values []string `json:"values" yaml:"values"`
+	// Env is the list of environment variables that templates are allowed to read using the env function.
+	Env []string `json:"env" yaml:"env"`
+	// Secrets are values available to templates using the secret function.
+	// They are redacted from events and error messages.
+	Secrets map[string]Secret `json:"secrets" yaml:"secrets"`
+	// MetaDefaults are control fields (e.g. Maintainer, Section, Priority, Homepage) set on every package
+	// built from a definition, unless its meta or its input package sets them. Values are templates,
+	// rendered with the package defines.
+	MetaDefaults map[string]string `json:"meta_defaults" yaml:"meta_defaults"`
+	// Packages is a list of package definition files to include in the repository.
+	Packages []PackageRef `json:"packages" yaml:"packages"`
+	// Upstream are APT repositories whose packages are imported before applying the local packages.
+	Upstream []Upstream `json:"upstream" yaml:"upstream"`
+	// Auth configures the credentials used to fetch web resources, by URL prefix.
+	Auth []Auth `json:"auth" yaml:"auth"`
+	// Output is an optional directory, relative to the repository file, where every built package
+	// is also written with its standard file name (e.g. to publish them as release assets).
+	Output string `json:"output" yaml:"output"`
+	// Layout is the repository layout: LayoutFlat (the default) or LayoutStandard.
+	Layout string `json:"layout" yaml:"layout"`
+	// Suite is the distribution suite of a standard layout (e.g. "stable").
+	Suite string `json:"suite" yaml:"suite"`
+	// Codename is the distribution codename of a standard layout (e.g. "bookworm"). Defaults to Suite.
+	Codename string `json:"codename" yaml:"codename"`
+	// Components are the components of a standard layout. Defaults to "main".
+	// Packages go to the first component, unless their entry sets one.
+	Components []string `json:"components" yaml:"components"`
+	// Architectures are the architectures of a standard layout.
+	// Defaults to the architectures of the packages.
+	Architectures []string `json:"architectures" yaml:"architectures"`
+	// Translations moves the long descriptions of the packages of a standard layout from the Packages
+	// indices to the i18n/Translation-en index of their component, see deb.StandardRepository.Translations.
+	Translations bool `json:"translations" yaml:"translations"`
+	// Retention removes old package versions after the packages have been added.
+	Retention *Retention `json:"retention" yaml:"retention"`
+	// Before are hooks executed before building the packages.
+	Before []Hook `json:"before" yaml:"before"`
+	// After are hooks executed after the repository has been written.
+	After []Hook `json:"after" yaml:"after"`
+	// Repositories are several repositories compiled from this file (e.g. stable and nightly).
+	// Each one inherits the other fields of this file: defines, values, env, secrets, auth, packages
+	// and upstream are merged, and the other fields are used when the repository does not set them.
+	Repositories []Repository `json:"repositories" yaml:"repositories"`
 
 	filePath string
-	engine   *templateEngine
+	// fsys is the file system of the local files, nil for the OS one.
+	fsys fs.FS
+	// overrides take precedence over Defines and Values.
+	overrides map[string]string
+	// allowExec is CompileOptions.AllowExec.
+	allowExec bool
+	engine    *templateEngine
+	redactor  *redactor
+	fetcher   *fetcher
+	// components records the component of each package of a standard layout.
+	components map[*deb.Package]string
+	// sourceComponents records the component of each source package of a standard layout.
+	sourceComponents map[*deb.SourcePackage]string
+	// repositories are the Repositories, merged with this file and initialized.
+	repositories []*Repository
+	// current is the repository being compiled, once loaded and its upstream packages imported.
+	current *deb.Repository
+}
+
+// repoVersion returns the highest version of the named package in the repository being compiled,
+// optionally for a single architecture, or "" if there is none (or when validating).
+// It is the 'repoVersion' template function.
+func (a *Repository) repoVersion(name string, arch ...string) (string, error) {
+	if len(arch) > 1 {
+		return "", fmt.Errorf("repoVersion: expected a package name and an optional architecture")
+	}
+	if a.current == nil {
+		return "", nil
+	}
+	var architecture string
+	if len(arch) == 1 {
+		architecture = arch[0]
+	}
+	if latest := a.current.Latest(name, architecture); latest != nil {
+		return latest.Metadata.Version, nil
+	}
+	return "", nil
 }
 
 // LoadRepository initializes the underlying deb.Repository from the configured Path.
 // If the directory does not exist, it creates a new empty repository in memory.
 func (a *Repository) LoadRepository() (*deb.Repository, error) {
+	a.components = make(map[*deb.Package]string)
+	a.sourceComponents = make(map[*deb.SourcePackage]string)
+	if a.Layout == LayoutStandard {
+		return a.loadStandardRepository()
+	}
 	repo, err := deb.NewRepositoryFromDir(a.resolve(a.Path))
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -73,64 +240,332 @@ func (a *Repository) LoadRepository() (*deb.Repository, error) {
 // LoadPackages reads and parses all package definition files listed in the configuration.
 // It resolves paths relative to the Repository file and initializes template engines for each package.
 func (a *Repository) LoadPackages() ([]Package, error) {
-	var pkgs []Package
+	return a.loadPackages(func(_ string, err error) error { return err })
+}
 
-	for _, pkgFileRaw := range a.Packages {
-		// pkgFile can be
-		//  - a relative path to the repository file
-		//  - an absolute file path on the machine
-		//  - a URL
-		//
-		// We want to find the resource, or course, but also get a valid string for error message
-		pkgFile, err := a.engine.render("package-list", pkgFileRaw)
+// loadPackages is like LoadPackages, but the entries that cannot be loaded are passed to fail,
+// and only stop the loading if fail returns an error.
+func (a *Repository) loadPackages(fail func(path string, err error) error) ([]Package, error) {
+	var pkgs []Package
+	for i, ref := range a.Packages {
+		loaded, err := a.loadPackageRef(i, ref)
 		if err != nil {
-			return nil, fmt.Errorf("rendering package path %q: %w", pkgFileRaw, err)
-		}
-		pkgPath := a.resolve(pkgFile)
-
-		if strings.HasSuffix(strings.ToLower(pkgPath), ".deb") {
-			eng, err := a.engine.sub(nil)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create engine for %s: %w", pkgPath, err)
-			}
-			pkg := Package{
-				Input:    pkgPath,
-				filePath: pkgPath,
-				engine:   eng,
+			if err := fail(ref.Path, err); err != nil {
+				return nil, err
 			}
-			pkgs = append(pkgs, pkg)
 			continue
 		}
+		pkgs = append(pkgs, loaded...)
+	}
+	return pkgs, nil
+}
 
-		pkgContent, err := a.loadResource(pkgFile)
+// loadPackageRef loads the packages defined by the i-th entry of the packages list.
+// It returns no package if the entry condition is false, and several if the definition has a matrix.
+func (a *Repository) loadPackageRef(i int, ref PackageRef) ([]Package, error) {
+	ok, err := a.engine.eval(fmt.Sprintf("packages[%d].when", i), ref.When)
+	if err != nil {
+		return nil, fmt.Errorf("evaluating condition for %q: %w", ref.Path, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	pkgFileRaw := ref.Path
+	// pkgFile can be
+	//  - a relative path to the repository file
+	//  - an absolute file path on the machine
+	//  - a URL
+	//
+	// We want to find the resource, or course, but also get a valid string for error message
+	pkgFile, err := a.engine.render("package-list", pkgFileRaw)
+	if err != nil {
+		return nil, fmt.Errorf("rendering package path %q: %w", pkgFileRaw, err)
+	}
+	pkgPath := a.resolve(pkgFile)
+	component, err := a.engine.render("package-component", ref.Component)
+	if err != nil {
+		return nil, fmt.Errorf("rendering component for %q: %w", pkgFileRaw, err)
+	}
+	if err := a.checkComponent(component); err != nil {
+		return nil, fmt.Errorf("%s: packages[%d]: %w", a.filePath, i, err)
+	}
+
+	if strings.HasSuffix(strings.ToLower(pkgPath), ".deb") {
+		eng, err := a.engine.sub(nil)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read package definition %s: %v", pkgPath, err)
+			return nil, fmt.Errorf("failed to create engine for %s: %w", pkgPath, err)
 		}
+		pkg := Package{
+			Input:     pkgPath,
+			filePath:  pkgPath,
+			component: component,
+			engine:    eng,
+			fetcher:   a.fetcher,
+		}
+		return []Package{pkg}, nil
+	}
+
+	pkgContent, err := a.loadResource(pkgFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package definition %s: %v", pkgPath, err)
+	}
+
+	var pkg Package
+	if err := unmarshal(pkgFile, []byte(pkgContent), &pkg); err != nil {
+		return nil, fmt.Errorf("failed to parse package definition %s: %v", pkgPath, err)
+	}
+	if pkg, err = a.loadExtends(pkgPath, pkg, []string{pkgPath}); err != nil {
+		return nil, fmt.Errorf("failed to load package definition %s: %w", pkgPath, err)
+	}
+	// Hooks of local bases, e.g. extended by absolute path, are refused in remote definitions too.
+	if err := checkRemoteHooks(pkgPath, pkg); err != nil {
+		return nil, fmt.Errorf("failed to load package definition %s: %w", pkgPath, err)
+	}
 
-		var pkg Package
-		if err := unmarshal(pkgFile, []byte(pkgContent), &pkg); err != nil {
-			return nil, fmt.Errorf("failed to parse package definition %s: %v", pkgPath, err)
+	// if the file path is a URL, use
+	pkg.filePath = pkgPath
+	pkg.component = component
+	pkg.fetcher = a.fetcher
+	pkg.allowExec = a.allowExec
+	pkg.metaDefaults = a.MetaDefaults
+
+	archs, err := pkg.expandArch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load package definition %s: %w", pkgPath, err)
+	}
+	var pkgs []Package
+	for _, pkg := range archs {
+		variants, err := a.expandPackage(pkgPath, pkg)
+		if err != nil {
+			return nil, err
 		}
+		pkgs = append(pkgs, variants...)
+	}
+	return pkgs, nil
+}
 
-		pkg.engine, err = a.engine.sub(pkg.Defines)
+// expandPackage returns the packages of every matrix combination of a definition, loaded from pkgPath,
+// whose condition holds.
+func (a *Repository) expandPackage(pkgPath string, pkg Package) ([]Package, error) {
+	for _, axis := range slices.Sorted(maps.Keys(pkg.Matrix)) {
+		if len(pkg.Matrix[axis]) == 0 {
+			return nil, fmt.Errorf("%s: matrix axis %q has no values", pkgPath, axis)
+		}
+		if _, ok := pkg.Defines[axis]; ok {
+			return nil, fmt.Errorf("%s: define %q clashes with the matrix axis of the same name", pkgPath, axis)
+		}
+	}
+	var pkgs []Package
+	for _, axes := range expandMatrix(pkg.Matrix) {
+		variant := pkg
+		eng, err := a.engine.sub(axes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process matrix %v for %s: %w", axes, pkgPath, err)
+		}
+		variant.engine, err = eng.sub(pkg.Defines)
 		if err != nil {
 			return nil, fmt.Errorf("failed to process defines for %s: %w", pkgPath, err)
 		}
+		ok, err := variant.engine.eval("when", pkg.When)
+		if err != nil {
+			return nil, fmt.Errorf("evaluating condition for %s: %w", pkgPath, err)
+		}
+		if !ok {
+			continue
+		}
+		pkgs = append(pkgs, variant)
+	}
+	return pkgs, nil
+}
+
+// PackageRef is an entry of the Repository packages list.
+// In configuration files, it is either a plain path, or an object with a path and a condition.
+type PackageRef struct {
+	// Path is the path or URL to a package definition file or a .deb file.
+	Path string `json:"path" yaml:"path" jsonschema:"required"`
+	// When is an optional condition. The package is skipped if it renders to a false value.
+	When string `json:"when" yaml:"when"`
+	// Component is the component of the package in a standard layout.
+	// Defaults to the first component of the repository.
+	Component string `json:"component" yaml:"component"`
+}
+
+// UnmarshalJSON accepts either a JSON string or a JSON object.
+func (r *PackageRef) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.Path); err == nil {
+		return nil
+	}
+	type plain PackageRef
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*plain)(r))
+}
 
-		// if the file path is a URL, use
-		pkg.filePath = pkgPath
-		pkgs = append(pkgs, pkg)
+// UnmarshalYAML accepts either a YAML scalar or a YAML mapping.
+func (r *PackageRef) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&r.Path)
 	}
+	if err := checkKnownFields(node, "path", "when", "component"); err != nil {
+		return err
+	}
+	type plain PackageRef
+	return node.Decode((*plain)(r))
+}
 
-	return pkgs, nil
+// checkKnownFields returns an error if the mapping node contains a key that is not listed.
+// Custom YAML unmarshalers need it because node.Decode does not honor KnownFields.
+func checkKnownFields(node *yaml.Node, known ...string) error {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		if !slices.Contains(known, key) {
+			return fmt.Errorf("line %d: field %s not found", node.Content[i].Line, key)
+		}
+	}
+	return nil
+}
+
+// CompileMode selects what Compile does with the repository.
+type CompileMode int
+
+const (
+	// ModeBuild builds all the packages and writes the repository.
+	ModeBuild CompileMode = iota
+	// ModeValidate checks the configuration and builds every package like ModePlan, without running hooks
+	// or commands, and reports every failure at once.
+	ModeValidate
+	// ModePlan builds all the packages and reports what would change in the repository, without writing anything.
+	// The hooks are checked and rendered, not executed.
+	ModePlan
+)
+
+// CompileOptions controls how the repository is compiled.
+type CompileOptions struct {
+	// GPGKey is the ASCII-armored private key used to sign the repository.
+	GPGKey string
+	// Mode selects what Compile does. Defaults to ModeBuild.
+	Mode CompileMode
+	// Parallelism is the maximum number of packages built concurrently.
+	// Zero or a negative value uses the number of CPUs.
+	// Packages are always added to the repository in the configuration order.
+	// The package hooks still run one at a time.
+	Parallelism int
+	// CacheDir is a directory where web resources are cached, and revalidated with
+	// their ETag or Last-Modified headers on the next compilations. Empty disables the cache.
+	CacheDir string
+	// Offline only uses the resources in CacheDir, without any network request.
+	Offline bool
+	// AllowExec allows package files to run their Exec command. Package definitions can come from
+	// the web, so commands must be explicitly allowed.
+	AllowExec bool
+	// LockFile is a file where every web resource used by the compilation is recorded, with the URL
+	// it was fetched from after redirects, its size and its SHA256 checksum. Empty disables it.
+	// It is written after a successful build.
+	LockFile string
+	// Frozen fails the compilation if a web resource is not in LockFile, or differs from it,
+	// and does not write LockFile.
+	Frozen bool
+	// ContinueOnError builds every package it can: a failing package is reported with an
+	// EventPackageFailure and left out, the repository is still written with the other packages,
+	// and the returned error joins all the failures. By default, the first failure stops the compilation.
+	ContinueOnError bool
+	// Logger receives the diagnostics: web requests, commands, and the decisions of the repository writers.
+	// Secret values are redacted. Nil discards them.
+	Logger *slog.Logger
+	// Limits bounds the size of the web and local resources, and of the packages and upstream indices
+	// parsed from them, which can come from untrusted sources. Zero fields use deb.DefaultLimits.
+	Limits deb.Limits
+	// Metrics receives the counters and timings of the compilation: packages built, bytes downloaded,
+	// cache hits and misses, signatures, and errors. See Prometheus for an exporter.
+	Metrics Metrics
+	// Hashes are the checksums of the package files and indices, listed in the Packages indices
+	// and the Release files, e.g. {deb.SHA256, deb.SHA512}. Empty uses deb.DefaultHashes.
+	Hashes []deb.Hash
+	// IndexCompressions are the compressed versions of the Packages and Sources indices, e.g.
+	// {deb.CompressionGzip, deb.CompressionXz}. Empty writes the gzip version only.
+	IndexCompressions []deb.Compression
+	// Lint checks every built package with deb.Lint: each finding is reported with an EventPackageLint,
+	// and a package with an error finding fails, so that it is not published.
+	Lint bool
 }
 
 // Compile orchestrates the repository building process.
 // It loads the repository, processes all packages, applies them, and saves the result.
+// Secret values are redacted from the events and the returned error.
 func (a *Repository) Compile(gpgKey string, l Listener) error {
+	return a.CompileWithOptions(CompileOptions{GPGKey: gpgKey}, l)
+}
+
+// CompileWithOptions is like Compile, with explicit options.
+func (a *Repository) CompileWithOptions(opts CompileOptions, l Listener) error {
+	return a.CompileContext(context.Background(), opts, l)
+}
+
+// CompileContext is like CompileWithOptions, and stops when ctx is canceled: web requests and commands
+// are interrupted, no new package is built, and the repository is not written if it was not already.
+// The returned error wraps the error of ctx in that case.
+func (a *Repository) CompileContext(ctx context.Context, opts CompileOptions, l Listener) (err error) {
 	if l == nil {
 		l = func(fmt.Stringer) {}
 	}
+	if opts.Offline && opts.CacheDir == "" {
+		return fmt.Errorf("offline mode requires a cache directory")
+	}
+	var cache *httpCache
+	if opts.CacheDir != "" {
+		cache = &httpCache{dir: opts.CacheDir, offline: opts.Offline}
+	}
+	if opts.Frozen && opts.LockFile == "" {
+		return fmt.Errorf("frozen mode requires a lock file")
+	}
+	var lock *resourceLock
+	if opts.LockFile != "" && opts.Mode != ModeValidate {
+		if lock, err = newResourceLock(opts.LockFile, opts.Frozen); err != nil {
+			return err
+		}
+	}
+	for _, r := range append([]*Repository{a}, a.repositories...) {
+		r.fetcher.cache = cache
+		r.fetcher.lock = lock
+		r.fetcher.ctx = ctx
+		r.fetcher.log = r.redactor.logger(opts.Logger)
+		r.fetcher.limits = opts.Limits
+		r.allowExec = opts.AllowExec
+	}
+	if opts.Mode == ModeValidate {
+		return a.redactor.error(a.Validate())
+	}
+	if opts.Metrics != nil {
+		l = metricsListener(opts.Metrics, cache != nil, opts.Mode == ModePlan, l)
+		start := time.Now()
+		defer func() { recordCompilation(opts.Metrics, err, time.Since(start)) }()
+	}
+	switch {
+	case len(a.repositories) > 0:
+		err = a.compileRepositories(ctx, opts, a.redactor.listener(l))
+	default:
+		err = a.compile(ctx, opts, a.redactor.listener(l))
+	}
+	if err == nil && lock != nil && !opts.Frozen && opts.Mode == ModeBuild {
+		err = lock.write()
+	}
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		// e.g. a command killed by the cancellation.
+		err = fmt.Errorf("%w: %w", ctx.Err(), err)
+	}
+	return a.redactor.error(err)
+}
+
+func (a *Repository) compile(ctx context.Context, opts CompileOptions, l Listener) error {
+	start := time.Now()
+	// Packages are built, and resources fetched, concurrently.
+	l = syncListener(l)
+	a.fetcher.l = l
+	defer func() { a.fetcher.l = nil }()
 
 	repo, err := a.LoadRepository()
 	if err != nil {
@@ -138,18 +573,91 @@ func (a *Repository) Compile(gpgKey string, l Listener) error {
 	}
 	l(EventRepositoryLoadSuccess{Path: a.Path})
 
-	repo.GPGKey = gpgKey
+	repo.GPGKey = opts.GPGKey
+	repo.Logger = a.fetcher.log
+	repo.Progress = func(p deb.Progress) { l(EventProgress{p}) }
+	repo.Hashes = opts.Hashes
+	repo.IndexCompressions = opts.IndexCompressions
+	before := slices.Clone(repo.Packages)
+
+	if err := a.runHooks(opts.Mode, "before", a.Before); err != nil {
+		return fmt.Errorf("failed to run repository hooks: %w", err)
+	}
+
+	if err := a.importUpstreams(ctx, repo, opts.Parallelism, l); err != nil {
+		return fmt.Errorf("failed to import upstream packages: %w", err)
+	}
+	a.current = repo
+	defer func() { a.current = nil }()
 
-	pkgs, err := a.LoadPackages()
+	// failures are the failing packages, with opts.ContinueOnError.
+	var failures []error
+	fail := func(path string, err error) error {
+		if !opts.ContinueOnError {
+			return err
+		}
+		l(EventPackageFailure{FilePath: path, Error: err.Error()})
+		failures = append(failures, err)
+		return nil
+	}
+
+	pkgs, err := a.loadPackages(func(path string, err error) error {
+		if !opts.ContinueOnError {
+			return err
+		}
+		return fail(path, fmt.Errorf("failed to load package %q: %w", path, err))
+	})
 	if err != nil {
 		return fmt.Errorf("failed to load packages: %w", err)
 	}
 
-	for _, pkg := range pkgs {
-		debPkg, err := pkg.Apply(repo)
+	for i := range pkgs {
+		pkgs[i].mode = opts.Mode
+	}
+	results := buildPackages(ctx, pkgs, opts.Parallelism, l)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var outputs []packageOutput
+	built := make(map[*deb.Package]bool)
+	for i, pkg := range pkgs {
+		res := results[i]
+		if res.err != nil {
+			if err := fail(pkg.filePath, fmt.Errorf("failed to apply package %q: %w", pkg.filePath, res.err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if opts.Lint {
+			findings := deb.Lint(res.pkg)
+			for _, f := range findings {
+				l(EventPackageLint{
+					FilePath: pkg.filePath,
+					Package:  res.pkg.Metadata.Package,
+					Severity: string(f.Severity),
+					Tag:      f.Tag,
+					Path:     f.Path,
+					Message:  f.Message,
+				})
+			}
+			if i := slices.IndexFunc(findings, func(f deb.Finding) bool { return f.Severity == deb.SeverityError }); i >= 0 {
+				if err := fail(pkg.filePath, fmt.Errorf("failed to lint package %q: %s", pkg.filePath, findings[i])); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		debPkg, err := addPackage(repo, res.pkg, pkg.Strategy)
 		if err != nil {
-			return fmt.Errorf("failed to apply package %q: %w", pkg.filePath, err)
+			if err := fail(pkg.filePath, fmt.Errorf("failed to apply package %q: %w", pkg.filePath, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if debPkg == res.pkg {
+			a.components[debPkg] = pkg.component
 		}
+		built[debPkg] = true
 		if debPkg != nil {
 			l(EventPackageApplySuccess{
 				FilePath:     pkg.filePath,
@@ -161,48 +669,223 @@ func (a *Repository) Compile(gpgKey string, l Listener) error {
 			// Should not happen if err is nil, but safe fallback
 			l(EventPackageApplySuccess{FilePath: pkg.filePath})
 		}
+		if opts.Mode == ModePlan && debPkg != nil {
+			e, err := planPackage(before, pkg.filePath, debPkg)
+			if err != nil {
+				return fmt.Errorf("failed to plan package %q: %w", pkg.filePath, err)
+			}
+			l(e)
+		}
+		dir, err := a.outputDir(&pkg)
+		if err != nil {
+			return fmt.Errorf("failed to render output of package %q: %w", pkg.filePath, err)
+		}
+		if dir != "" && debPkg != nil {
+			outputs = append(outputs, packageOutput{filePath: pkg.filePath, dir: dir, pkg: debPkg})
+		}
+	}
+
+	pruned, err := a.applyRetention(repo, built)
+	if err != nil {
+		return fmt.Errorf("failed to apply retention: %w", err)
+	}
+	for _, e := range pruned {
+		l(e)
 	}
 
-	ops, err := a.SaveRepository(repo)
+	ops, err := a.saveRepository(ctx, repo, opts.Mode == ModePlan)
 	if err != nil {
 		return fmt.Errorf("failed to save repo: %w", err)
 	}
 
 	for _, op := range ops {
-		l(EventFileOperation{
-			Path:      op.Path,
-			OldDigest: op.OldDigest,
-			NewDigest: op.NewDigest,
-			Created:   op.OldDigest == "",
-			Updated:   op.OldDigest != "" && op.OldDigest != op.NewDigest,
-		})
+		l(fileOperationEvent(op))
 	}
-	l(EventRepositorySaveSuccess{Path: a.Path})
+	for _, out := range outputs {
+		e, err := a.writeOutput(out, opts.Mode == ModePlan)
+		if err != nil {
+			return fmt.Errorf("failed to write output of package %q: %w", out.filePath, err)
+		}
+		l(e)
+	}
+	if opts.Mode == ModePlan {
+		return joinFailures(failures)
+	}
+	for _, e := range pruned {
+		if err := removePrunedFile(e); err != nil {
+			return fmt.Errorf("failed to apply retention: %w", err)
+		}
+	}
+	l(EventRepositorySaveSuccess{Path: a.Path, Duration: time.Since(start)})
 
-	return nil
+	if err := a.runHooks(opts.Mode, "after", a.After); err != nil {
+		return fmt.Errorf("failed to run repository hooks: %w", err)
+	}
+
+	return joinFailures(failures)
+}
+
+// fileOperationEvent returns the event reporting a file operation of the repository.
+func fileOperationEvent(op deb.FileOperation) EventFileOperation {
+	return EventFileOperation{
+		Path:      op.Path,
+		OldDigest: op.OldDigest,
+		NewDigest: op.NewDigest,
+		Created:   op.OldDigest == "",
+		Updated:   op.OldDigest != "" && op.OldDigest != op.NewDigest,
+		Size:      op.Size,
+	}
+}
+
+// joinFailures returns an error joining the package failures, or nil if there is none.
+func joinFailures(failures []error) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d package(s) failed:\n%w", len(failures), errors.Join(failures...))
+}
+
+// buildResult is the outcome of building a package definition.
+type buildResult struct {
+	pkg *deb.Package
+	err error
+}
+
+// buildPackages builds all the package definitions, running at most parallelism builds at once.
+// Results are returned in the same order as pkgs. l receives the build events, and must be safe for concurrent use.
+// Once ctx is canceled, the remaining packages fail with its error.
+func buildPackages(ctx context.Context, pkgs []Package, parallelism int, l Listener) []buildResult {
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+	results := make([]buildResult, len(pkgs))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range pkgs {
+		wg.Add(1)
+		sem <- struct{}{}
+		if err := ctx.Err(); err != nil {
+			results[i] = buildResult{err: err}
+			<-sem
+			wg.Done()
+			continue
+		}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			l(EventPackageBuildStarted{FilePath: pkgs[i].filePath})
+			start := time.Now()
+			pkg, err := pkgs[i].Build()
+			results[i] = buildResult{pkg, err}
+			l(buildFinished(pkgs[i].filePath, pkg, err, time.Since(start)))
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// buildFinished returns the event reporting the build of the package definition at filePath.
+func buildFinished(filePath string, pkg *deb.Package, err error, d time.Duration) EventPackageBuildFinished {
+	e := EventPackageBuildFinished{FilePath: filePath, Duration: d}
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	e.Package = pkg.Metadata.Package
+	e.Version = pkg.Metadata.Version
+	e.Architecture = pkg.Metadata.Architecture
+	e.Files = len(pkg.Files)
+	for _, f := range pkg.Files {
+		e.InstalledSize += f.Size()
+	}
+	return e
+}
+
+// planPackage describes what applying pkg does to a repository that contained the packages before.
+func planPackage(before []*deb.Package, filePath string, pkg *deb.Package) (EventPackagePlan, error) {
+	e := EventPackagePlan{
+		FilePath:     filePath,
+		Package:      pkg.Metadata.Package,
+		Version:      pkg.Metadata.Version,
+		Architecture: pkg.Metadata.Architecture,
+		Action:       PlanAdd,
+	}
+	if slices.Contains(before, pkg) {
+		e.Action = PlanUnchanged
+		return e, nil
+	}
+	// The package is compared with the highest version already in the repository.
+	var previous *deb.Package
+	for _, old := range before {
+		if old.Metadata.Package == pkg.Metadata.Package && old.Metadata.Architecture == pkg.Metadata.Architecture &&
+			(previous == nil || deb.CompareVersions(old.Metadata.Version, previous.Metadata.Version) > 0) {
+			previous = old
+		}
+	}
+	if previous != nil {
+		e.Action = PlanBump
+		e.Previous = previous.Metadata.Version
+		changes, err := deb.Diff(previous, pkg)
+		if err != nil {
+			return e, err
+		}
+		e.Changes = changes
+	}
+	return e, nil
 }
 
 // SaveRepository writes the current state of the deb.Repository to the configured Path.
 func (a *Repository) SaveRepository(repo *deb.Repository) ([]deb.FileOperation, error) {
-	return repo.WriteToDir(a.resolve(a.Path))
+	return a.saveRepository(context.Background(), repo, false)
+}
+
+// PlanRepository returns the file operations that SaveRepository would perform, without writing anything.
+func (a *Repository) PlanRepository(repo *deb.Repository) ([]deb.FileOperation, error) {
+	return a.saveRepository(context.Background(), repo, true)
+}
+
+// saveRepository implements SaveRepository and PlanRepository, stopping when ctx is canceled.
+func (a *Repository) saveRepository(ctx context.Context, repo *deb.Repository, plan bool) ([]deb.FileOperation, error) {
+	dir := a.resolve(a.Path)
+	if a.Layout == LayoutStandard {
+		std, err := a.standardRepository(repo)
+		if err != nil {
+			return nil, err
+		}
+		if plan {
+			return std.PlanDirContext(ctx, dir)
+		}
+		return std.WriteToDirContext(ctx, dir)
+	}
+	if plan {
+		return repo.PlanDirContext(ctx, dir)
+	}
+	return repo.WriteToDirContext(ctx, dir)
 }
 
 func (a *Repository) resolve(path string) string {
-	if filepath.IsAbs(path) || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+	if filepath.IsAbs(path) || isURL(path) {
 		return path
 	}
 	return filepath.Join(filepath.Dir(a.filePath), path)
 }
 
 func (a *Repository) loadResource(path string) (string, error) {
-	resolved := a.resolve(path)
-	content, err := os.ReadFile(resolved)
+	if isURL(path) {
+		content, err := a.fetcher.fetch(path)
+		return string(content), err
+	}
+	content, err := a.fetcher.readFile(a.resolve(path))
 	if err != nil {
 		return "", err
 	}
 	return string(content), nil
 }
 
+// isURL reports whether path is a web URL rather than a file path.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
 // unmarshal parses JSON or YAML based on file extension.
 func unmarshal(path string, data []byte, v interface{}) error {
 	ext := strings.ToLower(filepath.Ext(path))
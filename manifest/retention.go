@@ -0,0 +1,123 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Retention controls which old package versions are removed from the repository after a build.
+// Packages produced by the build, and the most recent version of each package, are always kept.
+type Retention struct {
+	// Versions is the number of most recent versions kept for each package and architecture.
+	// Zero keeps all the versions.
+	Versions int `json:"versions" yaml:"versions"`
+	// Revisions is the number of most recent Debian revisions kept for each upstream version.
+	// Zero keeps all the revisions.
+	Revisions int `json:"revisions" yaml:"revisions"`
+	// MaxAge removes the versions whose file is older than this duration (e.g. "720h" or "30d").
+	MaxAge string `json:"max_age" yaml:"max_age"`
+	// Packages are shell patterns (e.g. "myapp-*") of the package names the retention applies to.
+	// Defaults to all packages.
+	Packages []string `json:"packages" yaml:"packages"`
+	// Protect are relations (e.g. "myapp (= 1.2.0-1)" or "mylib (>= 3.0)") pinning the versions that
+	// are never removed.
+	Protect []string `json:"protect" yaml:"protect"`
+}
+
+// check checks the retention configuration.
+func (r *Retention) check() error {
+	if r.Versions < 0 || r.Revisions < 0 {
+		return fmt.Errorf("retention 'versions' and 'revisions' cannot be negative")
+	}
+	for _, pin := range r.Protect {
+		if _, err := deb.ParseRelation(pin); err != nil {
+			return fmt.Errorf("invalid retention 'protect': %w", err)
+		}
+	}
+	_, err := r.maxAge()
+	return err
+}
+
+// maxAge returns the parsed MaxAge, or zero if not set.
+// In addition to the time.ParseDuration units, it accepts days (e.g. "30d").
+func (r *Retention) maxAge() (time.Duration, error) {
+	if r.MaxAge == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(r.MaxAge, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(r.MaxAge)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid retention 'max_age' %q, expected a positive duration like \"720h\" or \"30d\"", r.MaxAge)
+	}
+	return d, nil
+}
+
+// prune removes from repo the packages that the retention does not keep, and returns them.
+// Packages in keep are never removed. age returns the age of a package.
+func (r *Retention) prune(repo *deb.Repository, keep map[*deb.Package]bool, age func(*deb.Package) time.Duration) ([]deb.Eviction, error) {
+	maxAge, err := r.maxAge()
+	if err != nil {
+		return nil, err
+	}
+	return repo.Prune(deb.RetentionPolicy{
+		Versions:  r.Versions,
+		Revisions: r.Revisions,
+		MaxAge:    maxAge,
+		Age:       age,
+		Pins:      r.Protect,
+		Match:     func(pkg *deb.Package) bool { return matchAny(r.Packages, pkg.Metadata.Package) },
+		Keep:      func(pkg *deb.Package) bool { return keep[pkg] },
+	})
+}
+
+// applyRetention removes the old packages from repo, and returns the removed packages with their files.
+// Packages in keep are never removed.
+func (a *Repository) applyRetention(repo *deb.Repository, keep map[*deb.Package]bool) ([]EventPackagePrune, error) {
+	if a.Retention == nil {
+		return nil, nil
+	}
+	now := time.Now()
+	age := func(pkg *deb.Package) time.Duration {
+		info, err := os.Stat(a.packageFile(pkg))
+		if err != nil {
+			// Not written yet.
+			return 0
+		}
+		return now.Sub(info.ModTime())
+	}
+	pruned, err := a.Retention.prune(repo, keep, age)
+	if err != nil {
+		return nil, err
+	}
+	var events []EventPackagePrune
+	for _, e := range pruned {
+		events = append(events, EventPackagePrune{
+			Package:      e.Package.Metadata.Package,
+			Version:      e.Package.Metadata.Version,
+			Architecture: e.Package.Metadata.Architecture,
+			Path:         a.packageFile(e.Package),
+			Reason:       e.Reason,
+		})
+	}
+	return events, nil
+}
+
+// removePrunedFile removes the file of a pruned package. A missing file is not an error.
+func removePrunedFile(e EventPackagePrune) error {
+	if e.Path == "" {
+		return nil
+	}
+	if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing %s: %w", e.Path, err)
+	}
+	return nil
+}
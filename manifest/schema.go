@@ -0,0 +1,21 @@
+package manifest
+
+import _ "embed"
+
+//go:generate go run ../internal/schemagen -pkg . -out . -out ..
+
+//go:embed repository.schema.json
+var repositorySchema []byte
+
+//go:embed package.schema.json
+var packageSchema []byte
+
+// RepositorySchema returns the JSON Schema of repository files.
+// It is generated from the Repository type, and also published as repository.schema.json
+// at the root of the source repository.
+func RepositorySchema() []byte { return repositorySchema }
+
+// PackageSchema returns the JSON Schema of package definition files.
+// It is generated from the Package type, and also published as package.schema.json
+// at the root of the source repository.
+func PackageSchema() []byte { return packageSchema }
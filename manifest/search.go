@@ -0,0 +1,143 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// SearchResult is a package found by Search.
+type SearchResult struct {
+	Package      string `json:"package"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+	// Synopsis is the first line of the description.
+	Synopsis string   `json:"synopsis,omitempty"`
+	Provides []string `json:"provides,omitempty"`
+	// Origin is the path of the repository for the packages already published,
+	// or the URL of the upstream repository.
+	Origin string `json:"origin"`
+	// URL is the path or URL of the package file.
+	URL string `json:"url"`
+}
+
+// Search returns the packages of the repository, or of every repository of the file, and of all their upstreams
+// whose name, provided names or description match pattern. Upstream packages are searched even if
+// the upstream 'packages' patterns do not import them, to check whether a dependency is available.
+//
+// pattern is a shell pattern (e.g. "libssl*") matched against the names and provided names,
+// or a case insensitive text searched in the names, provided names and descriptions.
+// Only the CacheDir, Offline, Logger and Limits options are used.
+func (a *Repository) Search(pattern string, opts CompileOptions) ([]SearchResult, error) {
+	return a.SearchContext(context.Background(), pattern, opts)
+}
+
+// SearchContext is like Search, and interrupts the requests to the upstreams when ctx is canceled.
+func (a *Repository) SearchContext(ctx context.Context, pattern string, opts CompileOptions) ([]SearchResult, error) {
+	if opts.Offline && opts.CacheDir == "" {
+		return nil, fmt.Errorf("offline mode requires a cache directory")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	var cache *httpCache
+	if opts.CacheDir != "" {
+		cache = &httpCache{dir: opts.CacheDir, offline: opts.Offline}
+	}
+	repos := []*Repository{a}
+	if len(a.repositories) > 0 {
+		repos = a.repositories
+	}
+	var results []SearchResult
+	for i, r := range repos {
+		r.fetcher.cache = cache
+		r.fetcher.ctx = ctx
+		r.fetcher.log = r.redactor.logger(opts.Logger)
+		r.fetcher.limits = opts.Limits
+		found, err := r.search(pattern)
+		if err != nil {
+			if len(a.repositories) > 0 {
+				err = fmt.Errorf("repositories[%d] (%s): %w", i, r.Path, err)
+			}
+			return nil, a.redactor.error(err)
+		}
+		results = append(results, found...)
+	}
+	return results, nil
+}
+
+// search returns the packages of the repository and of its upstreams that match pattern.
+func (a *Repository) search(pattern string) ([]SearchResult, error) {
+	repo, err := a.LoadRepository()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repo: %w", err)
+	}
+	var results []SearchResult
+	for _, pkg := range repo.Find(func(pkg *deb.Package) bool { return matchPackage(pattern, pkg.Metadata) }) {
+		results = append(results, searchResult(pkg.Metadata, a.Path, a.packageFile(pkg)))
+	}
+
+	for i, u := range a.Upstream {
+		name := fmt.Sprintf("upstream[%d]", i)
+		ok, err := a.engine.eval(name+".when", u.When)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if u, err = a.renderUpstream(name, u); err != nil {
+			return nil, err
+		}
+		base := u.URL
+		keyring, err := a.upstreamKey(name, u)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := a.upstreamIndex(base, u.Suite, u.Components, u.Architectures, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, e := range entries {
+			if matchPackage(pattern, e.Metadata) {
+				results = append(results, searchResult(e.Metadata, base, fileURL(base, e.Filename)))
+			}
+		}
+	}
+	return results, nil
+}
+
+// matchPackage reports whether the name or a provided name of a package matches the shell pattern,
+// or whether they, or the description, contain pattern, ignoring case.
+func matchPackage(pattern string, m deb.Metadata) bool {
+	names := []string{m.Package}
+	for _, p := range m.Provides {
+		// e.g. "mail-transport-agent" or "libfoo (= 1.0)".
+		name, _, _ := strings.Cut(strings.TrimSpace(p), " ")
+		names = append(names, name)
+	}
+	text := strings.ToLower(pattern)
+	for _, name := range names {
+		if ok, _ := path.Match(pattern, name); ok || strings.Contains(strings.ToLower(name), text) {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(m.Description), text)
+}
+
+// searchResult returns the search result of a package.
+func searchResult(m deb.Metadata, origin, url string) SearchResult {
+	synopsis, _, _ := strings.Cut(m.Description, "\n")
+	return SearchResult{
+		Package:      m.Package,
+		Version:      m.Version,
+		Architecture: m.Architecture,
+		Synopsis:     synopsis,
+		Provides:     m.Provides,
+		Origin:       origin,
+		URL:          url,
+	}
+}
@@ -0,0 +1,258 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// redacted replaces secret values in events, logs and error messages.
+const redacted = "[REDACTED]"
+
+// Secret describes where the value of a secret comes from.
+// Exactly one of Env or File must be set.
+type Secret struct {
+	// Env is the name of the environment variable holding the secret.
+	Env string `json:"env" yaml:"env"`
+	// File is the path to a file holding the secret (relative to the repository file).
+	// Trailing newlines are removed.
+	File string `json:"file" yaml:"file"`
+}
+
+// resolveSecrets reads the value of every secret.
+// Secrets that cannot be resolved are reported in the errs map, so that they only
+// fail the build if a template actually uses them.
+func (a *Repository) resolveSecrets() (values map[string]string, errs map[string]error) {
+	values = make(map[string]string)
+	errs = make(map[string]error)
+	for name, s := range a.Secrets {
+		switch {
+		case s.Env != "" && s.File != "":
+			errs[name] = fmt.Errorf("secret %q must define either 'env' or 'file', not both", name)
+		case s.Env != "":
+			v, ok := os.LookupEnv(s.Env)
+			if !ok {
+				errs[name] = fmt.Errorf("secret %q: environment variable %s is not set", name, s.Env)
+				continue
+			}
+			values[name] = v
+		case s.File != "":
+			content, err := readLocal(a.fsys, a.resolve(s.File))
+			if err != nil {
+				errs[name] = fmt.Errorf("secret %q: %w", name, err)
+				continue
+			}
+			values[name] = strings.TrimRight(string(content), "\r\n")
+		default:
+			errs[name] = fmt.Errorf("secret %q must define either 'env' or 'file'", name)
+		}
+	}
+	return values, errs
+}
+
+// secretFunc returns the 'secret' template function.
+func secretFunc(values map[string]string, errs map[string]error) func(string) (string, error) {
+	return func(name string) (string, error) {
+		if err, ok := errs[name]; ok {
+			return "", err
+		}
+		v, ok := values[name]
+		if !ok {
+			return "", fmt.Errorf("secret %q is not declared in 'secrets'", name)
+		}
+		return v, nil
+	}
+}
+
+// redactor hides secret values from strings, errors and events.
+type redactor struct {
+	values []string
+}
+
+// newRedactor creates a redactor for the given secret values.
+func newRedactor(secrets map[string]string) *redactor {
+	r := &redactor{}
+	for _, v := range secrets {
+		if v != "" {
+			r.values = append(r.values, v)
+		}
+	}
+	// Longest first, so that a secret containing another one is fully redacted.
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+	return r
+}
+
+// redact replaces every secret value in s.
+func (r *redactor) redact(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, v := range r.values {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	return s
+}
+
+// error returns err with secret values removed from its message.
+func (r *redactor) error(err error) error {
+	if err == nil || r == nil || len(r.values) == 0 {
+		return err
+	}
+	msg := r.redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	// The original error is not wrapped: unwrapping it would reveal the secret.
+	return errors.New(msg)
+}
+
+// listener returns a Listener that redacts every string of the events, see redactor.value,
+// before passing them to l.
+func (r *redactor) listener(l Listener) Listener {
+	if r == nil || len(r.values) == 0 {
+		return l
+	}
+	return func(e fmt.Stringer) {
+		if e == nil {
+			l(e)
+			return
+		}
+		l(r.value(reflect.ValueOf(e)).Interface().(fmt.Stringer))
+	}
+}
+
+// value returns a copy of v where the secret values are redacted from every string it holds,
+// recursively: in the exported fields of structs, and in slices, arrays, maps, pointers and interfaces.
+// Errors are replaced like redactor.error does. v itself is not modified.
+func (r *redactor) value(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		cp := reflect.New(v.Type()).Elem()
+		cp.SetString(r.redact(v.String()))
+		return cp
+	case reflect.Struct:
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(v)
+		for i := 0; i < cp.NumField(); i++ {
+			if f := cp.Field(i); f.CanSet() {
+				f.Set(r.value(f))
+			}
+		}
+		return cp
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(r.value(v.Index(i)))
+		}
+		return cp
+	case reflect.Array:
+		cp := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(r.value(v.Index(i)))
+		}
+		return cp
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for it := v.MapRange(); it.Next(); {
+			cp.SetMapIndex(r.value(it.Key()), r.value(it.Value()))
+		}
+		return cp
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.New(v.Type().Elem())
+		cp.Elem().Set(r.value(v.Elem()))
+		return cp
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.New(v.Type()).Elem()
+		if err, ok := v.Interface().(error); ok {
+			if redacted := reflect.ValueOf(r.error(err)); redacted.Type().AssignableTo(v.Type()) {
+				cp.Set(redacted)
+				return cp
+			}
+		}
+		cp.Set(r.value(v.Elem()))
+		return cp
+	}
+	return v
+}
+
+// logger returns a logger that redacts the message and every attribute of the records before passing them to l.
+// A nil l discards the records.
+func (r *redactor) logger(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	if r == nil || len(r.values) == 0 {
+		return l
+	}
+	return slog.New(&redactHandler{h: l.Handler(), r: r})
+}
+
+// redactHandler is a slog.Handler removing secret values from the records.
+type redactHandler struct {
+	h slog.Handler
+	r *redactor
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, rec slog.Record) error {
+	cp := slog.NewRecord(rec.Time, rec.Level, h.r.redact(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		cp.AddAttrs(h.attr(a))
+		return true
+	})
+	return h.h.Handle(ctx, cp)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.attr(a)
+	}
+	return &redactHandler{h: h.h.WithAttrs(redacted), r: h.r}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{h: h.h.WithGroup(name), r: h.r}
+}
+
+// attr returns a with its secret values removed. Values other than strings and groups, e.g. errors,
+// are redacted as text when they contain a secret.
+func (h *redactHandler) attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.r.redact(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]any, len(group))
+		for i, g := range group {
+			redacted[i] = h.attr(g)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		if text := v.String(); h.r.redact(text) != text {
+			return slog.String(a.Key, h.r.redact(text))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
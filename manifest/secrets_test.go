@@ -0,0 +1,46 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// nestedEvent is an event with the secret in nested values.
+type nestedEvent struct {
+	Name    string
+	Labels  map[string]string
+	Entries []struct{ URL string }
+	Next    *nestedEvent
+	Err     error
+	Any     any
+}
+
+func (e nestedEvent) String() string {
+	next := ""
+	if e.Next != nil {
+		next = e.Next.String()
+	}
+	return fmt.Sprint(e.Name, e.Labels, e.Entries, e.Err, e.Any, next)
+}
+
+func TestRedactorListener(t *testing.T) {
+	r := newRedactor(map[string]string{"TOKEN": "s3cr3t"})
+	original := nestedEvent{
+		Name:    "s3cr3t",
+		Labels:  map[string]string{"url": "https://s3cr3t@example.com"},
+		Entries: []struct{ URL string }{{URL: "https://example.com/?t=s3cr3t"}},
+		Next:    &nestedEvent{Name: "next s3cr3t"},
+		Err:     fmt.Errorf("failed: %w", errors.New("bad token s3cr3t")),
+		Any:     []string{"s3cr3t"},
+	}
+	var got fmt.Stringer
+	r.listener(func(e fmt.Stringer) { got = e })(original)
+	if s := got.String(); strings.Contains(s, "s3cr3t") {
+		t.Errorf("the event was not redacted: %s", s)
+	}
+	if s := original.String(); strings.Count(s, "s3cr3t") != 6 {
+		t.Errorf("the original event was modified: %s", s)
+	}
+}
@@ -0,0 +1,109 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Service describes a systemd service shipped by the package.
+//
+// The unit file is installed in /lib/systemd/system and the maintainer scripts are
+// extended with the snippets that enable, start, stop and clean up the service,
+// the same way debhelper's dh_installsystemd does.
+type Service struct {
+	// Name is the unit file name. Defaults to the package name followed by ".service".
+	Name string `json:"name" yaml:"name"`
+	// Src is the path to the unit file (relative to the package definition file) or a web URL.
+	Src string `json:"src" yaml:"src"`
+	// Raw indicates whether the Src file should be processed as a template (false) or not (true).
+	Raw bool `json:"raw" yaml:"raw"`
+	// Content is the unit file content, used when Src is empty.
+	Content string `json:"content" yaml:"content"`
+	// Unit, Service and Install are the unit file sections, used when Src and Content are empty.
+	// Keys are written in alphabetical order.
+	Unit    map[string]string `json:"unit" yaml:"unit"`
+	Service map[string]string `json:"service" yaml:"service"`
+	Install map[string]string `json:"install" yaml:"install"`
+	// Enable enables the service on installation. Defaults to true.
+	Enable *bool `json:"enable" yaml:"enable"`
+	// Start starts the service on installation. Defaults to true.
+	Start *bool `json:"start" yaml:"start"`
+	// RestartOnUpgrade restarts the service after an upgrade. Defaults to true.
+	RestartOnUpgrade *bool `json:"restart_on_upgrade" yaml:"restart_on_upgrade"`
+}
+
+// isSet returns the value of an optional boolean, or def if it is not set.
+func isSet(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+// applyService injects the unit file and the maintainer scripts snippets into pkg, see deb.Package.AddSystemdService.
+func (p *Package) applyService(pkg *deb.Package) error {
+	s := p.Service
+	name, err := p.engine.render("service.name", s.Name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = pkg.Metadata.Package + ".service"
+	}
+
+	var content string
+	switch {
+	case s.Src != "":
+		src, err := p.engine.render("service.src", s.Src)
+		if err != nil {
+			return err
+		}
+		if content, err = p.loadResource(src, s.Raw, ""); err != nil {
+			return err
+		}
+	case s.Content != "":
+		if content, err = p.engine.render("service.content", s.Content); err != nil {
+			return err
+		}
+	default:
+		var b strings.Builder
+		for _, section := range []struct {
+			name   string
+			fields map[string]string
+		}{{"Unit", s.Unit}, {"Service", s.Service}, {"Install", s.Install}} {
+			if len(section.fields) == 0 {
+				continue
+			}
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "[%s]\n", section.name)
+			keys := make([]string, 0, len(section.fields))
+			for k := range section.fields {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				v, err := p.engine.render(fmt.Sprintf("service.%s.%s", strings.ToLower(section.name), k), section.fields[k])
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(&b, "%s=%s\n", k, v)
+			}
+		}
+		content = b.String()
+	}
+	if content == "" {
+		return fmt.Errorf("service %s has no unit content: set 'src', 'content' or the unit sections", name)
+	}
+	return pkg.AddSystemdService(deb.SystemdService{
+		UnitName:         name,
+		UnitContent:      content,
+		EnableOnInstall:  isSet(s.Enable, true),
+		StartOnInstall:   isSet(s.Start, true),
+		RestartOnUpgrade: isSet(s.RestartOnUpgrade, true),
+	})
+}
@@ -0,0 +1,78 @@
+package manifest
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Strategies decide what happens when a package version is already in the repository with a different content.
+const (
+	// StrategyStrict fails the build. It is the default strategy: published packages are immutable,
+	// and only identical re-publishes are accepted, see deb.Repository.AddSafe.
+	StrategyStrict = "strict"
+	// StrategyBump increments the Debian revision past the latest revision of the same upstream version,
+	// unless the latest revision has the same content.
+	StrategyBump = "bump"
+	// StrategyOverwrite replaces the existing package.
+	StrategyOverwrite = "overwrite"
+	// StrategySafe is like StrategyBump, but reuses any existing revision of the same upstream version
+	// with the same content, so rebuilding a previous state never creates a new revision.
+	StrategySafe = "safe"
+)
+
+// checkStrategy checks that strategy is a known strategy.
+func checkStrategy(strategy string) error {
+	switch strategy {
+	case "", StrategyStrict, StrategyBump, StrategyOverwrite, StrategySafe:
+		return nil
+	}
+	return fmt.Errorf("unknown strategy %q, expected one of %s, %s, %s, %s", strategy, StrategyStrict, StrategyBump, StrategyOverwrite, StrategySafe)
+}
+
+// addPackage adds pkg to repo following the strategy, and returns the package actually in the repository.
+// With StrategyBump and StrategySafe, the version of pkg may be changed.
+func addPackage(repo *deb.Repository, pkg *deb.Package, strategy string) (*deb.Package, error) {
+	m := pkg.Metadata
+	existing := repo.Get(m.Package, m.Version, m.Architecture)
+	if existing == nil || existing.Equal(pkg) || strategy == "" || strategy == StrategyStrict {
+		return appendPackage(repo, pkg)
+	}
+
+	switch strategy {
+	case StrategyOverwrite:
+		repo.AddOverwrite(pkg)
+		return pkg, nil
+
+	case StrategyBump, StrategySafe:
+		// revisions is not empty: it contains at least the existing package.
+		revisions := repo.PackagesByUpstream(m.Package, pkg.UpstreamVersion(), m.Architecture)
+		candidates := revisions
+		if strategy == StrategyBump {
+			candidates = revisions[:1]
+		}
+		for _, rev := range candidates {
+			if sameContent(rev, pkg) {
+				return rev, nil
+			}
+		}
+		// The new version must differ, for apt, from every version in the repository: equal versions
+		// by the dpkg ordering (e.g. "1.0-1" and "1.0-01") are the same version.
+		versions := repo.Versions(m.Package, m.Architecture)
+		version := deb.BumpVersion(revisions[0].Metadata.Version)
+		for slices.ContainsFunc(versions, func(p *deb.Package) bool { return deb.CompareVersions(p.Metadata.Version, version) == 0 }) {
+			version = deb.BumpVersion(version)
+		}
+		pkg.Metadata.Version = version
+		return appendPackage(repo, pkg)
+	}
+	return nil, checkStrategy(strategy)
+}
+
+// sameContent reports whether both packages are equal, regardless of their version.
+func sameContent(existing, pkg *deb.Package) bool {
+	versioned := *pkg
+	versioned.Metadata.Version = existing.Metadata.Version
+	return existing.Equal(&versioned)
+}
@@ -0,0 +1,75 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+func TestAddPackageStrategies(t *testing.T) {
+	// pkg returns a package of app, whose content is body.
+	pkg := func(version, body string) *deb.Package {
+		return &deb.Package{
+			Metadata: deb.Metadata{Package: "app", Version: version, Architecture: "amd64", Maintainer: "Test <test@example.com>", Description: "app"},
+			Files:    []deb.File{{DestPath: "/usr/share/app/data", Mode: 0o644, Body: body}},
+		}
+	}
+	// repo returns a repository of the packages.
+	repo := func(pkgs ...*deb.Package) *deb.Repository {
+		t.Helper()
+		r := &deb.Repository{}
+		for _, p := range pkgs {
+			if _, err := appendPackage(r, p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r
+	}
+
+	for _, tt := range []struct {
+		name     string
+		repo     []*deb.Package
+		pkg      *deb.Package
+		strategy string
+		// want is the version of the returned package, and count the number of packages in the repository.
+		want  string
+		count int
+		// wantErr is true if the strategy must fail.
+		wantErr bool
+	}{
+		{"strict identical", []*deb.Package{pkg("1.0-1", "a")}, pkg("1.0-1", "a"), StrategyStrict, "1.0-1", 1, false},
+		{"strict different", []*deb.Package{pkg("1.0-1", "a")}, pkg("1.0-1", "b"), StrategyStrict, "", 1, true},
+		{"bump identical", []*deb.Package{pkg("1.0-1", "a")}, pkg("1.0-1", "a"), StrategyBump, "1.0-1", 1, false},
+		{"bump latest", []*deb.Package{pkg("1.0-1", "a"), pkg("1.0-2", "b")}, pkg("1.0-1", "c"), StrategyBump, "1.0-3", 3, false},
+		{"bump same as latest", []*deb.Package{pkg("1.0-1", "a"), pkg("1.0-2", "b")}, pkg("1.0-1", "b"), StrategyBump, "1.0-2", 2, false},
+		{"bump older", []*deb.Package{pkg("1.0-1", "a"), pkg("1.0-2", "b")}, pkg("1.0-2", "a"), StrategyBump, "1.0-3", 3, false},
+		// 1.00-2 is 1.0-2 for apt: the bumped version must be past it.
+		{"bump equal version", []*deb.Package{pkg("1.0-1", "a"), pkg("1.00-2", "b")}, pkg("1.0-1", "c"), StrategyBump, "1.0-3", 3, false},
+		{"bump letters", []*deb.Package{pkg("1.0-1z", "a")}, pkg("1.0-1z", "b"), StrategyBump, "1.0-1z1", 2, false},
+		{"safe older", []*deb.Package{pkg("1.0-1", "a"), pkg("1.0-2", "b")}, pkg("1.0-2", "a"), StrategySafe, "1.0-1", 2, false},
+		{"safe new", []*deb.Package{pkg("1.0-1", "a"), pkg("1.0-2", "b")}, pkg("1.0-2", "c"), StrategySafe, "1.0-3", 3, false},
+		{"overwrite", []*deb.Package{pkg("1.0-1", "a")}, pkg("1.0-1", "b"), StrategyOverwrite, "1.0-1", 1, false},
+	} {
+		r := repo(tt.repo...)
+		got, err := addPackage(r, tt.pkg, tt.strategy)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: addPackage succeeded, want an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: addPackage failed: %v", tt.name, err)
+			continue
+		}
+		if got.Metadata.Version != tt.want {
+			t.Errorf("%s: addPackage = %s, want %s", tt.name, got.Metadata.Version, tt.want)
+		}
+		if len(r.Packages) != tt.count {
+			t.Errorf("%s: the repository has %d packages, want %d", tt.name, len(r.Packages), tt.count)
+		}
+		if tt.strategy == StrategyOverwrite && r.Get("app", "1.0-1", "amd64") != tt.pkg {
+			t.Errorf("%s: the package was not replaced", tt.name)
+		}
+	}
+}
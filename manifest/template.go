@@ -1,7 +1,9 @@
 package manifest
 
 import (
+	"cmp"
 	"fmt"
+	"os"
 	"sort"
 	"strings"
 	"text/template"
@@ -12,14 +14,21 @@ import (
 type templateEngine struct {
 	defines map[string]string
 	funcs   template.FuncMap
+	// pinned are definitions that sub engines cannot override.
+	pinned map[string]string
 }
 
 // newTemplateEngine creates a new engine with the provided global definitions.
-func newTemplateEngine(defines map[string]string) (*templateEngine, error) {
+// extra functions are added to the builtin ones.
+func newTemplateEngine(defines map[string]string, extra template.FuncMap) (*templateEngine, error) {
 	finalDefines := make(map[string]string)
+	funcs := builtinFuncs()
+	for name, f := range extra {
+		funcs[name] = f
+	}
 	e := &templateEngine{
 		defines: finalDefines,
-		funcs:   template.FuncMap{},
+		funcs:   funcs,
 	}
 
 	sorted, err := sortLocals(defines)
@@ -57,23 +66,75 @@ func (e *templateEngine) sub(locals map[string]string) (*templateEngine, error)
 		}
 		newDefines[kv.key] = val
 	}
+	for k, v := range e.pinned {
+		newDefines[k] = v
+	}
 	return &templateEngine{
 		defines: newDefines,
 		funcs:   e.funcs,
+		pinned:  e.pinned,
 	}, nil
 }
 
+// pin prevents sub engines from overriding the definitions of keys.
+func (e *templateEngine) pin(keys []string) {
+	e.pinned = make(map[string]string)
+	for _, k := range keys {
+		e.pinned[k] = e.defines[k]
+	}
+}
+
 // render executes the provided text as a template using the engine's definitions.
 // If the text does not contain "{{", it is returned as-is.
 func (e *templateEngine) render(name, text string) (string, error) {
 	return e.renderWith(name, text, e.defines)
 }
 
+// eval renders a condition and reports whether it holds.
+// An empty condition always holds. Otherwise the rendered text is false if it is empty,
+// "false", "0", "no" or "off" (case insensitive), and true in any other case.
+func (e *templateEngine) eval(name, cond string) (bool, error) {
+	if cond == "" {
+		return true, nil
+	}
+	val, err := e.render(name, cond)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "", "false", "0", "no", "off":
+		return false, nil
+	}
+	return true, nil
+}
+
 func (e *templateEngine) renderWith(name, text string, defines map[string]string) (string, error) {
-	if !strings.Contains(text, "{{") {
+	return e.execute(name, text, defines, textOptions{})
+}
+
+// textOptions are the template options of a file content.
+type textOptions struct {
+	// left and right are the action delimiters. Default to "{{" and "}}".
+	left, right string
+	// missingKey is the text/template "missingkey" option. Defaults to "error".
+	missingKey string
+}
+
+// renderText is like render, with the options of a file content.
+func (e *templateEngine) renderText(name, text string, o textOptions) (string, error) {
+	return e.execute(name, text, e.defines, o)
+}
+
+// execute renders text with defines. If the text does not contain the left delimiter, it is returned as-is.
+func (e *templateEngine) execute(name, text string, defines map[string]string, o textOptions) (string, error) {
+	left := cmp.Or(o.left, "{{")
+	if !strings.Contains(text, left) {
 		return text, nil
 	}
-	t, err := template.New(name).Funcs(e.funcs).Option("missingkey=error").Parse(text)
+	t, err := template.New(name).Funcs(e.funcs).
+		Delims(left, cmp.Or(o.right, "}}")).
+		Option("missingkey=" + cmp.Or(o.missingKey, "error")).
+		Parse(text)
 	if err != nil {
 		return "", fmt.Errorf("parsing template %s: %w", name, err)
 	}
@@ -84,6 +145,22 @@ func (e *templateEngine) renderWith(name, text string, defines map[string]string
 	return buf.String(), nil
 }
 
+// envFunc returns the 'env' template function.
+// It returns the value of the named environment variable, or an error if the
+// variable is not part of the allowed list.
+func envFunc(allowed []string) func(string) (string, error) {
+	allow := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		allow[name] = true
+	}
+	return func(name string) (string, error) {
+		if !allow[name] {
+			return "", fmt.Errorf("environment variable %q is not allowed, add it to 'env' in the repository file", name)
+		}
+		return os.Getenv(name), nil
+	}
+}
+
 type kvPair struct {
 	key, value string
 }
@@ -102,8 +179,11 @@ func sortLocals(locals map[string]string) ([]kvPair, error) {
 			continue
 		}
 
-		trees, err := parse.Parse(k, v, "{{", "}}")
-		if err != nil {
+		// The functions are only checked when rendering, the tree only lists the dependencies.
+		tree := parse.New(k)
+		tree.Mode = parse.SkipFuncCheck
+		trees := make(map[string]*parse.Tree)
+		if _, err := tree.Parse(v, "{{", "}}", trees); err != nil {
 			return nil, fmt.Errorf("parsing template for define.%s: %w", k, err)
 		}
 
@@ -0,0 +1,23 @@
+package manifest
+
+import (
+	"testing"
+	"text/template"
+)
+
+func TestDefinesCallingFunctions(t *testing.T) {
+	t.Setenv("CI_BUILD_NUMBER", "42")
+	e, err := newTemplateEngine(map[string]string{
+		"BUILD":   `{{ env "CI_BUILD_NUMBER" }}`,
+		"VERSION": `{{ printf "1.0+%s" .BUILD | upper }}`,
+	}, template.FuncMap{"env": envFunc([]string{"CI_BUILD_NUMBER"})})
+	if err != nil {
+		t.Fatalf("newTemplateEngine failed: %v", err)
+	}
+	if got := e.defines["VERSION"]; got != "1.0+42" {
+		t.Errorf("VERSION = %q, want %q", got, "1.0+42")
+	}
+	if _, err := newTemplateEngine(map[string]string{"BUILD": `{{ undefined "x" }}`}, nil); err == nil {
+		t.Error("newTemplateEngine accepts a define calling an undefined function")
+	}
+}
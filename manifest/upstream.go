@@ -0,0 +1,382 @@
+package manifest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+// Upstream is an APT repository whose packages are imported into the repository
+// before the local packages are applied.
+type Upstream struct {
+	// URL is the base URL of the APT repository (the directory that contains dists/ or the flat indices).
+	URL string `json:"url" yaml:"url" jsonschema:"required"`
+	// Suite is the distribution (e.g. "bookworm"), or the directory of a flat repository relative to URL.
+	Suite string `json:"suite" yaml:"suite"`
+	// Components are the components to import. Leave empty for a flat repository.
+	Components []string `json:"components" yaml:"components"`
+	// Architectures are the architectures to import. Required with Components.
+	Architectures []string `json:"architectures" yaml:"architectures"`
+	// Packages are shell patterns (e.g. "nginx*") of the package names to import. Defaults to all packages.
+	Packages []string `json:"packages" yaml:"packages"`
+	// Component is the component of the imported packages in a standard layout.
+	// Defaults to the first component of the repository.
+	Component string `json:"component" yaml:"component"`
+	// Key is the ASCII-armored public key signing the Release file of the upstream: a file, relative to
+	// the repository file, or a URL. When set, the signature of InRelease, or Release.gpg, is checked.
+	// The indices are always checked against the checksums of the Release file.
+	Key string `json:"key" yaml:"key"`
+	// When is an optional condition. The upstream is skipped if it renders to a false value.
+	When string `json:"when" yaml:"when"`
+}
+
+// importUpstreams imports the packages of every upstream into repo.
+// Packages already in repo, with the same name, version and architecture, are not downloaded again.
+// The packages of an upstream are downloaded concurrently, at most parallelism at once, and added
+// in the order of its index.
+func (a *Repository) importUpstreams(ctx context.Context, repo *deb.Repository, parallelism int, l Listener) error {
+	for i, u := range a.Upstream {
+		name := fmt.Sprintf("upstream[%d]", i)
+		ok, err := a.engine.eval(name+".when", u.When)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if u, err = a.renderUpstream(name, u); err != nil {
+			return err
+		}
+		base := u.URL
+		keyring, err := a.upstreamKey(name, u)
+		if err != nil {
+			return err
+		}
+
+		entries, err := a.upstreamIndex(base, u.Suite, u.Components, u.Architectures, keyring)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		seen := make(map[string]bool)
+		entries = slices.DeleteFunc(entries, func(e deb.IndexEntry) bool {
+			m := e.Metadata
+			key := m.Package + " " + m.Version + " " + m.Architecture
+			if seen[key] || !matchAny(u.Packages, m.Package) {
+				return true
+			}
+			seen[key] = true
+			// Already in the repository (e.g. imported by a previous build): skip the download,
+			// but keep the package in the lock file.
+			if repo.Get(m.Package, m.Version, m.Architecture) == nil {
+				return false
+			}
+			a.fetcher.lock.keep(fileURL(base, e.Filename))
+			return true
+		})
+		pkgs, err := a.fetchUpstreamPackages(ctx, base, entries, parallelism)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, pkg := range pkgs {
+			if _, err := appendPackage(repo, pkg); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			a.components[pkg] = u.Component
+			l(EventUpstreamImport{
+				URL:          base,
+				Package:      pkg.Metadata.Package,
+				Version:      pkg.Metadata.Version,
+				Architecture: pkg.Metadata.Architecture,
+			})
+		}
+	}
+	return nil
+}
+
+// fetchUpstreamPackages downloads the packages of the index entries of the upstream at base,
+// running at most parallelism downloads at once. Packages are returned in the order of entries,
+// or the first error in that order.
+func (a *Repository) fetchUpstreamPackages(ctx context.Context, base string, entries []deb.IndexEntry, parallelism int) ([]*deb.Package, error) {
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+	pkgs := make([]*deb.Package, len(entries))
+	errs := make([]error, len(entries))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		sem <- struct{}{}
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			<-sem
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			pkgs[i], errs[i] = a.fetchUpstreamPackage(fileURL(base, e.Filename), e)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pkgs, nil
+}
+
+// renderUpstream returns the upstream with its URL (without trailing slash), suite, components,
+// architectures and component rendered, and checks them.
+func (a *Repository) renderUpstream(name string, u Upstream) (Upstream, error) {
+	var err error
+	for _, f := range []struct {
+		field string
+		dst   *string
+	}{
+		{"url", &u.URL},
+		{"suite", &u.Suite},
+		{"component", &u.Component},
+	} {
+		if *f.dst, err = a.engine.render(name+"."+f.field, *f.dst); err != nil {
+			return u, err
+		}
+	}
+	u.URL = strings.TrimSuffix(u.URL, "/")
+	renderAll := func(field string, texts []string) ([]string, error) {
+		var values []string
+		for i, text := range texts {
+			v, err := a.engine.render(fmt.Sprintf("%s.%s[%d]", name, field, i), text)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	if u.Components, err = renderAll("components", u.Components); err != nil {
+		return u, err
+	}
+	if u.Architectures, err = renderAll("architectures", u.Architectures); err != nil {
+		return u, err
+	}
+	if err := a.checkUpstream(u); err != nil {
+		return u, fmt.Errorf("%s: %w", name, err)
+	}
+	return u, nil
+}
+
+// checkUpstream checks the rendered fields of an upstream.
+func (a *Repository) checkUpstream(u Upstream) error {
+	switch {
+	case u.URL == "":
+		return fmt.Errorf("'url' is required")
+	case len(u.Components) > 0 && len(u.Architectures) == 0:
+		return fmt.Errorf("'architectures' is required with 'components'")
+	}
+	for _, p := range u.Packages {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid package pattern %q", p)
+		}
+	}
+	return a.checkComponent(u.Component)
+}
+
+// upstreamKey returns the public key of an upstream, or nil if it has none.
+func (a *Repository) upstreamKey(name string, u Upstream) ([]byte, error) {
+	key, err := a.engine.render(name+".key", u.Key)
+	if err != nil || key == "" {
+		return nil, err
+	}
+	content, err := a.loadResource(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading key %s: %w", name, key, err)
+	}
+	return []byte(content), nil
+}
+
+// upstreamIndex fetches the Packages indices of an upstream repository, and checks them against its
+// Release file, signed by keyring if it is not empty.
+// Packages listed in several indices (e.g. architecture independent ones) are returned once.
+func (a *Repository) upstreamIndex(base, suite string, components, architectures []string, keyring []byte) ([]deb.IndexEntry, error) {
+	// dir is the directory of the Release file, and subs the directories of the indices, relative to it.
+	var dir string
+	var subs []string
+	if len(components) == 0 {
+		dir = base
+		if s := strings.Trim(suite, "/"); s != "" && s != "." {
+			dir += "/" + s
+		}
+		subs = append(subs, "")
+	} else {
+		dir = fmt.Sprintf("%s/dists/%s", base, suite)
+		for _, comp := range components {
+			for _, arch := range architectures {
+				subs = append(subs, fmt.Sprintf("%s/binary-%s", comp, arch))
+			}
+		}
+	}
+	release, err := a.upstreamRelease(dir, keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var entries []deb.IndexEntry
+	for _, sub := range subs {
+		index, err := a.fetchIndex(dir, sub, release)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := deb.ParsePackagesIndexReader(index, a.fetcher.parseLimits())
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", fileURL(dir, path.Join(sub, "Packages")), err)
+		}
+		for _, e := range parsed {
+			if !seen[e.Filename] {
+				seen[e.Filename] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// upstreamRelease fetches the Release file in the directory dir, and returns the index files it lists.
+// InRelease is preferred, and Release is used when it is missing. If keyring is not empty, the
+// signature of InRelease, or Release.gpg, must be valid.
+func (a *Repository) upstreamRelease(dir string, keyring []byte) ([]deb.ReleaseEntry, error) {
+	var release []byte
+	signed, err := a.fetcher.fetch(dir + "/InRelease")
+	switch {
+	case err == nil && len(keyring) > 0:
+		release, err = deb.VerifyClearSigned(signed, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("checking %s/InRelease: %w", dir, err)
+		}
+	case err == nil:
+		release, err = deb.ClearSignedText(signed)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s/InRelease: %w", dir, err)
+		}
+	case !isNotFound(err):
+		return nil, err
+	default:
+		release, err = a.fetcher.fetch(dir + "/Release")
+		if err != nil {
+			return nil, err
+		}
+		if len(keyring) > 0 {
+			signature, err := a.fetcher.fetch(dir + "/Release.gpg")
+			if err != nil {
+				return nil, err
+			}
+			if err := deb.VerifyDetached(release, signature, keyring); err != nil {
+				return nil, fmt.Errorf("checking %s/Release.gpg: %w", dir, err)
+			}
+		}
+	}
+	entries, err := deb.ParseReleaseEntries(string(release))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s/Release: %w", dir, err)
+	}
+	return entries, nil
+}
+
+// fetchIndex fetches the Packages index in the directory sub, relative to the directory dir of the
+// Release file, preferring the compressed one, and returns a reader of its decompressed content.
+// The uncompressed index is only fetched if the compressed one is missing.
+// The index must match its entry in release.
+func (a *Repository) fetchIndex(dir, sub string, release []deb.ReleaseEntry) (io.Reader, error) {
+	name := path.Join(sub, "Packages.gz")
+	content, err := a.fetchReleased(dir, name, release)
+	if err == nil {
+		gr, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", fileURL(dir, name), err)
+		}
+		return a.fetcher.limitReader(fileURL(dir, name), gr), nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+	content, err = a.fetchReleased(dir, path.Join(sub, "Packages"), release)
+	return bytes.NewReader(content), err
+}
+
+// fetchReleased fetches the file name, relative to the directory dir of the Release file, and checks
+// it against its entry in release.
+func (a *Repository) fetchReleased(dir, name string, release []deb.ReleaseEntry) ([]byte, error) {
+	url := fileURL(dir, name)
+	content, err := a.fetcher.fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(release, func(e deb.ReleaseEntry) bool { return e.Path == name })
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not listed in the Release file", url)
+	}
+	if err := release[i].Verify(content); err != nil {
+		return nil, fmt.Errorf("checking %s: %w", url, err)
+	}
+	return content, nil
+}
+
+// fetchUpstreamPackage downloads and parses the package at url, the file of the index entry e, and
+// checks its SHA256 and SHA512 checksums. The index must list at least one of them.
+// The package keeps its original content.
+func (a *Repository) fetchUpstreamPackage(url string, e deb.IndexEntry) (*deb.Package, error) {
+	if e.SHA256 == "" && e.SHA512 == "" {
+		return nil, fmt.Errorf("%s: the index lists no SHA256 or SHA512 checksum", url)
+	}
+	content, err := a.fetcher.fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	if e.SHA256 != "" {
+		if err := verifySHA256(url, content, e.SHA256); err != nil {
+			return nil, err
+		}
+	}
+	if e.SHA512 != "" {
+		if err := verifySHA512(url, content, e.SHA512); err != nil {
+			return nil, err
+		}
+	}
+	pkg, err := deb.NewPackageWithLimits(bytes.NewReader(content), a.fetcher.parseLimits())
+	if err != nil {
+		return nil, fmt.Errorf("parsing package %s: %w", url, err)
+	}
+	// The package is published byte for byte, with the checksums of the upstream index.
+	pkg.SetOriginalContent(content)
+	return pkg, nil
+}
+
+// fileURL returns the URL of the file of an index entry, relative to the repository base URL.
+// The '%' of a filename, e.g. of an epoch encoded as "%3a", is escaped like apt does, so that the
+// server finds the file by its literal name.
+func fileURL(base, filename string) string {
+	return base + "/" + strings.ReplaceAll(filename, "%", "%25")
+}
+
+// matchAny reports whether name matches one of the shell patterns, or if there are no patterns.
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package manifest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/etnz/apt-repo-builder/deb"
+)
+
+func TestUpstreamIndex(t *testing.T) {
+	key, err := deb.GenerateKey(deb.KeyOptions{Name: "Test", Algorithm: deb.KeyAlgorithmEd25519})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	pub, err := deb.PublicKey(key, true)
+	if err != nil {
+		t.Fatalf("PublicKey failed: %v", err)
+	}
+	dir := t.TempDir()
+	upstream := &deb.StandardRepository{
+		ArchiveInfo: deb.ArchiveInfo{Codename: "stable"},
+		Parts: []*deb.Repository{{
+			ArchiveInfo: deb.ArchiveInfo{Components: "main", Architectures: "amd64"},
+			Packages:    []*deb.Package{{Metadata: deb.Metadata{Package: "app", Version: "1.0", Architecture: "amd64", Maintainer: "Test <test@example.com>", Description: "app"}}},
+		}},
+		GPGKey: key,
+	}
+	if _, err := upstream.WriteToDir(dir); err != nil {
+		t.Fatalf("WriteToDir failed: %v", err)
+	}
+	failing := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.FileServer(http.Dir(dir)).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	a, err := NewRepositoryFromFS(fstest.MapFS{"repository.yml": {Data: []byte("path: repo\n")}}, "repository.yml", nil)
+	if err != nil {
+		t.Fatalf("NewRepositoryFromFS failed: %v", err)
+	}
+	index := func(keyring []byte) error {
+		t.Helper()
+		entries, err := a.upstreamIndex(server.URL, "stable", []string{"main"}, []string{"amd64"}, keyring)
+		if err == nil && (len(entries) != 1 || entries[0].Metadata.Package != "app") {
+			t.Errorf("upstreamIndex returned %v", entries)
+		}
+		return err
+	}
+	if err := index([]byte(pub)); err != nil {
+		t.Fatalf("upstreamIndex failed: %v", err)
+	}
+
+	// A server error is not a missing compressed index.
+	failing = "/dists/stable/main/binary-amd64/Packages.gz"
+	if err := index(nil); err == nil {
+		t.Error("upstreamIndex falls back to the uncompressed index on a server error")
+	}
+	failing = ""
+
+	// Without Packages.gz, the uncompressed index is used.
+	gz := filepath.Join(dir, "dists/stable/main/binary-amd64/Packages.gz")
+	if err := os.Rename(gz, gz+".orig"); err != nil {
+		t.Fatal(err)
+	}
+	if err := index([]byte(pub)); err != nil {
+		t.Errorf("upstreamIndex failed without Packages.gz: %v", err)
+	}
+
+	// An index that does not match the Release file is rejected.
+	packages := filepath.Join(dir, "dists/stable/main/binary-amd64/Packages")
+	if err := os.WriteFile(packages, []byte("Package: evil\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := index(nil); err == nil || !strings.Contains(err.Error(), "Release says") {
+		t.Errorf("upstreamIndex of a modified index: %v", err)
+	}
+
+	// The Release file must be signed by the key.
+	other, err := deb.GenerateKey(deb.KeyOptions{Name: "Other", Algorithm: deb.KeyAlgorithmEd25519})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	otherPub, err := deb.PublicKey(other, true)
+	if err != nil {
+		t.Fatalf("PublicKey failed: %v", err)
+	}
+	if err := index([]byte(otherPub)); err == nil || !strings.Contains(err.Error(), "InRelease") {
+		t.Errorf("upstreamIndex with another key: %v", err)
+	}
+}
+
+func TestFetchUpstreamPackageChecksum(t *testing.T) {
+	a := &Repository{}
+	if _, err := a.fetchUpstreamPackage("https://deb.example.com/app.deb", deb.IndexEntry{Filename: "app.deb"}); err == nil {
+		t.Error("fetchUpstreamPackage accepts an index entry without checksum")
+	}
+}
+
+func TestRenderUpstream(t *testing.T) {
+	repository := `path: repo
+layout: standard
+codename: stable
+components: [main, extra]
+architectures: [amd64]
+defines:
+  DIST: bookworm
+  COMP: extra
+upstream:
+  - url: https://deb.example.com/{{.DIST}}/
+    suite: "{{.DIST}}"
+    components: ["{{.COMP}}"]
+    architectures: ["{{.ARCH}}"]
+    component: "{{.COMP}}"
+`
+	a, err := NewRepositoryFromFS(fstest.MapFS{"repository.yml": {Data: []byte(repository)}}, "repository.yml", map[string]string{"ARCH": "arm64"})
+	if err != nil {
+		t.Fatalf("NewRepositoryFromFS failed: %v", err)
+	}
+	u, err := a.renderUpstream("upstream[0]", a.Upstream[0])
+	if err != nil {
+		t.Fatalf("renderUpstream failed: %v", err)
+	}
+	if u.URL != "https://deb.example.com/bookworm" || u.Suite != "bookworm" || u.Component != "extra" ||
+		len(u.Components) != 1 || u.Components[0] != "extra" || len(u.Architectures) != 1 || u.Architectures[0] != "arm64" {
+		t.Errorf("renderUpstream = %+v", u)
+	}
+
+	a.Upstream[0].Component = "{{.ARCH}}"
+	if _, err := a.renderUpstream("upstream[0]", a.Upstream[0]); err == nil {
+		t.Error("renderUpstream accepts a rendered component that is not a repository component")
+	}
+}
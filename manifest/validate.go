@@ -0,0 +1,73 @@
+package manifest
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Validate loads the repository file at path and checks it, without writing anything.
+// See Repository.Validate.
+func Validate(path string) error {
+	repo, err := NewRepository(path)
+	if err != nil {
+		return err
+	}
+	return repo.redactor.error(repo.Validate())
+}
+
+// Validate checks the repository configuration and all the package definitions it references.
+// The packages are built like in ModePlan, with the same checks, but their hooks and commands are
+// only checked, not run, and the repository is not loaded nor written.
+// Every problem of the configuration, and the first problem of each package, is reported in the
+// returned error, which is nil if the configuration is valid.
+func (a *Repository) Validate() error {
+	var errs []error
+	if len(a.repositories) > 0 {
+		return a.validateRepositories()
+	}
+	for _, err := range checkHooks(a.engine, "before", a.Before) {
+		errs = append(errs, fmt.Errorf("%s: %w", a.filePath, err))
+	}
+	for _, err := range checkHooks(a.engine, "after", a.After) {
+		errs = append(errs, fmt.Errorf("%s: %w", a.filePath, err))
+	}
+	for i, u := range a.Upstream {
+		name := fmt.Sprintf("upstream[%d]", i)
+		if _, err := a.engine.render(name+".when", u.When); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.filePath, err))
+		}
+		if _, err := a.renderUpstream(name, u); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.filePath, err))
+		}
+		if _, err := a.upstreamKey(name, u); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.filePath, err))
+		}
+	}
+	for i, ref := range a.Packages {
+		pkgs, err := a.loadPackageRef(i, ref)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, pkg := range pkgs {
+			pkg.mode = ModeValidate
+			if _, err := pkg.Build(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", pkg.filePath, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkSHA256Syntax checks that sum is either empty or a hex encoded SHA256 checksum.
+func checkSHA256Syntax(name, sum string) error {
+	if sum == "" {
+		return nil
+	}
+	if b, err := hex.DecodeString(strings.TrimSpace(sum)); err != nil || len(b) != 32 {
+		return fmt.Errorf("%s %q is not a hex encoded SHA256 checksum", name, sum)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// loadValues reads the Values files in order, and returns defines merged with their keys.
+// Keys of later files override earlier ones, and all of them override defines.
+func (a *Repository) loadValues(defines map[string]string) (map[string]string, error) {
+	if len(a.Values) == 0 {
+		return defines, nil
+	}
+	merged := maps.Clone(defines)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	for _, path := range a.Values {
+		content, err := readLocal(a.fsys, a.resolve(path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read values file: %w", err)
+		}
+		values, err := parseValues(path, content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse values file %s: %w", path, err)
+		}
+		maps.Copy(merged, values)
+	}
+	return merged, nil
+}
+
+// parseValues parses a YAML or JSON values file, based on its extension.
+// Values must be scalars, and keep their text (e.g. the YAML number 1.10 is "1.10", not "1.1").
+func parseValues(path string, content []byte) (map[string]string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".yaml" || ext == ".yml" {
+		var node yaml.Node
+		if err := yaml.Unmarshal(content, &node); err != nil {
+			return nil, err
+		}
+		values := make(map[string]string)
+		if len(node.Content) == 0 {
+			return values, nil
+		}
+		root := node.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d: expected a mapping", root.Line)
+		}
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			k, v := root.Content[i], root.Content[i+1]
+			if v.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: value of %q must be a scalar", v.Line, k.Value)
+			}
+			if v.Tag == "!!null" {
+				values[k.Value] = ""
+				continue
+			}
+			values[k.Value] = v.Value
+		}
+		return values, nil
+	}
+
+	var raw map[string]any
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for _, k := range slices.Sorted(maps.Keys(raw)) {
+		switch v := raw[k].(type) {
+		case nil:
+			values[k] = ""
+		case string, bool, json.Number:
+			values[k] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("value of %q must be a string, a number or a boolean", k)
+		}
+	}
+	return values, nil
+}